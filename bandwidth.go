@@ -10,15 +10,18 @@ import (
 	"github.com/urfave/cli/v2"
 	bdlib "github.com/yuyang0/resource-bandwidth/bandwidth"
 	"github.com/yuyang0/resource-bandwidth/cmd"
+	"github.com/yuyang0/resource-bandwidth/cmd/agent"
 	"github.com/yuyang0/resource-bandwidth/cmd/bandwidth"
 	"github.com/yuyang0/resource-bandwidth/cmd/calculate"
+	"github.com/yuyang0/resource-bandwidth/cmd/config"
 	"github.com/yuyang0/resource-bandwidth/cmd/metrics"
 	"github.com/yuyang0/resource-bandwidth/cmd/node"
+	"github.com/yuyang0/resource-bandwidth/cmd/serve"
 	"github.com/yuyang0/resource-bandwidth/version"
 )
 
 func NewPlugin(ctx context.Context, config coretypes.Config) (plugins.Plugin, error) {
-	p, err := bdlib.NewPlugin(ctx, config, nil)
+	p, err := bdlib.NewPluginFromConfig(ctx, config)
 	return p, err
 }
 
@@ -49,6 +52,12 @@ func main() {
 		calculate.CalculateDeploy(),
 		calculate.CalculateRealloc(),
 		calculate.CalculateRemap(),
+		calculate.CommitHolds(),
+		calculate.ReleaseHolds(),
+
+		serve.Serve(),
+		agent.Agent(),
+		config.CheckConfig(),
 	}
 	app.Flags = []cli.Flag{
 		&cli.StringFlag{
@@ -56,13 +65,19 @@ func main() {
 			Value:       "bandwidth.yaml",
 			Usage:       "config file path for plugin, in yaml",
 			Destination: &cmd.ConfigPath,
-			EnvVars:     []string{"ERU_RESOURCE_CONFIG_PATH"},
+			EnvVars:     []string{bdlib.ConfigPathEnv},
 		},
 		&cli.BoolFlag{
 			Name:        "embedded-storage",
-			Usage:       "active embedded storage",
+			Usage:       "use in-memory storage instead of the store in config, for dry runs",
 			Destination: &cmd.EmbeddedStorage,
 		},
+		&cli.StringFlag{
+			Name:        "daemon",
+			Usage:       "address of the daemon, overrides daemon in config",
+			Destination: &cmd.DaemonAddress,
+			EnvVars:     []string{"ERU_RESOURCE_BANDWIDTH_DAEMON"},
+		},
 	}
 	_ = app.Run(os.Args)
 }
@@ -0,0 +1,127 @@
+// Package agent enforces the allocations of a node with linux traffic control.
+// It runs on the node, renders the ledger of the node into a tc plan, observes what is in the kernel,
+// applies only the differences and reports what is still different back to the node resource info.
+package agent
+
+import (
+	"context"
+	"time"
+
+	"github.com/projecteru2/core/log"
+	"github.com/yuyang0/resource-bandwidth/bandwidth/tc"
+	bdtypes "github.com/yuyang0/resource-bandwidth/bandwidth/types"
+)
+
+// Source is where the agent reads allocations and reports drift, it's the plugin
+type Source interface {
+	GetNodeAllocations(ctx context.Context, nodename string) (bdtypes.Ledger, *bdtypes.NodeResource, error)
+	ReportDrift(ctx context.Context, nodename string, drift []string) error
+	// Unit is the unit of the bandwidths of allocations
+	Unit() bdtypes.Unit
+}
+
+// Agent reconciles the shaping of one node to its allocations
+type Agent struct {
+	source   Source
+	nodename string
+	config   bdtypes.AgentConfig
+	executor tc.Executor
+}
+
+// Result is the outcome of one reconciliation
+type Result struct {
+	// Drift is the differences found before applying
+	Drift []string `json:"drift"`
+	// Commands fix the drift, they are not run on dry runs
+	Commands []string `json:"commands"`
+	// Remains is the differences left after applying, they are reported to the node
+	Remains []string `json:"remains"`
+}
+
+// New .
+func New(source Source, nodename string, config bdtypes.AgentConfig, executor tc.Executor) *Agent {
+	return &Agent{
+		source:   source,
+		nodename: nodename,
+		config:   config,
+		executor: executor,
+	}
+}
+
+// Reconcile changes the shaping of the node to its allocations, a dry run only observes and reports the drift
+func (a *Agent) Reconcile(ctx context.Context, dryRun bool) (*Result, error) {
+	desired, err := a.desired(ctx)
+	if err != nil {
+		return nil, err
+	}
+	current, err := tc.Observe(ctx, a.executor, desired)
+	if err != nil {
+		return nil, err
+	}
+	cmds, drift := tc.Diff(current, desired)
+	result := &Result{Drift: drift, Commands: []string{}, Remains: drift}
+	for _, cmd := range cmds {
+		result.Commands = append(result.Commands, cmd.String())
+	}
+
+	if !dryRun && len(cmds) > 0 {
+		applyErr := tc.Apply(ctx, a.executor, cmds)
+		// what a failed command left behind is found by observing again
+		if current, err = tc.Observe(ctx, a.executor, desired); err != nil {
+			return nil, err
+		}
+		_, result.Remains = tc.Diff(current, desired)
+		if err := a.source.ReportDrift(ctx, a.nodename, result.Remains); err != nil {
+			return result, err
+		}
+		return result, applyErr
+	}
+	return result, a.source.ReportDrift(ctx, a.nodename, result.Remains)
+}
+
+// Run reconciles every interval until ctx is done, failures are logged and retried at the next interval
+func (a *Agent) Run(ctx context.Context) {
+	logger := log.WithFunc("resource.bandwidth.agent.Run").WithField("node", a.nodename)
+	ticker := time.NewTicker(a.config.Interval)
+	defer ticker.Stop()
+	for {
+		if result, err := a.Reconcile(ctx, false); err != nil {
+			logger.Error(ctx, err)
+		} else if len(result.Drift) > 0 {
+			logger.Infof(ctx, "fixed drift %v, remains %v", result.Drift, result.Remains)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// desired renders the allocations of the node into a plan, workloads without engine params are not shaped
+func (a *Agent) desired(ctx context.Context) (*tc.Plan, error) {
+	ledger, capacity, err := a.source.GetNodeAllocations(ctx, a.nodename)
+	if err != nil {
+		return nil, err
+	}
+	targets := []*tc.Target{}
+	for _, id := range ledger.IDs() {
+		allocation := ledger[id]
+		if allocation.EngineParams == nil {
+			continue
+		}
+		targets = append(targets, &tc.Target{
+			ID:           id,
+			Slot:         allocation.Slot,
+			EngineParams: allocation.EngineParams,
+			Selector:     a.config.Selector,
+			Match:        a.config.MatchOf(id),
+		})
+	}
+	return tc.NewPlan(targets, tc.Options{
+		Device:   a.config.Device,
+		Capacity: capacity,
+		Devices:  append([]string{a.config.Device}, a.config.Devices...),
+		Unit:     a.source.Unit(),
+	})
+}
@@ -0,0 +1,192 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	enginetypes "github.com/projecteru2/core/engine/types"
+	plugintypes "github.com/projecteru2/core/resource/plugins/types"
+	coretypes "github.com/projecteru2/core/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/yuyang0/resource-bandwidth/bandwidth"
+	"github.com/yuyang0/resource-bandwidth/bandwidth/tc"
+	"github.com/yuyang0/resource-bandwidth/bandwidth/types"
+)
+
+// fakeKernel answers the commands observing the node from outputs, fails other observations
+// and takes all changes without changing outputs, it records the changes
+type fakeKernel struct {
+	outputs map[string]string
+	changes []string
+}
+
+func (k *fakeKernel) Run(_ context.Context, args []string) ([]byte, error) {
+	line := strings.Join(args, " ")
+	if out, ok := k.outputs[line]; ok {
+		return []byte(out), nil
+	}
+	for _, arg := range args {
+		if arg == "show" || arg == "-S" || arg == "-C" {
+			return nil, errors.Newf("%s: exit status 1", line)
+		}
+	}
+	k.changes = append(k.changes, line)
+	return nil, nil
+}
+
+// enforce sets outputs to what tc and iptables print once plan is in place
+func (k *fakeKernel) enforce(plan *tc.Plan) {
+	k.outputs = map[string]string{}
+	chains := map[string][2]string{
+		tc.SelectCgroup:    {"BANDWIDTH-CGROUP", "OUTPUT"},
+		tc.SelectInterface: {"BANDWIDTH-IFACE", "PREROUTING"},
+	}
+	for _, selector := range plan.Chains {
+		chain, hook := chains[selector][0], chains[selector][1]
+		rules := "-N " + chain + "\n"
+		for _, mark := range plan.Marks {
+			if mark.Selector == selector {
+				rules += fmt.Sprintf("-A %s -m cgroup --path %s -j MARK --set-xmark 0x%x/0xffffffff\n", chain, mark.Match, mark.Mark)
+			}
+		}
+		rules += fmt.Sprintf("-A %s -j CONNMARK --save-mark --nfmask 0xffffffff --ctmask 0xffffffff\n", chain)
+		k.outputs["iptables -t mangle -S "+chain] = rules
+		k.outputs[fmt.Sprintf("iptables -t mangle -C %s -j %s", hook, chain)] = ""
+	}
+	for _, device := range plan.Devices {
+		k.outputs["tc -j qdisc show dev "+device.Name] = `[{"kind":"htb","handle":"1:","root":true}]`
+		classes := ""
+		for _, class := range device.Classes {
+			parent := "parent " + class.Parent
+			if class.Parent == "1:" {
+				parent = "root"
+			}
+			classes += fmt.Sprintf("class htb %s %s prio 0 rate %dbit ceil %dbit burst 1600b cburst 1600b \n", class.ClassID, parent, class.Rate, class.Ceil)
+		}
+		k.outputs["tc class show dev "+device.Name] = classes
+		filters := "filter protocol all pref 1 fw chain 0 \n"
+		for _, filter := range device.Filters {
+			filters += fmt.Sprintf("filter protocol all pref 1 fw chain 0 handle 0x%x classid %s \n", filter.Mark, filter.FlowID)
+		}
+		k.outputs["tc filter show dev "+device.Name+" parent 1:"] = filters
+		if device.Uplink != "" {
+			k.outputs["tc filter show dev "+device.Uplink+" parent ffff:"] =
+				"\taction order 2: mirred (Egress Redirect to device " + device.Name + ") stolen\n"
+		}
+	}
+}
+
+func TestDesiredMatchesEngineParams(t *testing.T) {
+	ctx := context.Background()
+	config := coretypes.Config{Scheduler: coretypes.SchedulerConfig{MaxShare: -1, ShareBase: 100}}
+	p, err := bandwidth.NewPluginWithStore(ctx, config, nil, bandwidth.NewMemoryStore())
+	assert.Nil(t, err)
+	node := "node1"
+	_, err = p.AddNode(ctx, node, plugintypes.NodeResourceRequest{"bandwidth": 1000}, &enginetypes.Info{})
+	assert.Nil(t, err)
+	d, err := p.CalculateDeploy(ctx, node, 2, plugintypes.WorkloadResourceRequest{"bandwidth": 100})
+	assert.Nil(t, err)
+	_, err = p.SetNodeResourceUsage(ctx, node, nil, nil, d.WorkloadsResource, true, true)
+	assert.Nil(t, err)
+
+	agentConfig := types.DefaultConfig().Agent
+	desired, err := New(p, node, agentConfig, &fakeKernel{}).desired(ctx)
+	assert.Nil(t, err)
+	matches := map[string]string{}
+	for _, mark := range desired.Marks {
+		matches[mark.Target] = mark.Match
+	}
+	assert.Len(t, matches, 2)
+	// the engine only gets the engine params, their id names the cgroup the agent marks
+	for _, engineParams := range d.EnginesParams {
+		id, ok := engineParams["id"].(string)
+		assert.True(t, ok)
+		assert.Equal(t, agentConfig.MatchOf(id), matches[id])
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	ctx := context.Background()
+	config := coretypes.Config{Scheduler: coretypes.SchedulerConfig{MaxShare: -1, ShareBase: 100}}
+	p, err := bandwidth.NewPluginWithStore(ctx, config, nil, bandwidth.NewMemoryStore())
+	assert.Nil(t, err)
+	node := "node1"
+	_, err = p.AddNode(ctx, node, plugintypes.NodeResourceRequest{"bandwidth": 1000}, &enginetypes.Info{})
+	assert.Nil(t, err)
+	d, err := p.CalculateDeploy(ctx, node, 2, plugintypes.WorkloadResourceRequest{"bandwidth": 100})
+	assert.Nil(t, err)
+	_, err = p.SetNodeResourceUsage(ctx, node, nil, nil, d.WorkloadsResource, true, true)
+	assert.Nil(t, err)
+
+	kernel := &fakeKernel{}
+	a := New(p, node, types.DefaultConfig().Agent, kernel)
+	desired, err := a.desired(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, desired.Marks, 2)
+	id := d.WorkloadsResource[0]["id"].(string)
+	assert.Contains(t, []string{desired.Marks[0].Match, desired.Marks[1].Match}, "/resource-bandwidth/"+id)
+
+	// a dry run reports the drift without changing anything
+	r, err := a.Reconcile(ctx, true)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"chain BANDWIDTH-CGROUP is not in place",
+		"chain BANDWIDTH-IFACE is not in place",
+		"device eth0 is not shaped",
+		"device ifb-eth0 is not shaped",
+	}, r.Drift)
+	assert.NotEmpty(t, r.Commands)
+	assert.Empty(t, kernel.changes)
+	info, err := p.GetNodeResourceInfo(ctx, node, d.WorkloadsResource)
+	assert.Nil(t, err)
+	assert.Len(t, info.Diffs, 4)
+
+	// changes which don't take are still reported
+	r, err = a.Reconcile(ctx, false)
+	assert.Nil(t, err)
+	assert.Len(t, r.Drift, 4)
+	assert.Equal(t, r.Drift, r.Remains)
+	assert.Len(t, kernel.changes, len(r.Commands))
+
+	// nothing to do and nothing reported once in place
+	kernel.enforce(desired)
+	kernel.changes = nil
+	r, err = a.Reconcile(ctx, false)
+	assert.Nil(t, err)
+	assert.Empty(t, r.Drift)
+	assert.Empty(t, r.Commands)
+	assert.Empty(t, kernel.changes)
+	info, err = p.GetNodeResourceInfo(ctx, node, d.WorkloadsResource)
+	assert.Nil(t, err)
+	assert.Empty(t, info.Diffs)
+
+	// the released workload of the last mark leaves its mark, filters and classes stale,
+	// the other workload gets the spare bandwidth it left
+	last := desired.Marks[1]
+	for _, workloadResource := range d.WorkloadsResource {
+		if workloadResource["id"] == last.Target {
+			_, err = p.SetNodeResourceUsage(ctx, node, nil, nil, []plugintypes.WorkloadResource{workloadResource}, true, false)
+			assert.Nil(t, err)
+		}
+	}
+	r, err = a.Reconcile(ctx, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		fmt.Sprintf("mark 0x11 of cgroup /resource-bandwidth/%s in BANDWIDTH-CGROUP is stale", last.Target),
+		"class 1:10 of eth0: rate 100bit ceil 600bit != rate 100bit ceil 1000bit",
+		"filter 0x11 of eth0 is stale",
+		"class 1:11 of eth0 is stale",
+		"class 1:10 of ifb-eth0: rate 100bit ceil 600bit != rate 100bit ceil 1000bit",
+		"filter 0x11 of ifb-eth0 is stale",
+		"class 1:11 of ifb-eth0 is stale",
+	}, r.Drift)
+	assert.Equal(t, r.Commands, kernel.changes)
+
+	// observations which can't be parsed fail
+	kernel.outputs["tc class show dev eth0"] = "class htb 1:1 root rate 1XB ceil 1bit\n"
+	_, err = a.Reconcile(ctx, false)
+	assert.Error(t, err)
+}
@@ -0,0 +1,40 @@
+package bandwidth
+
+import (
+	"context"
+
+	"github.com/projecteru2/core/log"
+	bdtypes "github.com/yuyang0/resource-bandwidth/bandwidth/types"
+)
+
+// maxWarnings is the number of the latest warnings kept on a node
+const maxWarnings = 16
+
+// checkArithmetic applies the arithmetic policy to bandwidths which went out of range on nodename,
+// they are already clamped so the node can always be written, the error policy fails the update instead.
+// Clamped bandwidths are kept as warnings of the node, which are returned as diffs until FixNodeResource.
+func (p Plugin) checkArithmetic(ctx context.Context, nodename string, resourceInfo *bdtypes.NodeResourceInfo, rangeErrs []*bdtypes.RangeError) error {
+	logger := log.WithFunc("resource.bandwidth.checkArithmetic").WithField("node", nodename)
+	for _, rangeErr := range rangeErrs {
+		rangeErr.Node = nodename
+		if p.bdConfig.Arithmetic == bdtypes.ArithmeticError {
+			return rangeErr
+		}
+		logger.Warnf(ctx, "%s, clamped", rangeErr)
+		addWarning(resourceInfo, "arithmetic warning: "+rangeErr.Error()+", clamped")
+	}
+	return nil
+}
+
+// addWarning keeps warning on the node, only the latest maxWarnings are kept
+func addWarning(resourceInfo *bdtypes.NodeResourceInfo, warning string) {
+	resourceInfo.Warnings = append(resourceInfo.Warnings, warning)
+	if n := len(resourceInfo.Warnings); n > maxWarnings {
+		resourceInfo.Warnings = resourceInfo.Warnings[n-maxWarnings:]
+	}
+}
+
+// warningDiffs returns the warnings of the node as diffs
+func warningDiffs(nodeResourceInfo *bdtypes.NodeResourceInfo) []string {
+	return append([]string{}, nodeResourceInfo.Warnings...)
+}
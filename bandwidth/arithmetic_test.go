@@ -0,0 +1,78 @@
+package bandwidth
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	plugintypes "github.com/projecteru2/core/resource/plugins/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/yuyang0/resource-bandwidth/bandwidth/types"
+)
+
+func TestArithmeticClamp(t *testing.T) {
+	ctx := context.Background()
+	cm := initBandwidth(ctx, t)
+	nodes := generateNodes(ctx, t, cm, 1, 0)
+	node := nodes[0]
+
+	workloads := []plugintypes.WorkloadResource{{"id": "w1", "bandwidth": 20}}
+	_, err := cm.SetNodeResourceUsage(ctx, node, nil, nil, workloads, true, true)
+	assert.Nil(t, err)
+
+	// a mismatched release can't push usage nor the record below 0
+	workloads = []plugintypes.WorkloadResource{{"id": "w1", "bandwidth": 30}}
+	r, err := cm.SetNodeResourceUsage(ctx, node, nil, nil, workloads, true, false)
+	assert.Nil(t, err)
+	assert.Equal(t, types.NewNodeResource(0, 0).AsRawParams(), r.After)
+	info, err := cm.doGetNodeResourceInfo(ctx, node)
+	assert.Nil(t, err)
+	assert.Empty(t, info.Workloads)
+	assert.Nil(t, info.Validate())
+
+	// the clamping is returned as diffs until it's fixed
+	warning := `arithmetic warning: node "` + node + `" ingress 20-30: bandwidth underflow, clamped`
+	ri, err := cm.GetNodeResourceInfo(ctx, node, nil)
+	assert.Nil(t, err)
+	assert.Contains(t, ri.Diffs, warning)
+	ri, err = cm.FixNodeResource(ctx, node, nil)
+	assert.Nil(t, err)
+	assert.Contains(t, ri.Diffs, warning)
+	ri, err = cm.GetNodeResourceInfo(ctx, node, nil)
+	assert.Nil(t, err)
+	assert.Empty(t, ri.Diffs)
+
+	_, err = cm.SetNodeResourceCapacity(ctx, node, nil, plugintypes.NodeResource{"bandwidth": math.MaxInt64}, true, true)
+	assert.Nil(t, err)
+	info, err = cm.doGetNodeResourceInfo(ctx, node)
+	assert.Nil(t, err)
+	assert.Equal(t, types.NewNodeResource(math.MaxInt64, math.MaxInt64), info.Capacity)
+}
+
+func TestArithmeticError(t *testing.T) {
+	ctx := context.Background()
+	bdConfig := types.DefaultConfig()
+	bdConfig.Arithmetic = types.ArithmeticError
+	cm := initBandwidthWithConfig(ctx, t, bdConfig)
+	nodes := generateNodes(ctx, t, cm, 1, 0)
+	node := nodes[0]
+
+	_, err := cm.SetNodeResourceUsage(ctx, node, nil, plugintypes.NodeResource{"bandwidth": 20}, nil, true, true)
+	assert.Nil(t, err)
+	_, err = cm.SetNodeResourceUsage(ctx, node, nil, plugintypes.NodeResource{"ingress": 30, "egress": 10}, nil, true, false)
+	assert.ErrorIs(t, err, types.ErrBandwidthUnderflow)
+	rangeErr := &types.RangeError{}
+	assert.ErrorAs(t, err, &rangeErr)
+	assert.Equal(t, node, rangeErr.Node)
+	assert.Equal(t, "ingress", rangeErr.Field)
+	assert.Equal(t, int64(20), rangeErr.Value)
+	assert.Equal(t, int64(-30), rangeErr.Delta)
+
+	// nothing is written
+	info, err := cm.doGetNodeResourceInfo(ctx, node)
+	assert.Nil(t, err)
+	assert.Equal(t, types.NewNodeResource(20, 20), info.Usage)
+
+	_, err = cm.SetNodeResourceCapacity(ctx, node, nil, plugintypes.NodeResource{"bandwidth": math.MaxInt64}, true, true)
+	assert.ErrorIs(t, err, types.ErrBandwidthOverflow)
+}
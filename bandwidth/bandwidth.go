@@ -2,39 +2,114 @@ package bandwidth
 
 import (
 	"context"
-	"testing"
+	"os"
+	"path/filepath"
 
 	"github.com/projecteru2/core/log"
-	"github.com/projecteru2/core/store/etcdv3/meta"
 	coretypes "github.com/projecteru2/core/types"
+	bdtypes "github.com/yuyang0/resource-bandwidth/bandwidth/types"
 )
 
 const (
 	name                = "bandwidth"
-	rate                = 8
 	nodeResourceInfoKey = "/resource/bandwidth/%s"
-	priority            = 100
+	domainRecordKey     = "/resource/bandwidth/domains/%s"
+	// ConfigPathEnv is the env of the plugin config path, both the binary and the shared library read it
+	ConfigPathEnv = "ERU_RESOURCE_CONFIG_PATH"
+	// configName is the plugin config in the resource plugin dir of core
+	configName = "bandwidth.yaml"
 )
 
 // Plugin
 type Plugin struct {
-	name   string
-	config coretypes.Config
-	store  meta.KV
+	name     string
+	config   coretypes.Config
+	bdConfig *bdtypes.Config
+	// unit is the unit of all bandwidths of the plugin
+	unit    bdtypes.Unit
+	domains bdtypes.Domains
+	// weightBase is the node capacity weighing 1
+	weightBase int64
+	store      Store
 }
 
-// NewPlugin .
-func NewPlugin(ctx context.Context, config coretypes.Config, t *testing.T) (*Plugin, error) {
-	if t == nil && len(config.Etcd.Machines) < 1 {
-		return nil, coretypes.ErrConfigInvaild
+// NewPlugin creates the plugin, bdConfig is the plugin specific config, nil means default config,
+// the storage backend is chosen by bdConfig.Store
+func NewPlugin(ctx context.Context, config coretypes.Config, bdConfig *bdtypes.Config) (*Plugin, error) {
+	p, err := newPlugin(config, bdConfig)
+	if err != nil {
+		log.WithFunc("resource.bandwidth.NewPlugin").Error(ctx, err)
+		return nil, err
 	}
-	var err error
-	plugin := &Plugin{name: name, config: config}
-	if plugin.store, err = meta.NewETCD(config.Etcd, t); err != nil {
+	if p.store, err = NewStore(config, p.bdConfig.Store); err != nil {
 		log.WithFunc("resource.bandwidth.NewPlugin").Error(ctx, err)
 		return nil, err
 	}
-	return plugin, nil
+	return p, nil
+}
+
+// ConfigPath returns the plugin config of the shared library, $ERU_RESOURCE_CONFIG_PATH
+// or bandwidth.yaml in the resource plugin dir of core
+func ConfigPath(config coretypes.Config) string {
+	if path := os.Getenv(ConfigPathEnv); path != "" {
+		return path
+	}
+	return filepath.Join(config.ResourcePlugin.Dir, configName)
+}
+
+// NewPluginFromConfig creates the plugin of the shared library, core only passes its own config,
+// so the bandwidth section is loaded from ConfigPath, a missing file means default config
+func NewPluginFromConfig(ctx context.Context, config coretypes.Config) (*Plugin, error) {
+	bdConfig, err := bdtypes.LoadConfig(ConfigPath(config))
+	if err != nil {
+		log.WithFunc("resource.bandwidth.NewPluginFromConfig").Error(ctx, err)
+		return nil, err
+	}
+	return NewPlugin(ctx, config, bdConfig)
+}
+
+// NewPluginWithStore creates the plugin on an existing store, bdConfig.Store is ignored
+func NewPluginWithStore(ctx context.Context, config coretypes.Config, bdConfig *bdtypes.Config, store Store) (*Plugin, error) {
+	p, err := newPlugin(config, bdConfig)
+	if err != nil {
+		log.WithFunc("resource.bandwidth.NewPluginWithStore").Error(ctx, err)
+		return nil, err
+	}
+	p.store = store
+	return p, nil
+}
+
+// newPlugin validates bdConfig, nil means default config
+func newPlugin(config coretypes.Config, bdConfig *bdtypes.Config) (*Plugin, error) {
+	if bdConfig == nil {
+		bdConfig = bdtypes.DefaultConfig()
+	}
+	if err := bdConfig.Validate(); err != nil {
+		return nil, err
+	}
+	unit, err := bdtypes.ParseUnit(bdConfig.Unit)
+	if err != nil {
+		return nil, err
+	}
+	domains, err := bdtypes.NewDomains(bdConfig.Domains, unit)
+	if err != nil {
+		return nil, err
+	}
+	weightBase, err := unit.ParseBandwidth(bdConfig.Weight.Base)
+	if err != nil {
+		return nil, err
+	}
+	return &Plugin{name: name, config: config, bdConfig: bdConfig, unit: unit, domains: domains, weightBase: weightBase}, nil
+}
+
+// Unit returns the unit of all bandwidths of the plugin
+func (p Plugin) Unit() bdtypes.Unit {
+	return p.unit
+}
+
+// Close closes the store
+func (p Plugin) Close() error {
+	return p.store.Close()
 }
 
 // Name .
@@ -3,20 +3,75 @@ package bandwidth
 import (
 	"context"
 	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
 
 	enginetypes "github.com/projecteru2/core/engine/types"
 	plugintypes "github.com/projecteru2/core/resource/plugins/types"
+	embedded "github.com/projecteru2/core/store/etcdv3/embedded"
 	coretypes "github.com/projecteru2/core/types"
 	"github.com/stretchr/testify/assert"
+	"github.com/yuyang0/resource-bandwidth/bandwidth/types"
 )
 
+func TestNewPluginFromConfig(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	config := coretypes.Config{}
+	config.ResourcePlugin.Dir = dir
+	assert.Equal(t, filepath.Join(dir, "bandwidth.yaml"), ConfigPath(config))
+
+	data := "bandwidth:\n  overcommit_ratio: 2.5\n  store:\n    type: memory\n"
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "bandwidth.yaml"), []byte(data), 0600))
+	p, err := NewPluginFromConfig(ctx, config)
+	assert.Nil(t, err)
+	assert.Equal(t, 2.5, p.bdConfig.OvercommitRatio)
+
+	// the env is the same one the binary reads
+	path := filepath.Join(t.TempDir(), "plugin.yaml")
+	assert.Nil(t, os.WriteFile(path, []byte("bandwidth:\n  overcommit_ratio: 0\n  store:\n    type: memory\n"), 0600))
+	t.Setenv(ConfigPathEnv, path)
+	assert.Equal(t, path, ConfigPath(config))
+	_, err = NewPluginFromConfig(ctx, config)
+	assert.ErrorIs(t, err, types.ErrInvalidConfig)
+}
+
+func TestNewPluginFromConfigStore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	config := coretypes.Config{}
+	config.ResourcePlugin.Dir = dir
+	path := filepath.Join(dir, "bandwidth.db")
+	data := fmt.Sprintf("bandwidth:\n  store:\n    type: bolt\n    path: %s\n", path)
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "bandwidth.yaml"), []byte(data), 0600))
+
+	p, err := NewPluginFromConfig(ctx, config)
+	assert.Nil(t, err)
+	assert.IsType(t, &boltStore{}, p.store)
+	_, err = p.AddNode(ctx, "node1", plugintypes.NodeResourceRequest{"bandwidth": 100}, &enginetypes.Info{})
+	assert.Nil(t, err)
+	assert.Nil(t, p.store.Close())
+
+	// the nodes are in the file of the store
+	store, err := NewBoltStore(path)
+	assert.Nil(t, err)
+	defer store.Close()
+	value, _, err := store.Get(ctx, fmt.Sprintf(nodeResourceInfoKey, "node1"))
+	assert.Nil(t, err)
+	assert.NotNil(t, value)
+}
+
 func TestName(t *testing.T) {
 	cm := initBandwidth(context.Background(), t)
 	assert.Equal(t, cm.name, cm.Name())
 }
 
 func initBandwidth(ctx context.Context, t *testing.T) *Plugin {
+	return initBandwidthWithConfig(ctx, t, nil)
+}
+
+func initBandwidthWithConfig(ctx context.Context, t *testing.T, bdConfig *types.Config) *Plugin {
 	config := coretypes.Config{
 		Etcd: coretypes.EtcdConfig{
 			Prefix: "/bandwidth",
@@ -27,11 +82,19 @@ func initBandwidth(ctx context.Context, t *testing.T) *Plugin {
 		},
 	}
 
-	cm, err := NewPlugin(ctx, config, t)
+	store := &etcdStore{cli: embedded.NewCluster(t, config.Etcd.Prefix).RandClient()}
+	cm, err := NewPluginWithStore(ctx, config, bdConfig, store)
 	assert.NoError(t, err)
 	return cm
 }
 
+func capacityCheckConfig(overcommitRatio float64) *types.Config {
+	bdConfig := types.DefaultConfig()
+	bdConfig.CapacityCheck = true
+	bdConfig.OvercommitRatio = overcommitRatio
+	return bdConfig
+}
+
 func generateNodes(
 	ctx context.Context, t *testing.T, cm *Plugin,
 	nums int, index int,
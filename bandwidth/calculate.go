@@ -2,7 +2,10 @@ package bandwidth
 
 import (
 	"context"
+	"sort"
+	"time"
 
+	"github.com/cockroachdb/errors"
 	"github.com/projecteru2/core/log"
 	plugintypes "github.com/projecteru2/core/resource/plugins/types"
 	resourcetypes "github.com/projecteru2/core/resource/types"
@@ -18,31 +21,31 @@ func (p Plugin) CalculateDeploy(
 ) {
 	logger := log.WithFunc("resource.bandwidth.CalculateDeploy").WithField("node", nodename)
 	req := &bdtypes.WorkloadResourceRequest{}
-	if err := req.Parse(resourceRequest); err != nil {
+	if err := req.Parse(resourceRequest, p.unit); err != nil {
 		return nil, err
 	}
-	if err := req.Validate(); err != nil {
-		logger.Errorf(ctx, err, "invalid resource opts %+v", req)
+	renderer, err := p.renderer(ctx, req.Engine)
+	if err != nil {
 		return nil, err
 	}
-
-	nodeResourceInfo, err := p.doGetNodeResourceInfo(ctx, nodename)
-	if err != nil {
-		logger.WithField("node", nodename).Error(ctx, err)
+	if err := req.Validate(); err != nil {
+		logger.Errorf(ctx, err, "invalid resource opts %+v", req)
 		return nil, err
 	}
 
-	var enginesParams []*bdtypes.EngineParams
-	var workloadsResource []*bdtypes.WorkloadResource
-
-	enginesParams, workloadsResource, err = p.doAlloc(nodeResourceInfo, deployCount, req)
+	enginesParams, workloadsResource, err := p.doAllocAndIssue(ctx, nodename, deployCount, req)
 	if err != nil {
+		logger.Error(ctx, err)
 		return nil, err
 	}
 
 	epRaws := make([]resourcetypes.RawParams, 0, len(enginesParams))
 	for _, ep := range enginesParams {
-		epRaws = append(epRaws, ep.AsRawParams())
+		epRaw, err := renderer.Render(ep)
+		if err != nil {
+			return nil, err
+		}
+		epRaws = append(epRaws, epRaw)
 	}
 	wrRaws := make([]resourcetypes.RawParams, 0, len(workloadsResource))
 	for _, wr := range workloadsResource {
@@ -63,13 +66,13 @@ func (p Plugin) CalculateRealloc(
 	*plugintypes.CalculateReallocResponse, error,
 ) {
 	req := &bdtypes.WorkloadResourceRequest{}
-	if err := req.Parse(resourceRequest); err != nil {
+	if err := req.Parse(resourceRequest, p.unit); err != nil {
 		return nil, err
 	}
 	// realloc needs negative count, so don't need to validate
 
 	originResource := &bdtypes.WorkloadResource{}
-	if err := originResource.Parse(resource); err != nil {
+	if err := originResource.Parse(resource, p.unit); err != nil {
 		return nil, err
 	}
 	if err := originResource.Validate(); err != nil {
@@ -81,15 +84,33 @@ func (p Plugin) CalculateRealloc(
 		return nil, err
 	}
 
+	if originResource.NIC != "" && req.NIC != "" && req.NIC != originResource.NIC {
+		return nil, errors.Wrapf(bdtypes.ErrNoMatchedNIC, "can't move workload from nic %s to %s", originResource.NIC, req.NIC)
+	}
+	// the delta resource can't express a change of reservation between classes
+	if req.QoS != "" && req.QoSClass() != originResource.QoSClass() {
+		return nil, errors.Wrapf(bdtypes.ErrInvalidQoS, "can't change qos class from %q to %q", originResource.QoS, req.QoS)
+	}
+	nic := originResource.NIC
+	if nic == "" {
+		nic = req.NIC
+	}
+	if err := req.Resolve(nodeResourceInfo.Capacity.NIC(nic)); err != nil {
+		return nil, err
+	}
+
 	// put resources back into the resource pool
-	nodeResourceInfo.Usage.Sub(&bdtypes.NodeResource{
-		Bandwidth: originResource.Bandwidth,
-	})
+	nodeResourceInfo = nodeResourceInfo.Schedulable(time.Now())
+	nodeResourceInfo.Usage.Sub(originResource.NodeResource())
 
 	newReq := req.DeepCopy()
 	newReq.MergeFromResource(originResource)
 
-	if err = newReq.Validate(); err != nil {
+	if err := newReq.Validate(); err != nil {
+		return nil, err
+	}
+	renderer, err := p.renderer(ctx, newReq.Engine)
+	if err != nil {
 		return nil, err
 	}
 
@@ -101,36 +122,302 @@ func (p Plugin) CalculateRealloc(
 
 	engineParams := enginesParams[0]
 	newResource := workloadsResource[0]
+	// it's still the same workload, the delta resource updates its record in the ledger
+	newResource.ID = originResource.ID
 
 	deltaWorkloadResource := newResource.DeepCopy()
 	deltaWorkloadResource.Sub(originResource)
+	if err = p.checkDomains(ctx, nodename, []*bdtypes.WorkloadResource{deltaWorkloadResource}); err != nil {
+		return nil, err
+	}
+	if err = p.issue(ctx, nodename, workloadsResource, enginesParams); err != nil {
+		p.releaseDomains(ctx, nodename, operationIDs([]*bdtypes.WorkloadResource{deltaWorkloadResource}))
+		return nil, err
+	}
+	epRaw, err := renderer.Render(engineParams)
+	if err != nil {
+		return nil, err
+	}
 
 	return &plugintypes.CalculateReallocResponse{
-		EngineParams:     engineParams.AsRawParams(),
+		EngineParams:     epRaw,
 		DeltaResource:    deltaWorkloadResource.AsRawParams(),
 		WorkloadResource: newResource.AsRawParams(),
 	}, nil
 }
 
-// CalculateRemap .
-func (p Plugin) CalculateRemap(context.Context, string, map[string]plugintypes.WorkloadResource) (*plugintypes.CalculateRemapResponse, error) {
+// CalculateRemap shares the spare bandwidth of the node among workloads by raising their peaks,
+// averages are kept so every workload keeps its guarantee
+func (p Plugin) CalculateRemap(
+	ctx context.Context, nodename string,
+	workloadsResource map[string]plugintypes.WorkloadResource,
+) (
+	*plugintypes.CalculateRemapResponse, error,
+) {
+	if len(workloadsResource) == 0 {
+		return &plugintypes.CalculateRemapResponse{
+			EngineParamsMap: nil,
+		}, nil
+	}
+
+	resources := map[string]*bdtypes.WorkloadResource{}
+	for workloadID, rawParams := range workloadsResource {
+		workloadResource := &bdtypes.WorkloadResource{}
+		if err := workloadResource.Parse(rawParams, p.unit); err != nil {
+			return nil, err
+		}
+		if err := workloadResource.Validate(); err != nil {
+			return nil, err
+		}
+		resources[workloadID] = workloadResource
+	}
+
+	nodeResourceInfo, err := p.doGetNodeResourceInfo(ctx, nodename)
+	if err != nil {
+		log.WithFunc("resource.bandwidth.CalculateRemap").WithField("node", nodename).Error(ctx, err, "failed to get resource info of node")
+		return nil, err
+	}
+
+	engineParamsMap := map[string]resourcetypes.RawParams{}
+	for workloadID, engineParams := range p.doRemap(nodeResourceInfo.Schedulable(time.Now()), resources) {
+		// workloads on a node may run on different engines
+		renderer, err := p.renderer(ctx, resources[workloadID].Engine)
+		if err != nil {
+			return nil, err
+		}
+		if engineParamsMap[workloadID], err = renderer.Render(engineParams); err != nil {
+			return nil, err
+		}
+	}
 	return &plugintypes.CalculateRemapResponse{
-		EngineParamsMap: nil,
+		EngineParamsMap: engineParamsMap,
 	}, nil
 }
 
-func (p Plugin) doAlloc(_ *bdtypes.NodeResourceInfo, deployCount int, req *bdtypes.WorkloadResourceRequest) ([]*bdtypes.EngineParams, []*bdtypes.WorkloadResource, error) { //nolint
+// doAllocAndIssue allocates on the node and keeps the issued engine params for the ledger, held workloads are
+// also held until req.Hold passes, it's one compare-and-swap so parallel holds never share free bandwidth
+func (p Plugin) doAllocAndIssue(ctx context.Context, nodename string, deployCount int, req *bdtypes.WorkloadResourceRequest) ([]*bdtypes.EngineParams, []*bdtypes.WorkloadResource, error) {
+	var enginesParams []*bdtypes.EngineParams
+	var workloadsResource []*bdtypes.WorkloadResource
+	var pending []string
+	err := p.updateNodeResourceInfo(ctx, nodename, func(resourceInfo *bdtypes.NodeResourceInfo) (err error) {
+		// a try which lost the compare-and-swap allocates again, what it left pending in domains is dropped
+		p.releaseDomains(ctx, nodename, pending)
+		pending = nil
+		if err = req.Resolve(resourceInfo.Capacity.NIC(req.NIC)); err != nil {
+			return err
+		}
+		if err = req.Validate(); err != nil {
+			return err
+		}
+		now := time.Now()
+		if enginesParams, workloadsResource, err = p.doAlloc(resourceInfo.Schedulable(now), deployCount, req); err != nil {
+			return err
+		}
+		if err = p.checkDomains(ctx, nodename, workloadsResource); err != nil {
+			return err
+		}
+		pending = operationIDs(workloadsResource)
+		// issues wait for the holds to be committed
+		expiresAt := now.Add(issueTTL)
+		if req.Hold > 0 {
+			p.addHolds(resourceInfo, workloadsResource, now.Add(req.Hold))
+			if req.Hold > issueTTL {
+				expiresAt = now.Add(req.Hold)
+			}
+		}
+		addIssues(resourceInfo, workloadsResource, enginesParams, expiresAt)
+		return nil
+	})
+	if err != nil {
+		p.releaseDomains(ctx, nodename, pending)
+	}
+	return enginesParams, workloadsResource, err
+}
+
+func (p Plugin) doAlloc(resourceInfo *bdtypes.NodeResourceInfo, deployCount int, req *bdtypes.WorkloadResourceRequest) ([]*bdtypes.EngineParams, []*bdtypes.WorkloadResource, error) {
+	nics := resourceInfo.CandidateNICs(req.NIC, req.NICLabel)
+	if len(nics) == 0 {
+		return nil, nil, errors.Wrapf(bdtypes.ErrNoMatchedNIC, "nic %q, label %q", req.NIC, req.NICLabel)
+	}
+
 	enginesParams := []*bdtypes.EngineParams{}
 	workloadsResource := []*bdtypes.WorkloadResource{}
 
+	// workloads are placed one by one, so usage is updated on a copy
+	resourceInfo = resourceInfo.DeepCopy()
 	for i := 0; i < deployCount; i++ {
-		workloadsResource = append(workloadsResource, &bdtypes.WorkloadResource{
-			Bandwidth: req.Bandwidth,
-		})
-		enginesParams = append(enginesParams, &bdtypes.EngineParams{
-			Average: req.Bandwidth,
-			Peak:    req.Bandwidth * 2,
-		})
+		nic, err := p.selectNIC(resourceInfo, nics, req)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "%d workloads allocated, %d requested", i, deployCount)
+		}
+		workloadResource := p.newWorkloadResource(resourceInfo, req, nic)
+		workloadResource.ID = newAllocationID()
+		workloadResource.OperationID = newOperationID()
+		resourceInfo.Usage.Add(workloadResource.NodeResource())
+
+		engineParams := p.newEngineParams(resourceInfo, workloadResource)
+		workloadsResource = append(workloadsResource, workloadResource)
+		enginesParams = append(enginesParams, engineParams)
 	}
 	return enginesParams, workloadsResource, nil
 }
+
+// doRemap raises the peak of each workload by its share of the spare bandwidth of its NIC,
+// peaks always start from the base ones, so they shrink back once the spare bandwidth is used
+func (p Plugin) doRemap(resourceInfo *bdtypes.NodeResourceInfo, workloadsResource map[string]*bdtypes.WorkloadResource) map[string]*bdtypes.EngineParams {
+	// workloads on the same NIC share the same spare bandwidth
+	// guaranteed workloads never borrow
+	enginesParams := map[string]*bdtypes.EngineParams{}
+	pools := map[string][]string{}
+	for workloadID, workloadResource := range workloadsResource {
+		if workloadResource.QoSClass() == bdtypes.QoSGuaranteed {
+			enginesParams[workloadID] = p.newEngineParams(resourceInfo, workloadResource)
+			continue
+		}
+		pools[workloadResource.NIC] = append(pools[workloadResource.NIC], workloadID)
+	}
+
+	for nic, workloadIDs := range pools {
+		sort.Strings(workloadIDs)
+		ingressWeights := make([]float64, 0, len(workloadIDs))
+		egressWeights := make([]float64, 0, len(workloadIDs))
+		for _, workloadID := range workloadIDs {
+			ingressWeight, egressWeight := workloadsResource[workloadID].Weights()
+			ingressWeights = append(ingressWeights, ingressWeight)
+			egressWeights = append(egressWeights, egressWeight)
+		}
+
+		capacity := resourceInfo.Capacity.NIC(nic)
+		spare := resourceInfo.GetNICAllocatableResource(nic, 1)
+		ingressShares := share(spare.Ingress, ingressWeights)
+		egressShares := share(spare.Egress, egressWeights)
+		for i, workloadID := range workloadIDs {
+			// best effort workloads can't go beyond the leftover
+			ceiling := capacity
+			if workloadsResource[workloadID].QoSClass() == bdtypes.QoSBestEffort {
+				ceiling = spare
+			}
+			engineParams := p.newEngineParams(resourceInfo, workloadsResource[workloadID])
+			engineParams.Ingress = p.raiseLimit(engineParams.Ingress, ingressShares[i], ceiling.Ingress)
+			engineParams.Egress = p.raiseLimit(engineParams.Egress, egressShares[i], ceiling.Egress)
+			enginesParams[workloadID] = engineParams
+		}
+	}
+	return enginesParams
+}
+
+// newWorkloadResource returns the resource of a workload placed on nic,
+// peaks of guaranteed workloads are fixed here since they are reserved
+func (p Plugin) newWorkloadResource(resourceInfo *bdtypes.NodeResourceInfo, req *bdtypes.WorkloadResourceRequest, nic string) *bdtypes.WorkloadResource {
+	workloadResource := req.WorkloadResource(nic)
+	if workloadResource.QoSClass() != bdtypes.QoSGuaranteed {
+		return workloadResource
+	}
+	// guaranteed peaks are not cut by free capacity, the reservation fails instead
+	capacity := resourceInfo.Capacity.NIC(nic)
+	if workloadResource.IngressPeak == 0 {
+		workloadResource.IngressPeak = p.bdConfig.Peak.Peak(workloadResource.Ingress, capacity.Ingress, capacity.Ingress)
+	}
+	if workloadResource.EgressPeak == 0 {
+		workloadResource.EgressPeak = p.bdConfig.Peak.Peak(workloadResource.Egress, capacity.Egress, capacity.Egress)
+	}
+	return workloadResource
+}
+
+// newEngineParams returns the base engine params of a workload whose resource is already counted in usage,
+// peaks are the explicit ones or follow the peak policy
+func (p Plugin) newEngineParams(resourceInfo *bdtypes.NodeResourceInfo, workloadResource *bdtypes.WorkloadResource) *bdtypes.EngineParams {
+	capacity := resourceInfo.Capacity.NIC(workloadResource.NIC)
+	// free capacity includes what the workload itself reserved
+	free := resourceInfo.GetNICAllocatableResource(workloadResource.NIC, 1)
+	reserved := workloadResource.NodeResource()
+	free.Ingress += reserved.Ingress
+	free.Egress += reserved.Egress
+
+	engineParams := &bdtypes.EngineParams{NIC: workloadResource.NIC, ID: workloadResource.ID}
+	switch workloadResource.QoSClass() {
+	case bdtypes.QoSBestEffort:
+		engineParams.Ingress = p.newBestEffortLimit(workloadResource.Ingress, workloadResource.IngressPeak, capacity.Ingress, free.Ingress)
+		engineParams.Egress = p.newBestEffortLimit(workloadResource.Egress, workloadResource.EgressPeak, capacity.Egress, free.Egress)
+	default:
+		engineParams.Ingress = p.newLimit(workloadResource.Ingress, workloadResource.IngressPeak, capacity.Ingress, free.Ingress)
+		engineParams.Egress = p.newLimit(workloadResource.Egress, workloadResource.EgressPeak, capacity.Egress, free.Egress)
+	}
+	return engineParams
+}
+
+func (p Plugin) newLimit(average, peak, capacity, free int64) bdtypes.Limit {
+	if peak == 0 {
+		peak = p.bdConfig.Peak.Peak(average, capacity, free)
+	}
+	return bdtypes.Limit{
+		Average: average,
+		Peak:    peak,
+		Burst:   p.bdConfig.Peak.Burst(peak, p.unit),
+	}
+}
+
+// newBestEffortLimit guarantees nothing, peak is bounded by the free capacity,
+// which is all the workload gets if it requests nothing
+func (p Plugin) newBestEffortLimit(average, peak, capacity, free int64) bdtypes.Limit {
+	if peak == 0 && average > 0 {
+		peak = p.bdConfig.Peak.Peak(average, capacity, free)
+	}
+	if peak == 0 || peak > free {
+		peak = free
+	}
+	return bdtypes.Limit{
+		Average: 0,
+		Peak:    peak,
+		Burst:   p.bdConfig.Peak.Burst(peak, p.unit),
+	}
+}
+
+// raiseLimit raises peak by extra, but not beyond ceiling
+func (p Plugin) raiseLimit(limit bdtypes.Limit, extra, ceiling int64) bdtypes.Limit {
+	if extra <= 0 || limit.Peak >= ceiling {
+		return limit
+	}
+	limit.Peak += extra
+	if limit.Peak > ceiling {
+		limit.Peak = ceiling
+	}
+	limit.Burst = p.bdConfig.Peak.Burst(limit.Peak, p.unit)
+	return limit
+}
+
+// share splits total in proportion to weights, nothing is shared if all weights are 0
+func share(total int64, weights []float64) []int64 {
+	shares := make([]int64, len(weights))
+	sum := 0.0
+	for _, weight := range weights {
+		sum += weight
+	}
+	if total <= 0 || sum <= 0 {
+		return shares
+	}
+	for i, weight := range weights {
+		shares[i] = int64(float64(total) * weight / sum)
+	}
+	return shares
+}
+
+// selectNIC returns the least used NIC among nics which can hold req
+func (p Plugin) selectNIC(resourceInfo *bdtypes.NodeResourceInfo, nics []string, req *bdtypes.WorkloadResourceRequest) (string, error) {
+	selected, minUsage, found := "", 0.0, false
+	for _, nic := range nics {
+		need := p.newWorkloadResource(resourceInfo, req, nic).NodeResource()
+		if p.bdConfig.CapacityCheck && resourceInfo.GetNICAllocatableResource(nic, p.bdConfig.OvercommitRatio).Fit(need) < 1 {
+			continue
+		}
+		usage := resourceInfo.Usage.NIC(nic).Ratio(resourceInfo.Capacity.NIC(nic))
+		if !found || usage < minUsage {
+			selected, minUsage, found = nic, usage, true
+		}
+	}
+	if !found {
+		return "", errors.Wrapf(bdtypes.ErrNotEnoughBandwidth, "request ingress %d egress %d on nics %v", req.Ingress, req.Egress, nics)
+	}
+	return selected, nil
+}
@@ -3,6 +3,8 @@ package bandwidth
 import (
 	"context"
 	"errors"
+	"fmt"
+	"math"
 	"testing"
 
 	plugintypes "github.com/projecteru2/core/resource/plugins/types"
@@ -42,7 +44,7 @@ func TestCalculateDeploy(t *testing.T) {
 		}
 		for _, wrRaw := range d.WorkloadsResource {
 			wr := &types.WorkloadResource{}
-			err := wr.Parse(wrRaw)
+			err := wr.Parse(wrRaw, types.BitUnit)
 			assert.Nil(t, err)
 			wrs = append(wrs, wr)
 		}
@@ -57,8 +59,10 @@ func TestCalculateDeploy(t *testing.T) {
 	assert.Len(t, eParams, 4)
 	assert.Len(t, wResources, 4)
 	for i := 0; i < 4; i++ {
-		assert.Equal(t, eParams[i].Average, int64(0))
-		assert.Equal(t, wResources[i].Bandwidth, int64(0))
+		assert.Equal(t, eParams[i].Ingress.Average, int64(0))
+		assert.Equal(t, eParams[i].Egress.Average, int64(0))
+		assert.Equal(t, wResources[i].Ingress, int64(0))
+		assert.Equal(t, wResources[i].Egress, int64(0))
 	}
 	// has enough resource
 	d, err = cm.CalculateDeploy(ctx, node, 10, req)
@@ -73,6 +77,57 @@ func TestCalculateDeploy(t *testing.T) {
 	assert.Len(t, eParams, 100)
 }
 
+func TestCalculateDeployWithCapacityCheck(t *testing.T) {
+	ctx := context.Background()
+	cm := initBandwidthWithConfig(ctx, t, capacityCheckConfig(1.5))
+	nodes := generateNodes(ctx, t, cm, 1, 0)
+	node := nodes[0]
+
+	req := plugintypes.WorkloadResourceRequest{
+		"bandwidth": 10,
+	}
+	// capacity 100, overcommitted to 150
+	d, err := cm.CalculateDeploy(ctx, node, 15, req)
+	assert.Nil(t, err)
+	assert.Len(t, d.EnginesParams, 15)
+
+	_, err = cm.CalculateDeploy(ctx, node, 16, req)
+	assert.ErrorIs(t, err, types.ErrNotEnoughBandwidth)
+
+	_, err = cm.SetNodeResourceUsage(ctx, node, nil, plugintypes.NodeResource{"bandwidth": 100}, nil, false, true)
+	assert.Nil(t, err)
+	_, err = cm.CalculateDeploy(ctx, node, 6, req)
+	assert.ErrorIs(t, err, types.ErrNotEnoughBandwidth)
+	d, err = cm.CalculateDeploy(ctx, node, 5, req)
+	assert.Nil(t, err)
+	assert.Len(t, d.EnginesParams, 5)
+
+	// only egress is exhausted
+	_, err = cm.SetNodeResourceUsage(ctx, node, nil, plugintypes.NodeResource{"ingress": 0, "egress": 150}, nil, false, true)
+	assert.Nil(t, err)
+	d, err = cm.CalculateDeploy(ctx, node, 15, plugintypes.WorkloadResourceRequest{"ingress": 10})
+	assert.Nil(t, err)
+	assert.Len(t, d.EnginesParams, 15)
+	_, err = cm.CalculateDeploy(ctx, node, 1, plugintypes.WorkloadResourceRequest{"ingress": 10, "egress": 1})
+	assert.ErrorIs(t, err, types.ErrNotEnoughBandwidth)
+
+	// empty request always fits
+	d, err = cm.CalculateDeploy(ctx, node, 100, nil)
+	assert.Nil(t, err)
+	assert.Len(t, d.EnginesParams, 100)
+
+	// realloc puts the origin resource back before checking
+	_, err = cm.SetNodeResourceUsage(ctx, node, nil, plugintypes.NodeResource{"bandwidth": 100}, nil, false, true)
+	assert.Nil(t, err)
+	origin := plugintypes.WorkloadResource{
+		"bandwidth": 50,
+	}
+	_, err = cm.CalculateRealloc(ctx, node, origin, plugintypes.WorkloadResourceRequest{"bandwidth": 50})
+	assert.Nil(t, err)
+	_, err = cm.CalculateRealloc(ctx, node, origin, plugintypes.WorkloadResourceRequest{"bandwidth": 51})
+	assert.ErrorIs(t, err, types.ErrNotEnoughBandwidth)
+}
+
 func TestCalculateRealloc(t *testing.T) {
 	ctx := context.Background()
 	cm := initBandwidth(ctx, t)
@@ -102,11 +157,11 @@ func TestCalculateRealloc(t *testing.T) {
 		assert.Nil(t, err)
 
 		wr := &types.WorkloadResource{}
-		err = wr.Parse(d.WorkloadResource)
+		err = wr.Parse(d.WorkloadResource, types.BitUnit)
 		assert.Nil(t, err)
 
 		dwr := &types.WorkloadResource{}
-		err = dwr.Parse(d.DeltaResource)
+		err = dwr.Parse(d.DeltaResource, types.BitUnit)
 		assert.Nil(t, err)
 		return ep, wr, dwr
 	}
@@ -115,9 +170,12 @@ func TestCalculateRealloc(t *testing.T) {
 	d, err := cm.CalculateRealloc(ctx, node, nil, nil)
 	assert.Nil(t, err)
 	eParams, wResource, dResource := parse(d)
-	assert.Equal(t, eParams.Bandwidth(), int64(0))
-	assert.Equal(t, wResource.Bandwidth, int64(0))
-	assert.Equal(t, dResource.Bandwidth, int64(0))
+	assert.Equal(t, eParams.Ingress.Average, int64(0))
+	assert.Equal(t, eParams.Egress.Average, int64(0))
+	assert.Equal(t, wResource.Ingress, int64(0))
+	assert.Equal(t, wResource.Egress, int64(0))
+	assert.Equal(t, dResource.Ingress, int64(0))
+	assert.Equal(t, dResource.Egress, int64(0))
 	// 2. empty request
 	origin = plugintypes.WorkloadResource{
 		"bandwidth": 10,
@@ -126,11 +184,14 @@ func TestCalculateRealloc(t *testing.T) {
 	assert.Nil(t, err)
 	eParams, wResource, dResource = parse(d)
 
-	assert.Equal(t, eParams.Average, int64(10))
+	assert.Equal(t, eParams.Ingress.Average, int64(10))
+	assert.Equal(t, eParams.Egress.Average, int64(10))
 
-	assert.Equal(t, wResource.Bandwidth, int64(10))
+	assert.Equal(t, wResource.Ingress, int64(10))
+	assert.Equal(t, wResource.Egress, int64(10))
 
-	assert.Equal(t, dResource.Bandwidth, int64(0))
+	assert.Equal(t, dResource.Ingress, int64(0))
+	assert.Equal(t, dResource.Egress, int64(0))
 	// 3. overwirte resource with request
 	origin = plugintypes.WorkloadResource{
 		"bandwidth": 10,
@@ -142,9 +203,12 @@ func TestCalculateRealloc(t *testing.T) {
 	d, err = cm.CalculateRealloc(ctx, node, origin, req)
 	assert.Nil(t, err)
 	eParams, wResource, dResource = parse(d)
-	assert.Equal(t, eParams.Average, int64(30))
-	assert.Equal(t, wResource.Bandwidth, int64(30))
-	assert.Equal(t, dResource.Bandwidth, int64(20))
+	assert.Equal(t, eParams.Ingress.Average, int64(30))
+	assert.Equal(t, eParams.Egress.Average, int64(30))
+	assert.Equal(t, wResource.Ingress, int64(30))
+	assert.Equal(t, wResource.Egress, int64(30))
+	assert.Equal(t, dResource.Ingress, int64(20))
+	assert.Equal(t, dResource.Egress, int64(20))
 
 	// decrease bandwidth
 	origin = plugintypes.WorkloadResource{
@@ -158,9 +222,12 @@ func TestCalculateRealloc(t *testing.T) {
 	assert.Nil(t, err)
 	eParams, wResource, dResource = parse(d)
 
-	assert.Equal(t, eParams.Average, int64(10))
-	assert.Equal(t, wResource.Bandwidth, int64(10))
-	assert.Equal(t, dResource.Bandwidth, int64(-20))
+	assert.Equal(t, eParams.Ingress.Average, int64(10))
+	assert.Equal(t, eParams.Egress.Average, int64(10))
+	assert.Equal(t, wResource.Ingress, int64(10))
+	assert.Equal(t, wResource.Egress, int64(10))
+	assert.Equal(t, dResource.Ingress, int64(-20))
+	assert.Equal(t, dResource.Egress, int64(-20))
 	// smaller negative count
 	origin = plugintypes.WorkloadResource{
 		"bandwidth": 30,
@@ -173,9 +240,12 @@ func TestCalculateRealloc(t *testing.T) {
 	assert.Nil(t, err)
 	eParams, wResource, dResource = parse(d)
 
-	assert.Equal(t, eParams.Average, int64(0))
-	assert.Equal(t, wResource.Bandwidth, int64(0))
-	assert.Equal(t, dResource.Bandwidth, int64(-30))
+	assert.Equal(t, eParams.Ingress.Average, int64(0))
+	assert.Equal(t, eParams.Egress.Average, int64(0))
+	assert.Equal(t, wResource.Ingress, int64(0))
+	assert.Equal(t, wResource.Egress, int64(0))
+	assert.Equal(t, dResource.Ingress, int64(-30))
+	assert.Equal(t, dResource.Egress, int64(-30))
 }
 
 func TestCalculateRemap(t *testing.T) {
@@ -187,4 +257,392 @@ func TestCalculateRemap(t *testing.T) {
 
 	assert.NoError(t, err)
 	assert.Nil(t, d.EngineParamsMap)
+
+	peaks := func(d *plugintypes.CalculateRemapResponse) map[string][2]int64 {
+		res := map[string][2]int64{}
+		for workloadID, epRaw := range d.EngineParamsMap {
+			ep := &types.EngineParams{}
+			assert.Nil(t, ep.Parse(epRaw))
+			res[workloadID] = [2]int64{ep.Ingress.Peak, ep.Egress.Peak}
+		}
+		return res
+	}
+
+	workloadsResource := map[string]plugintypes.WorkloadResource{
+		"a": {"bandwidth": 10},
+		"b": {"ingress": 30, "egress": 0},
+	}
+	_, err = cm.SetNodeResourceUsage(ctx, node, nil, plugintypes.NodeResource{"ingress": 40, "egress": 10}, nil, false, true)
+	assert.Nil(t, err)
+
+	// spare 60 ingress and 90 egress are shared in proportion to the averages, peaks never exceed capacity
+	d, err = cm.CalculateRemap(ctx, node, workloadsResource)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][2]int64{"a": {35, 100}, "b": {100, 0}}, peaks(d))
+	ep := &types.EngineParams{}
+	assert.Nil(t, ep.Parse(d.EngineParamsMap["b"]))
+	assert.Equal(t, int64(30), ep.Ingress.Average)
+
+	// explicit weights
+	workloadsResource["a"]["weight"] = 1
+	workloadsResource["b"]["weight"] = 2
+	d, err = cm.CalculateRemap(ctx, node, workloadsResource)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][2]int64{"a": {40, 50}, "b": {100, 60}}, peaks(d))
+
+	// peaks shrink back when the spare bandwidth is used
+	_, err = cm.SetNodeResourceUsage(ctx, node, nil, plugintypes.NodeResource{"ingress": 100, "egress": 90}, nil, false, true)
+	assert.Nil(t, err)
+	d, err = cm.CalculateRemap(ctx, node, workloadsResource)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][2]int64{"a": {20, 23}, "b": {60, 6}}, peaks(d))
+
+	_, err = cm.CalculateRemap(ctx, node, map[string]plugintypes.WorkloadResource{"a": {"bandwidth": -1}})
+	assert.ErrorIs(t, err, types.ErrInvalidBandwidth)
+	_, err = cm.CalculateRemap(ctx, "xxx", workloadsResource)
+	assert.ErrorIs(t, err, coretypes.ErrNodeNotExists)
+}
+
+func TestCalculateRemapWithNICs(t *testing.T) {
+	ctx := context.Background()
+	cm := initBandwidth(ctx, t)
+	node := "test-nics"
+	_, err := cm.AddNode(ctx, node, plugintypes.NodeResourceRequest{
+		"nics": map[string]any{
+			"eth0": map[string]any{"bandwidth": 100},
+			"eth1": map[string]any{"bandwidth": 50},
+		},
+	}, nil)
+	assert.Nil(t, err)
+	t.Cleanup(func() {
+		_, err := cm.RemoveNode(ctx, node)
+		assert.Nil(t, err)
+	})
+
+	workloadsResource := map[string]plugintypes.WorkloadResource{
+		"a": {"bandwidth": 10, "nic": "eth0"},
+		"b": {"bandwidth": 10, "nic": "eth0"},
+		"c": {"bandwidth": 10, "nic": "eth1"},
+	}
+	_, err = cm.SetNodeResourceUsage(ctx, node, nil, nil, []plugintypes.WorkloadResource{
+		workloadsResource["a"], workloadsResource["b"], workloadsResource["c"],
+	}, false, true)
+	assert.Nil(t, err)
+
+	// each nic shares its own spare bandwidth
+	d, err := cm.CalculateRemap(ctx, node, workloadsResource)
+	assert.Nil(t, err)
+	for workloadID, peak := range map[string]int64{"a": 60, "b": 60, "c": 50} {
+		ep := &types.EngineParams{}
+		assert.Nil(t, ep.Parse(d.EngineParamsMap[workloadID]))
+		assert.Equal(t, peak, ep.Ingress.Peak, workloadID)
+		assert.Equal(t, workloadsResource[workloadID]["nic"], ep.NIC)
+	}
+}
+
+func TestCalculateDeployWithNICs(t *testing.T) {
+	ctx := context.Background()
+	cm := initBandwidthWithConfig(ctx, t, capacityCheckConfig(1))
+	node := "test-nics"
+	_, err := cm.AddNode(ctx, node, plugintypes.NodeResourceRequest{
+		"nics": map[string]any{
+			"eth0": map[string]any{"bandwidth": 100, "labels": []string{"storage-net"}},
+			"eth1": map[string]any{"bandwidth": 50},
+		},
+	}, nil)
+	assert.Nil(t, err)
+	t.Cleanup(func() {
+		_, err := cm.RemoveNode(ctx, node)
+		assert.Nil(t, err)
+	})
+
+	// spread to the least used nic
+	d, err := cm.CalculateDeploy(ctx, node, 3, plugintypes.WorkloadResourceRequest{"bandwidth": 30})
+	assert.Nil(t, err)
+	nics := []string{}
+	for _, wrRaw := range d.WorkloadsResource {
+		wr := &types.WorkloadResource{}
+		assert.Nil(t, wr.Parse(wrRaw, types.BitUnit))
+		nics = append(nics, wr.NIC)
+	}
+	assert.Equal(t, []string{"eth0", "eth1", "eth0"}, nics)
+	for i, epRaw := range d.EnginesParams {
+		ep := &types.EngineParams{}
+		assert.Nil(t, ep.Parse(epRaw))
+		assert.Equal(t, nics[i], ep.NIC)
+	}
+
+	// 3 on eth0 and 1 on eth1
+	_, err = cm.CalculateDeploy(ctx, node, 5, plugintypes.WorkloadResourceRequest{"bandwidth": 30})
+	assert.ErrorIs(t, err, types.ErrNotEnoughBandwidth)
+
+	// pinned by name
+	d, err = cm.CalculateDeploy(ctx, node, 1, plugintypes.WorkloadResourceRequest{"bandwidth": 30, "nic": "eth1"})
+	assert.Nil(t, err)
+	assert.Equal(t, "eth1", d.WorkloadsResource[0]["nic"])
+	_, err = cm.CalculateDeploy(ctx, node, 2, plugintypes.WorkloadResourceRequest{"bandwidth": 30, "nic": "eth1"})
+	assert.ErrorIs(t, err, types.ErrNotEnoughBandwidth)
+
+	// pinned by label
+	d, err = cm.CalculateDeploy(ctx, node, 3, plugintypes.WorkloadResourceRequest{"bandwidth": 30, "nic_label": "storage-net"})
+	assert.Nil(t, err)
+	for _, wrRaw := range d.WorkloadsResource {
+		assert.Equal(t, "eth0", wrRaw["nic"])
+	}
+
+	_, err = cm.CalculateDeploy(ctx, node, 1, plugintypes.WorkloadResourceRequest{"bandwidth": 30, "nic": "eth2"})
+	assert.ErrorIs(t, err, types.ErrNoMatchedNIC)
+	_, err = cm.CalculateDeploy(ctx, node, 1, plugintypes.WorkloadResourceRequest{"bandwidth": 30, "nic_label": "public"})
+	assert.ErrorIs(t, err, types.ErrNoMatchedNIC)
+
+	// node without nics can't satisfy a nic filter
+	nodes := generateNodes(ctx, t, cm, 1, 0)
+	_, err = cm.CalculateDeploy(ctx, nodes[0], 1, plugintypes.WorkloadResourceRequest{"bandwidth": 30, "nic": "eth0"})
+	assert.ErrorIs(t, err, types.ErrNoMatchedNIC)
+
+	// realloc stays on the origin nic
+	_, err = cm.SetNodeResourceUsage(ctx, node, nil, nil, []plugintypes.WorkloadResource{{"bandwidth": 40, "nic": "eth1"}}, false, true)
+	assert.Nil(t, err)
+	origin := plugintypes.WorkloadResource{"bandwidth": 40, "nic": "eth1"}
+	r, err := cm.CalculateRealloc(ctx, node, origin, plugintypes.WorkloadResourceRequest{"bandwidth": 10})
+	assert.Nil(t, err)
+	assert.Equal(t, "eth1", r.WorkloadResource["nic"])
+	assert.Equal(t, "eth1", r.EngineParams["nic"])
+	_, err = cm.CalculateRealloc(ctx, node, origin, plugintypes.WorkloadResourceRequest{"bandwidth": 11})
+	assert.ErrorIs(t, err, types.ErrNotEnoughBandwidth)
+	_, err = cm.CalculateRealloc(ctx, node, origin, plugintypes.WorkloadResourceRequest{"nic": "eth0"})
+	assert.ErrorIs(t, err, types.ErrNoMatchedNIC)
+}
+
+func TestCalculateDeployWithUnits(t *testing.T) {
+	ctx := context.Background()
+	bdConfig := capacityCheckConfig(1)
+	bdConfig.Unit = "Mbps"
+	cm := initBandwidthWithConfig(ctx, t, bdConfig)
+	node := "test-units"
+	r, err := cm.AddNode(ctx, node, plugintypes.NodeResourceRequest{"bandwidth": "1Gbps"}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1000), r.Capacity["ingress"])
+	t.Cleanup(func() {
+		_, err := cm.RemoveNode(ctx, node)
+		assert.Nil(t, err)
+	})
+
+	d, err := cm.CalculateDeploy(ctx, node, 10, plugintypes.WorkloadResourceRequest{"bandwidth": "100Mbps"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(100), d.WorkloadsResource[0]["ingress"])
+	_, err = cm.CalculateDeploy(ctx, node, 11, plugintypes.WorkloadResourceRequest{"bandwidth": "100Mbps"})
+	assert.ErrorIs(t, err, types.ErrNotEnoughBandwidth)
+
+	// percentage of the node capacity
+	d, err = cm.CalculateDeploy(ctx, node, 4, plugintypes.WorkloadResourceRequest{"ingress": "25%", "egress": "12.5MB/s"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(250), d.WorkloadsResource[0]["ingress"])
+	assert.Equal(t, int64(100), d.WorkloadsResource[0]["egress"])
+	_, err = cm.CalculateDeploy(ctx, node, 5, plugintypes.WorkloadResourceRequest{"bandwidth": "25%"})
+	assert.ErrorIs(t, err, types.ErrNotEnoughBandwidth)
+	_, err = cm.CalculateDeploy(ctx, node, 1, plugintypes.WorkloadResourceRequest{"bandwidth": "-25%"})
+	assert.ErrorIs(t, err, types.ErrInvalidBandwidth)
+	_, err = cm.CalculateDeploy(ctx, node, 1, plugintypes.WorkloadResourceRequest{"bandwidth": "1Gbpx"})
+	assert.ErrorIs(t, err, types.ErrInvalidBandwidth)
+
+	c, err := cm.GetNodesDeployCapacity(ctx, []string{node}, plugintypes.WorkloadResourceRequest{"bandwidth": "30%"})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, c.Total)
+
+	// realloc by percentage
+	origin := plugintypes.WorkloadResource{"bandwidth": 100}
+	rr, err := cm.CalculateRealloc(ctx, node, origin, plugintypes.WorkloadResourceRequest{"bandwidth": "10%"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(200), rr.WorkloadResource["ingress"])
+	assert.Equal(t, int64(100), rr.DeltaResource["ingress"])
+	rr, err = cm.CalculateRealloc(ctx, node, origin, plugintypes.WorkloadResourceRequest{"bandwidth": "-5%"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(50), rr.WorkloadResource["ingress"])
+
+	// the node is kept in Mbps, a plugin in another unit converts it and rewrites it in its unit on the next update
+	other, err := NewPluginWithStore(ctx, cm.config, capacityCheckConfig(1), cm.store)
+	assert.Nil(t, err)
+	info, err := other.doGetNodeResourceInfo(ctx, node)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1e9), info.Capacity.Ingress)
+	c, err = other.GetNodesDeployCapacity(ctx, []string{node}, plugintypes.WorkloadResourceRequest{"bandwidth": "100Mbps"})
+	assert.Nil(t, err)
+	assert.Equal(t, 10, c.Total)
+	_, err = other.SetNodeResourceUsage(ctx, node, nil, nil, []plugintypes.WorkloadResource{{"bandwidth": "100Mbps"}}, true, true)
+	assert.Nil(t, err)
+	data, _, err := cm.store.Get(ctx, fmt.Sprintf(nodeResourceInfoKey, node))
+	assert.Nil(t, err)
+	assert.Contains(t, string(data), `"unit":"`+types.DefaultUnit+`"`)
+	info, err = cm.doGetNodeResourceInfo(ctx, node)
+	assert.Nil(t, err)
+	assert.Equal(t, types.NewNodeResource(1000, 1000), info.Capacity)
+	assert.Equal(t, types.NewNodeResource(100, 100), info.Usage)
+	bdConfig = capacityCheckConfig(1)
+	bdConfig.Unit = "Mbit"
+	other, err = NewPluginWithStore(ctx, cm.config, bdConfig, cm.store)
+	assert.Nil(t, err)
+	_, err = other.GetNodeResourceInfo(ctx, node, nil)
+	assert.Nil(t, err)
+
+	// nodes written before the unit was kept are in the unit of the plugin, so is their reservation
+	legacy := "test-units-legacy"
+	assert.Nil(t, cm.store.Put(ctx, fmt.Sprintf(nodeResourceInfoKey, legacy), []byte(`{"capacity":{"bandwidth":1000},"usage":{"bandwidth":0},"reserved":{"bandwidth":"100Mbps"}}`)))
+	t.Cleanup(func() {
+		_, err := cm.RemoveNode(ctx, legacy)
+		assert.Nil(t, err)
+	})
+	c, err = cm.GetNodesDeployCapacity(ctx, []string{legacy}, plugintypes.WorkloadResourceRequest{"bandwidth": "100Mbps"})
+	assert.Nil(t, err)
+	assert.Equal(t, 9, c.Total)
+}
+
+func TestCalculateDeployPeak(t *testing.T) {
+	ctx := context.Background()
+	limits := func(d *plugintypes.CalculateDeployResponse) []*types.EngineParams {
+		eps := []*types.EngineParams{}
+		for _, epRaw := range d.EnginesParams {
+			ep := &types.EngineParams{}
+			assert.Nil(t, ep.Parse(epRaw))
+			eps = append(eps, ep)
+		}
+		return eps
+	}
+
+	// default ratio policy
+	cm := initBandwidth(ctx, t)
+	node := generateNodes(ctx, t, cm, 1, 0)[0]
+	d, err := cm.CalculateDeploy(ctx, node, 1, plugintypes.WorkloadResourceRequest{"ingress": 10, "egress": 8000})
+	assert.Nil(t, err)
+	ep := limits(d)[0]
+	assert.Equal(t, types.Limit{Average: 10, Peak: 20, Burst: 1}, ep.Ingress)
+	assert.Equal(t, types.Limit{Average: 8000, Peak: 16000, Burst: 200}, ep.Egress)
+
+	// explicit peak
+	d, err = cm.CalculateDeploy(ctx, node, 1, plugintypes.WorkloadResourceRequest{"bandwidth": 10, "peak": 50})
+	assert.Nil(t, err)
+	ep = limits(d)[0]
+	assert.Equal(t, int64(50), ep.Ingress.Peak)
+	assert.Equal(t, int64(50), ep.Egress.Peak)
+	assert.Equal(t, int64(50), d.WorkloadsResource[0]["ingress_peak"])
+	_, err = cm.CalculateDeploy(ctx, node, 1, plugintypes.WorkloadResourceRequest{"bandwidth": 10, "peak": 5})
+	assert.ErrorIs(t, err, types.ErrInvalidBandwidth)
+
+	// explicit peak is kept by realloc
+	r, err := cm.CalculateRealloc(ctx, node, d.WorkloadsResource[0], plugintypes.WorkloadResourceRequest{"bandwidth": 10})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(50), r.EngineParams["ingress"].(map[string]any)["peak"])
+	_, err = cm.CalculateRealloc(ctx, node, d.WorkloadsResource[0], plugintypes.WorkloadResourceRequest{"bandwidth": 50})
+	assert.ErrorIs(t, err, types.ErrInvalidBandwidth)
+
+	// capacity policy
+	bdConfig := types.DefaultConfig()
+	bdConfig.Peak.Mode = types.PeakCapacity
+	cm = initBandwidthWithConfig(ctx, t, bdConfig)
+	node = generateNodes(ctx, t, cm, 1, 1)[0]
+	d, err = cm.CalculateDeploy(ctx, node, 1, plugintypes.WorkloadResourceRequest{"bandwidth": 10})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(100), limits(d)[0].Ingress.Peak)
+
+	// free policy, each workload gets less as the node fills up
+	bdConfig.Peak.Mode = types.PeakFree
+	bdConfig.Peak.Ratio = 3
+	cm = initBandwidthWithConfig(ctx, t, bdConfig)
+	node = generateNodes(ctx, t, cm, 1, 2)[0]
+	_, err = cm.SetNodeResourceUsage(ctx, node, nil, plugintypes.NodeResource{"bandwidth": 50}, nil, false, true)
+	assert.Nil(t, err)
+	d, err = cm.CalculateDeploy(ctx, node, 3, plugintypes.WorkloadResourceRequest{"bandwidth": 20})
+	assert.Nil(t, err)
+	peaks := []int64{}
+	for _, ep := range limits(d) {
+		peaks = append(peaks, ep.Ingress.Peak)
+	}
+	assert.Equal(t, []int64{50, 30, 20}, peaks)
+}
+
+func TestCalculateDeployQoS(t *testing.T) {
+	ctx := context.Background()
+	cm := initBandwidthWithConfig(ctx, t, capacityCheckConfig(1))
+	node := generateNodes(ctx, t, cm, 1, 0)[0]
+
+	guaranteed := plugintypes.WorkloadResourceRequest{"bandwidth": 20, "qos": types.QoSGuaranteed}
+	burstable := plugintypes.WorkloadResourceRequest{"bandwidth": 20}
+	bestEffort := plugintypes.WorkloadResourceRequest{"bandwidth": 20, "qos": types.QoSBestEffort}
+
+	// guaranteed workloads reserve their peaks
+	c, err := cm.GetNodesDeployCapacity(ctx, []string{node}, guaranteed)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, c.Total)
+	assert.Equal(t, 0.4, c.NodeDeployCapacityMap[node].Rate)
+	c, err = cm.GetNodesDeployCapacity(ctx, []string{node}, burstable)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, c.Total)
+	c, err = cm.GetNodesDeployCapacity(ctx, []string{node}, bestEffort)
+	assert.Nil(t, err)
+	assert.Equal(t, math.MaxInt, c.Total)
+
+	d, err := cm.CalculateDeploy(ctx, node, 2, guaranteed)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(40), d.WorkloadsResource[0]["ingress_peak"])
+	assert.Equal(t, types.QoSGuaranteed, d.WorkloadsResource[0]["qos"])
+	_, err = cm.CalculateDeploy(ctx, node, 3, guaranteed)
+	assert.ErrorIs(t, err, types.ErrNotEnoughBandwidth)
+	_, err = cm.CalculateDeploy(ctx, node, 1, plugintypes.WorkloadResourceRequest{"bandwidth": 20, "qos": "xxx"})
+	assert.ErrorIs(t, err, types.ErrInvalidQoS)
+
+	// best effort workloads are not counted in usage
+	guaranteedResource := d.WorkloadsResource[0]
+	bestEffortResource := plugintypes.WorkloadResource{"bandwidth": 20, "qos": types.QoSBestEffort}
+	r, err := cm.SetNodeResourceUsage(ctx, node, nil, nil, []plugintypes.WorkloadResource{guaranteedResource, bestEffortResource}, false, true)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(40), r.After["ingress"])
+
+	// best effort workloads only get the leftover
+	d, err = cm.CalculateDeploy(ctx, node, 1, plugintypes.WorkloadResourceRequest{"qos": types.QoSBestEffort})
+	assert.Nil(t, err)
+	ep := &types.EngineParams{}
+	assert.Nil(t, ep.Parse(d.EnginesParams[0]))
+	assert.Equal(t, types.Limit{Average: 0, Peak: 60, Burst: 1}, ep.Ingress)
+	d, err = cm.CalculateDeploy(ctx, node, 1, bestEffort)
+	assert.Nil(t, err)
+	assert.Nil(t, ep.Parse(d.EnginesParams[0]))
+	assert.Equal(t, int64(0), ep.Ingress.Average)
+	assert.Equal(t, int64(40), ep.Ingress.Peak)
+
+	// guaranteed workloads never borrow, the others share the spare bandwidth
+	rd, err := cm.CalculateRemap(ctx, node, map[string]plugintypes.WorkloadResource{
+		"guaranteed":  guaranteedResource,
+		"best-effort": bestEffortResource,
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, ep.Parse(rd.EngineParamsMap["guaranteed"]))
+	assert.Equal(t, types.Limit{Average: 20, Peak: 40, Burst: 1}, ep.Ingress)
+	assert.Nil(t, ep.Parse(rd.EngineParamsMap["best-effort"]))
+	assert.Equal(t, int64(60), ep.Ingress.Peak)
+
+	// realloc keeps the class and the reservation
+	rr, err := cm.CalculateRealloc(ctx, node, guaranteedResource, plugintypes.WorkloadResourceRequest{"peak": 10})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(50), rr.WorkloadResource["ingress_peak"])
+	assert.Equal(t, int64(10), rr.DeltaResource["ingress_peak"])
+	_, err = cm.CalculateRealloc(ctx, node, guaranteedResource, plugintypes.WorkloadResourceRequest{"qos": types.QoSBurstable})
+	assert.ErrorIs(t, err, types.ErrInvalidQoS)
+	// the default class is burstable, naming it is no change
+	defaultResource := plugintypes.WorkloadResource{"bandwidth": 20}
+	rr, err = cm.CalculateRealloc(ctx, node, defaultResource, plugintypes.WorkloadResourceRequest{"bandwidth": 5, "qos": types.QoSBurstable})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(25), rr.WorkloadResource["ingress"])
+	_, err = cm.CalculateRealloc(ctx, node, defaultResource, plugintypes.WorkloadResourceRequest{"qos": types.QoSGuaranteed})
+	assert.ErrorIs(t, err, types.ErrInvalidQoS)
+
+	md, err := cm.GetMetrics(ctx, "testpod", node)
+	assert.Nil(t, err)
+	for _, mt := range *md {
+		switch mt.Name {
+		case "bandwidth_ingress_used":
+			assert.Equal(t, "40", mt.Value)
+		case "bandwidth_ingress_free":
+			assert.Equal(t, "60", mt.Value)
+		}
+	}
 }
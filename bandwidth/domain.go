@@ -0,0 +1,349 @@
+package bandwidth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/projecteru2/core/log"
+	plugintypes "github.com/projecteru2/core/resource/plugins/types"
+	bdtypes "github.com/yuyang0/resource-bandwidth/bandwidth/types"
+)
+
+// getDomainsUsage sums up usage, active holds and pending bandwidth of the nodes in each domain,
+// nodes not added yet are skipped. All the nodes are read at once, so are the records of domains if records is nil.
+// Pending bandwidth of the operations in skip or of active holds is left out, it's counted already.
+func (p Plugin) getDomainsUsage(
+	ctx context.Context, domains []*bdtypes.Domain,
+	records map[string]*bdtypes.DomainRecord, skip map[string]bool, now time.Time,
+) (map[string]*bdtypes.NodeResource, error) {
+	nodenames := []string{}
+	seen := map[string]bool{}
+	for _, domain := range domains {
+		for _, nodename := range p.domains.Nodes(domain.Name) {
+			if !seen[nodename] {
+				seen[nodename] = true
+				nodenames = append(nodenames, nodename)
+			}
+		}
+	}
+	nodesResourceInfo, err := p.doGetExistingNodesResourceInfo(ctx, nodenames)
+	if err != nil {
+		return nil, err
+	}
+	if records == nil {
+		if records, err = p.doGetDomainRecords(ctx, domains); err != nil {
+			return nil, err
+		}
+	}
+	counted := map[string]bool{}
+	for id := range skip {
+		counted[id] = true
+	}
+	for _, resourceInfo := range nodesResourceInfo {
+		for _, hold := range resourceInfo.Holds {
+			if !hold.Expired(now) && hold.Resource.OperationID != "" {
+				counted[hold.Resource.OperationID] = true
+			}
+		}
+	}
+
+	usages := map[string]*bdtypes.NodeResource{}
+	for _, domain := range domains {
+		usage := bdtypes.NewNodeResource(0, 0)
+		for _, nodename := range p.domains.Nodes(domain.Name) {
+			if resourceInfo, ok := nodesResourceInfo[nodename]; ok {
+				usage.Add(resourceInfo.WithHolds(now).Usage.NIC(""))
+			}
+		}
+		if record, ok := records[domain.Name]; ok {
+			usage.Add(record.PendingResource(now, counted))
+		}
+		usages[domain.Name] = usage
+	}
+	return usages, nil
+}
+
+// checkDomains returns ErrNotEnoughBandwidth if workloadsResource don't fit in a domain enclosing the node,
+// otherwise their bandwidth is pending in the domains until they are committed or issueTTL passes.
+// Records of the domains are written by compare-and-swap, so calculations on other nodes of the domains
+// in between make it check again with their pending bandwidth.
+func (p Plugin) checkDomains(ctx context.Context, nodename string, workloadsResource []*bdtypes.WorkloadResource) error {
+	path := p.domains.Path(nodename)
+	if !p.bdConfig.CapacityCheck || len(path) == 0 {
+		return nil
+	}
+	need := sumWorkloadsResource(workloadsResource)
+	// a try which lost some compare-and-swap may have left its own pending bandwidth
+	own := map[string]bool{}
+	for _, workloadResource := range workloadsResource {
+		own[workloadResource.OperationID] = true
+	}
+	return retryCAS(ctx, "domains of node "+nodename, func() (bool, error) {
+		now := time.Now()
+		records, revs, err := p.doGetDomainRecordsWithRevision(ctx, path)
+		if err != nil {
+			return false, err
+		}
+		usages, err := p.getDomainsUsage(ctx, path, records, own, now)
+		if err != nil {
+			return false, err
+		}
+		for _, domain := range path {
+			if domain.Allocatable(usages[domain.Name], p.bdConfig.OvercommitRatio).Fit(need) < 1 {
+				return false, errors.Wrapf(bdtypes.ErrNotEnoughBandwidth, "domain %s, request ingress %d egress %d", domain.Name, need.Ingress, need.Egress)
+			}
+		}
+		for _, domain := range path {
+			record := records[domain.Name]
+			record.Prune(now)
+			for _, workloadResource := range workloadsResource {
+				if workloadResource.OperationID == "" {
+					continue
+				}
+				record.Pending[workloadResource.OperationID] = &bdtypes.DomainPending{
+					Node:      nodename,
+					Resource:  pendingResource(workloadResource),
+					ExpiresAt: now.Add(issueTTL),
+				}
+			}
+			if ok, err := p.doUpdateDomainRecord(ctx, domain.Name, record, revs[domain.Name]); err != nil || !ok {
+				return false, err
+			}
+		}
+		return true, nil
+	})
+}
+
+// releaseDomains drops the pending bandwidth of the operations from the domains enclosing the node,
+// it's best-effort since pending bandwidth expires anyway
+func (p Plugin) releaseDomains(ctx context.Context, nodename string, ids []string) {
+	path := p.domains.Path(nodename)
+	if !p.bdConfig.CapacityCheck || len(path) == 0 || len(ids) == 0 {
+		return
+	}
+	for _, domain := range path {
+		err := retryCAS(ctx, "domain "+domain.Name, func() (bool, error) {
+			records, revs, err := p.doGetDomainRecordsWithRevision(ctx, []*bdtypes.Domain{domain})
+			if err != nil {
+				return false, err
+			}
+			record, released := records[domain.Name], false
+			for _, id := range ids {
+				if _, ok := record.Pending[id]; ok {
+					delete(record.Pending, id)
+					released = true
+				}
+			}
+			if !released {
+				return true, nil
+			}
+			record.Prune(time.Now())
+			return p.doUpdateDomainRecord(ctx, domain.Name, record, revs[domain.Name])
+		})
+		if err != nil {
+			log.WithFunc("resource.bandwidth.releaseDomains").WithField("domain", domain.Name).Error(ctx, err, "failed to release pending bandwidth")
+		}
+	}
+}
+
+// doGetDomainRecords reads the records of domains at once, domains without record get an empty one
+func (p Plugin) doGetDomainRecords(ctx context.Context, domains []*bdtypes.Domain) (map[string]*bdtypes.DomainRecord, error) {
+	keys := []string{}
+	for _, domain := range domains {
+		keys = append(keys, fmt.Sprintf(domainRecordKey, domain.Name))
+	}
+	values, err := p.store.GetExisting(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	records := map[string]*bdtypes.DomainRecord{}
+	for _, domain := range domains {
+		if records[domain.Name], err = p.unmarshalDomainRecord(values[fmt.Sprintf(domainRecordKey, domain.Name)]); err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+// doGetDomainRecordsWithRevision reads the records of domains with their revisions,
+// domains without record get an empty one of revision 0
+func (p Plugin) doGetDomainRecordsWithRevision(ctx context.Context, domains []*bdtypes.Domain) (map[string]*bdtypes.DomainRecord, map[string]int64, error) {
+	records := map[string]*bdtypes.DomainRecord{}
+	revs := map[string]int64{}
+	for _, domain := range domains {
+		value, rev, err := p.store.Get(ctx, fmt.Sprintf(domainRecordKey, domain.Name))
+		if err != nil {
+			return nil, nil, err
+		}
+		if records[domain.Name], err = p.unmarshalDomainRecord(value); err != nil {
+			return nil, nil, err
+		}
+		revs[domain.Name] = rev
+	}
+	return records, revs, nil
+}
+
+// doUpdateDomainRecord writes the record of the domain only if its revision is still rev
+func (p Plugin) doUpdateDomainRecord(ctx context.Context, name string, record *bdtypes.DomainRecord, rev int64) (bool, error) {
+	record.Unit = p.unitName()
+	data, err := json.Marshal(record)
+	if err != nil {
+		return false, err
+	}
+	return p.store.CompareAndSwap(ctx, fmt.Sprintf(domainRecordKey, name), data, rev)
+}
+
+// unmarshalDomainRecord converts a record kept in another unit to the unit of the plugin, nil value is an empty record
+func (p Plugin) unmarshalDomainRecord(value []byte) (*bdtypes.DomainRecord, error) {
+	record := &bdtypes.DomainRecord{}
+	if value != nil {
+		if err := json.Unmarshal(value, record); err != nil {
+			return nil, err
+		}
+	}
+	if record.Pending == nil {
+		record.Pending = map[string]*bdtypes.DomainPending{}
+	}
+	if record.Unit == "" {
+		return record, nil
+	}
+	unit, err := bdtypes.ParseUnit(record.Unit)
+	if err != nil {
+		return nil, err
+	}
+	if unit != p.unit {
+		record.Convert(unit, p.unit)
+	}
+	return record, nil
+}
+
+// limitByDomains caps the deploy capacity of each node by the domains enclosing it and returns the total,
+// nodes in the same domain share its capacity, needs are what one workload reserves on each node
+func (p Plugin) limitByDomains(
+	ctx context.Context,
+	capacityMap map[string]*plugintypes.NodeDeployCapacity,
+	needs map[string]*bdtypes.NodeResource,
+) (int, error) {
+	paths := map[string][]*bdtypes.Domain{}
+	domains := []*bdtypes.Domain{}
+	seen := map[string]bool{}
+	for nodename := range capacityMap {
+		paths[nodename] = p.domains.Path(nodename)
+		for _, domain := range paths[nodename] {
+			if !seen[domain.Name] {
+				seen[domain.Name] = true
+				domains = append(domains, domain)
+			}
+		}
+	}
+	usages, err := p.getDomainsUsage(ctx, domains, nil, nil, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	// the largest need of the nodes in a domain decides how many workloads it can hold
+	domainsNeed := map[string]*bdtypes.NodeResource{}
+	domainsSum := map[string]int{}
+	total := 0
+	for nodename, capacityInfo := range capacityMap {
+		need := needs[nodename]
+		for _, domain := range paths[nodename] {
+			capacityInfo.Capacity = minInt(capacityInfo.Capacity, domain.Allocatable(usages[domain.Name], p.bdConfig.OvercommitRatio).Fit(need))
+			domainsNeed[domain.Name] = maxResource(domainsNeed[domain.Name], need)
+		}
+		if capacityInfo.Capacity <= 0 {
+			delete(capacityMap, nodename)
+			continue
+		}
+		if len(paths[nodename]) == 0 {
+			total = addCapacity(total, capacityInfo.Capacity)
+		} else {
+			domainsSum[paths[nodename][0].Name] = addCapacity(domainsSum[paths[nodename][0].Name], capacityInfo.Capacity)
+		}
+	}
+
+	// roll sums up from the innermost domains, each level is bounded by its own capacity
+	for len(domainsSum) > 0 {
+		for _, domain := range domains {
+			sum, ok := domainsSum[domain.Name]
+			if !ok || p.hasPendingChild(domain, domainsSum) {
+				continue
+			}
+			delete(domainsSum, domain.Name)
+			sum = minInt(sum, domain.Allocatable(usages[domain.Name], p.bdConfig.OvercommitRatio).Fit(domainsNeed[domain.Name]))
+			if domain.Parent == "" {
+				total = addCapacity(total, sum)
+			} else {
+				domainsSum[domain.Parent] = addCapacity(domainsSum[domain.Parent], sum)
+			}
+		}
+	}
+	return total, nil
+}
+
+// hasPendingChild returns true if a domain under domain still has a sum to roll up
+func (p Plugin) hasPendingChild(domain *bdtypes.Domain, domainsSum map[string]int) bool {
+	for name := range domainsSum {
+		for d := p.domains[name]; d != nil && d.Parent != ""; d = p.domains[d.Parent] {
+			if d.Parent == domain.Name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// maxResource returns the larger one of each direction, r1 can be nil
+func maxResource(r1, r2 *bdtypes.NodeResource) *bdtypes.NodeResource {
+	if r1 == nil {
+		return r2
+	}
+	res := r1.DeepCopy()
+	if r2.Ingress > res.Ingress {
+		res.Ingress = r2.Ingress
+	}
+	if r2.Egress > res.Egress {
+		res.Egress = r2.Egress
+	}
+	return res
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// addCapacity adds capacities, it stays math.MaxInt once overflowed
+func addCapacity(a, b int) int {
+	if a > math.MaxInt-b {
+		return math.MaxInt
+	}
+	return a + b
+}
+
+// pendingResource returns the node totals workloadResource reserves, a shrinking one reserves nothing until it's committed
+func pendingResource(workloadResource *bdtypes.WorkloadResource) *bdtypes.NodeResource {
+	res := workloadResource.NodeResource().NIC("")
+	if res.Ingress < 0 {
+		res.Ingress = 0
+	}
+	if res.Egress < 0 {
+		res.Egress = 0
+	}
+	return res
+}
+
+// sumWorkloadsResource sums up the node totals reserved by workloadsResource
+func sumWorkloadsResource(workloadsResource []*bdtypes.WorkloadResource) *bdtypes.NodeResource {
+	res := bdtypes.NewNodeResource(0, 0)
+	for _, workloadResource := range workloadsResource {
+		res.Add(workloadResource.NodeResource().NIC(""))
+	}
+	return res
+}
@@ -0,0 +1,151 @@
+package bandwidth
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	plugintypes "github.com/projecteru2/core/resource/plugins/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/yuyang0/resource-bandwidth/bandwidth/types"
+)
+
+func TestDomains(t *testing.T) {
+	ctx := context.Background()
+	bdConfig := capacityCheckConfig(1)
+	bdConfig.Domains = []types.DomainConfig{
+		{Name: "rack1", Level: "rack", Parent: "row1", Capacity: map[string]any{"bandwidth": 150}, Nodes: []string{"test0", "test1", "test9"}},
+		{Name: "rack2", Level: "rack", Parent: "row1", Capacity: map[string]any{"bandwidth": 80}, Nodes: []string{"test2"}},
+		{Name: "row1", Level: "row", Capacity: map[string]any{"ingress": 200, "egress": 400}},
+	}
+	cm := initBandwidthWithConfig(ctx, t, bdConfig)
+	nodes := generateNodes(ctx, t, cm, 4, 0)
+	req := plugintypes.WorkloadResourceRequest{"bandwidth": 10}
+
+	// nodes share the capacity of their rack and row, test3 is in no domain
+	r, err := cm.GetNodesDeployCapacity(ctx, nodes, req)
+	assert.Nil(t, err)
+	assert.Equal(t, 10, r.NodeDeployCapacityMap["test0"].Capacity)
+	assert.Equal(t, 10, r.NodeDeployCapacityMap["test1"].Capacity)
+	assert.Equal(t, 8, r.NodeDeployCapacityMap["test2"].Capacity)
+	assert.Equal(t, 10, r.NodeDeployCapacityMap["test3"].Capacity)
+	assert.Equal(t, 30, r.Total)
+
+	_, err = cm.SetNodeResourceUsage(ctx, "test0", nil, nil, []plugintypes.WorkloadResource{{"bandwidth": 100}}, true, true)
+	assert.Nil(t, err)
+	_, err = cm.CalculateDeploy(ctx, "test1", 6, req)
+	assert.ErrorIs(t, err, types.ErrNotEnoughBandwidth)
+	d, err := cm.CalculateDeploy(ctx, "test1", 5, req)
+	assert.Nil(t, err)
+	// the workloads are pending in the domains until they are committed, so test2 can't take the same bandwidth of row1
+	_, err = cm.CalculateDeploy(ctx, "test2", 6, req)
+	assert.ErrorIs(t, err, types.ErrNotEnoughBandwidth)
+	pending := func() int {
+		records, err := cm.doGetDomainRecords(ctx, cm.domains.Path("test1"))
+		assert.Nil(t, err)
+		return len(records["rack1"].Pending) + len(records["row1"].Pending)
+	}
+	assert.Equal(t, 10, pending())
+	_, err = cm.SetNodeResourceUsage(ctx, "test1", nil, nil, d.WorkloadsResource, true, true)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, pending())
+	_, err = cm.CalculateDeploy(ctx, "test1", 1, plugintypes.WorkloadResourceRequest{"bandwidth": 10, "hold": "1h"})
+	assert.ErrorIs(t, err, types.ErrNotEnoughBandwidth)
+	_, err = cm.SetNodeResourceUsage(ctx, "test1", nil, nil, d.WorkloadsResource, true, false)
+	assert.Nil(t, err)
+	_, err = cm.CalculateDeploy(ctx, "test1", 5, plugintypes.WorkloadResourceRequest{"bandwidth": 10, "hold": "1h"})
+	assert.Nil(t, err)
+	_, err = cm.CalculateDeploy(ctx, "test3", 10, req)
+	assert.Nil(t, err)
+
+	r, err = cm.GetNodesDeployCapacity(ctx, nodes, req)
+	assert.Nil(t, err)
+	assert.NotContains(t, r.NodeDeployCapacityMap, "test0")
+	assert.NotContains(t, r.NodeDeployCapacityMap, "test1")
+	assert.Equal(t, 5, r.NodeDeployCapacityMap["test2"].Capacity)
+	assert.Equal(t, 15, r.Total)
+
+	// growing a workload also counts against its domains
+	_, err = cm.SetNodeResourceUsage(ctx, "test2", nil, nil, []plugintypes.WorkloadResource{{"bandwidth": 50}}, true, true)
+	assert.Nil(t, err)
+	_, err = cm.CalculateRealloc(ctx, "test2", plugintypes.WorkloadResource{"bandwidth": 50}, plugintypes.WorkloadResourceRequest{"bandwidth": 40})
+	assert.ErrorIs(t, err, types.ErrNotEnoughBandwidth)
+
+	// each domain is reported by one of its nodes
+	values := map[string]string{}
+	for _, node := range nodes {
+		resp, err := cm.GetMetrics(ctx, "testpod", node)
+		assert.Nil(t, err)
+		for _, mt := range *resp {
+			assert.NotContains(t, values, mt.Key)
+			values[mt.Key] = mt.Value
+		}
+	}
+	assert.Equal(t, "150", values["core.domain.rack1.bandwidth.ingress.capacity"])
+	assert.Equal(t, "150", values["core.domain.rack1.bandwidth.ingress.used"])
+	assert.Equal(t, "0", values["core.domain.rack1.bandwidth.ingress.free"])
+	assert.Equal(t, "400", values["core.domain.row1.bandwidth.egress.capacity"])
+	assert.Equal(t, "200", values["core.domain.row1.bandwidth.egress.free"])
+}
+
+// countingStore counts reads of single keys
+type countingStore struct {
+	Store
+	gets int
+}
+
+func (s *countingStore) Get(ctx context.Context, key string) ([]byte, int64, error) {
+	s.gets++
+	return s.Store.Get(ctx, key)
+}
+
+func TestDomainsUsageReadsOnce(t *testing.T) {
+	ctx := context.Background()
+	bdConfig := capacityCheckConfig(1)
+	bdConfig.Domains = []types.DomainConfig{
+		{Name: "rack1", Level: "rack", Capacity: map[string]any{"bandwidth": 150}, Nodes: []string{"test0", "test1", "test2", "test9"}},
+	}
+	cm := initBandwidthWithConfig(ctx, t, bdConfig)
+	nodes := generateNodes(ctx, t, cm, 3, 0)
+	_, err := cm.SetNodeResourceUsage(ctx, "test1", nil, nil, []plugintypes.WorkloadResource{{"bandwidth": 30}}, true, true)
+	assert.Nil(t, err)
+
+	store := &countingStore{Store: cm.store}
+	cm.store = store
+	r, err := cm.GetNodesDeployCapacity(ctx, nodes, plugintypes.WorkloadResourceRequest{"bandwidth": 10})
+	assert.Nil(t, err)
+	assert.Equal(t, 12, r.Total)
+	assert.Equal(t, 0, store.gets)
+
+	_, err = cm.CalculateDeploy(ctx, "test0", 1, plugintypes.WorkloadResourceRequest{"bandwidth": 10})
+	assert.Nil(t, err)
+	// only the node being allocated on and the record of its domain
+	assert.Equal(t, 2, store.gets)
+}
+
+func TestDomainsParallelDeploys(t *testing.T) {
+	ctx := context.Background()
+	bdConfig := capacityCheckConfig(1)
+	bdConfig.Domains = []types.DomainConfig{
+		{Name: "rack1", Level: "rack", Capacity: map[string]any{"bandwidth": 100}, Nodes: []string{"test0", "test1", "test2"}},
+	}
+	cm := initBandwidthWithConfig(ctx, t, bdConfig)
+	nodes := generateNodes(ctx, t, cm, 3, 0)
+
+	// deploys on different nodes of the domain never share its bandwidth
+	var wg sync.WaitGroup
+	var deployed atomic.Int64
+	for i := 0; i < 30; i++ {
+		wg.Add(1)
+		go func(node string) {
+			defer wg.Done()
+			if _, err := cm.CalculateDeploy(ctx, node, 1, plugintypes.WorkloadResourceRequest{"bandwidth": 10}); err == nil {
+				deployed.Add(1)
+			}
+		}(nodes[i%len(nodes)])
+	}
+	wg.Wait()
+	assert.LessOrEqual(t, deployed.Load(), int64(10))
+	assert.Greater(t, deployed.Load(), int64(0))
+}
@@ -0,0 +1,53 @@
+package bandwidth
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	bdtypes "github.com/yuyang0/resource-bandwidth/bandwidth/types"
+)
+
+// GetNodeAllocations returns the ledger and the capacity of a node, it's what the agent of the node enforces.
+// Engine params of the ledger are remapped like calculate-remap does, so workloads keep their share of spare bandwidth.
+func (p Plugin) GetNodeAllocations(ctx context.Context, nodename string) (bdtypes.Ledger, *bdtypes.NodeResource, error) {
+	nodeResourceInfo, err := p.doGetNodeResourceInfo(ctx, nodename)
+	if err != nil {
+		return nil, nil, err
+	}
+	ledger := nodeResourceInfo.Workloads.DeepCopy()
+	// like the next write of the node does for records without slots
+	ledger.AssignSlots()
+	workloadsResource := map[string]*bdtypes.WorkloadResource{}
+	for id, allocation := range ledger {
+		workloadsResource[id] = allocation.Resource
+	}
+	for id, engineParams := range p.doRemap(nodeResourceInfo.Schedulable(time.Now()), workloadsResource) {
+		ledger[id].EngineParams = engineParams
+	}
+	return ledger, nodeResourceInfo.Capacity, nil
+}
+
+// ReportDrift records the differences between the allocations and the shaping of a node,
+// they are returned as diffs of the node until the agent reports none
+func (p Plugin) ReportDrift(ctx context.Context, nodename string, drift []string) error {
+	if len(drift) == 0 {
+		drift = nil
+	}
+	return p.updateNodeResourceInfo(ctx, nodename, func(resourceInfo *bdtypes.NodeResourceInfo) error {
+		if reflect.DeepEqual(resourceInfo.Drift, drift) {
+			return errSkipUpdate
+		}
+		resourceInfo.Drift = drift
+		return nil
+	})
+}
+
+// driftDiffs returns the drift reported by the agent as diffs
+func driftDiffs(nodeResourceInfo *bdtypes.NodeResourceInfo) []string {
+	diffs := []string{}
+	for _, drift := range nodeResourceInfo.Drift {
+		diffs = append(diffs, "enforcement drift: "+drift)
+	}
+	return diffs
+}
@@ -0,0 +1,57 @@
+package bandwidth
+
+import (
+	"context"
+	"testing"
+
+	plugintypes "github.com/projecteru2/core/resource/plugins/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/yuyang0/resource-bandwidth/bandwidth/types"
+)
+
+func TestDrift(t *testing.T) {
+	ctx := context.Background()
+	cm := initBandwidth(ctx, t)
+	nodes := generateNodes(ctx, t, cm, 1, 0)
+	node := nodes[0]
+
+	d, err := cm.CalculateDeploy(ctx, node, 1, plugintypes.WorkloadResourceRequest{"bandwidth": 10})
+	assert.Nil(t, err)
+	_, err = cm.SetNodeResourceUsage(ctx, node, nil, nil, d.WorkloadsResource, true, true)
+	assert.Nil(t, err)
+
+	ledger, capacity, err := cm.GetNodeAllocations(ctx, node)
+	assert.Nil(t, err)
+	assert.Len(t, ledger, 1)
+	assert.Equal(t, int64(100), capacity.Ingress)
+
+	// the spare bandwidth of the node raises the ceiling like calculate-remap does
+	id := d.WorkloadsResource[0]["id"].(string)
+	assert.Equal(t, int64(20), d.EnginesParams[0]["ingress"].(map[string]any)["peak"])
+	remap, err := cm.CalculateRemap(ctx, node, map[string]plugintypes.WorkloadResource{id: d.WorkloadsResource[0]})
+	assert.Nil(t, err)
+	remapped := &types.EngineParams{}
+	assert.Nil(t, remapped.Parse(remap.EngineParamsMap[id]))
+	assert.Equal(t, remapped, ledger[id].EngineParams)
+	assert.Equal(t, int64(100), ledger[id].EngineParams.Ingress.Peak)
+
+	// reported drift shows up in diffs of the node until the agent reports none
+	assert.Nil(t, cm.ReportDrift(ctx, node, []string{"class 1:10 of eth0 is missing"}))
+	r, err := cm.GetNodeResourceInfo(ctx, node, d.WorkloadsResource)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"enforcement drift: class 1:10 of eth0 is missing"}, r.Diffs)
+	r, err = cm.FixNodeResource(ctx, node, d.WorkloadsResource)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"enforcement drift: class 1:10 of eth0 is missing"}, r.Diffs)
+
+	assert.Nil(t, cm.ReportDrift(ctx, node, []string{}))
+	r, err = cm.GetNodeResourceInfo(ctx, node, d.WorkloadsResource)
+	assert.Nil(t, err)
+	assert.Empty(t, r.Diffs)
+	info, err := cm.doGetNodeResourceInfo(ctx, node)
+	assert.Nil(t, err)
+	assert.Nil(t, info.Drift)
+
+	_, _, err = cm.GetNodeAllocations(ctx, "unknown")
+	assert.Error(t, err)
+}
@@ -0,0 +1,83 @@
+package bandwidth
+
+import (
+	"context"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/projecteru2/core/log"
+	plugintypes "github.com/projecteru2/core/resource/plugins/types"
+	bdtypes "github.com/yuyang0/resource-bandwidth/bandwidth/types"
+)
+
+// CommitHolds turns holds into usage, like SetNodeResourceUsage with the held workloads does.
+// It fails without any change if some hold is not found or expired.
+func (p Plugin) CommitHolds(ctx context.Context, nodename string, ids []string) (*plugintypes.SetNodeResourceUsageResponse, error) {
+	logger := log.WithFunc("resource.bandwidth.CommitHolds").WithField("node", nodename)
+	var before, after *bdtypes.NodeResource
+	var workloadsResource []*bdtypes.WorkloadResource
+	err := p.updateNodeResourceInfo(ctx, nodename, func(resourceInfo *bdtypes.NodeResourceInfo) error {
+		now := time.Now()
+		workloadsResource = []*bdtypes.WorkloadResource{}
+		for _, id := range ids {
+			hold, ok := resourceInfo.Holds[id]
+			if !ok || hold.Expired(now) {
+				return errors.Wrapf(bdtypes.ErrHoldNotFound, "id %s", id)
+			}
+			workloadsResource = append(workloadsResource, hold.Resource)
+		}
+		before = resourceInfo.Usage.DeepCopy()
+		var rangeErrs []*bdtypes.RangeError
+		resourceInfo.Usage, rangeErrs = p.calculateNodeResource(nil, nil, resourceInfo.Usage, workloadsResource, true, true)
+		rangeErrs = append(rangeErrs, p.recordWorkloads(resourceInfo, workloadsResource, true, now)...)
+		if err := p.checkArithmetic(ctx, nodename, resourceInfo, rangeErrs); err != nil {
+			return err
+		}
+		after = resourceInfo.Usage
+		return nil
+	})
+	if err != nil {
+		logger.Error(ctx, err)
+		return nil, err
+	}
+	p.releaseDomains(ctx, nodename, operationIDs(workloadsResource))
+	return &plugintypes.SetNodeResourceUsageResponse{
+		Before: before.AsRawParams(),
+		After:  after.AsRawParams(),
+	}, nil
+}
+
+// ReleaseHolds drops holds, unknown or expired ones are ignored
+func (p Plugin) ReleaseHolds(ctx context.Context, nodename string, ids []string) error {
+	var workloadsResource []*bdtypes.WorkloadResource
+	err := p.updateNodeResourceInfo(ctx, nodename, func(resourceInfo *bdtypes.NodeResourceInfo) error {
+		workloadsResource = []*bdtypes.WorkloadResource{}
+		for _, id := range ids {
+			if hold, ok := resourceInfo.Holds[id]; ok {
+				workloadsResource = append(workloadsResource, hold.Resource)
+			}
+			delete(resourceInfo.Holds, id)
+		}
+		return nil
+	})
+	if err != nil {
+		log.WithFunc("resource.bandwidth.ReleaseHolds").WithField("node", nodename).Error(ctx, err)
+		return err
+	}
+	p.releaseDomains(ctx, nodename, operationIDs(workloadsResource))
+	return nil
+}
+
+// addHolds holds workloadsResource on the node until expiresAt
+func (p Plugin) addHolds(resourceInfo *bdtypes.NodeResourceInfo, workloadsResource []*bdtypes.WorkloadResource, expiresAt time.Time) {
+	if resourceInfo.Holds == nil {
+		resourceInfo.Holds = bdtypes.Holds{}
+	}
+	for _, workloadResource := range workloadsResource {
+		resourceInfo.Holds[workloadResource.ID] = &bdtypes.Hold{
+			ID:        workloadResource.ID,
+			Resource:  workloadResource.DeepCopy(),
+			ExpiresAt: expiresAt,
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package bandwidth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	plugintypes "github.com/projecteru2/core/resource/plugins/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/yuyang0/resource-bandwidth/bandwidth/types"
+)
+
+func TestHolds(t *testing.T) {
+	ctx := context.Background()
+	cm := initBandwidthWithConfig(ctx, t, capacityCheckConfig(1))
+	nodes := generateNodes(ctx, t, cm, 2, 0)
+	node := nodes[0]
+
+	deployCapacity := func() int {
+		r, err := cm.GetNodesDeployCapacity(ctx, []string{node}, plugintypes.WorkloadResourceRequest{"bandwidth": 10})
+		assert.Nil(t, err)
+		return r.NodeDeployCapacityMap[node].Capacity
+	}
+	getInfo := func() *types.NodeResourceInfo {
+		info, err := cm.doGetNodeResourceInfo(ctx, node)
+		assert.Nil(t, err)
+		return info
+	}
+	assert.Equal(t, 10, deployCapacity())
+
+	// a hold counts as usage before core sets usage
+	d, err := cm.CalculateDeploy(ctx, node, 6, plugintypes.WorkloadResourceRequest{"bandwidth": 10, "hold": "1h"})
+	assert.Nil(t, err)
+	assert.Len(t, getInfo().Holds, 6)
+	assert.Equal(t, types.NewNodeResource(0, 0), getInfo().Usage)
+	assert.Equal(t, 4, deployCapacity())
+	_, err = cm.CalculateDeploy(ctx, node, 5, plugintypes.WorkloadResourceRequest{"bandwidth": 10})
+	assert.ErrorIs(t, err, types.ErrNotEnoughBandwidth)
+	r, err := cm.GetMostIdleNode(ctx, nodes)
+	assert.Nil(t, err)
+	assert.Equal(t, nodes[1], r.Nodename)
+
+	// setting usage of the workloads commits their holds
+	_, err = cm.SetNodeResourceUsage(ctx, node, nil, nil, d.WorkloadsResource[:2], true, true)
+	assert.Nil(t, err)
+	info := getInfo()
+	assert.Len(t, info.Holds, 4)
+	assert.Len(t, info.Workloads, 2)
+	assert.Equal(t, types.NewNodeResource(20, 20), info.Usage)
+	assert.Equal(t, 4, deployCapacity())
+
+	// explicit commit and release
+	ids := info.Holds.IDs()
+	_, err = cm.CommitHolds(ctx, node, []string{ids[0], "xxx"})
+	assert.ErrorIs(t, err, types.ErrHoldNotFound)
+	assert.Len(t, getInfo().Holds, 4)
+	c, err := cm.CommitHolds(ctx, node, ids[:1])
+	assert.Nil(t, err)
+	after := &types.NodeResource{}
+	assert.Nil(t, after.Parse(c.After, types.BitUnit))
+	assert.Equal(t, types.NewNodeResource(30, 30), after)
+	assert.Nil(t, cm.ReleaseHolds(ctx, node, []string{ids[1], "xxx"}))
+	info = getInfo()
+	assert.Equal(t, ids[2:], info.Holds.IDs())
+	assert.Len(t, info.Workloads, 3)
+	assert.Equal(t, 5, deployCapacity())
+
+	// holds expire on their own
+	_, err = cm.CalculateDeploy(ctx, node, 6, plugintypes.WorkloadResourceRequest{"bandwidth": 10, "hold": "0.05"})
+	assert.ErrorIs(t, err, types.ErrNotEnoughBandwidth)
+	assert.Nil(t, cm.ReleaseHolds(ctx, node, ids[2:]))
+	_, err = cm.CalculateDeploy(ctx, node, 5, plugintypes.WorkloadResourceRequest{"bandwidth": 10, "hold": "0.05"})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, deployCapacity())
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 7, deployCapacity())
+	_, err = cm.CommitHolds(ctx, node, getInfo().Holds.IDs()[:1])
+	assert.ErrorIs(t, err, types.ErrHoldNotFound)
+	// and are dropped on the next update
+	assert.Nil(t, cm.ReleaseHolds(ctx, node, nil))
+	assert.Empty(t, getInfo().Holds)
+
+	_, err = cm.CalculateDeploy(ctx, node, 1, plugintypes.WorkloadResourceRequest{"bandwidth": 10, "hold": "-1s"})
+	assert.ErrorIs(t, err, types.ErrInvalidDuration)
+}
@@ -0,0 +1,58 @@
+package bandwidth
+
+import (
+	"context"
+	"math"
+	"time"
+
+	plugintypes "github.com/projecteru2/core/resource/plugins/types"
+	bdtypes "github.com/yuyang0/resource-bandwidth/bandwidth/types"
+)
+
+type incomingRequestKey struct{}
+
+// WithIncomingRequest returns a context carrying the request about to be deployed,
+// the after-request idle strategy scores nodes as if it were already on them
+func WithIncomingRequest(ctx context.Context, resourceRequest plugintypes.WorkloadResourceRequest) context.Context {
+	return context.WithValue(ctx, incomingRequestKey{}, resourceRequest)
+}
+
+// incomingRequest returns the parsed request carried by ctx, nil if there isn't one
+func (p Plugin) incomingRequest(ctx context.Context) (*bdtypes.WorkloadResourceRequest, error) {
+	resourceRequest, _ := ctx.Value(incomingRequestKey{}).(plugintypes.WorkloadResourceRequest)
+	if resourceRequest == nil {
+		return nil, nil
+	}
+	req := &bdtypes.WorkloadResourceRequest{}
+	if err := req.Parse(resourceRequest, p.unit); err != nil {
+		return nil, err
+	}
+	return req, req.Validate()
+}
+
+// idleScore scores the node by the idle strategy, lower is more idle
+func (p Plugin) idleScore(nodeResourceInfo *bdtypes.NodeResourceInfo, req *bdtypes.WorkloadResourceRequest, now time.Time) (float64, error) {
+	resourceInfo := nodeResourceInfo.Schedulable(now)
+	capacity := resourceInfo.Capacity.NIC("")
+	usage := resourceInfo.Usage.NIC("")
+	switch p.bdConfig.Idle.Strategy {
+	case bdtypes.IdleFree:
+		free := resourceInfo.GetAllocatableResource(p.bdConfig.OvercommitRatio)
+		return -math.Min(float64(free.Ingress), float64(free.Egress)), nil
+	case bdtypes.IdleAfterRequest:
+		// core doesn't send the request, it's the ratio strategy then
+		if req == nil {
+			break
+		}
+		nodeReq := req.DeepCopy()
+		if err := nodeReq.Resolve(resourceInfo.Capacity.NIC(nodeReq.NIC)); err != nil {
+			return 0, err
+		}
+		usage.Add(p.newWorkloadResource(resourceInfo, nodeReq, nodeReq.NIC).NodeResource().NIC(""))
+	case bdtypes.IdlePeakHeadroom:
+		// untracked workloads and holds count by their reservation
+		usage.Sub(resourceInfo.Workloads.NodeResource().NIC(""))
+		usage.Add(resourceInfo.Workloads.PeakResource())
+	}
+	return usage.Ratio(capacity), nil
+}
@@ -0,0 +1,187 @@
+package bandwidth
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/projecteru2/core/utils"
+	bdtypes "github.com/yuyang0/resource-bandwidth/bandwidth/types"
+)
+
+const allocationIDLength = 16
+
+// issueTTL is how long issued engine params wait for their workloads to be committed
+const issueTTL = 10 * time.Minute
+
+func newAllocationID() string {
+	return utils.RandomString(allocationIDLength)
+}
+
+// recordWorkloads applies the usage change of workloadsResource to the ledger, so usage stays the sum of records.
+// A known ID is changed by the workload resource, which is a delta on realloc, and is removed once it's zero.
+// Workloads without ID are only counted in usage. Bandwidths of records out of range are clamped and returned.
+// Engine params are the ones issued for the workload, they are derived only if there are none.
+func (p Plugin) recordWorkloads(resourceInfo *bdtypes.NodeResourceInfo, workloadsResource []*bdtypes.WorkloadResource, incr bool, now time.Time) []*bdtypes.RangeError {
+	var rangeErrs []*bdtypes.RangeError
+	if resourceInfo.Workloads == nil {
+		resourceInfo.Workloads = bdtypes.Ledger{}
+	}
+	// records written before slots keep the ones the agent got for them
+	resourceInfo.Workloads.AssignSlots()
+	for _, workloadResource := range workloadsResource {
+		if workloadResource.ID == "" {
+			continue
+		}
+		// the usage change commits or releases the hold of the workload
+		delete(resourceInfo.Holds, workloadResource.ID)
+		allocation := resourceInfo.Workloads[workloadResource.ID]
+		switch {
+		case allocation == nil && !incr:
+			continue
+		case allocation == nil:
+			allocation = &bdtypes.Allocation{
+				ID:        workloadResource.ID,
+				Resource:  ledgerResource(workloadResource),
+				CreatedAt: now,
+			}
+			resourceInfo.Workloads[workloadResource.ID] = allocation
+		case incr:
+			rangeErrs = append(rangeErrs, allocation.Resource.AddChecked(workloadResource)...)
+			allocation.Resource.NIC = workloadResource.NIC
+			allocation.Resource.Weight = workloadResource.Weight
+			allocation.Resource.QoS = workloadResource.QoS
+			allocation.Resource.Engine = workloadResource.Engine
+		default:
+			rangeErrs = append(rangeErrs, allocation.Resource.SubChecked(workloadResource)...)
+			if allocation.Resource.IsZero() {
+				delete(resourceInfo.Workloads, workloadResource.ID)
+				continue
+			}
+		}
+		allocation.UpdatedAt = now
+		if engineParams := takeIssue(resourceInfo, workloadResource.ID, now); engineParams != nil {
+			allocation.EngineParams = engineParams
+		} else if allocation.EngineParams == nil {
+			allocation.EngineParams = p.newEngineParams(resourceInfo, allocation.Resource)
+		}
+	}
+	resourceInfo.Workloads.AssignSlots()
+	return rangeErrs
+}
+
+// rebuildLedger replaces the ledger by workloadsResource, records which don't change are kept as they are,
+// engine params are the ones issued for the workloads, or kept or derived for those without
+func (p Plugin) rebuildLedger(resourceInfo *bdtypes.NodeResourceInfo, workloadsResource []*bdtypes.WorkloadResource, now time.Time) {
+	origin := resourceInfo.Workloads
+	origin.AssignSlots()
+	resourceInfo.Workloads = bdtypes.Ledger{}
+	changed := []*bdtypes.Allocation{}
+	for _, workloadResource := range workloadsResource {
+		if workloadResource.ID == "" {
+			continue
+		}
+		delete(resourceInfo.Holds, workloadResource.ID)
+		engineParams := takeIssue(resourceInfo, workloadResource.ID, now)
+		allocation := origin[workloadResource.ID]
+		if allocation != nil && reflect.DeepEqual(allocation.Resource, ledgerResource(workloadResource)) {
+			if engineParams != nil {
+				allocation.EngineParams = engineParams
+			}
+			resourceInfo.Workloads[workloadResource.ID] = allocation
+			continue
+		}
+		createdAt, slot := now, 0
+		if allocation != nil {
+			createdAt, slot = allocation.CreatedAt, allocation.Slot
+		}
+		allocation = &bdtypes.Allocation{
+			ID:        workloadResource.ID,
+			Resource:  ledgerResource(workloadResource),
+			Slot:      slot,
+			CreatedAt: createdAt,
+			UpdatedAt: now,
+		}
+		resourceInfo.Workloads[workloadResource.ID] = allocation
+		if engineParams != nil {
+			allocation.EngineParams = engineParams
+			continue
+		}
+		changed = append(changed, allocation)
+	}
+	resourceInfo.Workloads.AssignSlots()
+	// derived engine params are against the whole new ledger
+	for _, allocation := range changed {
+		allocation.EngineParams = p.newEngineParams(resourceInfo, allocation.Resource)
+	}
+}
+
+// diffLedger reports workloads which are missing in the ledger, extra in the ledger or recorded differently
+func (p Plugin) diffLedger(resourceInfo *bdtypes.NodeResourceInfo, workloadsResource []*bdtypes.WorkloadResource) []string {
+	diffs := []string{}
+	seen := map[string]bool{}
+	for _, workloadResource := range workloadsResource {
+		if workloadResource.ID == "" {
+			continue
+		}
+		seen[workloadResource.ID] = true
+		allocation, ok := resourceInfo.Workloads[workloadResource.ID]
+		switch {
+		case !ok:
+			diffs = append(diffs, fmt.Sprintf("workload %s is missing in ledger", workloadResource.ID))
+		case !reflect.DeepEqual(allocation.Resource, ledgerResource(workloadResource)):
+			diffs = append(diffs, fmt.Sprintf("workload %s in ledger != workload: %+v != %+v", workloadResource.ID, *allocation.Resource, *ledgerResource(workloadResource)))
+		}
+	}
+	for _, id := range resourceInfo.Workloads.IDs() {
+		if !seen[id] {
+			diffs = append(diffs, fmt.Sprintf("workload %s in ledger is extra", id))
+		}
+	}
+	return diffs
+}
+
+// ledgerResource returns the copy of workloadResource kept in the ledger, without the operation which issued it
+func ledgerResource(workloadResource *bdtypes.WorkloadResource) *bdtypes.WorkloadResource {
+	res := workloadResource.DeepCopy()
+	res.OperationID = ""
+	return res
+}
+
+// issue keeps the engine params issued for workloadsResource on the node until the workloads are committed
+func (p Plugin) issue(ctx context.Context, nodename string, workloadsResource []*bdtypes.WorkloadResource, enginesParams []*bdtypes.EngineParams) error {
+	return p.updateNodeResourceInfo(ctx, nodename, func(resourceInfo *bdtypes.NodeResourceInfo) error {
+		addIssues(resourceInfo, workloadsResource, enginesParams, time.Now().Add(issueTTL))
+		return nil
+	})
+}
+
+// addIssues keeps enginesParams for workloadsResource of the same order until expiresAt, untracked workloads are skipped
+func addIssues(resourceInfo *bdtypes.NodeResourceInfo, workloadsResource []*bdtypes.WorkloadResource, enginesParams []*bdtypes.EngineParams, expiresAt time.Time) {
+	if resourceInfo.Issues == nil {
+		resourceInfo.Issues = bdtypes.Issues{}
+	}
+	for i, workloadResource := range workloadsResource {
+		if workloadResource.ID == "" {
+			continue
+		}
+		resourceInfo.Issues[workloadResource.ID] = &bdtypes.Issue{
+			EngineParams: enginesParams[i].DeepCopy(),
+			ExpiresAt:    expiresAt,
+		}
+	}
+}
+
+// takeIssue removes the issue of the workload and returns its engine params, nil if there is none or it's expired
+func takeIssue(resourceInfo *bdtypes.NodeResourceInfo, id string, now time.Time) *bdtypes.EngineParams {
+	issue, ok := resourceInfo.Issues[id]
+	if !ok {
+		return nil
+	}
+	delete(resourceInfo.Issues, id)
+	if issue.Expired(now) {
+		return nil
+	}
+	return issue.EngineParams
+}
@@ -0,0 +1,163 @@
+package bandwidth
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	plugintypes "github.com/projecteru2/core/resource/plugins/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/yuyang0/resource-bandwidth/bandwidth/types"
+)
+
+func TestLedger(t *testing.T) {
+	ctx := context.Background()
+	cm := initBandwidth(ctx, t)
+	nodes := generateNodes(ctx, t, cm, 1, 0)
+	node := nodes[0]
+
+	getInfo := func() *types.NodeResourceInfo {
+		info, err := cm.doGetNodeResourceInfo(ctx, node)
+		assert.Nil(t, err)
+		return info
+	}
+
+	// deploy 3 workloads, each gets an allocation ID
+	d, err := cm.CalculateDeploy(ctx, node, 3, plugintypes.WorkloadResourceRequest{"bandwidth": 10})
+	assert.Nil(t, err)
+	ids := map[string]bool{}
+	for _, wr := range d.WorkloadsResource {
+		assert.NotEmpty(t, wr["id"])
+		ids[wr["id"].(string)] = true
+	}
+	assert.Len(t, ids, 3)
+	workloadsResource := d.WorkloadsResource
+
+	_, err = cm.SetNodeResourceUsage(ctx, node, nil, nil, workloadsResource, true, true)
+	assert.Nil(t, err)
+	info := getInfo()
+	assert.Len(t, info.Workloads, 3)
+	assert.Equal(t, info.Usage, info.Workloads.NodeResource())
+	slots := map[string]int{}
+	for id, allocation := range info.Workloads {
+		assert.Equal(t, int64(10), allocation.EngineParams.Ingress.Average)
+		assert.False(t, allocation.CreatedAt.IsZero())
+		slots[id] = allocation.Slot
+	}
+	assert.ElementsMatch(t, []int{1, 2, 3}, []int{slots[info.Workloads.IDs()[0]], slots[info.Workloads.IDs()[1]], slots[info.Workloads.IDs()[2]]})
+
+	// realloc keeps the ID and the delta resource updates the record
+	r, err := cm.CalculateRealloc(ctx, node, workloadsResource[0], plugintypes.WorkloadResourceRequest{"bandwidth": 5})
+	assert.Nil(t, err)
+	assert.Equal(t, workloadsResource[0]["id"], r.WorkloadResource["id"])
+	assert.Equal(t, workloadsResource[0]["id"], r.DeltaResource["id"])
+	_, err = cm.SetNodeResourceUsage(ctx, node, nil, nil, []plugintypes.WorkloadResource{r.DeltaResource}, true, true)
+	assert.Nil(t, err)
+	info = getInfo()
+	reallocated := info.Workloads[workloadsResource[0]["id"].(string)]
+	assert.Equal(t, int64(15), reallocated.Resource.Ingress)
+	assert.Equal(t, int64(15), reallocated.EngineParams.Ingress.Average)
+	assert.Equal(t, info.Usage, info.Workloads.NodeResource())
+	workloadsResource[0] = r.WorkloadResource
+
+	// removing a workload drops its record, others keep their slots
+	_, err = cm.SetNodeResourceUsage(ctx, node, nil, nil, workloadsResource[2:], true, false)
+	assert.Nil(t, err)
+	info = getInfo()
+	assert.Len(t, info.Workloads, 2)
+	for id, allocation := range info.Workloads {
+		assert.Equal(t, slots[id], allocation.Slot)
+	}
+	assert.Equal(t, types.NewNodeResource(25, 25), info.Usage)
+
+	// workloads without ID are only counted in usage
+	_, err = cm.SetNodeResourceUsage(ctx, node, nil, nil, []plugintypes.WorkloadResource{{"bandwidth": 1}}, true, true)
+	assert.Nil(t, err)
+	info = getInfo()
+	assert.Len(t, info.Workloads, 2)
+	assert.Equal(t, types.NewNodeResource(26, 26), info.Usage)
+
+	// fix reports which workloads are missing and extra
+	actual := []plugintypes.WorkloadResource{workloadsResource[0], {"id": "new", "bandwidth": 3}}
+	resp, err := cm.FixNodeResource(ctx, node, actual)
+	assert.Nil(t, err)
+	diffs := strings.Join(resp.Diffs, "\n")
+	assert.Contains(t, diffs, "workload new is missing in ledger")
+	assert.Contains(t, diffs, "workload "+workloadsResource[1]["id"].(string)+" in ledger is extra")
+	assert.NotContains(t, diffs, workloadsResource[0]["id"].(string))
+	info = getInfo()
+	assert.ElementsMatch(t, []string{workloadsResource[0]["id"].(string), "new"}, info.Workloads.IDs())
+	assert.Equal(t, types.NewNodeResource(18, 18), info.Usage)
+	assert.Equal(t, reallocated.CreatedAt.Unix(), info.Workloads[workloadsResource[0]["id"].(string)].CreatedAt.Unix())
+
+	resp, err = cm.FixNodeResource(ctx, node, actual)
+	assert.Nil(t, err)
+	assert.Empty(t, resp.Diffs)
+
+	// overwriting usage by workloads rebuilds the ledger
+	_, err = cm.SetNodeResourceUsage(ctx, node, nil, nil, actual[1:], false, false)
+	assert.Nil(t, err)
+	info = getInfo()
+	assert.Equal(t, []string{"new"}, info.Workloads.IDs())
+	assert.Equal(t, types.NewNodeResource(3, 3), info.Usage)
+}
+
+func TestLedgerEngineParams(t *testing.T) {
+	ctx := context.Background()
+	bdConfig := types.DefaultConfig()
+	bdConfig.Peak.Mode = types.PeakCapacity
+	cm := initBandwidthWithConfig(ctx, t, bdConfig)
+	nodes := generateNodes(ctx, t, cm, 1, 0)
+	node := nodes[0]
+
+	// the engine params issued by calculate-deploy wait on the node for the workload to be committed
+	d, err := cm.CalculateDeploy(ctx, node, 1, plugintypes.WorkloadResourceRequest{"bandwidth": 10})
+	assert.Nil(t, err)
+	issued := &types.EngineParams{}
+	assert.Nil(t, issued.Parse(d.EnginesParams[0]))
+	data, err := json.Marshal(d.WorkloadsResource[0])
+	assert.Nil(t, err)
+	workloadResource := plugintypes.WorkloadResource{}
+	assert.Nil(t, json.Unmarshal(data, &workloadResource))
+	assert.NotContains(t, workloadResource, "engine_params")
+
+	// the node changes before the workload is committed, the issued params are kept anyway
+	_, err = cm.SetNodeResourceCapacity(ctx, node, nil, plugintypes.NodeResource{"bandwidth": 10000}, true, true)
+	assert.Nil(t, err)
+	_, err = cm.SetNodeResourceUsage(ctx, node, nil, nil, []plugintypes.WorkloadResource{workloadResource}, true, true)
+	assert.Nil(t, err)
+	info, err := cm.doGetNodeResourceInfo(ctx, node)
+	assert.Nil(t, err)
+	id := workloadResource["id"].(string)
+	assert.Equal(t, issued, info.Workloads[id].EngineParams)
+	assert.NotEqual(t, issued, cm.newEngineParams(info, info.Workloads[id].Resource))
+	assert.Empty(t, info.Issues)
+
+	resp, err := cm.FixNodeResource(ctx, node, []plugintypes.WorkloadResource{workloadResource})
+	assert.Nil(t, err)
+	assert.Empty(t, resp.Diffs)
+
+	// legacy workloads without engine params get derived ones
+	_, err = cm.SetNodeResourceUsage(ctx, node, nil, nil, []plugintypes.WorkloadResource{{"id": "legacy", "bandwidth": 5}}, true, true)
+	assert.Nil(t, err)
+	info, err = cm.doGetNodeResourceInfo(ctx, node)
+	assert.Nil(t, err)
+	assert.Equal(t, cm.newEngineParams(info, info.Workloads["legacy"].Resource), info.Workloads["legacy"].EngineParams)
+	assert.Equal(t, issued, info.Workloads[id].EngineParams)
+
+	// calculate-realloc issues too, neither the delta nor the new resource carries engine params
+	rr, err := cm.CalculateRealloc(ctx, node, workloadResource, plugintypes.WorkloadResourceRequest{"bandwidth": 5})
+	assert.Nil(t, err)
+	assert.NotContains(t, rr.DeltaResource, "engine_params")
+	assert.NotContains(t, rr.WorkloadResource, "engine_params")
+	reissued := &types.EngineParams{}
+	assert.Nil(t, reissued.Parse(rr.EngineParams))
+	_, err = cm.SetNodeResourceUsage(ctx, node, nil, nil, []plugintypes.WorkloadResource{rr.DeltaResource}, true, true)
+	assert.Nil(t, err)
+	info, err = cm.doGetNodeResourceInfo(ctx, node)
+	assert.Nil(t, err)
+	assert.Equal(t, reissued, info.Workloads[id].EngineParams)
+	assert.Equal(t, int64(15), info.Workloads[id].Resource.Ingress)
+	assert.Empty(t, info.Issues)
+}
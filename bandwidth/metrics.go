@@ -4,9 +4,11 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/mitchellh/mapstructure"
 	plugintypes "github.com/projecteru2/core/resource/plugins/types"
+	bdtypes "github.com/yuyang0/resource-bandwidth/bandwidth/types"
 )
 
 // GetMetricsDescription .
@@ -15,16 +17,148 @@ func (p Plugin) GetMetricsDescription(context.Context) (*plugintypes.GetMetricsD
 	return resp, mapstructure.Decode([]map[string]interface{}{
 		{
 			"name":   "bandwidth_capacity",
-			"help":   "node available bandwidth.",
+			"help":   "node available bandwidth, the larger one of ingress and egress.",
 			"type":   "gauge",
 			"labels": []string{"podname", "nodename"},
 		},
 		{
 			"name":   "bandwidth_used",
-			"help":   "node used bandwidth.",
+			"help":   "node used bandwidth, the larger one of ingress and egress.",
 			"type":   "gauge",
 			"labels": []string{"podname", "nodename"},
 		},
+		{
+			"name":   "bandwidth_ingress_capacity",
+			"help":   "node available ingress bandwidth.",
+			"type":   "gauge",
+			"labels": []string{"podname", "nodename"},
+		},
+		{
+			"name":   "bandwidth_ingress_used",
+			"help":   "node used ingress bandwidth.",
+			"type":   "gauge",
+			"labels": []string{"podname", "nodename"},
+		},
+		{
+			"name":   "bandwidth_egress_capacity",
+			"help":   "node available egress bandwidth.",
+			"type":   "gauge",
+			"labels": []string{"podname", "nodename"},
+		},
+		{
+			"name":   "bandwidth_egress_used",
+			"help":   "node used egress bandwidth.",
+			"type":   "gauge",
+			"labels": []string{"podname", "nodename"},
+		},
+		{
+			"name":   "bandwidth_ingress_free",
+			"help":   "node unreserved ingress bandwidth, shared by burstable and best effort workloads.",
+			"type":   "gauge",
+			"labels": []string{"podname", "nodename"},
+		},
+		{
+			"name":   "bandwidth_egress_free",
+			"help":   "node unreserved egress bandwidth, shared by burstable and best effort workloads.",
+			"type":   "gauge",
+			"labels": []string{"podname", "nodename"},
+		},
+		{
+			"name":   "bandwidth_ingress_reserved",
+			"help":   "node ingress bandwidth reserved for the system.",
+			"type":   "gauge",
+			"labels": []string{"podname", "nodename"},
+		},
+		{
+			"name":   "bandwidth_ingress_allocatable",
+			"help":   "node ingress bandwidth workloads can be scheduled on, capacity minus reserved.",
+			"type":   "gauge",
+			"labels": []string{"podname", "nodename"},
+		},
+		{
+			"name":   "bandwidth_egress_reserved",
+			"help":   "node egress bandwidth reserved for the system.",
+			"type":   "gauge",
+			"labels": []string{"podname", "nodename"},
+		},
+		{
+			"name":   "bandwidth_egress_allocatable",
+			"help":   "node egress bandwidth workloads can be scheduled on, capacity minus reserved.",
+			"type":   "gauge",
+			"labels": []string{"podname", "nodename"},
+		},
+		{
+			"name":   "bandwidth_nic_ingress_capacity",
+			"help":   "nic available ingress bandwidth.",
+			"type":   "gauge",
+			"labels": []string{"podname", "nodename", "nic"},
+		},
+		{
+			"name":   "bandwidth_nic_ingress_used",
+			"help":   "nic used ingress bandwidth.",
+			"type":   "gauge",
+			"labels": []string{"podname", "nodename", "nic"},
+		},
+		{
+			"name":   "bandwidth_nic_egress_capacity",
+			"help":   "nic available egress bandwidth.",
+			"type":   "gauge",
+			"labels": []string{"podname", "nodename", "nic"},
+		},
+		{
+			"name":   "bandwidth_nic_egress_used",
+			"help":   "nic used egress bandwidth.",
+			"type":   "gauge",
+			"labels": []string{"podname", "nodename", "nic"},
+		},
+		{
+			"name":   "bandwidth_nic_ingress_free",
+			"help":   "nic unreserved ingress bandwidth, shared by burstable and best effort workloads.",
+			"type":   "gauge",
+			"labels": []string{"podname", "nodename", "nic"},
+		},
+		{
+			"name":   "bandwidth_nic_egress_free",
+			"help":   "nic unreserved egress bandwidth, shared by burstable and best effort workloads.",
+			"type":   "gauge",
+			"labels": []string{"podname", "nodename", "nic"},
+		},
+		{
+			"name":   "bandwidth_domain_ingress_capacity",
+			"help":   "domain available ingress bandwidth, shared by the nodes in it.",
+			"type":   "gauge",
+			"labels": []string{"domain", "level"},
+		},
+		{
+			"name":   "bandwidth_domain_ingress_used",
+			"help":   "domain used ingress bandwidth, the sum of the nodes in it.",
+			"type":   "gauge",
+			"labels": []string{"domain", "level"},
+		},
+		{
+			"name":   "bandwidth_domain_ingress_free",
+			"help":   "domain unreserved ingress bandwidth.",
+			"type":   "gauge",
+			"labels": []string{"domain", "level"},
+		},
+		{
+			"name":   "bandwidth_domain_egress_capacity",
+			"help":   "domain available egress bandwidth, shared by the nodes in it.",
+			"type":   "gauge",
+			"labels": []string{"domain", "level"},
+		},
+		{
+			"name":   "bandwidth_domain_egress_used",
+			"help":   "domain used egress bandwidth, the sum of the nodes in it.",
+			"type":   "gauge",
+			"labels": []string{"domain", "level"},
+		},
+		{
+			"name":   "bandwidth_domain_egress_free",
+			"help":   "domain unreserved egress bandwidth.",
+			"type":   "gauge",
+			"labels": []string{"domain", "level"},
+		},
 	}, resp)
 }
 
@@ -34,21 +168,134 @@ func (p Plugin) GetMetrics(ctx context.Context, podname, nodename string) (*plug
 	if err != nil {
 		return nil, err
 	}
+	// free is what workloads can still get, so it excludes the reservation
+	schedulable := nodeResourceInfo.Schedulable(time.Now())
 	safeNodename := strings.ReplaceAll(nodename, ".", "_")
-	var metrics []map[string]any
-	metrics = append(metrics, map[string]any{
-		"name":   "bandwidth_capacity",
-		"labels": []string{podname, nodename},
-		"value":  fmt.Sprintf("%+v", nodeResourceInfo.Capacity.Bandwidth),
-		"key":    fmt.Sprintf("core.node.%s.bandwidth.capacity", safeNodename),
-	})
-	metrics = append(metrics, map[string]any{
-		"name":   "bandwidth_used",
-		"labels": []string{podname, nodename},
-		"value":  fmt.Sprintf("%+v", nodeResourceInfo.Usage.Bandwidth),
-		"key":    fmt.Sprintf("core.node.%s.bandwidth.used", safeNodename),
-	})
+	keyPrefix := fmt.Sprintf("core.node.%s.bandwidth", safeNodename)
+	// the series of symmetric bandwidth are kept for existing dashboards
+	metrics := []map[string]any{
+		{
+			"name":   "bandwidth_capacity",
+			"labels": []string{podname, nodename},
+			"value":  fmt.Sprintf("%+v", maxDirection(nodeResourceInfo.Capacity)),
+			"key":    fmt.Sprintf("%s.capacity", keyPrefix),
+		},
+		{
+			"name":   "bandwidth_used",
+			"labels": []string{podname, nodename},
+			"value":  fmt.Sprintf("%+v", maxDirection(nodeResourceInfo.Usage)),
+			"key":    fmt.Sprintf("%s.used", keyPrefix),
+		},
+	}
+	metrics = append(metrics, directionMetrics("bandwidth", []string{podname, nodename}, keyPrefix,
+		nodeResourceInfo.Capacity.NIC(""), nodeResourceInfo.Usage.NIC(""), schedulable.GetNICAllocatableResource("", 1))...)
+	reserved := nodeResourceInfo.ReservedResource()
+	for _, direction := range []struct {
+		name                  string
+		reserved, allocatable int64
+	}{
+		{"ingress", reserved.Ingress, schedulable.Capacity.Ingress},
+		{"egress", reserved.Egress, schedulable.Capacity.Egress},
+	} {
+		metrics = append(metrics, map[string]any{
+			"name":   fmt.Sprintf("bandwidth_%s_reserved", direction.name),
+			"labels": []string{podname, nodename},
+			"value":  fmt.Sprintf("%+v", direction.reserved),
+			"key":    fmt.Sprintf("%s.%s.reserved", keyPrefix, direction.name),
+		})
+		metrics = append(metrics, map[string]any{
+			"name":   fmt.Sprintf("bandwidth_%s_allocatable", direction.name),
+			"labels": []string{podname, nodename},
+			"value":  fmt.Sprintf("%+v", direction.allocatable),
+			"key":    fmt.Sprintf("%s.%s.allocatable", keyPrefix, direction.name),
+		})
+	}
+	for _, nic := range nodeResourceInfo.Capacity.NICs.Names() {
+		safeNIC := strings.ReplaceAll(nic, ".", "_")
+		metrics = append(metrics, directionMetrics("bandwidth_nic", []string{podname, nodename, nic}, fmt.Sprintf("core.node.%s.bandwidth.nic.%s", safeNodename, safeNIC),
+			nodeResourceInfo.Capacity.NIC(nic), nodeResourceInfo.Usage.NIC(nic), schedulable.GetNICAllocatableResource(nic, 1))...)
+	}
+	reported, err := p.reportedDomains(ctx, nodename)
+	if err != nil {
+		return nil, err
+	}
+	usages, err := p.getDomainsUsage(ctx, reported, nil, nil, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	for _, domain := range reported {
+		safeDomain := strings.ReplaceAll(domain.Name, ".", "_")
+		metrics = append(metrics, directionMetrics("bandwidth_domain", []string{domain.Name, domain.Level}, fmt.Sprintf("core.domain.%s.bandwidth", safeDomain),
+			domain.Capacity, usages[domain.Name], domain.Allocatable(usages[domain.Name], 1))...)
+	}
 
 	resp := &plugintypes.GetMetricsResponse{}
 	return resp, mapstructure.Decode(metrics, resp)
 }
+
+// directionMetrics returns capacity, usage and free metrics of both directions,
+// usage only counts reservations, so best effort workloads are not in it
+func directionMetrics(namePrefix string, labels []string, keyPrefix string, capacity, usage, free *bdtypes.NodeResource) []map[string]any {
+	var metrics []map[string]any
+	for _, direction := range []struct {
+		name                  string
+		capacity, usage, free int64
+	}{
+		{"ingress", capacity.Ingress, usage.Ingress, free.Ingress},
+		{"egress", capacity.Egress, usage.Egress, free.Egress},
+	} {
+		metrics = append(metrics, map[string]any{
+			"name":   fmt.Sprintf("%s_%s_capacity", namePrefix, direction.name),
+			"labels": labels,
+			"value":  fmt.Sprintf("%+v", direction.capacity),
+			"key":    fmt.Sprintf("%s.%s.capacity", keyPrefix, direction.name),
+		})
+		metrics = append(metrics, map[string]any{
+			"name":   fmt.Sprintf("%s_%s_used", namePrefix, direction.name),
+			"labels": labels,
+			"value":  fmt.Sprintf("%+v", direction.usage),
+			"key":    fmt.Sprintf("%s.%s.used", keyPrefix, direction.name),
+		})
+		metrics = append(metrics, map[string]any{
+			"name":   fmt.Sprintf("%s_%s_free", namePrefix, direction.name),
+			"labels": labels,
+			"value":  fmt.Sprintf("%+v", direction.free),
+			"key":    fmt.Sprintf("%s.%s.free", keyPrefix, direction.name),
+		})
+	}
+	return metrics
+}
+
+// maxDirection returns the larger one of the node totals of ingress and egress
+func maxDirection(r *bdtypes.NodeResource) int64 {
+	if r.Ingress > r.Egress {
+		return r.Ingress
+	}
+	return r.Egress
+}
+
+// reportedDomains returns the domains enclosing the node which it reports metrics of,
+// a domain is reported by the first of its nodes which is added, so its series come from one place
+func (p Plugin) reportedDomains(ctx context.Context, nodename string) ([]*bdtypes.Domain, error) {
+	path := p.domains.Path(nodename)
+	if len(path) == 0 {
+		return nil, nil
+	}
+	// the outermost domain has all the nodes of the path
+	existing, err := p.doGetExistingNodesResourceInfo(ctx, p.domains.Nodes(path[len(path)-1].Name))
+	if err != nil {
+		return nil, err
+	}
+	reported := []*bdtypes.Domain{}
+	for _, domain := range path {
+		for _, node := range p.domains.Nodes(domain.Name) {
+			if _, ok := existing[node]; ok {
+				if node == nodename {
+					reported = append(reported, domain)
+				}
+				break
+			}
+		}
+	}
+	return reported, nil
+}
@@ -2,9 +2,12 @@ package bandwidth
 
 import (
 	"context"
+	"strings"
 	"testing"
 
+	plugintypes "github.com/projecteru2/core/resource/plugins/types"
 	"github.com/stretchr/testify/assert"
+	"github.com/yuyang0/resource-bandwidth/bandwidth/types"
 )
 
 func TestGetMetricsDescription(t *testing.T) {
@@ -13,7 +16,7 @@ func TestGetMetricsDescription(t *testing.T) {
 	md, err := cm.GetMetricsDescription(ctx)
 	assert.NoError(t, err)
 	assert.NotNil(t, md)
-	assert.Len(t, *md, 2)
+	assert.Len(t, *md, 24)
 }
 
 func TestGetMetrics(t *testing.T) {
@@ -30,12 +33,62 @@ func TestGetMetrics(t *testing.T) {
 		assert.Equal(t, mt.Labels[0], "testpod")
 		assert.Equal(t, mt.Labels[1], nodes[0])
 		switch mt.Name {
-		case "bandwidth_capacity":
+		case "bandwidth_capacity", "bandwidth_ingress_capacity", "bandwidth_egress_capacity", "bandwidth_ingress_free", "bandwidth_egress_free",
+			"bandwidth_ingress_allocatable", "bandwidth_egress_allocatable":
 			assert.Equal(t, mt.Value, "100")
-		case "bandwidth_used":
+		case "bandwidth_used", "bandwidth_ingress_used", "bandwidth_egress_used", "bandwidth_ingress_reserved", "bandwidth_egress_reserved":
 			assert.Equal(t, mt.Value, "0")
 		default:
 			assert.True(t, false)
 		}
 	}
+
+	// the symmetric series take the busier direction
+	_, err = cm.SetNodeResourceUsage(ctx, nodes[0], nil, nil, []plugintypes.WorkloadResource{{"ingress": 10, "egress": 30}}, true, true)
+	assert.NoError(t, err)
+	resp, err = cm.GetMetrics(ctx, "testpod", nodes[0])
+	assert.NoError(t, err)
+	values := map[string]string{}
+	for _, mt := range *resp {
+		values[mt.Name] = mt.Value
+	}
+	assert.Equal(t, "30", values["bandwidth_used"])
+	assert.Equal(t, "10", values["bandwidth_ingress_used"])
+}
+
+func TestGetMetricsWithDomains(t *testing.T) {
+	ctx := context.Background()
+	bdConfig := types.DefaultConfig()
+	bdConfig.Domains = []types.DomainConfig{
+		{Name: "rack1", Level: "rack", Capacity: map[string]any{"bandwidth": 150}, Nodes: []string{"test0", "test1", "test9"}},
+	}
+	cm := initBandwidthWithConfig(ctx, t, bdConfig)
+	nodes := generateNodes(ctx, t, cm, 2, -1)
+
+	// the domain is reported by its first node which is added
+	for _, node := range nodes {
+		resp, err := cm.GetMetrics(ctx, "testpod", node)
+		assert.NoError(t, err)
+		domainMetrics := 0
+		for _, mt := range *resp {
+			if !strings.HasPrefix(mt.Name, "bandwidth_domain_") {
+				continue
+			}
+			domainMetrics++
+			assert.Equal(t, []string{"rack1", "rack"}, mt.Labels)
+			switch mt.Name {
+			case "bandwidth_domain_ingress_capacity", "bandwidth_domain_egress_capacity", "bandwidth_domain_ingress_free", "bandwidth_domain_egress_free":
+				assert.Equal(t, "150", mt.Value)
+			case "bandwidth_domain_ingress_used", "bandwidth_domain_egress_used":
+				assert.Equal(t, "0", mt.Value)
+			default:
+				assert.True(t, false)
+			}
+		}
+		if node == "test0" {
+			assert.Equal(t, 6, domainMetrics)
+		} else {
+			assert.Equal(t, 0, domainMetrics)
+		}
+	}
 }
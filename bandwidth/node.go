@@ -5,11 +5,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"math"
+	"math/rand"
+	"sort"
+	"time"
 
 	"github.com/cockroachdb/errors"
 	enginetypes "github.com/projecteru2/core/engine/types"
 	"github.com/projecteru2/core/log"
 	plugintypes "github.com/projecteru2/core/resource/plugins/types"
+	resourcetypes "github.com/projecteru2/core/resource/types"
 
 	coretypes "github.com/projecteru2/core/types"
 	"github.com/projecteru2/core/utils"
@@ -17,8 +21,13 @@ import (
 	bdtypes "github.com/yuyang0/resource-bandwidth/bandwidth/types"
 )
 
-const (
-	maxCapacity = 1000000
+var (
+	// updateRetries is how many times a conflicted update of node resource info is retried
+	updateRetries = 8
+	// updateBackoff is the wait before the first retry, it doubles after each one
+	updateBackoff = 10 * time.Millisecond
+	// errSkipUpdate is returned by an update to leave the resource info as it is
+	errSkipUpdate = errors.New("skip update")
 )
 
 // AddNode .
@@ -41,27 +50,37 @@ func (p Plugin) AddNode(
 	}
 
 	req := &bdtypes.NodeResourceRequest{}
-	if err := req.Parse(resource); err != nil {
+	if err := req.Parse(resource, p.unit); err != nil {
 		return nil, err
 	}
+	// percentages are relative to the bandwidth reported by engine
+	if req.IsRelative() {
+		reported, err := p.getEngineCapacity(info)
+		if err != nil {
+			return nil, err
+		}
+		if err := req.Resolve(reported); err != nil {
+			return nil, err
+		}
+	}
 	if err := req.Validate(); err != nil {
 		return nil, err
 	}
-	capacity := bdtypes.NewNodeResource(req.Bandwidth)
+	capacity := req.NodeResource()
 	// try to fetch resource from info
-	if info != nil && info.Resources != nil { //nolint
-		if capacity.Bandwidth == 0 {
-			if b, ok := info.Resources[p.name]; ok {
-				err := json.Unmarshal(b, capacity)
-				if err != nil {
-					return nil, err
-				}
-			}
+	if capacity.IsZero() {
+		if capacity, err = p.getEngineCapacity(info); err != nil {
+			return nil, err
 		}
 	}
+	reserved, err := p.newReserved(resource, capacity)
+	if err != nil {
+		return nil, err
+	}
 	nodeResourceInfo := &bdtypes.NodeResourceInfo{
 		Capacity: capacity,
-		Usage:    bdtypes.NewNodeResource(0),
+		Usage:    bdtypes.NewNodeResource(0, 0),
+		Reserved: reserved,
 	}
 
 	if err = p.doSetNodeResourceInfo(ctx, nodename, nodeResourceInfo); err != nil {
@@ -73,10 +92,53 @@ func (p Plugin) AddNode(
 	}, nil
 }
 
+// newReserved returns the reservation of the node resource request, or the default one of config
+func (p Plugin) newReserved(resource plugintypes.NodeResourceRequest, capacity *bdtypes.NodeResource) (resourcetypes.RawParams, error) {
+	var value any
+	switch {
+	case resource.IsSet("reserved"):
+		value = resource["reserved"]
+	case p.bdConfig.Reserved != "":
+		value = p.bdConfig.Reserved
+	default:
+		return nil, nil
+	}
+	reserved, err := bdtypes.NewReserved(value, p.unit)
+	if err != nil {
+		return nil, err
+	}
+	res, err := bdtypes.ParseReserved(reserved, capacity)
+	if err != nil {
+		return nil, err
+	}
+	if res.Ingress > capacity.Ingress || res.Egress > capacity.Egress {
+		return nil, errors.Wrapf(bdtypes.ErrInvalidBandwidth, "reserved ingress %d egress %d exceeds capacity", res.Ingress, res.Egress)
+	}
+	return reserved, nil
+}
+
+// getEngineCapacity returns the bandwidth reported by engine, zero if not reported
+func (p Plugin) getEngineCapacity(info *enginetypes.Info) (*bdtypes.NodeResource, error) {
+	capacity := bdtypes.NewNodeResource(0, 0)
+	if info == nil || info.Resources == nil {
+		return capacity, nil
+	}
+	if b, ok := info.Resources[p.name]; ok {
+		rawParams := resourcetypes.RawParams{}
+		if err := json.Unmarshal(b, &rawParams); err != nil {
+			return nil, err
+		}
+		if err := capacity.Parse(rawParams, p.unit); err != nil {
+			return nil, err
+		}
+	}
+	return capacity, nil
+}
+
 // RemoveNode .
 func (p Plugin) RemoveNode(ctx context.Context, nodename string) (*plugintypes.RemoveNodeResponse, error) {
 	var err error
-	if _, err = p.store.Delete(ctx, fmt.Sprintf(nodeResourceInfoKey, nodename)); err != nil {
+	if err = p.store.Delete(ctx, fmt.Sprintf(nodeResourceInfoKey, nodename)); err != nil {
 		log.WithFunc("resource.bandwidth.RemoveNode").WithField("node", nodename).Error(ctx, err, "faield to delete node")
 	}
 	return &plugintypes.RemoveNodeResponse{}, err
@@ -91,7 +153,7 @@ func (p Plugin) GetNodesDeployCapacity(
 ) {
 	logger := log.WithFunc("resource.bandwidth.GetNodesDeployCapacity")
 	req := &bdtypes.WorkloadResourceRequest{}
-	if err := req.Parse(resource); err != nil {
+	if err := req.Parse(resource, p.unit); err != nil {
 		return nil, err
 	}
 
@@ -108,10 +170,21 @@ func (p Plugin) GetNodesDeployCapacity(
 		return nil, err
 	}
 
+	now := time.Now()
+	needs := map[string]*bdtypes.NodeResource{}
 	for nodename, nodeResourceInfo := range nodesResourceInfos {
-		nodeDeployCapacity := p.doGetNodeDeployCapacity(nodeResourceInfo, req)
+		nodeReq := req.DeepCopy()
+		if err := nodeReq.Resolve(nodeResourceInfo.Capacity.NIC(nodeReq.NIC)); err != nil {
+			return nil, err
+		}
+		if err := nodeReq.Validate(); err != nil {
+			logger.WithField("node", nodename).Errorf(ctx, err, "invalid resource opts %+v", nodeReq)
+			return nil, err
+		}
+		nodeDeployCapacity := p.doGetNodeDeployCapacity(nodename, nodeResourceInfo.Schedulable(now), nodeReq)
 		if nodeDeployCapacity.Capacity > 0 {
 			nodesDeployCapacityMap[nodename] = nodeDeployCapacity
+			needs[nodename] = p.newWorkloadResource(nodeResourceInfo, nodeReq, "").NodeResource().NIC("")
 			if total == math.MaxInt || nodeDeployCapacity.Capacity == math.MaxInt {
 				total = math.MaxInt
 			} else {
@@ -119,6 +192,12 @@ func (p Plugin) GetNodesDeployCapacity(
 			}
 		}
 	}
+	// shared uplinks bound the nodes behind them
+	if len(p.domains) > 0 {
+		if total, err = p.limitByDomains(ctx, nodesDeployCapacityMap, needs); err != nil {
+			return nil, err
+		}
+	}
 	return &plugintypes.GetNodesDeployCapacityResponse{
 		NodeDeployCapacityMap: nodesDeployCapacityMap,
 		Total:                 total,
@@ -135,31 +214,55 @@ func (p Plugin) SetNodeResourceCapacity(
 	*plugintypes.SetNodeResourceCapacityResponse, error,
 ) {
 	logger := log.WithFunc("resource.bandwidth.SetNodeResourceCapacity").WithField("node", "nodename")
-	req, nodeResource, _, err := p.parseNodeResourceInfos(resourceRequest, resource, nil)
-	if err != nil {
-		return nil, err
-	}
-	nodeResourceInfo, err := p.doGetNodeResourceInfo(ctx, nodename)
-	if err != nil {
-		return nil, err
-	}
-
-	origin := nodeResourceInfo.Capacity
-	before := origin.DeepCopy()
+	var before, after *bdtypes.NodeResource
+	var nodeResourceInfo *bdtypes.NodeResourceInfo
+	err := p.updateNodeResourceInfo(ctx, nodename, func(resourceInfo *bdtypes.NodeResourceInfo) error {
+		nodeResourceInfo = resourceInfo
+		op, err := p.replayOperation(ctx, resourceInfo, bdtypes.OperationCapacity)
+		if err != nil {
+			return err
+		}
+		if op != nil {
+			before, after = op.Before, op.After
+			return errSkipUpdate
+		}
+		// parse again on each try, resolving and loading modify the request
+		req, nodeResource, _, err := p.parseNodeResourceInfos(resourceRequest, resource, nil)
+		if err != nil {
+			return err
+		}
 
-	if !delta && req != nil {
-		req.LoadFromOrigin(origin, resourceRequest)
-	}
-	nodeResourceInfo.Capacity = p.calculateNodeResource(req, nodeResource, origin, nil, delta, incr)
+		origin := resourceInfo.Capacity
+		before = origin.DeepCopy()
+		if err := p.resolveNodeResourceInfos(origin, req, nodeResource); err != nil {
+			return err
+		}
 
-	if err := p.doSetNodeResourceInfo(ctx, nodename, nodeResourceInfo); err != nil {
+		if !delta && req != nil {
+			req.LoadFromOrigin(origin, resourceRequest)
+		}
+		var rangeErrs []*bdtypes.RangeError
+		resourceInfo.Capacity, rangeErrs = p.calculateNodeResource(req, nodeResource, origin, nil, delta, incr)
+		if err := p.checkArithmetic(ctx, nodename, resourceInfo, rangeErrs); err != nil {
+			return err
+		}
+		if resourceRequest.IsSet("reserved") {
+			if resourceInfo.Reserved, err = p.newReserved(resourceRequest, resourceInfo.Capacity); err != nil {
+				return err
+			}
+		}
+		after = resourceInfo.Capacity
+		p.recordOperation(ctx, resourceInfo, bdtypes.OperationCapacity, before, after)
+		return nil
+	})
+	if err != nil {
 		logger.Errorf(ctx, err, "node resource info %+v", litter.Sdump(nodeResourceInfo))
 		return nil, err
 	}
 
 	return &plugintypes.SetNodeResourceCapacityResponse{
 		Before: before.AsRawParams(),
-		After:  nodeResourceInfo.Capacity.AsRawParams(),
+		After:  after.AsRawParams(),
 	}, nil
 }
 
@@ -178,7 +281,7 @@ func (p Plugin) GetNodeResourceInfo(
 	return &plugintypes.GetNodeResourceInfoResponse{
 		Capacity: nodeResourceInfo.Capacity.AsRawParams(),
 		Usage:    nodeResourceInfo.Usage.AsRawParams(),
-		Diffs:    diffs,
+		Diffs:    append(append(diffs, warningDiffs(nodeResourceInfo)...), driftDiffs(nodeResourceInfo)...),
 	}, nil
 }
 
@@ -192,18 +295,38 @@ func (p Plugin) SetNodeResourceInfo(
 ) {
 	capacityResource := &bdtypes.NodeResource{}
 	usageResource := &bdtypes.NodeResource{}
-	if err := capacityResource.Parse(capacity); err != nil {
+	if err := capacityResource.Parse(capacity, p.unit); err != nil {
 		return nil, err
 	}
-	if err := usageResource.Parse(usage); err != nil {
+	if err := usageResource.Parse(usage, p.unit); err != nil {
 		return nil, err
 	}
-	resourceInfo := &bdtypes.NodeResourceInfo{
-		Capacity: capacityResource,
-		Usage:    usageResource,
+	if capacityResource.IsRelative() {
+		return nil, errors.Wrap(bdtypes.ErrInvalidBandwidth, "capacity can't be a percentage")
+	}
+	if err := usageResource.Resolve(capacityResource); err != nil {
+		return nil, err
 	}
 
-	return &plugintypes.SetNodeResourceInfoResponse{}, p.doSetNodeResourceInfo(ctx, nodename, resourceInfo)
+	// only capacity and usage are overwritten, the reservation, the ledger, holds and operations are kept
+	err := p.updateNodeResourceInfo(ctx, nodename, func(resourceInfo *bdtypes.NodeResourceInfo) error {
+		resourceInfo.Capacity, resourceInfo.Usage = capacityResource.DeepCopy(), usageResource.DeepCopy()
+		// core doesn't pass the workloads, so the ledger can't be rebuilt, a ledger beyond the usage is left for fixing
+		if ledger := resourceInfo.Workloads.NodeResource(); ledger.Ingress > usageResource.Ingress || ledger.Egress > usageResource.Egress {
+			addWarning(resourceInfo, fmt.Sprintf("ledger warning: usage %d/%d set by set-node-resource-info is less than the ledger %d/%d",
+				usageResource.Ingress, usageResource.Egress, ledger.Ingress, ledger.Egress))
+		}
+		return nil
+	})
+	if errors.Is(err, coretypes.ErrNodeNotExists) {
+		// a node which doesn't exist is created as before
+		resourceInfo := &bdtypes.NodeResourceInfo{Capacity: capacityResource, Usage: usageResource}
+		var ok bool
+		if ok, err = p.doUpdateNodeResourceInfo(ctx, nodename, resourceInfo, 0); err == nil && !ok {
+			err = errors.Wrapf(bdtypes.ErrConcurrentUpdate, "node %s was added concurrently", nodename)
+		}
+	}
+	return &plugintypes.SetNodeResourceInfoResponse{}, err
 }
 
 // SetNodeResourceUsage .
@@ -218,55 +341,109 @@ func (p Plugin) SetNodeResourceUsage(
 ) {
 
 	logger := log.WithFunc("resource.bandwidth.SetNodeResourceUsage").WithField("node", "nodename")
-	req, nodeResource, wrksResource, err := p.parseNodeResourceInfos(resourceRequest, resource, workloadsResource)
-	if err != nil {
-		return nil, err
-	}
-	nodeResourceInfo, err := p.doGetNodeResourceInfo(ctx, nodename)
-	if err != nil {
-		return nil, err
-	}
-
-	origin := nodeResourceInfo.Usage
-	before := origin.DeepCopy()
+	ctx = withWorkloadsOperationID(ctx, resourceRequest, resource, workloadsResource, delta, incr)
+	var before, after *bdtypes.NodeResource
+	var nodeResourceInfo *bdtypes.NodeResourceInfo
+	err := p.updateNodeResourceInfo(ctx, nodename, func(resourceInfo *bdtypes.NodeResourceInfo) error {
+		nodeResourceInfo = resourceInfo
+		op, err := p.replayOperation(ctx, resourceInfo, bdtypes.OperationUsage)
+		if err != nil {
+			return err
+		}
+		if op != nil {
+			before, after = op.Before, op.After
+			return errSkipUpdate
+		}
+		// parse again on each try, resolving modifies the request
+		req, nodeResource, wrksResource, err := p.parseNodeResourceInfos(resourceRequest, resource, workloadsResource)
+		if err != nil {
+			return err
+		}
 
-	nodeResourceInfo.Usage = p.calculateNodeResource(req, nodeResource, origin, wrksResource, delta, incr)
+		origin := resourceInfo.Usage
+		before = origin.DeepCopy()
+		if err := p.resolveNodeResourceInfos(resourceInfo.Capacity, req, nodeResource); err != nil {
+			return err
+		}
 
-	if err := p.doSetNodeResourceInfo(ctx, nodename, nodeResourceInfo); err != nil {
+		var rangeErrs []*bdtypes.RangeError
+		resourceInfo.Usage, rangeErrs = p.calculateNodeResource(req, nodeResource, origin, wrksResource, delta, incr)
+		// workloads only count when there is neither req nor nodeResource
+		if req == nil && nodeResource == nil {
+			if delta {
+				rangeErrs = append(rangeErrs, p.recordWorkloads(resourceInfo, wrksResource, incr, time.Now())...)
+			} else {
+				p.rebuildLedger(resourceInfo, wrksResource, time.Now())
+			}
+		}
+		if err := p.checkArithmetic(ctx, nodename, resourceInfo, rangeErrs); err != nil {
+			return err
+		}
+		after = resourceInfo.Usage
+		p.recordOperation(ctx, resourceInfo, bdtypes.OperationUsage, before, after)
+		return nil
+	})
+	if err != nil {
 		logger.Errorf(ctx, err, "node resource info %+v", litter.Sdump(nodeResourceInfo))
 		return nil, err
 	}
+	// the workloads are in the usage of the node now, they are no longer pending in domains
+	if resourceRequest == nil && resource == nil && delta {
+		p.releaseDomains(ctx, nodename, rawOperationIDs(workloadsResource))
+	}
 
 	return &plugintypes.SetNodeResourceUsageResponse{
 		Before: before.AsRawParams(),
-		After:  nodeResourceInfo.Usage.AsRawParams(),
+		After:  after.AsRawParams(),
 	}, nil
 }
 
-// GetMostIdleNode .
+// GetMostIdleNode returns the node with the lowest score of the idle strategy, or the highest one with binpack,
+// ties go to the first name
 func (p Plugin) GetMostIdleNode(ctx context.Context, nodenames []string) (*plugintypes.GetMostIdleNodeResponse, error) {
 	var mostIdleNode string
-	var minIdle = math.MaxFloat64
+	var minScore float64
 
+	req, err := p.incomingRequest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if req == nil && p.bdConfig.Idle.Strategy == bdtypes.IdleAfterRequest {
+		log.WithFunc("resource.bandwidth.GetMostIdleNode").Warn(ctx, "no incoming request for the after-request idle strategy, falling back to ratio")
+	}
 	nodesResourceInfo, err := p.doGetNodesResourceInfo(ctx, nodenames)
 	if err != nil {
 		return nil, err
 	}
 
-	for nodename, nodeResourceInfo := range nodesResourceInfo {
-		var idle float64
-		if nodeResourceInfo.CapBandwidth() > 0 {
-			idle = float64(nodeResourceInfo.UsageBandwidth()) / float64(nodeResourceInfo.CapBandwidth())
-		}
+	names := make([]string, 0, len(nodesResourceInfo))
+	for nodename := range nodesResourceInfo {
+		names = append(names, nodename)
+	}
+	sort.Strings(names)
 
-		if idle < minIdle {
+	now := time.Now()
+	for _, nodename := range names {
+		score, err := p.idleScore(nodesResourceInfo[nodename], req, now)
+		if err != nil {
+			return nil, err
+		}
+		// binpack goes to the busiest node which isn't full yet
+		if p.bdConfig.Placement == bdtypes.PlacementBinpack {
+			score = -score
+			free := nodesResourceInfo[nodename].Schedulable(now).GetAllocatableResource(p.bdConfig.OvercommitRatio)
+			if free.Ingress <= 0 || free.Egress <= 0 {
+				score = math.Inf(1)
+			}
+		}
+		if mostIdleNode == "" || score < minScore {
 			mostIdleNode = nodename
-			minIdle = idle
+			minScore = score
 		}
 	}
 	return &plugintypes.GetMostIdleNodeResponse{
 		Nodename: mostIdleNode,
-		Priority: priority,
+		Priority: p.bdConfig.Idle.Priority,
 	}, nil
 }
 
@@ -278,175 +455,384 @@ func (p Plugin) FixNodeResource(ctx context.Context, nodename string, workloadsR
 		return nil, err
 	}
 
-	if len(diffs) != 0 {
-		nodeResourceInfo.Usage = &bdtypes.NodeResource{
-			Bandwidth: actuallyWorkloadsUsage.Bandwidth,
-		}
-		if err = p.doSetNodeResourceInfo(ctx, nodename, nodeResourceInfo); err != nil {
+	// warnings are returned once more and cleared
+	warnings := warningDiffs(nodeResourceInfo)
+	if len(diffs) != 0 || len(warnings) != 0 {
+		nodeResourceInfo.Usage = actuallyWorkloadsUsage
+		// diff again against the resource info being swapped, it may have changed since the read above
+		err = p.updateNodeResourceInfo(ctx, nodename, func(resourceInfo *bdtypes.NodeResourceInfo) error {
+			_, _, wrksResource, err := p.parseNodeResourceInfos(nil, nil, workloadsResource)
+			if err != nil {
+				return err
+			}
+			actuallyWorkloadsUsage, d := p.diffNodeResourceInfo(resourceInfo, wrksResource)
+			resourceInfo.Usage = actuallyWorkloadsUsage
+			p.rebuildLedger(resourceInfo, wrksResource, time.Now())
+			warnings = warningDiffs(resourceInfo)
+			resourceInfo.Warnings = nil
+			nodeResourceInfo, diffs = resourceInfo, d
+			return nil
+		})
+		if err != nil {
 			log.WithFunc("resource.bandwidth.FixNodeResource").Error(ctx, err)
 			diffs = append(diffs, err.Error())
 		}
 	}
+	// drift is fixed by the agent of the node, not here
 	return &plugintypes.GetNodeResourceInfoResponse{
 		Capacity: nodeResourceInfo.Capacity.AsRawParams(),
 		Usage:    nodeResourceInfo.Usage.AsRawParams(),
-		Diffs:    diffs,
+		Diffs:    append(append(diffs, warnings...), driftDiffs(nodeResourceInfo)...),
 	}, nil
 }
 
-func (p Plugin) getNodeResourceInfo(ctx context.Context, nodename string, workloadsResource []plugintypes.WorkloadResource) (*bdtypes.NodeResourceInfo, *bdtypes.WorkloadResource, []string, error) {
+func (p Plugin) getNodeResourceInfo(ctx context.Context, nodename string, workloadsResource []plugintypes.WorkloadResource) (*bdtypes.NodeResourceInfo, *bdtypes.NodeResource, []string, error) {
 	logger := log.WithFunc("resource.bandwidth.getNodeResourceInfo").WithField("node", nodename)
 	nodeResourceInfo, err := p.doGetNodeResourceInfo(ctx, nodename)
 	if err != nil {
 		logger.Error(ctx, err)
 		return nodeResourceInfo, nil, nil, err
 	}
+	_, _, wrksResource, err := p.parseNodeResourceInfos(nil, nil, workloadsResource)
+	if err != nil {
+		logger.Error(ctx, err)
+		return nil, nil, nil, err
+	}
+	actuallyWorkloadsUsage, diffs := p.diffNodeResourceInfo(nodeResourceInfo, wrksResource)
+	return nodeResourceInfo, actuallyWorkloadsUsage, diffs, nil
+}
 
-	actuallyWorkloadsUsage := &bdtypes.WorkloadResource{}
+// diffNodeResourceInfo sums workloadsResource up and compares it with the usage and the ledger of nodeResourceInfo
+func (p Plugin) diffNodeResourceInfo(nodeResourceInfo *bdtypes.NodeResourceInfo, workloadsResource []*bdtypes.WorkloadResource) (*bdtypes.NodeResource, []string) {
+	actuallyWorkloadsUsage := &bdtypes.NodeResource{}
 	for _, workloadResource := range workloadsResource {
-		workloadUsage := &bdtypes.WorkloadResource{}
-		if err := workloadUsage.Parse(workloadResource); err != nil {
-			logger.Error(ctx, err)
-			return nil, nil, nil, err
-		}
-		actuallyWorkloadsUsage.Add(workloadUsage)
+		actuallyWorkloadsUsage.Add(workloadResource.NodeResource())
 	}
 
 	diffs := []string{}
 
-	if actuallyWorkloadsUsage.Bandwidth != nodeResourceInfo.UsageBandwidth() {
-		diffs = append(diffs, fmt.Sprintf("node.BandwidthUsed != sum(workload.BandwidthRequest): %.2d != %.2d", nodeResourceInfo.UsageBandwidth(), actuallyWorkloadsUsage.Bandwidth))
+	if actuallyWorkloadsUsage.Ingress != nodeResourceInfo.Usage.Ingress {
+		diffs = append(diffs, fmt.Sprintf("node.IngressUsed != sum(workload.IngressRequest): %.2d != %.2d", nodeResourceInfo.Usage.Ingress, actuallyWorkloadsUsage.Ingress))
+	}
+	if actuallyWorkloadsUsage.Egress != nodeResourceInfo.Usage.Egress {
+		diffs = append(diffs, fmt.Sprintf("node.EgressUsed != sum(workload.EgressRequest): %.2d != %.2d", nodeResourceInfo.Usage.Egress, actuallyWorkloadsUsage.Egress))
+	}
+	for _, nic := range bdtypes.NICNames(nodeResourceInfo.Usage.NICs, actuallyWorkloadsUsage.NICs) {
+		used, actual := nodeResourceInfo.Usage.NIC(nic), actuallyWorkloadsUsage.NIC(nic)
+		if actual.Ingress != used.Ingress {
+			diffs = append(diffs, fmt.Sprintf("node.NICs[%s].IngressUsed != sum(workload.IngressRequest): %.2d != %.2d", nic, used.Ingress, actual.Ingress))
+		}
+		if actual.Egress != used.Egress {
+			diffs = append(diffs, fmt.Sprintf("node.NICs[%s].EgressUsed != sum(workload.EgressRequest): %.2d != %.2d", nic, used.Egress, actual.Egress))
+		}
 	}
 
-	return nodeResourceInfo, actuallyWorkloadsUsage, diffs, nil
+	diffs = append(diffs, p.diffLedger(nodeResourceInfo, workloadsResource)...)
+
+	return actuallyWorkloadsUsage, diffs
 }
 
 func (p Plugin) doGetNodeResourceInfo(ctx context.Context, nodename string) (*bdtypes.NodeResourceInfo, error) {
+	r, _, err := p.doGetNodeResourceInfoWithRevision(ctx, nodename)
+	return r, err
+}
+
+// doGetNodeResourceInfoWithRevision also returns the mod revision of the resource info
+func (p Plugin) doGetNodeResourceInfoWithRevision(ctx context.Context, nodename string) (*bdtypes.NodeResourceInfo, int64, error) {
 	key := fmt.Sprintf(nodeResourceInfoKey, nodename)
-	resp, err := p.store.Get(ctx, key)
+	value, rev, err := p.store.Get(ctx, key)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
-	r := &bdtypes.NodeResourceInfo{}
-	switch resp.Count {
-	case 0:
-		return r, errors.Wrapf(coretypes.ErrNodeNotExists, "key: %s", nodename)
-	case 1:
-		if err := json.Unmarshal(resp.Kvs[0].Value, r); err != nil {
-			return nil, err
-		}
-		return r, nil
-	default:
-		return nil, errors.Wrapf(coretypes.ErrInvaildCount, "key: %s", nodename)
+	if value == nil {
+		return &bdtypes.NodeResourceInfo{}, 0, errors.Wrapf(coretypes.ErrNodeNotExists, "key: %s", nodename)
+	}
+	r, err := p.unmarshalNodeResourceInfo(nodename, value)
+	if err != nil {
+		return nil, 0, err
 	}
+	return r, rev, nil
 }
 
 func (p Plugin) doGetNodesResourceInfo(ctx context.Context, nodenames []string) (map[string]*bdtypes.NodeResourceInfo, error) {
-	keys := []string{}
-	for _, nodename := range nodenames {
-		keys = append(keys, fmt.Sprintf(nodeResourceInfoKey, nodename))
+	values, err := p.store.GetMulti(ctx, nodeResourceInfoKeys(nodenames))
+	if err != nil {
+		return nil, err
 	}
-	resps, err := p.store.GetMulti(ctx, keys)
+	return p.unmarshalNodesResourceInfo(values)
+}
+
+// doGetExistingNodesResourceInfo reads nodes in one read like doGetNodesResourceInfo, nodes not added yet are left out
+func (p Plugin) doGetExistingNodesResourceInfo(ctx context.Context, nodenames []string) (map[string]*bdtypes.NodeResourceInfo, error) {
+	values, err := p.store.GetExisting(ctx, nodeResourceInfoKeys(nodenames))
 	if err != nil {
 		return nil, err
 	}
+	return p.unmarshalNodesResourceInfo(values)
+}
+
+func nodeResourceInfoKeys(nodenames []string) []string {
+	keys := []string{}
+	for _, nodename := range nodenames {
+		keys = append(keys, fmt.Sprintf(nodeResourceInfoKey, nodename))
+	}
+	return keys
+}
 
+func (p Plugin) unmarshalNodesResourceInfo(values map[string][]byte) (map[string]*bdtypes.NodeResourceInfo, error) {
 	result := map[string]*bdtypes.NodeResourceInfo{}
 
-	for _, resp := range resps {
-		r := &bdtypes.NodeResourceInfo{}
-		if err := json.Unmarshal(resp.Value, r); err != nil {
+	for key, value := range values {
+		nodename := utils.Tail(key)
+		r, err := p.unmarshalNodeResourceInfo(nodename, value)
+		if err != nil {
 			return nil, err
 		}
-		result[utils.Tail(string(resp.Key))] = r
+		result[nodename] = r
 	}
 	return result, nil
 }
 
+// unmarshalNodeResourceInfo converts resource info kept in another unit to the unit of the plugin,
+// it's written back in the unit of the plugin by the next update.
+// Resource info without unit was written before the unit was stored, it's taken as in the unit of the plugin.
+func (p Plugin) unmarshalNodeResourceInfo(nodename string, value []byte) (*bdtypes.NodeResourceInfo, error) {
+	r := &bdtypes.NodeResourceInfo{}
+	if err := json.Unmarshal(value, r); err != nil {
+		return nil, err
+	}
+	if r.Unit == "" {
+		// reservations were kept as given, like "100Mbps"
+		if len(r.Reserved) > 0 {
+			reserved, err := bdtypes.NewReserved(r.Reserved, p.unit)
+			if err != nil {
+				return nil, err
+			}
+			r.Reserved = reserved
+		}
+		return r, nil
+	}
+	unit, err := bdtypes.ParseUnit(r.Unit)
+	if err != nil {
+		return nil, errors.Wrapf(err, "node %s", nodename)
+	}
+	if unit != p.unit {
+		r.Convert(unit, p.unit)
+		r.Unit = p.unitName()
+	}
+	return r, nil
+}
+
 func (p Plugin) doSetNodeResourceInfo(ctx context.Context, nodename string, resourceInfo *bdtypes.NodeResourceInfo) error {
-	if err := resourceInfo.Validate(); err != nil {
+	data, err := p.marshalNodeResourceInfo(resourceInfo)
+	if err != nil {
 		return err
 	}
+	return p.store.Put(ctx, fmt.Sprintf(nodeResourceInfoKey, nodename), data)
+}
 
-	data, err := json.Marshal(resourceInfo)
+// doUpdateNodeResourceInfo writes the resource info only if its mod revision is still rev,
+// false means it was changed by others
+func (p Plugin) doUpdateNodeResourceInfo(ctx context.Context, nodename string, resourceInfo *bdtypes.NodeResourceInfo, rev int64) (bool, error) {
+	data, err := p.marshalNodeResourceInfo(resourceInfo)
 	if err != nil {
-		return err
+		return false, err
+	}
+	return p.store.CompareAndSwap(ctx, fmt.Sprintf(nodeResourceInfoKey, nodename), data, rev)
+}
+
+func (p Plugin) marshalNodeResourceInfo(resourceInfo *bdtypes.NodeResourceInfo) ([]byte, error) {
+	if err := resourceInfo.Validate(); err != nil {
+		return nil, err
+	}
+	resourceInfo.Unit = p.unitName()
+	return json.Marshal(resourceInfo)
+}
+
+// unitName returns the name of the unit of the plugin
+func (p Plugin) unitName() string {
+	if p.bdConfig.Unit == "" {
+		return bdtypes.DefaultUnit
 	}
+	return p.bdConfig.Unit
+}
+
+// updateNodeResourceInfo runs update on the resource info of the node and writes it back with compare-and-swap,
+// update runs again on a fresh copy with backoff if the resource info was changed in between
+func (p Plugin) updateNodeResourceInfo(ctx context.Context, nodename string, update func(*bdtypes.NodeResourceInfo) error) error {
+	return retryCAS(ctx, "node "+nodename, func() (bool, error) {
+		resourceInfo, rev, err := p.doGetNodeResourceInfoWithRevision(ctx, nodename)
+		if err != nil {
+			return false, err
+		}
+		if err := update(resourceInfo); err != nil {
+			if errors.Is(err, errSkipUpdate) {
+				return true, nil
+			}
+			return false, err
+		}
+		// expired holds and issues are dropped on every update
+		now := time.Now()
+		resourceInfo.Holds.Prune(now)
+		resourceInfo.Issues.Prune(now)
+		return p.doUpdateNodeResourceInfo(ctx, nodename, resourceInfo, rev)
+	})
+}
+
+// retryCAS runs try until it succeeds or fails, try returns false if its compare-and-swap lost to others,
+// it's run again with backoff then
+func retryCAS(ctx context.Context, what string, try func() (bool, error)) error {
+	backoff := updateBackoff
+	for retries := 0; ; retries++ {
+		ok, err := try()
+		if err != nil || ok {
+			return err
+		}
+		if retries >= updateRetries {
+			return errors.Wrapf(bdtypes.ErrConcurrentUpdate, "%s, gave up after %d retries", what, retries)
+		}
 
-	_, err = p.store.Put(ctx, fmt.Sprintf(nodeResourceInfoKey, nodename), string(data))
-	return err
+		// jitter spreads the retries of concurrent writers
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1)) //nolint:gosec
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		backoff *= 2
+	}
 }
 
-func (p Plugin) doGetNodeDeployCapacity(nodeResourceInfo *bdtypes.NodeResourceInfo, req *bdtypes.WorkloadResourceRequest) *plugintypes.NodeDeployCapacity {
-	// bandwidth oversale is allowed, so just return the max capacity
+// doGetNodeDeployCapacity returns how many workloads of req fit in the free bandwidth of the node under overcommit ratio,
+// it's reported even if capacity check is off, which only stops the plugin from rejecting deploys itself
+// and leaves directions without registered bandwidth unlimited
+func (p Plugin) doGetNodeDeployCapacity(nodename string, nodeResourceInfo *bdtypes.NodeResourceInfo, req *bdtypes.WorkloadResourceRequest) *plugintypes.NodeDeployCapacity {
 	capacityInfo := &plugintypes.NodeDeployCapacity{
-		Weight:   1, // TODO why 1?
-		Capacity: maxCapacity,
+		Weight: p.nodeWeight(nodename, nodeResourceInfo),
+	}
+	nics := nodeResourceInfo.CandidateNICs(req.NIC, req.NICLabel)
+	if len(nics) > 0 {
+		capacityInfo.Capacity = p.doGetNICsDeployCapacity(nodeResourceInfo, nics, req)
+	}
+	capacityInfo.Usage = nodeResourceInfo.Usage.Ratio(nodeResourceInfo.Capacity)
+	nic := ""
+	if len(nics) > 0 {
+		nic = nics[0]
 	}
-	if nodeResourceInfo.CapBandwidth() > 0 {
-		capacityInfo.Usage = float64(nodeResourceInfo.UsageBandwidth()) / float64(nodeResourceInfo.CapBandwidth())
-		capacityInfo.Rate = float64(req.Bandwidth) / float64(nodeResourceInfo.CapBandwidth())
+	capacityInfo.Rate = p.newWorkloadResource(nodeResourceInfo, req, nic).NodeResource().Ratio(nodeResourceInfo.Capacity)
+	if p.bdConfig.Placement == bdtypes.PlacementBinpack {
+		// core prefers low usage plus rate, inverted ones make it fill the busiest node first,
+		// and the fuller the node the more bandwidth counts against other resources
+		capacityInfo.Weight *= 1 + capacityInfo.Usage
+		capacityInfo.Usage = 1 - capacityInfo.Usage
+		capacityInfo.Rate = -capacityInfo.Rate
 	}
 	return capacityInfo
 }
 
+// nodeWeight returns the weight of the node in core's average of resource usage,
+// a configured weight or its capacity in the busier direction relative to the weight base
+func (p Plugin) nodeWeight(nodename string, nodeResourceInfo *bdtypes.NodeResourceInfo) float64 {
+	if weight, ok := p.bdConfig.Weight.Nodes[nodename]; ok {
+		return weight
+	}
+	capacity := nodeResourceInfo.Capacity.NIC("")
+	bandwidth := capacity.Ingress
+	if capacity.Egress > bandwidth {
+		bandwidth = capacity.Egress
+	}
+	// core divides by the weight, nodes without bandwidth are neutral
+	if bandwidth <= 0 {
+		return 1
+	}
+	return float64(bandwidth) / float64(p.weightBase)
+}
+
+// doGetNICsDeployCapacity sums up capacity of each NIC, bounded by the capacity of the whole node
+func (p Plugin) doGetNICsDeployCapacity(nodeResourceInfo *bdtypes.NodeResourceInfo, nics []string, req *bdtypes.WorkloadResourceRequest) int {
+	nodeAllocatable := nodeResourceInfo.GetAllocatableResource(p.bdConfig.OvercommitRatio)
+	// reservations of guaranteed workloads depend on the NIC
+	nodeCapacity, capacity := 0, 0
+	for _, nic := range nics {
+		need := p.newWorkloadResource(nodeResourceInfo, req, nic).NodeResource()
+		nodeNeed, nicNeed := need, need
+		if !p.bdConfig.CapacityCheck {
+			nodeNeed = withoutUnregistered(need, nodeResourceInfo.Capacity.NIC(""))
+			nicNeed = withoutUnregistered(nodeNeed, nodeResourceInfo.Capacity.NIC(nic))
+		}
+		if fit := nodeAllocatable.Fit(nodeNeed); fit > nodeCapacity {
+			nodeCapacity = fit
+		}
+		capacity += nodeResourceInfo.GetNICAllocatableResource(nic, p.bdConfig.OvercommitRatio).Fit(nicNeed)
+		if capacity < 0 {
+			capacity = math.MaxInt
+		}
+	}
+	if capacity > nodeCapacity {
+		return nodeCapacity
+	}
+	return capacity
+}
+
+// withoutUnregistered drops the directions of need which capacity has no bandwidth in,
+// with capacity check off they are not limited, so nodes which never registered bandwidth stay schedulable
+func withoutUnregistered(need *bdtypes.NodeResource, capacity *bdtypes.NodeResource) *bdtypes.NodeResource {
+	res := bdtypes.NewNodeResource(need.Ingress, need.Egress)
+	if capacity.Ingress <= 0 {
+		res.Ingress = 0
+	}
+	if capacity.Egress <= 0 {
+		res.Egress = 0
+	}
+	return res
+}
+
 // 丢弃origin，完全用新数据重写
-func (p Plugin) overwriteNodeResource(req *bdtypes.NodeResourceRequest, nodeResource *bdtypes.NodeResource, workloadsResource []*bdtypes.WorkloadResource) *bdtypes.NodeResource {
+func (p Plugin) overwriteNodeResource(req *bdtypes.NodeResourceRequest, nodeResource *bdtypes.NodeResource, workloadsResource []*bdtypes.WorkloadResource) (*bdtypes.NodeResource, []*bdtypes.RangeError) {
 	resp := (&bdtypes.NodeResource{}).DeepCopy() // init nil pointer!
 	if req != nil {
-		nodeResource = &bdtypes.NodeResource{
-			Bandwidth: req.Bandwidth,
-		}
+		nodeResource = req.NodeResource()
 	}
 
 	if nodeResource != nil {
-		resp.Add(nodeResource)
-		return resp
+		return resp, resp.AddChecked(nodeResource)
 	}
 
+	var rangeErrs []*bdtypes.RangeError
 	for _, workloadResource := range workloadsResource {
-		nodeResource = &bdtypes.NodeResource{
-			Bandwidth: workloadResource.Bandwidth,
-		}
-		resp.Add(nodeResource)
+		nodeResource = workloadResource.NodeResource()
+		rangeErrs = append(rangeErrs, resp.AddChecked(nodeResource)...)
 	}
-	return resp
+	return resp, rangeErrs
 }
 
 // 增量更新
-func (p Plugin) incrUpdateNodeResource(req *bdtypes.NodeResourceRequest, nodeResource *bdtypes.NodeResource, origin *bdtypes.NodeResource, workloadsResource []*bdtypes.WorkloadResource, incr bool) *bdtypes.NodeResource {
+func (p Plugin) incrUpdateNodeResource(req *bdtypes.NodeResourceRequest, nodeResource *bdtypes.NodeResource, origin *bdtypes.NodeResource, workloadsResource []*bdtypes.WorkloadResource, incr bool) (*bdtypes.NodeResource, []*bdtypes.RangeError) {
 	resp := origin.DeepCopy()
 	if req != nil {
-		nodeResource = &bdtypes.NodeResource{
-			Bandwidth: req.Bandwidth,
-		}
+		nodeResource = req.NodeResource()
 	}
 
+	update := resp.SubChecked
+	if incr {
+		update = resp.AddChecked
+	}
 	if nodeResource != nil {
-		if incr {
-			resp.Add(nodeResource)
-		} else {
-			resp.Sub(nodeResource)
-		}
-		return resp
+		return resp, update(nodeResource)
 	}
 
+	var rangeErrs []*bdtypes.RangeError
 	for _, workloadResource := range workloadsResource {
-		nodeResource = &bdtypes.NodeResource{
-			Bandwidth: workloadResource.Bandwidth,
-		}
-		if incr {
-			resp.Add(nodeResource)
-		} else {
-			resp.Sub(nodeResource)
-		}
+		nodeResource = workloadResource.NodeResource()
+		rangeErrs = append(rangeErrs, update(nodeResource)...)
 	}
-	return resp
+	return resp, rangeErrs
 }
 
-// calculateNodeResource priority: node resource request > node resource > workload resource args list
-func (p Plugin) calculateNodeResource(req *bdtypes.NodeResourceRequest, nodeResource *bdtypes.NodeResource, origin *bdtypes.NodeResource, workloadsResource []*bdtypes.WorkloadResource, delta bool, incr bool) *bdtypes.NodeResource {
+// calculateNodeResource priority: node resource request > node resource > workload resource args list,
+// bandwidths out of range are clamped and returned
+func (p Plugin) calculateNodeResource(req *bdtypes.NodeResourceRequest, nodeResource *bdtypes.NodeResource, origin *bdtypes.NodeResource, workloadsResource []*bdtypes.WorkloadResource, delta bool, incr bool) (*bdtypes.NodeResource, []*bdtypes.RangeError) {
 	// req, nodeResource, workloadResource只有一个会生效, 优先级是req, nodeResource, workloadsReource
 	// 如果delta为false那就不考虑origin
 	// 如果delta为true那就把3者中生效的那个加到origin上
@@ -473,21 +859,21 @@ func (p Plugin) parseNodeResourceInfos(
 
 	if resourceRequest != nil {
 		req = &bdtypes.NodeResourceRequest{}
-		if err := req.Parse(resourceRequest); err != nil {
+		if err := req.Parse(resourceRequest, p.unit); err != nil {
 			return nil, nil, nil, err
 		}
 	}
 
 	if resource != nil {
 		nodeResource = &bdtypes.NodeResource{}
-		if err := nodeResource.Parse(resource); err != nil {
+		if err := nodeResource.Parse(resource, p.unit); err != nil {
 			return nil, nil, nil, err
 		}
 	}
 
 	for _, workloadResource := range workloadsResource {
 		wrkResource := &bdtypes.WorkloadResource{}
-		if err := wrkResource.Parse(workloadResource); err != nil {
+		if err := wrkResource.Parse(workloadResource, p.unit); err != nil {
 			return nil, nil, nil, err
 		}
 		wrksResource = append(wrksResource, wrkResource)
@@ -495,3 +881,16 @@ func (p Plugin) parseNodeResourceInfos(
 
 	return req, nodeResource, wrksResource, nil
 }
+
+// resolveNodeResourceInfos resolves percentages of req and nodeResource against capacity
+func (p Plugin) resolveNodeResourceInfos(capacity *bdtypes.NodeResource, req *bdtypes.NodeResourceRequest, nodeResource *bdtypes.NodeResource) error {
+	if req != nil {
+		if err := req.Resolve(capacity); err != nil {
+			return err
+		}
+	}
+	if nodeResource != nil {
+		return nodeResource.Resolve(capacity)
+	}
+	return nil
+}
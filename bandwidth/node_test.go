@@ -4,7 +4,10 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"math"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/docker/go-units"
 	enginetypes "github.com/projecteru2/core/engine/types"
@@ -36,25 +39,28 @@ func TestAddNode(t *testing.T) {
 	// normal case
 	r, err := cm.AddNode(ctx, "xxx", nil, nil)
 	assert.Nil(t, err)
-	err = cv.Parse(r.Capacity)
+	err = cv.Parse(r.Capacity, types.BitUnit)
 	assert.Nil(t, err)
 	// check empty capacity
 	nr, err := cm.GetNodeResourceInfo(ctx, "xxx", nil)
 	assert.Nil(t, err)
-	err = cv.Parse(nr.Capacity)
+	err = cv.Parse(nr.Capacity, types.BitUnit)
 	assert.Nil(t, err)
-	assert.Equal(t, cv.Bandwidth, int64(0))
+	assert.Equal(t, cv.Ingress, int64(0))
+	assert.Equal(t, cv.Egress, int64(0))
 	cm.RemoveNode(ctx, "xxx")
 
 	r, err = cm.AddNode(ctx, nodeForAdd, req, info)
 	assert.Nil(t, err)
-	err = cv.Parse(r.Capacity)
+	err = cv.Parse(r.Capacity, types.BitUnit)
 	assert.Nil(t, err)
-	assert.Equal(t, cv.Bandwidth, int64(20))
+	assert.Equal(t, cv.Ingress, int64(20))
+	assert.Equal(t, cv.Egress, int64(20))
 
 	// test engine info
 	nRes := types.NodeResource{
-		Bandwidth: 50,
+		Ingress: 50,
+		Egress:  40,
 	}
 	data, err := json.Marshal(&nRes)
 	assert.Nil(t, err)
@@ -68,10 +74,49 @@ func TestAddNode(t *testing.T) {
 
 	nr, err = cm.GetNodeResourceInfo(ctx, "xxx1", nil)
 	assert.Nil(t, err)
-	err = cv.Parse(nr.Capacity)
+	err = cv.Parse(nr.Capacity, types.BitUnit)
 	assert.Nil(t, err)
-	assert.Equal(t, cv.Bandwidth, int64(50))
+	assert.Equal(t, cv.Ingress, int64(50))
+	assert.Equal(t, cv.Egress, int64(40))
 	cm.RemoveNode(ctx, "xxx1")
+
+	// engine info in the old symmetric format
+	eInfo = &enginetypes.Info{
+		Resources: map[string][]byte{
+			"bandwidth": []byte(`{"bandwidth": 30}`),
+		},
+	}
+	_, err = cm.AddNode(ctx, "xxx2", nil, eInfo)
+	assert.Nil(t, err)
+	nr, err = cm.GetNodeResourceInfo(ctx, "xxx2", nil)
+	assert.Nil(t, err)
+	err = cv.Parse(nr.Capacity, types.BitUnit)
+	assert.Nil(t, err)
+	assert.Equal(t, cv.Ingress, int64(30))
+	assert.Equal(t, cv.Egress, int64(30))
+	cm.RemoveNode(ctx, "xxx2")
+
+	// percentage of the bandwidth reported by engine
+	_, err = cm.AddNode(ctx, "xxx3", plugintypes.NodeResourceRequest{"bandwidth": "80%"}, eInfo)
+	assert.Nil(t, err)
+	nr, err = cm.GetNodeResourceInfo(ctx, "xxx3", nil)
+	assert.Nil(t, err)
+	err = cv.Parse(nr.Capacity, types.BitUnit)
+	assert.Nil(t, err)
+	assert.Equal(t, cv.Ingress, int64(24))
+	assert.Equal(t, cv.Egress, int64(24))
+	cm.RemoveNode(ctx, "xxx3")
+
+	// units
+	_, err = cm.AddNode(ctx, "xxx4", plugintypes.NodeResourceRequest{"ingress": "1Kbps", "egress": "1KB/s"}, nil)
+	assert.Nil(t, err)
+	nr, err = cm.GetNodeResourceInfo(ctx, "xxx4", nil)
+	assert.Nil(t, err)
+	err = cv.Parse(nr.Capacity, types.BitUnit)
+	assert.Nil(t, err)
+	assert.Equal(t, cv.Ingress, int64(1000))
+	assert.Equal(t, cv.Egress, int64(8000))
+	cm.RemoveNode(ctx, "xxx4")
 }
 
 func TestRemoveNode(t *testing.T) {
@@ -98,13 +143,27 @@ func TestGetNodesDeployCapacity(t *testing.T) {
 	nodes := generateEmptyNodes(ctx, t, cm, 2, 0)
 	r, err := cm.GetNodesDeployCapacity(ctx, nodes, nil)
 	assert.Nil(t, err)
-	assert.Equal(t, 2*maxCapacity, r.Total)
+	assert.Equal(t, math.MaxInt, r.Total)
 	for _, node := range nodes {
 		cap := r.NodeDeployCapacityMap[node]
-		assert.Equal(t, maxCapacity, cap.Capacity)
+		assert.Equal(t, math.MaxInt, cap.Capacity)
 		assert.Equal(t, float64(0), cap.Usage)
 		assert.Equal(t, float64(0), cap.Rate)
+		assert.Equal(t, float64(1), cap.Weight)
 	}
+	// nodes without bandwidth stay schedulable for any request unless capacity check is on
+	r, err = cm.GetNodesDeployCapacity(ctx, nodes, plugintypes.WorkloadResourceRequest{"bandwidth": 20})
+	assert.Nil(t, err)
+	assert.Equal(t, math.MaxInt, r.Total)
+	for _, node := range nodes {
+		assert.Equal(t, math.MaxInt, r.NodeDeployCapacityMap[node].Capacity)
+	}
+	cm.bdConfig.CapacityCheck = true
+	r, err = cm.GetNodesDeployCapacity(ctx, nodes, plugintypes.WorkloadResourceRequest{"bandwidth": 20})
+	cm.bdConfig.CapacityCheck = false
+	assert.Nil(t, err)
+	assert.Equal(t, 0, r.Total)
+	assert.Empty(t, r.NodeDeployCapacityMap)
 
 	nodes = generateNodes(ctx, t, cm, 2, 0)
 
@@ -120,47 +179,181 @@ func TestGetNodesDeployCapacity(t *testing.T) {
 	// 1. empty request
 	r, err = cm.GetNodesDeployCapacity(ctx, nodes, nil)
 	assert.Nil(t, err)
-	assert.Equal(t, 2*maxCapacity, r.Total)
+	assert.Equal(t, math.MaxInt, r.Total)
+	for _, node := range nodes {
+		cap := r.NodeDeployCapacityMap[node]
+		assert.Equal(t, math.MaxInt, cap.Capacity)
+	}
+
+	// capacity is reported even without capacity check
+	r, err = cm.GetNodesDeployCapacity(ctx, nodes, req)
+	assert.Nil(t, err)
+	assert.Equal(t, 10, r.Total)
+	for _, node := range nodes {
+		cap := r.NodeDeployCapacityMap[node]
+		assert.Equal(t, 5, cap.Capacity)
+		assert.Equal(t, 100/1e9, cap.Weight)
+	}
+
+	// more bandwidth
+	req = plugintypes.WorkloadResourceRequest{
+		"bandwidth": 60,
+	}
+	r, err = cm.GetNodesDeployCapacity(ctx, nodes, req)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, r.Total)
+	for _, node := range nodes {
+		cap := r.NodeDeployCapacityMap[node]
+		assert.Equal(t, 1, cap.Capacity)
+	}
+
+	req = plugintypes.WorkloadResourceRequest{
+		"bandwidth": 100,
+	}
+	r, err = cm.GetNodesDeployCapacity(ctx, nodes, req)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, r.Total)
 	for _, node := range nodes {
 		cap := r.NodeDeployCapacityMap[node]
-		assert.Equal(t, maxCapacity, cap.Capacity)
+		assert.Equal(t, 1, cap.Capacity)
+	}
+
+	// more bandwidth
+	req = plugintypes.WorkloadResourceRequest{
+		"bandwidth": 101,
+	}
+	r, err = cm.GetNodesDeployCapacity(ctx, nodes, req)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, r.Total)
+	assert.Len(t, r.NodeDeployCapacityMap, 0)
+}
+
+func TestGetNodesDeployCapacityWeight(t *testing.T) {
+	ctx := context.Background()
+	bdConfig := types.DefaultConfig()
+	bdConfig.OvercommitRatio = 2
+	bdConfig.Weight = types.WeightConfig{Base: "50", Nodes: map[string]float64{"test1": 8}}
+	cm := initBandwidthWithConfig(ctx, t, bdConfig)
+	nodes := generateNodes(ctx, t, cm, 2, 0)
+
+	// overcommit ratio is the policy of reported capacity
+	r, err := cm.GetNodesDeployCapacity(ctx, nodes, plugintypes.WorkloadResourceRequest{"bandwidth": 20})
+	assert.Nil(t, err)
+	assert.Equal(t, 20, r.Total)
+	assert.Equal(t, float64(2), r.NodeDeployCapacityMap["test0"].Weight)
+	assert.Equal(t, float64(8), r.NodeDeployCapacityMap["test1"].Weight)
+
+	_, err = cm.SetNodeResourceCapacity(ctx, "test0", nil, plugintypes.NodeResource{"ingress": 100}, true, true)
+	assert.Nil(t, err)
+	r, err = cm.GetNodesDeployCapacity(ctx, nodes, plugintypes.WorkloadResourceRequest{"bandwidth": 20})
+	assert.Nil(t, err)
+	assert.Equal(t, float64(4), r.NodeDeployCapacityMap["test0"].Weight)
+}
+
+func TestGetNodesDeployCapacityWithCapacityCheck(t *testing.T) {
+	ctx := context.Background()
+	cm := initBandwidthWithConfig(ctx, t, capacityCheckConfig(1))
+	nodes := generateNodes(ctx, t, cm, 2, 0)
+
+	// empty request
+	r, err := cm.GetNodesDeployCapacity(ctx, nodes, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, math.MaxInt, r.Total)
+
+	req := plugintypes.WorkloadResourceRequest{
+		"bandwidth": 20,
+	}
+	r, err = cm.GetNodesDeployCapacity(ctx, nodes, req)
+	assert.Nil(t, err)
+	assert.Equal(t, 10, r.Total)
+	for _, node := range nodes {
+		assert.Equal(t, 5, r.NodeDeployCapacityMap[node].Capacity)
+		assert.Equal(t, 0.2, r.NodeDeployCapacityMap[node].Rate)
+	}
+
+	// more bandwidth
+	req = plugintypes.WorkloadResourceRequest{
+		"bandwidth": 60,
 	}
+	r, err = cm.GetNodesDeployCapacity(ctx, nodes, req)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, r.Total)
+	for _, node := range nodes {
+		assert.Equal(t, 1, r.NodeDeployCapacityMap[node].Capacity)
+	}
+
+	req = plugintypes.WorkloadResourceRequest{
+		"bandwidth": 101,
+	}
+	r, err = cm.GetNodesDeployCapacity(ctx, nodes, req)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, r.Total)
+	assert.Len(t, r.NodeDeployCapacityMap, 0)
 
+	// usage counts
+	_, err = cm.SetNodeResourceUsage(ctx, nodes[0], nil, plugintypes.NodeResource{"bandwidth": 50}, nil, false, true)
+	assert.Nil(t, err)
+	req = plugintypes.WorkloadResourceRequest{
+		"bandwidth": 20,
+	}
 	r, err = cm.GetNodesDeployCapacity(ctx, nodes, req)
 	assert.Nil(t, err)
-	assert.Equal(t, len(nodes)*maxCapacity, r.Total)
-
-	// // more bandwidth
-	// req = plugintypes.WorkloadResourceRequest{
-	// 	"bandwidth": 60,
-	// }
-	// r, err = cm.GetNodesDeployCapacity(ctx, nodes, req)
-	// assert.Nil(t, err)
-	// assert.Equal(t, 2, r.Total)
-	// for _, node := range nodes {
-	// 	cap := r.NodeDeployCapacityMap[node]
-	// 	assert.Equal(t, 1, cap.Capacity)
-	// }
-
-	// req = plugintypes.WorkloadResourceRequest{
-	// 	"bandwidth": 100,
-	// }
-	// r, err = cm.GetNodesDeployCapacity(ctx, nodes, req)
-	// assert.Nil(t, err)
-	// assert.Equal(t, 2, r.Total)
-	// for _, node := range nodes {
-	// 	cap := r.NodeDeployCapacityMap[node]
-	// 	assert.Equal(t, 1, cap.Capacity)
-	// }
-
-	// // more bandwidth
-	// req = plugintypes.WorkloadResourceRequest{
-	// 	"bandwidth": 101,
-	// }
-	// r, err = cm.GetNodesDeployCapacity(ctx, nodes, req)
-	// assert.Nil(t, err)
-	// assert.Equal(t, 0, r.Total)
-	// assert.Len(t, r.NodeDeployCapacityMap, 0)
+	assert.Equal(t, 7, r.Total)
+	assert.Equal(t, 2, r.NodeDeployCapacityMap[nodes[0]].Capacity)
+	assert.Equal(t, 0.5, r.NodeDeployCapacityMap[nodes[0]].Usage)
+}
+
+func TestGetNodesDeployCapacityWithNICs(t *testing.T) {
+	ctx := context.Background()
+	cm := initBandwidthWithConfig(ctx, t, capacityCheckConfig(1))
+	node := "test-nics"
+	_, err := cm.AddNode(ctx, node, plugintypes.NodeResourceRequest{
+		"nics": map[string]any{
+			"eth0": map[string]any{"bandwidth": 100, "labels": []string{"storage-net"}},
+			"eth1": map[string]any{"bandwidth": 50},
+		},
+	}, nil)
+	assert.Nil(t, err)
+	t.Cleanup(func() {
+		_, err := cm.RemoveNode(ctx, node)
+		assert.Nil(t, err)
+	})
+
+	// a workload can't span nics
+	r, err := cm.GetNodesDeployCapacity(ctx, []string{node}, plugintypes.WorkloadResourceRequest{"bandwidth": 30})
+	assert.Nil(t, err)
+	assert.Equal(t, 4, r.Total)
+	r, err = cm.GetNodesDeployCapacity(ctx, []string{node}, plugintypes.WorkloadResourceRequest{"bandwidth": 60})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, r.Total)
+
+	r, err = cm.GetNodesDeployCapacity(ctx, []string{node}, plugintypes.WorkloadResourceRequest{"bandwidth": 30, "nic": "eth1"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, r.Total)
+	r, err = cm.GetNodesDeployCapacity(ctx, []string{node}, plugintypes.WorkloadResourceRequest{"bandwidth": 30, "nic_label": "storage-net"})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, r.Total)
+	r, err = cm.GetNodesDeployCapacity(ctx, []string{node}, plugintypes.WorkloadResourceRequest{"bandwidth": 30, "nic": "eth2"})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, r.Total)
+	assert.Len(t, r.NodeDeployCapacityMap, 0)
+
+	// per nic usage is fixed from workloads
+	workloadsResource := []plugintypes.WorkloadResource{
+		{"bandwidth": 30, "nic": "eth0"},
+		{"bandwidth": 20, "nic": "eth1"},
+	}
+	fr, err := cm.FixNodeResource(ctx, node, workloadsResource)
+	assert.Nil(t, err)
+	assert.Len(t, fr.Diffs, 6)
+	gr, err := cm.GetNodeResourceInfo(ctx, node, workloadsResource)
+	assert.Nil(t, err)
+	assert.Len(t, gr.Diffs, 0)
+
+	r, err = cm.GetNodesDeployCapacity(ctx, []string{node}, plugintypes.WorkloadResourceRequest{"bandwidth": 30})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, r.Total)
+	assert.InDelta(t, 1.0/3, r.NodeDeployCapacityMap[node].Usage, 1e-9)
 }
 
 func TestSetNodeResourceCapacity(t *testing.T) {
@@ -172,9 +365,10 @@ func TestSetNodeResourceCapacity(t *testing.T) {
 	capcaity := &types.NodeResource{}
 	gr, err := cm.GetNodeResourceInfo(ctx, node, nil)
 	assert.Nil(t, err)
-	err = capcaity.Parse(gr.Capacity)
+	err = capcaity.Parse(gr.Capacity, types.BitUnit)
 	assert.Nil(t, err)
-	assert.Equal(t, capcaity.Bandwidth, int64(100))
+	assert.Equal(t, capcaity.Ingress, int64(100))
+	assert.Equal(t, capcaity.Egress, int64(100))
 
 	nodeResource := plugintypes.NodeResource{
 		"bandwidth": 10,
@@ -186,67 +380,89 @@ func TestSetNodeResourceCapacity(t *testing.T) {
 
 	parse := func(r *plugintypes.SetNodeResourceCapacityResponse) (*types.NodeResource, *types.NodeResource) {
 		before := &types.NodeResource{}
-		err := before.Parse(r.Before)
+		err := before.Parse(r.Before, types.BitUnit)
 		assert.Nil(t, err)
 		after := &types.NodeResource{}
-		err = after.Parse(r.After)
+		err = after.Parse(r.After, types.BitUnit)
 		assert.Nil(t, err)
 		return before, after
 	}
 	r, err := cm.SetNodeResourceCapacity(ctx, node, nil, nil, true, true)
 	assert.Nil(t, err)
 	_, v := parse(r)
-	assert.Equal(t, v.Bandwidth, int64(100))
+	assert.Equal(t, v.Ingress, int64(100))
+	assert.Equal(t, v.Egress, int64(100))
 
 	r, err = cm.SetNodeResourceCapacity(ctx, node, nil, nil, true, false)
 	assert.Nil(t, err)
 	_, v = parse(r)
-	assert.Equal(t, v.Bandwidth, int64(100))
+	assert.Equal(t, v.Ingress, int64(100))
+	assert.Equal(t, v.Egress, int64(100))
 
 	// INC
 	r, err = cm.SetNodeResourceCapacity(ctx, node, nodeResourceRequest, nil, true, true)
 	assert.Nil(t, err)
 	_, v = parse(r)
-	assert.Equal(t, v.Bandwidth, int64(110))
+	assert.Equal(t, v.Ingress, int64(110))
+	assert.Equal(t, v.Egress, int64(110))
 
 	// DEC
 	r, err = cm.SetNodeResourceCapacity(ctx, node, nodeResourceRequest, nil, true, false)
 	assert.Nil(t, err)
 	_, v = parse(r)
-	assert.Equal(t, v.Bandwidth, int64(100))
+	assert.Equal(t, v.Ingress, int64(100))
+	assert.Equal(t, v.Egress, int64(100))
 
 	// INC
 	r, err = cm.SetNodeResourceCapacity(ctx, node, nil, nodeResource, true, true)
 	assert.Nil(t, err)
 	_, v = parse(r)
-	assert.Equal(t, v.Bandwidth, int64(110))
+	assert.Equal(t, v.Ingress, int64(110))
+	assert.Equal(t, v.Egress, int64(110))
 
 	// DEC
 	r, err = cm.SetNodeResourceCapacity(ctx, node, nodeResource, nil, true, false)
 	assert.Nil(t, err)
 	_, v = parse(r)
-	assert.Equal(t, v.Bandwidth, int64(100))
+	assert.Equal(t, v.Ingress, int64(100))
+	assert.Equal(t, v.Egress, int64(100))
 
 	// overwirte node resource
 	r, err = cm.SetNodeResourceCapacity(ctx, node, nodeResourceRequest, nil, false, false)
 	assert.Nil(t, err)
 	_, v = parse(r)
-	assert.Equal(t, v.Bandwidth, int64(10))
+	assert.Equal(t, v.Ingress, int64(10))
+	assert.Equal(t, v.Egress, int64(10))
 
 	r, err = cm.SetNodeResourceCapacity(ctx, node, nil, nodeResource, false, false)
 	assert.Nil(t, err)
 	_, v = parse(r)
-	assert.Equal(t, v.Bandwidth, int64(10))
+	assert.Equal(t, v.Ingress, int64(10))
+	assert.Equal(t, v.Egress, int64(10))
 
 	r, err = cm.SetNodeResourceCapacity(ctx, node, nodeResourceRequest, nodeResource, false, false)
 	assert.Nil(t, err)
 	_, v = parse(r)
-	assert.Equal(t, v.Bandwidth, int64(10))
+	assert.Equal(t, v.Ingress, int64(10))
+	assert.Equal(t, v.Egress, int64(10))
+
+	// percentage of the current capacity
+	r, err = cm.SetNodeResourceCapacity(ctx, node, plugintypes.NodeResourceRequest{"bandwidth": "50%"}, nil, true, true)
+	assert.Nil(t, err)
+	_, v = parse(r)
+	assert.Equal(t, v.Ingress, int64(15))
+	assert.Equal(t, v.Egress, int64(15))
+	r, err = cm.SetNodeResourceCapacity(ctx, node, plugintypes.NodeResourceRequest{"ingress": "200%"}, nil, false, false)
+	assert.Nil(t, err)
+	_, v = parse(r)
+	assert.Equal(t, v.Ingress, int64(30))
+	assert.Equal(t, v.Egress, int64(15))
 
 	r, err = cm.SetNodeResourceCapacity(ctx, node, nil, nil, false, false)
 	assert.Nil(t, err)
 	_, v = parse(r)
-	assert.Equal(t, v.Bandwidth, int64(0))
+	assert.Equal(t, v.Ingress, int64(0))
+	assert.Equal(t, v.Egress, int64(0))
 
 	// for negative add
 	nodeResourceRequest1 := plugintypes.NodeResourceRequest{
@@ -255,7 +471,8 @@ func TestSetNodeResourceCapacity(t *testing.T) {
 	r, err = cm.SetNodeResourceCapacity(ctx, node, nodeResourceRequest1, nil, true, true)
 	assert.Nil(t, err)
 	_, v = parse(r)
-	assert.Equal(t, v.Bandwidth, int64(10))
+	assert.Equal(t, v.Ingress, int64(10))
+	assert.Equal(t, v.Egress, int64(10))
 
 	nodeResourceRequest1 = plugintypes.NodeResourceRequest{
 		"bandwidth": -10,
@@ -263,7 +480,8 @@ func TestSetNodeResourceCapacity(t *testing.T) {
 	r, err = cm.SetNodeResourceCapacity(ctx, node, nodeResourceRequest1, nil, true, true)
 	assert.Nil(t, err)
 	_, v = parse(r)
-	assert.Equal(t, v.Bandwidth, int64(0))
+	assert.Equal(t, v.Ingress, int64(0))
+	assert.Equal(t, v.Egress, int64(0))
 
 }
 
@@ -291,15 +509,31 @@ func TestGetAndFixNodeResourceInfo(t *testing.T) {
 	}
 	r, err = cm.GetNodeResourceInfo(ctx, node, workloadsResource)
 	assert.Nil(t, err)
-	assert.Len(t, r.Diffs, 1)
+	assert.Len(t, r.Diffs, 2)
 
 	r, err = cm.FixNodeResource(ctx, node, workloadsResource)
 	assert.Nil(t, err)
-	assert.Len(t, r.Diffs, 1)
+	assert.Len(t, r.Diffs, 2)
 	usage := &types.NodeResource{}
-	err = usage.Parse(r.Usage)
+	err = usage.Parse(r.Usage, types.BitUnit)
+	assert.Nil(t, err)
+	assert.Equal(t, usage.Ingress, int64(20))
+	assert.Equal(t, usage.Egress, int64(20))
+
+	// only egress drifts
+	workloadsResource = []plugintypes.WorkloadResource{
+		{
+			"ingress": 20,
+			"egress":  5,
+		},
+	}
+	r, err = cm.FixNodeResource(ctx, node, workloadsResource)
 	assert.Nil(t, err)
-	assert.Equal(t, usage.Bandwidth, int64(20))
+	assert.Len(t, r.Diffs, 1)
+	err = usage.Parse(r.Usage, types.BitUnit)
+	assert.Nil(t, err)
+	assert.Equal(t, usage.Ingress, int64(20))
+	assert.Equal(t, usage.Egress, int64(5))
 }
 
 func TestSetNodeResourceInfo(t *testing.T) {
@@ -311,12 +545,14 @@ func TestSetNodeResourceInfo(t *testing.T) {
 	capacity, usage := &types.NodeResource{}, &types.NodeResource{}
 	r, err := cm.GetNodeResourceInfo(ctx, node, nil)
 	assert.Nil(t, err)
-	err = capacity.Parse(r.Capacity)
+	err = capacity.Parse(r.Capacity, types.BitUnit)
 	assert.Nil(t, err)
-	err = usage.Parse(r.Usage)
+	err = usage.Parse(r.Usage, types.BitUnit)
 	assert.Nil(t, err)
-	assert.Equal(t, capacity.Bandwidth, int64(100))
-	assert.Equal(t, usage.Bandwidth, int64(0))
+	assert.Equal(t, capacity.Ingress, int64(100))
+	assert.Equal(t, capacity.Egress, int64(100))
+	assert.Equal(t, usage.Ingress, int64(0))
+	assert.Equal(t, usage.Egress, int64(0))
 
 	rcv := resourcetypes.RawParams{
 		"bandwidth": 30,
@@ -329,12 +565,55 @@ func TestSetNodeResourceInfo(t *testing.T) {
 
 	r, err = cm.GetNodeResourceInfo(ctx, "node-2", nil)
 	assert.Nil(t, err)
-	err = capacity.Parse(r.Capacity)
+	err = capacity.Parse(r.Capacity, types.BitUnit)
+	assert.Nil(t, err)
+	err = usage.Parse(r.Usage, types.BitUnit)
+	assert.Nil(t, err)
+	assert.Equal(t, capacity.Ingress, int64(30))
+	assert.Equal(t, capacity.Egress, int64(30))
+	assert.Equal(t, usage.Ingress, int64(40))
+	assert.Equal(t, usage.Egress, int64(40))
+}
+
+func TestSetNodeResourceInfoKeepsState(t *testing.T) {
+	ctx := context.Background()
+	cm := initBandwidth(ctx, t)
+	node := "test-keep"
+	_, err := cm.AddNode(ctx, node, plugintypes.NodeResourceRequest{"bandwidth": 100, "reserved": 10}, nil)
+	assert.Nil(t, err)
+	t.Cleanup(func() {
+		_, err := cm.RemoveNode(ctx, node)
+		assert.Nil(t, err)
+	})
+	d, err := cm.CalculateDeploy(ctx, node, 2, plugintypes.WorkloadResourceRequest{"bandwidth": 10, "hold": "1h"})
+	assert.Nil(t, err)
+	_, err = cm.SetNodeResourceUsage(ctx, node, nil, nil, d.WorkloadsResource[:1], true, true)
+	assert.Nil(t, err)
+	before, err := cm.doGetNodeResourceInfo(ctx, node)
+	assert.Nil(t, err)
+	assert.Len(t, before.Workloads, 1)
+	assert.Len(t, before.Holds, 1)
+
+	_, err = cm.SetNodeResourceInfo(ctx, node, resourcetypes.RawParams{"bandwidth": 200}, resourcetypes.RawParams{"bandwidth": 10})
+	assert.Nil(t, err)
+	after, err := cm.doGetNodeResourceInfo(ctx, node)
+	assert.Nil(t, err)
+	assert.Equal(t, types.NewNodeResource(200, 200), after.Capacity)
+	assert.Equal(t, types.NewNodeResource(10, 10), after.Usage)
+	assert.Equal(t, before.Reserved, after.Reserved)
+	assert.Equal(t, before.Workloads, after.Workloads)
+	assert.Equal(t, before.Holds, after.Holds)
+	assert.Empty(t, after.Warnings)
+
+	// usage below the ledger is reported until fixed
+	_, err = cm.SetNodeResourceInfo(ctx, node, resourcetypes.RawParams{"bandwidth": 200}, resourcetypes.RawParams{"bandwidth": 5})
+	assert.Nil(t, err)
+	r, err := cm.GetNodeResourceInfo(ctx, node, nil)
 	assert.Nil(t, err)
-	err = usage.Parse(r.Usage)
+	assert.Contains(t, r.Diffs, "ledger warning: usage 5/5 set by set-node-resource-info is less than the ledger 10/10")
+	after, err = cm.doGetNodeResourceInfo(ctx, node)
 	assert.Nil(t, err)
-	assert.Equal(t, capacity.Bandwidth, int64(30))
-	assert.Equal(t, usage.Bandwidth, int64(40))
+	assert.Equal(t, before.Workloads, after.Workloads)
 }
 
 func TestSetNodeResourceUsage(t *testing.T) {
@@ -346,9 +625,10 @@ func TestSetNodeResourceUsage(t *testing.T) {
 	usage := &types.NodeResource{}
 	gr, err := cm.GetNodeResourceInfo(ctx, node, nil)
 	assert.Nil(t, err)
-	err = usage.Parse(gr.Usage)
+	err = usage.Parse(gr.Usage, types.BitUnit)
 	assert.Nil(t, err)
-	assert.Equal(t, usage.Bandwidth, int64(0))
+	assert.Equal(t, usage.Ingress, int64(0))
+	assert.Equal(t, usage.Egress, int64(0))
 
 	nodeResource := plugintypes.NodeResource{
 		"bandwidth": 10,
@@ -366,105 +646,122 @@ func TestSetNodeResourceUsage(t *testing.T) {
 
 	parse := func(r *plugintypes.SetNodeResourceUsageResponse) (*types.NodeResource, *types.NodeResource) {
 		before := &types.NodeResource{}
-		err := before.Parse(r.Before)
+		err := before.Parse(r.Before, types.BitUnit)
 		assert.Nil(t, err)
 		after := &types.NodeResource{}
-		err = after.Parse(r.After)
+		err = after.Parse(r.After, types.BitUnit)
 		assert.Nil(t, err)
 		return before, after
 	}
 	r, err := cm.SetNodeResourceUsage(ctx, node, nil, nil, nil, true, true)
 	assert.Nil(t, err)
 	_, v := parse(r)
-	assert.Equal(t, v.Bandwidth, int64(0))
+	assert.Equal(t, v.Ingress, int64(0))
+	assert.Equal(t, v.Egress, int64(0))
 
 	// all are nil
 	r, err = cm.SetNodeResourceUsage(ctx, node, nil, nil, nil, true, false)
 	assert.Nil(t, err)
 	_, v = parse(r)
-	assert.Equal(t, v.Bandwidth, int64(0))
+	assert.Equal(t, v.Ingress, int64(0))
+	assert.Equal(t, v.Egress, int64(0))
 
 	r, err = cm.SetNodeResourceUsage(ctx, node, nodeResourceRequest, nil, nil, true, true)
 	assert.Nil(t, err)
 	_, v = parse(r)
-	assert.Equal(t, v.Bandwidth, int64(10))
+	assert.Equal(t, v.Ingress, int64(10))
+	assert.Equal(t, v.Egress, int64(10))
 
 	// only request is  not nil
 	r, err = cm.SetNodeResourceUsage(ctx, node, nodeResourceRequest, nil, nil, true, false)
 	assert.Nil(t, err)
 	_, v = parse(r)
-	assert.Equal(t, v.Bandwidth, int64(0))
+	assert.Equal(t, v.Ingress, int64(0))
+	assert.Equal(t, v.Egress, int64(0))
 
 	// only resource is not nil
 	r, err = cm.SetNodeResourceUsage(ctx, node, nil, nodeResource, nil, true, true)
 	assert.Nil(t, err)
 	_, v = parse(r)
-	assert.Equal(t, v.Bandwidth, int64(10))
+	assert.Equal(t, v.Ingress, int64(10))
+	assert.Equal(t, v.Egress, int64(10))
 
 	r, err = cm.SetNodeResourceUsage(ctx, node, nil, nodeResource, nil, true, false)
 	assert.Nil(t, err)
 	_, v = parse(r)
-	assert.Equal(t, v.Bandwidth, int64(0))
+	assert.Equal(t, v.Ingress, int64(0))
+	assert.Equal(t, v.Egress, int64(0))
 
 	// only workload resource is not nil
 	r, err = cm.SetNodeResourceUsage(ctx, node, nil, nil, workloadsResource, true, true)
 	assert.Nil(t, err)
 	_, v = parse(r)
-	assert.Equal(t, v.Bandwidth, int64(10))
+	assert.Equal(t, v.Ingress, int64(10))
+	assert.Equal(t, v.Egress, int64(10))
 
 	r, err = cm.SetNodeResourceUsage(ctx, node, nil, nil, workloadsResource, true, false)
 	assert.Nil(t, err)
 	_, v = parse(r)
-	assert.Equal(t, v.Bandwidth, int64(0))
+	assert.Equal(t, v.Ingress, int64(0))
+	assert.Equal(t, v.Egress, int64(0))
 
 	r, err = cm.SetNodeResourceUsage(ctx, node, nil, nil, nil, true, false)
 	assert.Nil(t, err)
 	_, v = parse(r)
-	assert.Equal(t, v.Bandwidth, int64(0))
+	assert.Equal(t, v.Ingress, int64(0))
+	assert.Equal(t, v.Egress, int64(0))
 
 	// overwirte usage node resource
 	// one params
 	r, err = cm.SetNodeResourceUsage(ctx, node, nodeResourceRequest, nil, nil, false, false)
 	assert.Nil(t, err)
 	_, v = parse(r)
-	assert.Equal(t, v.Bandwidth, int64(10))
+	assert.Equal(t, v.Ingress, int64(10))
+	assert.Equal(t, v.Egress, int64(10))
 
 	r, err = cm.SetNodeResourceUsage(ctx, node, nil, nodeResource, nil, false, false)
 	assert.Nil(t, err)
 	_, v = parse(r)
-	assert.Equal(t, v.Bandwidth, int64(10))
+	assert.Equal(t, v.Ingress, int64(10))
+	assert.Equal(t, v.Egress, int64(10))
 
 	r, err = cm.SetNodeResourceUsage(ctx, node, nil, nil, workloadsResource, false, false)
 	assert.Nil(t, err)
 	_, v = parse(r)
-	assert.Equal(t, v.Bandwidth, int64(10))
+	assert.Equal(t, v.Ingress, int64(10))
+	assert.Equal(t, v.Egress, int64(10))
 
 	// two parmas
 	r, err = cm.SetNodeResourceUsage(ctx, node, nodeResourceRequest, nodeResource, nil, false, true)
 	assert.Nil(t, err)
 	_, v = parse(r)
-	assert.Equal(t, v.Bandwidth, int64(10))
+	assert.Equal(t, v.Ingress, int64(10))
+	assert.Equal(t, v.Egress, int64(10))
 
 	r, err = cm.SetNodeResourceUsage(ctx, node, nodeResourceRequest, nil, workloadsResource, false, true)
 	assert.Nil(t, err)
 	_, v = parse(r)
-	assert.Equal(t, v.Bandwidth, int64(10))
+	assert.Equal(t, v.Ingress, int64(10))
+	assert.Equal(t, v.Egress, int64(10))
 
 	r, err = cm.SetNodeResourceUsage(ctx, node, nil, nodeResource, workloadsResource, false, true)
 	assert.Nil(t, err)
 	_, v = parse(r)
-	assert.Equal(t, v.Bandwidth, int64(10))
+	assert.Equal(t, v.Ingress, int64(10))
+	assert.Equal(t, v.Egress, int64(10))
 
 	// three params
 	r, err = cm.SetNodeResourceUsage(ctx, node, nodeResourceRequest, nodeResource, workloadsResource, false, true)
 	assert.Nil(t, err)
 	_, v = parse(r)
-	assert.Equal(t, v.Bandwidth, int64(10))
+	assert.Equal(t, v.Ingress, int64(10))
+	assert.Equal(t, v.Egress, int64(10))
 
 	r, err = cm.SetNodeResourceUsage(ctx, node, nodeResourceRequest, nodeResource, workloadsResource, true, false)
 	assert.Nil(t, err)
 	_, v = parse(r)
-	assert.Equal(t, v.Bandwidth, int64(0))
+	assert.Equal(t, v.Ingress, int64(0))
+	assert.Equal(t, v.Egress, int64(0))
 
 	// for negative add
 	nodeResourceRequest1 := plugintypes.NodeResourceRequest{
@@ -473,7 +770,8 @@ func TestSetNodeResourceUsage(t *testing.T) {
 	r, err = cm.SetNodeResourceUsage(ctx, node, nodeResourceRequest1, nil, nil, true, true)
 	assert.Nil(t, err)
 	_, v = parse(r)
-	assert.Equal(t, v.Bandwidth, int64(10))
+	assert.Equal(t, v.Ingress, int64(10))
+	assert.Equal(t, v.Egress, int64(10))
 
 	nodeResourceRequest1 = plugintypes.NodeResourceRequest{
 		"bandwidth": -10,
@@ -481,10 +779,69 @@ func TestSetNodeResourceUsage(t *testing.T) {
 	r, err = cm.SetNodeResourceUsage(ctx, node, nodeResourceRequest1, nil, nil, true, true)
 	assert.Nil(t, err)
 	_, v = parse(r)
-	assert.Equal(t, v.Bandwidth, int64(0))
+	assert.Equal(t, v.Ingress, int64(0))
+	assert.Equal(t, v.Egress, int64(0))
 
 }
 
+func TestSetNodeResourceUsageConcurrently(t *testing.T) {
+	ctx := context.Background()
+	cm := initBandwidth(ctx, t)
+	nodes := generateNodes(ctx, t, cm, 1, 0)
+	node := nodes[0]
+
+	n := 20
+	wg := sync.WaitGroup{}
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := cm.SetNodeResourceUsage(ctx, node, nil, plugintypes.NodeResource{"bandwidth": 1}, nil, true, true)
+			assert.Nil(t, err)
+		}()
+	}
+	wg.Wait()
+
+	// no increment is lost
+	info, err := cm.doGetNodeResourceInfo(ctx, node)
+	assert.Nil(t, err)
+	assert.Equal(t, types.NewNodeResource(int64(n), int64(n)), info.Usage)
+}
+
+func TestUpdateNodeResourceInfoConflict(t *testing.T) {
+	ctx := context.Background()
+	cm := initBandwidth(ctx, t)
+	nodes := generateNodes(ctx, t, cm, 1, 0)
+	node := nodes[0]
+
+	retries, backoff := updateRetries, updateBackoff
+	updateRetries, updateBackoff = 2, time.Millisecond
+	defer func() { updateRetries, updateBackoff = retries, backoff }()
+
+	// another writer changes the resource info on every try
+	tries := 0
+	err := cm.updateNodeResourceInfo(ctx, node, func(info *types.NodeResourceInfo) error {
+		tries++
+		assert.Nil(t, cm.doSetNodeResourceInfo(ctx, node, info))
+		info.Usage.Add(types.NewNodeResource(1, 1))
+		return nil
+	})
+	assert.ErrorIs(t, err, types.ErrConcurrentUpdate)
+	assert.Equal(t, 3, tries)
+	info, err := cm.doGetNodeResourceInfo(ctx, node)
+	assert.Nil(t, err)
+	assert.Equal(t, types.NewNodeResource(0, 0), info.Usage)
+
+	// errors of update are returned as they are
+	err = cm.updateNodeResourceInfo(ctx, node, func(*types.NodeResourceInfo) error {
+		return types.ErrInvalidBandwidth
+	})
+	assert.ErrorIs(t, err, types.ErrInvalidBandwidth)
+
+	err = cm.updateNodeResourceInfo(ctx, "xxx", func(*types.NodeResourceInfo) error { return nil })
+	assert.ErrorIs(t, err, coretypes.ErrNodeNotExists)
+}
+
 func TestGetMostIdleNode(t *testing.T) {
 	ctx := context.Background()
 	cm := initBandwidth(ctx, t)
@@ -505,3 +862,74 @@ func TestGetMostIdleNode(t *testing.T) {
 	_, err = cm.GetMostIdleNode(ctx, nodes)
 	assert.Error(t, err)
 }
+
+func TestGetMostIdleNodeStrategies(t *testing.T) {
+	ctx := context.Background()
+	bdConfig := types.DefaultConfig()
+	bdConfig.Idle.Priority = 7
+	cm := initBandwidthWithConfig(ctx, t, bdConfig)
+	nodes := generateNodes(ctx, t, cm, 2, 0)
+
+	// ties go to the first name
+	for i := 0; i < 5; i++ {
+		r, err := cm.GetMostIdleNode(ctx, []string{"test1", "test0"})
+		assert.Nil(t, err)
+		assert.Equal(t, "test0", r.Nodename)
+		assert.Equal(t, 7, r.Priority)
+	}
+
+	_, err := cm.SetNodeResourceCapacity(ctx, "test1", nil, plugintypes.NodeResource{"bandwidth": 900}, true, true)
+	assert.Nil(t, err)
+	_, err = cm.SetNodeResourceUsage(ctx, "test0", nil, nil, []plugintypes.WorkloadResource{{"id": "a", "bandwidth": 10, "peak": 90}}, true, true)
+	assert.Nil(t, err)
+	_, err = cm.SetNodeResourceUsage(ctx, "test1", nil, nil, []plugintypes.WorkloadResource{{"id": "b", "bandwidth": 200}}, true, true)
+	assert.Nil(t, err)
+
+	request := WithIncomingRequest(ctx, plugintypes.WorkloadResourceRequest{"bandwidth": 100})
+	for strategy, node := range map[string]string{
+		types.IdleRatio:        "test0",
+		types.IdleFree:         "test1",
+		types.IdleAfterRequest: "test1",
+		types.IdlePeakHeadroom: "test1",
+	} {
+		cm.bdConfig.Idle.Strategy = strategy
+		r, err := cm.GetMostIdleNode(request, nodes)
+		assert.Nil(t, err)
+		assert.Equal(t, node, r.Nodename, strategy)
+	}
+
+	// core doesn't send the incoming request, it falls back to ratio
+	cm.bdConfig.Idle.Strategy = types.IdleAfterRequest
+	r, err := cm.GetMostIdleNode(ctx, nodes)
+	assert.Nil(t, err)
+	assert.Equal(t, "test0", r.Nodename)
+	_, err = cm.GetMostIdleNode(WithIncomingRequest(ctx, plugintypes.WorkloadResourceRequest{"bandwidth": -1}), nodes)
+	assert.ErrorIs(t, err, types.ErrInvalidBandwidth)
+}
+
+func TestBinpackPlacement(t *testing.T) {
+	ctx := context.Background()
+	bdConfig := types.DefaultConfig()
+	bdConfig.Placement = types.PlacementBinpack
+	cm := initBandwidthWithConfig(ctx, t, bdConfig)
+	nodes := generateNodes(ctx, t, cm, 3, 0)
+
+	_, err := cm.SetNodeResourceUsage(ctx, "test1", nil, plugintypes.NodeResource{"bandwidth": 40}, nil, false, true)
+	assert.Nil(t, err)
+	_, err = cm.SetNodeResourceUsage(ctx, "test2", nil, plugintypes.NodeResource{"bandwidth": 100}, nil, false, true)
+	assert.Nil(t, err)
+
+	// the busiest node which isn't full looks least used to core
+	r, err := cm.GetNodesDeployCapacity(ctx, nodes, plugintypes.WorkloadResourceRequest{"bandwidth": 20})
+	assert.Nil(t, err)
+	assert.Len(t, r.NodeDeployCapacityMap, 2)
+	assert.Equal(t, float64(1), r.NodeDeployCapacityMap["test0"].Usage)
+	assert.Equal(t, -0.2, r.NodeDeployCapacityMap["test0"].Rate)
+	assert.InDelta(t, 0.6, r.NodeDeployCapacityMap["test1"].Usage, 1e-9)
+	assert.Equal(t, -0.2, r.NodeDeployCapacityMap["test1"].Rate)
+	assert.InDelta(t, 1.4*r.NodeDeployCapacityMap["test0"].Weight, r.NodeDeployCapacityMap["test1"].Weight, 1e-18)
+
+	mr, err := cm.GetMostIdleNode(ctx, nodes)
+	assert.Nil(t, err)
+	assert.Equal(t, "test1", mr.Nodename)
+}
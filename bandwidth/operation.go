@@ -0,0 +1,113 @@
+package bandwidth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	plugintypes "github.com/projecteru2/core/resource/plugins/types"
+	"github.com/projecteru2/core/utils"
+	bdtypes "github.com/yuyang0/resource-bandwidth/bandwidth/types"
+)
+
+const operationIDLength = 16
+
+type operationIDKey struct{}
+
+func newOperationID() string {
+	return utils.RandomString(operationIDLength)
+}
+
+// WithOperationID returns a context carrying the operation ID of SetNodeResourceUsage or SetNodeResourceCapacity,
+// updates with the same ID are applied once on a node and their replays return the original Before/After
+func WithOperationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, operationIDKey{}, id)
+}
+
+// OperationID returns the operation ID carried by ctx, empty if there isn't one
+func OperationID(ctx context.Context) string {
+	id, _ := ctx.Value(operationIDKey{}).(string)
+	return id
+}
+
+// withWorkloadsOperationID returns a context carrying the operation ID of a usage update by the workloads issued
+// by the plugin, it's derived from their operation IDs and the direction, so core's retries of the update replay.
+// ctx is returned as it is if it carries an ID already or the update isn't only by such workloads.
+func withWorkloadsOperationID(
+	ctx context.Context,
+	resourceRequest plugintypes.NodeResourceRequest, resource plugintypes.NodeResource,
+	workloadsResource []plugintypes.WorkloadResource, delta, incr bool,
+) context.Context {
+	if OperationID(ctx) != "" || resourceRequest != nil || resource != nil || !delta || len(workloadsResource) == 0 {
+		return ctx
+	}
+	ids := make([]string, 0, len(workloadsResource))
+	for _, workloadResource := range workloadsResource {
+		id, _ := workloadResource["operation_id"].(string)
+		if id == "" {
+			return ctx
+		}
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	sign := "-"
+	if incr {
+		sign = "+"
+	}
+	sum := sha256.Sum256([]byte(strings.Join(ids, ",")))
+	return WithOperationID(ctx, sign+hex.EncodeToString(sum[:operationIDLength/2]))
+}
+
+// operationIDs returns the operation IDs of workloadsResource, ones without are skipped
+func operationIDs(workloadsResource []*bdtypes.WorkloadResource) []string {
+	ids := []string{}
+	for _, workloadResource := range workloadsResource {
+		if workloadResource.OperationID != "" {
+			ids = append(ids, workloadResource.OperationID)
+		}
+	}
+	return ids
+}
+
+// rawOperationIDs is operationIDs of workload resources not parsed yet
+func rawOperationIDs(workloadsResource []plugintypes.WorkloadResource) []string {
+	ids := []string{}
+	for _, workloadResource := range workloadsResource {
+		if id, _ := workloadResource["operation_id"].(string); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// replayOperation looks for an applied operation with the ID of ctx, nil means it's a new one
+func (p Plugin) replayOperation(ctx context.Context, resourceInfo *bdtypes.NodeResourceInfo, kind string) (*bdtypes.Operation, error) {
+	id := OperationID(ctx)
+	if id == "" {
+		return nil, nil
+	}
+	op := resourceInfo.Operations.Find(id)
+	if op != nil && op.Kind != kind {
+		return nil, errors.Wrapf(bdtypes.ErrOperationIDReused, "operation %s is a %s update", id, op.Kind)
+	}
+	return op, nil
+}
+
+// recordOperation remembers the operation ID of ctx with its result
+func (p Plugin) recordOperation(ctx context.Context, resourceInfo *bdtypes.NodeResourceInfo, kind string, before, after *bdtypes.NodeResource) {
+	id := OperationID(ctx)
+	if id == "" {
+		return
+	}
+	resourceInfo.Operations = resourceInfo.Operations.Record(&bdtypes.Operation{
+		ID:        id,
+		Kind:      kind,
+		Before:    before.DeepCopy(),
+		After:     after.DeepCopy(),
+		AppliedAt: time.Now(),
+	}, p.bdConfig.OperationWindow)
+}
@@ -0,0 +1,99 @@
+package bandwidth
+
+import (
+	"context"
+	"testing"
+
+	"github.com/projecteru2/core/resource/plugins"
+	plugintypes "github.com/projecteru2/core/resource/plugins/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/yuyang0/resource-bandwidth/bandwidth/types"
+)
+
+func TestOperationReplay(t *testing.T) {
+	ctx := context.Background()
+	bdConfig := types.DefaultConfig()
+	bdConfig.OperationWindow = 2
+	cm := initBandwidthWithConfig(ctx, t, bdConfig)
+	node := generateNodes(ctx, t, cm, 1, 0)[0]
+	workloads := []plugintypes.WorkloadResource{{"ingress": 10, "egress": 10}}
+
+	opCtx := WithOperationID(ctx, "op1")
+	assert.Equal(t, "op1", OperationID(opCtx))
+	assert.Equal(t, "", OperationID(ctx))
+	r, err := cm.SetNodeResourceUsage(opCtx, node, nil, nil, workloads, true, true)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), r.After["ingress"])
+
+	// a retry of op1 doesn't count the workload again
+	r1, err := cm.SetNodeResourceUsage(opCtx, node, nil, nil, workloads, true, true)
+	assert.Nil(t, err)
+	assert.Equal(t, r, r1)
+	info, err := cm.doGetNodeResourceInfo(ctx, node)
+	assert.Nil(t, err)
+	assert.Equal(t, types.NewNodeResource(10, 10), info.Usage)
+
+	// updates without operation ID are always applied
+	_, err = cm.SetNodeResourceUsage(ctx, node, nil, nil, workloads, true, true)
+	assert.Nil(t, err)
+	_, err = cm.SetNodeResourceCapacity(opCtx, node, nil, plugintypes.NodeResource{"ingress": 10}, true, true)
+	assert.ErrorIs(t, err, types.ErrOperationIDReused)
+
+	c, err := cm.SetNodeResourceCapacity(WithOperationID(ctx, "op2"), node, nil, plugintypes.NodeResource{"ingress": 10, "egress": 10}, true, true)
+	assert.Nil(t, err)
+	c1, err := cm.SetNodeResourceCapacity(WithOperationID(ctx, "op2"), node, nil, plugintypes.NodeResource{"ingress": 10, "egress": 10}, true, true)
+	assert.Nil(t, err)
+	assert.Equal(t, c, c1)
+	info, err = cm.doGetNodeResourceInfo(ctx, node)
+	assert.Nil(t, err)
+	assert.Equal(t, types.NewNodeResource(110, 110), info.Capacity)
+	assert.Equal(t, types.NewNodeResource(20, 20), info.Usage)
+
+	// op1 falls out of the window
+	_, err = cm.SetNodeResourceUsage(WithOperationID(ctx, "op3"), node, nil, nil, workloads, true, false)
+	assert.Nil(t, err)
+	_, err = cm.SetNodeResourceUsage(opCtx, node, nil, nil, workloads, true, true)
+	assert.Nil(t, err)
+	info, err = cm.doGetNodeResourceInfo(ctx, node)
+	assert.Nil(t, err)
+	assert.Equal(t, types.NewNodeResource(20, 20), info.Usage)
+	assert.Len(t, info.Operations, 2)
+}
+
+func TestOperationReplayOfCore(t *testing.T) {
+	ctx := context.Background()
+	cm := initBandwidth(ctx, t)
+	node := generateNodes(ctx, t, cm, 1, 0)[0]
+	// core only knows the plugin interface, nothing is carried by its context
+	var plugin plugins.Plugin = cm
+	usage := func() *types.NodeResource {
+		info, err := cm.doGetNodeResourceInfo(ctx, node)
+		assert.Nil(t, err)
+		return info.Usage
+	}
+
+	d, err := plugin.CalculateDeploy(ctx, node, 2, plugintypes.WorkloadResourceRequest{"bandwidth": 10})
+	assert.Nil(t, err)
+	r, err := plugin.SetNodeResourceUsage(ctx, node, nil, nil, d.WorkloadsResource, true, true)
+	assert.Nil(t, err)
+	r1, err := plugin.SetNodeResourceUsage(ctx, node, nil, nil, d.WorkloadsResource, true, true)
+	assert.Nil(t, err)
+	assert.Equal(t, r, r1)
+	assert.Equal(t, types.NewNodeResource(20, 20), usage())
+
+	// the realloc delta of the same workload is another operation
+	rr, err := plugin.CalculateRealloc(ctx, node, d.WorkloadsResource[0], plugintypes.WorkloadResourceRequest{"bandwidth": 5})
+	assert.Nil(t, err)
+	for i := 0; i < 2; i++ {
+		_, err = plugin.SetNodeResourceUsage(ctx, node, nil, nil, []plugintypes.WorkloadResource{rr.DeltaResource}, true, true)
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, types.NewNodeResource(25, 25), usage())
+
+	// removing is the other direction of the same resources
+	for i := 0; i < 2; i++ {
+		_, err = plugin.SetNodeResourceUsage(ctx, node, nil, nil, []plugintypes.WorkloadResource{rr.WorkloadResource, d.WorkloadsResource[1]}, true, false)
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, types.NewNodeResource(0, 0), usage())
+}
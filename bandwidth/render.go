@@ -0,0 +1,58 @@
+package bandwidth
+
+import (
+	"context"
+
+	"github.com/cockroachdb/errors"
+	resourcetypes "github.com/projecteru2/core/resource/types"
+	bdtypes "github.com/yuyang0/resource-bandwidth/bandwidth/types"
+)
+
+type engineKey struct{}
+
+// WithEngine returns a context carrying the engine type for calculations whose request or resources don't name one,
+// like the calculate commands run by hand
+func WithEngine(ctx context.Context, engine string) context.Context {
+	return context.WithValue(ctx, engineKey{}, engine)
+}
+
+// Engine returns the engine type carried by ctx, empty if there isn't one
+func Engine(ctx context.Context) string {
+	engine, _ := ctx.Value(engineKey{}).(string)
+	return engine
+}
+
+// Renderer renders engine params into the form an engine takes
+type Renderer interface {
+	Render(engineParams *bdtypes.EngineParams) (resourcetypes.RawParams, error)
+}
+
+// NewRenderer returns the renderer by name, engine params are in unit
+func NewRenderer(name string, unit bdtypes.Unit) (Renderer, error) {
+	switch name {
+	case bdtypes.RenderGeneric:
+		return genericRenderer{}, nil
+	case bdtypes.RenderLibvirt:
+		return libvirtRenderer{unit: unit}, nil
+	case bdtypes.RenderCNI:
+		return cniRenderer{unit: unit}, nil
+	case bdtypes.RenderOVS:
+		return ovsRenderer{unit: unit}, nil
+	default:
+		return nil, errors.Wrapf(bdtypes.ErrInvalidConfig, "unknown renderer %q", name)
+	}
+}
+
+// renderer returns the renderer of engine, which is the engine type core sent in the request, or the one of ctx if it's empty
+func (p Plugin) renderer(ctx context.Context, engine string) (Renderer, error) {
+	if engine == "" {
+		engine = Engine(ctx)
+	}
+	return NewRenderer(p.bdConfig.Render.RendererOf(engine), p.unit)
+}
+
+type genericRenderer struct{}
+
+func (genericRenderer) Render(engineParams *bdtypes.EngineParams) (resourcetypes.RawParams, error) {
+	return engineParams.AsRawParams(), nil
+}
@@ -0,0 +1,100 @@
+package bandwidth
+
+import (
+	"strconv"
+
+	resourcetypes "github.com/projecteru2/core/resource/types"
+	bdtypes "github.com/yuyang0/resource-bandwidth/bandwidth/types"
+)
+
+const (
+	// IngressBandwidthAnnotation and EgressBandwidthAnnotation are the pod annotations of the CNI bandwidth plugin
+	IngressBandwidthAnnotation = "kubernetes.io/ingress-bandwidth"
+	EgressBandwidthAnnotation  = "kubernetes.io/egress-bandwidth"
+
+	// minCNIBurst is one MTU in bits, a token bucket below it can't pass a packet
+	minCNIBurst = 1500 * 8
+)
+
+// CNIBandwidth is the bandwidth entry of the runtimeConfig of the CNI bandwidth plugin, in bits per second and bits.
+// The plugin shapes by a token bucket, so the rate is the peak of a workload. A direction without limit is 0 and left out.
+type CNIBandwidth struct {
+	IngressRate  int64 `json:"ingressRate,omitempty"`
+	IngressBurst int64 `json:"ingressBurst,omitempty"`
+	EgressRate   int64 `json:"egressRate,omitempty"`
+	EgressBurst  int64 `json:"egressBurst,omitempty"`
+}
+
+// NewCNIBandwidth converts engine params in unit to the CNI bandwidth plugin, a burst is at least one MTU
+func NewCNIBandwidth(engineParams *bdtypes.EngineParams, unit bdtypes.Unit) *CNIBandwidth {
+	res := &CNIBandwidth{}
+	res.IngressRate, res.IngressBurst = newCNILimit(engineParams.Ingress, unit)
+	res.EgressRate, res.EgressBurst = newCNILimit(engineParams.Egress, unit)
+	return res
+}
+
+// newCNILimit returns the rate and the burst of limit, both 0 if it has no peak
+func newCNILimit(limit bdtypes.Limit, unit bdtypes.Unit) (int64, int64) {
+	rate := unit.BitsPerSecond(limit.Peak)
+	if rate <= 0 {
+		return 0, 0
+	}
+	burst := limit.Burst * 8
+	if burst < minCNIBurst {
+		burst = minCNIBurst
+	}
+	return rate, burst
+}
+
+func (b *CNIBandwidth) asMap() map[string]any {
+	res := map[string]any{}
+	if b.IngressRate > 0 {
+		res["ingressRate"], res["ingressBurst"] = b.IngressRate, b.IngressBurst
+	}
+	if b.EgressRate > 0 {
+		res["egressRate"], res["egressBurst"] = b.EgressRate, b.EgressBurst
+	}
+	return res
+}
+
+// Annotations returns the pod annotations of the limited directions, bursts are left to the plugin
+func (b *CNIBandwidth) Annotations() map[string]string {
+	res := map[string]string{}
+	if b.IngressRate > 0 {
+		res[IngressBandwidthAnnotation] = quantity(b.IngressRate)
+	}
+	if b.EgressRate > 0 {
+		res[EgressBandwidthAnnotation] = quantity(b.EgressRate)
+	}
+	return res
+}
+
+// quantity formats bits per second like a kubernetes quantity with the largest decimal suffix which keeps it exact
+func quantity(n int64) string {
+	for _, suffix := range []struct {
+		name string
+		size int64
+	}{{"P", 1e15}, {"T", 1e12}, {"G", 1e9}, {"M", 1e6}, {"k", 1e3}} {
+		if n != 0 && n%suffix.size == 0 {
+			return strconv.FormatInt(n/suffix.size, 10) + suffix.name
+		}
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+type cniRenderer struct {
+	unit bdtypes.Unit
+}
+
+// Render returns the generic form with `runtimeConfig` and `annotations` added
+func (r cniRenderer) Render(engineParams *bdtypes.EngineParams) (resourcetypes.RawParams, error) {
+	bandwidth := NewCNIBandwidth(engineParams, r.unit)
+	annotations := map[string]any{}
+	for key, value := range bandwidth.Annotations() {
+		annotations[key] = value
+	}
+	rawParams := engineParams.AsRawParams()
+	rawParams["runtimeConfig"] = map[string]any{"bandwidth": bandwidth.asMap()}
+	rawParams["annotations"] = annotations
+	return rawParams, nil
+}
@@ -0,0 +1,87 @@
+package bandwidth
+
+import (
+	"encoding/xml"
+	"math"
+
+	resourcetypes "github.com/projecteru2/core/resource/types"
+	bdtypes "github.com/yuyang0/resource-bandwidth/bandwidth/types"
+)
+
+// LibvirtLimit is the inbound or outbound element of a libvirt interface, average and peak are in KiB/s, burst in KiB
+type LibvirtLimit struct {
+	Average int64 `json:"average" xml:"average,attr"`
+	Peak    int64 `json:"peak,omitempty" xml:"peak,attr,omitempty"`
+	Burst   int64 `json:"burst,omitempty" xml:"burst,attr,omitempty"`
+}
+
+func (l *LibvirtLimit) asMap() map[string]any {
+	return map[string]any{
+		"average": l.Average,
+		"peak":    l.Peak,
+		"burst":   l.Burst,
+	}
+}
+
+// LibvirtBandwidth is the bandwidth element of a libvirt interface, inbound is the traffic into the guest
+type LibvirtBandwidth struct {
+	XMLName  xml.Name      `json:"-" xml:"bandwidth"`
+	Inbound  *LibvirtLimit `json:"inbound,omitempty" xml:"inbound,omitempty"`
+	Outbound *LibvirtLimit `json:"outbound,omitempty" xml:"outbound,omitempty"`
+}
+
+// NewLibvirtBandwidth converts engine params in unit to libvirt units, rounding up so no limit becomes 0,
+// which libvirt takes as unlimited. A direction without average is nil, libvirt needs one to limit it.
+func NewLibvirtBandwidth(engineParams *bdtypes.EngineParams, unit bdtypes.Unit) *LibvirtBandwidth {
+	return &LibvirtBandwidth{
+		Inbound:  newLibvirtLimit(engineParams.Ingress, unit),
+		Outbound: newLibvirtLimit(engineParams.Egress, unit),
+	}
+}
+
+func newLibvirtLimit(limit bdtypes.Limit, unit bdtypes.Unit) *LibvirtLimit {
+	if limit.Average <= 0 {
+		return nil
+	}
+	res := &LibvirtLimit{
+		Average: kib(float64(unit.BitsPerSecond(limit.Average)) / 8),
+		Peak:    kib(float64(unit.BitsPerSecond(limit.Peak)) / 8),
+		Burst:   kib(float64(limit.Burst)),
+	}
+	if res.Peak < res.Average {
+		res.Peak = 0
+	}
+	return res
+}
+
+// kib returns bytes in KiB rounded up
+func kib(bytes float64) int64 {
+	return int64(math.Ceil(bytes / 1024))
+}
+
+type libvirtRenderer struct {
+	unit bdtypes.Unit
+}
+
+// Render returns the inbound and outbound limits, the xml of the bandwidth element and the nic if it's set
+func (r libvirtRenderer) Render(engineParams *bdtypes.EngineParams) (resourcetypes.RawParams, error) {
+	bandwidth := NewLibvirtBandwidth(engineParams, r.unit)
+	data, err := xml.Marshal(bandwidth)
+	if err != nil {
+		return nil, err
+	}
+	rawParams := resourcetypes.RawParams{"xml": string(data)}
+	if bandwidth.Inbound != nil {
+		rawParams["inbound"] = bandwidth.Inbound.asMap()
+	}
+	if bandwidth.Outbound != nil {
+		rawParams["outbound"] = bandwidth.Outbound.asMap()
+	}
+	if engineParams.NIC != "" {
+		rawParams["nic"] = engineParams.NIC
+	}
+	if engineParams.ID != "" {
+		rawParams["id"] = engineParams.ID
+	}
+	return rawParams, nil
+}
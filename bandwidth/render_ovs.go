@@ -0,0 +1,153 @@
+package bandwidth
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	resourcetypes "github.com/projecteru2/core/resource/types"
+	"github.com/yuyang0/resource-bandwidth/bandwidth/tc"
+	bdtypes "github.com/yuyang0/resource-bandwidth/bandwidth/types"
+)
+
+const (
+	// OVSPortPlaceholder stands for the port of the workload in rendered commands, engines replace it once the port exists
+	OVSPortPlaceholder = "{port}"
+	// OVSPortExternalID tags the QoS and queue records of a port, so they can be found after the port is gone
+	OVSPortExternalID = "resource-bandwidth-port"
+)
+
+// OVSInterface is the policing of the interface record, which limits the traffic from the workload, in kbps and kb
+type OVSInterface struct {
+	IngressPolicingRate  int64 `json:"ingress_policing_rate"`
+	IngressPolicingBurst int64 `json:"ingress_policing_burst"`
+}
+
+// OVSQueue is a queue record, other_config is in bps and bits
+type OVSQueue struct {
+	OtherConfig map[string]string `json:"other_config"`
+}
+
+// OVSQoS is the linux-htb QoS record of the port, which limits the traffic into the workload by queue 0,
+// the queue of packets not assigned to others
+type OVSQoS struct {
+	Type        string               `json:"type"`
+	OtherConfig map[string]string    `json:"other_config"`
+	Queues      map[string]*OVSQueue `json:"queues"`
+}
+
+// OVSBandwidth is the records which shape a workload on an OVS port, QoS is nil if the traffic into the workload has no limit
+type OVSBandwidth struct {
+	Interface *OVSInterface `json:"interface"`
+	QoS       *OVSQoS       `json:"qos,omitempty"`
+}
+
+// NewOVSBandwidth converts engine params in unit to OVS records, a direction without limit gets policing of 0,
+// which OVS takes as unlimited, or no QoS record
+func NewOVSBandwidth(engineParams *bdtypes.EngineParams, unit bdtypes.Unit) *OVSBandwidth {
+	ingress, egress := engineParams.Ingress, engineParams.Egress
+	res := &OVSBandwidth{Interface: &OVSInterface{}}
+	// policing drops whatever exceeds it, so it's by the peak
+	if policingRate := int64(math.Ceil(float64(unit.BitsPerSecond(egress.Peak)) / 1000)); policingRate > 0 {
+		res.Interface.IngressPolicingRate = policingRate
+		res.Interface.IngressPolicingBurst = int64(math.Ceil(float64(egress.Burst) * 8 / 1000))
+	}
+
+	maxRate := unit.BitsPerSecond(ingress.Peak)
+	if maxRate <= 0 {
+		return res
+	}
+	queue := map[string]string{"max-rate": strconv.FormatInt(maxRate, 10)}
+	if minRate := unit.BitsPerSecond(ingress.Average); minRate > 0 {
+		queue["min-rate"] = strconv.FormatInt(minRate, 10)
+	}
+	if ingress.Burst > 0 {
+		queue["burst"] = strconv.FormatInt(ingress.Burst*8, 10)
+	}
+	res.QoS = &OVSQoS{
+		Type:        "linux-htb",
+		OtherConfig: map[string]string{"max-rate": strconv.FormatInt(maxRate, 10)},
+		Queues:      map[string]*OVSQueue{"0": {OtherConfig: queue}},
+	}
+	return res
+}
+
+// Commands returns the ovs-vsctl command lines which shape port, run Cleanup first if the port was shaped before
+func (b *OVSBandwidth) Commands(port string) []string {
+	port = tc.Quote(port)
+	cmds := []string{
+		fmt.Sprintf("ovs-vsctl set interface %s ingress_policing_rate=%d ingress_policing_burst=%d",
+			port, b.Interface.IngressPolicingRate, b.Interface.IngressPolicingBurst),
+	}
+	if b.QoS == nil {
+		return cmds
+	}
+	externalID := fmt.Sprintf("external_ids:%s=%s", OVSPortExternalID, port)
+	qos := fmt.Sprintf("type=%s %s queues:0=@q0 %s", b.QoS.Type, otherConfig(b.QoS.OtherConfig), externalID)
+	queue := fmt.Sprintf("%s %s", otherConfig(b.QoS.Queues["0"].OtherConfig), externalID)
+	return append(cmds, fmt.Sprintf("ovs-vsctl -- set port %s qos=@qos -- --id=@qos create qos %s -- --id=@q0 create queue %s", port, qos, queue))
+}
+
+// Cleanup returns the ovs-vsctl command lines which remove the shaping of port when the workload is released or reallocated,
+// they work whether the port still exists or not
+func (b *OVSBandwidth) Cleanup(port string) []string {
+	port = tc.Quote(port)
+	destroy := func(table string) string {
+		return fmt.Sprintf(`for uuid in $(ovs-vsctl --bare --columns=_uuid find %s external_ids:%s=%s); do ovs-vsctl destroy %s "$uuid"; done`,
+			table, OVSPortExternalID, port, table)
+	}
+	return []string{
+		fmt.Sprintf("ovs-vsctl set interface %s ingress_policing_rate=0 ingress_policing_burst=0 -- clear port %s qos 2>/dev/null || true", port, port),
+		destroy("qos"),
+		destroy("queue"),
+	}
+}
+
+// otherConfig returns the other_config column of ovs-vsctl create, sorted by key
+func otherConfig(config map[string]string) string {
+	s := ""
+	for _, key := range []string{"min-rate", "max-rate", "burst"} {
+		if value, ok := config[key]; ok {
+			if s != "" {
+				s += " "
+			}
+			s += fmt.Sprintf("other_config:%s=%s", key, value)
+		}
+	}
+	return s
+}
+
+type ovsRenderer struct {
+	unit bdtypes.Unit
+}
+
+// Render returns the interface and qos records and their commands and cleanup commands on OVSPortPlaceholder
+func (r ovsRenderer) Render(engineParams *bdtypes.EngineParams) (resourcetypes.RawParams, error) {
+	bandwidth := NewOVSBandwidth(engineParams, r.unit)
+	rawParams := resourcetypes.RawParams{
+		"interface": map[string]any{
+			"ingress_policing_rate":  bandwidth.Interface.IngressPolicingRate,
+			"ingress_policing_burst": bandwidth.Interface.IngressPolicingBurst,
+		},
+		"commands": bandwidth.Commands(OVSPortPlaceholder),
+		"cleanup":  bandwidth.Cleanup(OVSPortPlaceholder),
+	}
+	if bandwidth.QoS != nil {
+		queues := map[string]any{}
+		for id, queue := range bandwidth.QoS.Queues {
+			queues[id] = map[string]any{"other_config": queue.OtherConfig}
+		}
+		rawParams["qos"] = map[string]any{
+			"type":         bandwidth.QoS.Type,
+			"other_config": bandwidth.QoS.OtherConfig,
+			"queues":       queues,
+		}
+	}
+	if engineParams.NIC != "" {
+		rawParams["nic"] = engineParams.NIC
+	}
+	if engineParams.ID != "" {
+		rawParams["id"] = engineParams.ID
+	}
+	return rawParams, nil
+}
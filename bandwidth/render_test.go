@@ -0,0 +1,155 @@
+package bandwidth
+
+import (
+	"context"
+	"testing"
+
+	plugintypes "github.com/projecteru2/core/resource/plugins/types"
+	resourcetypes "github.com/projecteru2/core/resource/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/yuyang0/resource-bandwidth/bandwidth/types"
+)
+
+func TestLibvirtRenderer(t *testing.T) {
+	renderer, err := NewRenderer(types.RenderLibvirt, types.Unit(1e6))
+	assert.Nil(t, err)
+
+	// 8Mbps is 1000KiB/s rounded up to 977, the burst of 100KB is 98KiB
+	rawParams, err := renderer.Render(&types.EngineParams{
+		Ingress: types.Limit{Average: 8, Peak: 16, Burst: 100000},
+		Egress:  types.Limit{Average: 0, Peak: 0},
+		NIC:     "eth0",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, resourcetypes.RawParams{
+		"inbound": map[string]any{"average": int64(977), "peak": int64(1954), "burst": int64(98)},
+		"xml":     `<bandwidth><inbound average="977" peak="1954" burst="98"></inbound></bandwidth>`,
+		"nic":     "eth0",
+	}, rawParams)
+
+	// a direction without average is not limited at all
+	rawParams, err = renderer.Render(&types.EngineParams{})
+	assert.Nil(t, err)
+	assert.Equal(t, resourcetypes.RawParams{"xml": `<bandwidth></bandwidth>`}, rawParams)
+
+	_, err = NewRenderer("xml", types.Unit(1e6))
+	assert.ErrorIs(t, err, types.ErrInvalidConfig)
+}
+
+func TestCNIRenderer(t *testing.T) {
+	renderer, err := NewRenderer(types.RenderCNI, types.Unit(1e6))
+	assert.Nil(t, err)
+
+	engineParams := &types.EngineParams{
+		Ingress: types.Limit{Average: 10, Peak: 20, Burst: 250000},
+		Egress:  types.Limit{Average: 0, Peak: 0, Burst: 0},
+	}
+	rawParams, err := renderer.Render(engineParams)
+	assert.Nil(t, err)
+	assert.Equal(t, engineParams.AsRawParams()["ingress"], rawParams["ingress"])
+	assert.Equal(t, map[string]any{"bandwidth": map[string]any{
+		"ingressRate":  int64(20000000),
+		"ingressBurst": int64(2000000),
+	}}, rawParams["runtimeConfig"])
+	assert.Equal(t, map[string]any{
+		IngressBandwidthAnnotation: "20M",
+	}, rawParams["annotations"])
+
+	// a small burst is raised to one MTU, a direction without limit is left out
+	bandwidth := NewCNIBandwidth(&types.EngineParams{Egress: types.Limit{Average: 1, Peak: 1, Burst: 10}}, types.Unit(1e6))
+	assert.Equal(t, &CNIBandwidth{EgressRate: 1000000, EgressBurst: minCNIBurst}, bandwidth)
+	assert.Equal(t, map[string]string{EgressBandwidthAnnotation: "1M"}, bandwidth.Annotations())
+
+	// engine params round trip through the generic keys
+	ep := &types.EngineParams{}
+	assert.Nil(t, ep.Parse(rawParams))
+	assert.Equal(t, engineParams, ep)
+
+	assert.Equal(t, "1500k", quantity(1500000))
+	assert.Equal(t, "1G", quantity(1e9))
+	assert.Equal(t, "1000001", quantity(1000001))
+	assert.Equal(t, "0", quantity(0))
+}
+
+func TestOVSRenderer(t *testing.T) {
+	renderer, err := NewRenderer(types.RenderOVS, types.Unit(1e6))
+	assert.Nil(t, err)
+
+	engineParams := &types.EngineParams{
+		Ingress: types.Limit{Average: 10, Peak: 20, Burst: 250000},
+		Egress:  types.Limit{Average: 5, Peak: 8, Burst: 100000},
+	}
+	rawParams, err := renderer.Render(engineParams)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]any{"ingress_policing_rate": int64(8000), "ingress_policing_burst": int64(800)}, rawParams["interface"])
+	assert.Equal(t, map[string]any{
+		"type":         "linux-htb",
+		"other_config": map[string]string{"max-rate": "20000000"},
+		"queues": map[string]any{"0": map[string]any{"other_config": map[string]string{
+			"min-rate": "10000000", "max-rate": "20000000", "burst": "2000000",
+		}}},
+	}, rawParams["qos"])
+	assert.Equal(t, NewOVSBandwidth(engineParams, types.Unit(1e6)).Commands(OVSPortPlaceholder), rawParams["commands"])
+
+	bandwidth := NewOVSBandwidth(engineParams, types.Unit(1e6))
+	assert.Equal(t, []string{
+		"ovs-vsctl set interface tap0 ingress_policing_rate=8000 ingress_policing_burst=800",
+		"ovs-vsctl -- set port tap0 qos=@qos -- --id=@qos create qos type=linux-htb other_config:max-rate=20000000 " +
+			"queues:0=@q0 external_ids:resource-bandwidth-port=tap0 -- --id=@q0 create queue other_config:min-rate=10000000 " +
+			"other_config:max-rate=20000000 other_config:burst=2000000 external_ids:resource-bandwidth-port=tap0",
+	}, bandwidth.Commands("tap0"))
+	assert.Equal(t, []string{
+		"ovs-vsctl set interface tap0 ingress_policing_rate=0 ingress_policing_burst=0 -- clear port tap0 qos 2>/dev/null || true",
+		`for uuid in $(ovs-vsctl --bare --columns=_uuid find qos external_ids:resource-bandwidth-port=tap0); do ovs-vsctl destroy qos "$uuid"; done`,
+		`for uuid in $(ovs-vsctl --bare --columns=_uuid find queue external_ids:resource-bandwidth-port=tap0); do ovs-vsctl destroy queue "$uuid"; done`,
+	}, bandwidth.Cleanup("tap0"))
+
+	// OVS takes zero rates as unlimited, the port gets no QoS without an ingress limit
+	bandwidth = NewOVSBandwidth(&types.EngineParams{Egress: types.Limit{Burst: 1000}}, types.Unit(1e6))
+	assert.Equal(t, &OVSInterface{}, bandwidth.Interface)
+	assert.Nil(t, bandwidth.QoS)
+	assert.Equal(t, []string{"ovs-vsctl set interface tap0 ingress_policing_rate=0 ingress_policing_burst=0"}, bandwidth.Commands("tap0"))
+	rawParams, err = renderer.Render(&types.EngineParams{})
+	assert.Nil(t, err)
+	assert.NotContains(t, rawParams, "qos")
+}
+
+func TestRenderEngineParams(t *testing.T) {
+	ctx := context.Background()
+	bdConfig := types.DefaultConfig()
+	bdConfig.Render.Engines = map[string]string{"virt": types.RenderLibvirt}
+	cm := initBandwidthWithConfig(ctx, t, bdConfig)
+	nodes := generateNodes(ctx, t, cm, 1, 0)
+
+	// the default renderer for unknown engines
+	d, err := cm.CalculateDeploy(ctx, nodes[0], 1, plugintypes.WorkloadResourceRequest{"bandwidth": 10, "engine": "docker"})
+	assert.Nil(t, err)
+	assert.Contains(t, d.EnginesParams[0], "ingress")
+
+	for _, engine := range []string{"virt", types.RenderLibvirt} {
+		// core sends the engine type in the request, the workload resource keeps it for realloc and remap
+		d, err = cm.CalculateDeploy(ctx, nodes[0], 1, plugintypes.WorkloadResourceRequest{"bandwidth": 10, "engine": engine})
+		assert.Nil(t, err)
+		assert.Contains(t, d.EnginesParams[0], "inbound")
+		assert.Contains(t, d.EnginesParams[0], "xml")
+		assert.Equal(t, d.WorkloadsResource[0]["id"], d.EnginesParams[0]["id"])
+		assert.Equal(t, engine, d.WorkloadsResource[0]["engine"])
+
+		r, err := cm.CalculateRealloc(ctx, nodes[0], d.WorkloadsResource[0], plugintypes.WorkloadResourceRequest{"bandwidth": 10})
+		assert.Nil(t, err)
+		assert.Contains(t, r.EngineParams, "outbound")
+
+		m, err := cm.CalculateRemap(ctx, nodes[0], map[string]plugintypes.WorkloadResource{"w1": d.WorkloadsResource[0], "w2": {"bandwidth": 1}})
+		assert.Nil(t, err)
+		assert.Contains(t, m.EngineParamsMap["w1"], "inbound")
+		assert.Contains(t, m.EngineParamsMap["w2"], "ingress")
+	}
+
+	// the engine of ctx is for requests without one, like the ones of the calculate commands
+	d, err = cm.CalculateDeploy(WithEngine(ctx, "virt"), nodes[0], 1, plugintypes.WorkloadResourceRequest{"bandwidth": 10})
+	assert.Nil(t, err)
+	assert.Contains(t, d.EnginesParams[0], "inbound")
+	d, err = cm.CalculateDeploy(WithEngine(ctx, "virt"), nodes[0], 1, plugintypes.WorkloadResourceRequest{"bandwidth": 10, "engine": "docker"})
+	assert.Nil(t, err)
+	assert.Contains(t, d.EnginesParams[0], "ingress")
+}
@@ -0,0 +1,68 @@
+package bandwidth
+
+import (
+	"context"
+	"testing"
+
+	plugintypes "github.com/projecteru2/core/resource/plugins/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/yuyang0/resource-bandwidth/bandwidth/types"
+)
+
+func TestReserved(t *testing.T) {
+	ctx := context.Background()
+	bdConfig := capacityCheckConfig(1)
+	bdConfig.Reserved = "10%"
+	cm := initBandwidthWithConfig(ctx, t, bdConfig)
+	nodes := generateNodes(ctx, t, cm, 1, 0)
+	_, err := cm.AddNode(ctx, "test-reserved", plugintypes.NodeResourceRequest{"bandwidth": 100, "reserved": map[string]any{"ingress": 50, "egress": "20%"}}, nil)
+	assert.Nil(t, err)
+	t.Cleanup(func() {
+		_, err := cm.RemoveNode(ctx, "test-reserved")
+		assert.Nil(t, err)
+	})
+	_, err = cm.AddNode(ctx, "test-bad", plugintypes.NodeResourceRequest{"bandwidth": 100, "reserved": 101}, nil)
+	assert.ErrorIs(t, err, types.ErrInvalidBandwidth)
+	_, err = cm.AddNode(ctx, "test-bad", plugintypes.NodeResourceRequest{"bandwidth": 100, "reserved": "1x"}, nil)
+	assert.ErrorIs(t, err, types.ErrInvalidBandwidth)
+
+	// the default of config is used without one in the request
+	r, err := cm.GetNodesDeployCapacity(ctx, []string{nodes[0], "test-reserved"}, plugintypes.WorkloadResourceRequest{"bandwidth": 10})
+	assert.Nil(t, err)
+	assert.Equal(t, 9, r.NodeDeployCapacityMap[nodes[0]].Capacity)
+	assert.Equal(t, 5, r.NodeDeployCapacityMap["test-reserved"].Capacity)
+	_, err = cm.CalculateDeploy(ctx, "test-reserved", 6, plugintypes.WorkloadResourceRequest{"bandwidth": 10})
+	assert.ErrorIs(t, err, types.ErrNotEnoughBandwidth)
+	_, err = cm.CalculateDeploy(ctx, "test-reserved", 5, plugintypes.WorkloadResourceRequest{"bandwidth": 10})
+	assert.Nil(t, err)
+
+	// usage is against capacity minus the reservation
+	_, err = cm.SetNodeResourceUsage(ctx, nodes[0], nil, plugintypes.NodeResource{"bandwidth": 45}, nil, false, true)
+	assert.Nil(t, err)
+	_, err = cm.SetNodeResourceUsage(ctx, "test-reserved", nil, plugintypes.NodeResource{"bandwidth": 40}, nil, false, true)
+	assert.Nil(t, err)
+	mr, err := cm.GetMostIdleNode(ctx, []string{nodes[0], "test-reserved"})
+	assert.Nil(t, err)
+	assert.Equal(t, nodes[0], mr.Nodename)
+
+	// percentages follow the capacity, the reservation can be changed with capacity
+	_, err = cm.SetNodeResourceCapacity(ctx, "test-reserved", plugintypes.NodeResourceRequest{"reserved": "10%"}, nil, false, false)
+	assert.Nil(t, err)
+	_, err = cm.SetNodeResourceCapacity(ctx, "test-reserved", nil, plugintypes.NodeResource{"bandwidth": 100}, true, true)
+	assert.Nil(t, err)
+	info, err := cm.doGetNodeResourceInfo(ctx, "test-reserved")
+	assert.Nil(t, err)
+	assert.Equal(t, types.NewNodeResource(20, 20), info.ReservedResource())
+	assert.Equal(t, types.NewNodeResource(200, 200), info.Capacity)
+
+	resp, err := cm.GetMetrics(ctx, "testpod", "test-reserved")
+	assert.Nil(t, err)
+	values := map[string]string{}
+	for _, mt := range *resp {
+		values[mt.Name] = mt.Value
+	}
+	assert.Equal(t, "200", values["bandwidth_ingress_capacity"])
+	assert.Equal(t, "20", values["bandwidth_ingress_reserved"])
+	assert.Equal(t, "180", values["bandwidth_ingress_allocatable"])
+	assert.Equal(t, "140", values["bandwidth_ingress_free"])
+}
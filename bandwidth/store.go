@@ -0,0 +1,38 @@
+package bandwidth
+
+import (
+	"context"
+
+	"github.com/cockroachdb/errors"
+	coretypes "github.com/projecteru2/core/types"
+	bdtypes "github.com/yuyang0/resource-bandwidth/bandwidth/types"
+)
+
+// Store is the storage of node resource info, every key has a revision which changes on each write
+type Store interface {
+	// Get returns the value of key and its revision, nil value means key doesn't exist
+	Get(ctx context.Context, key string) ([]byte, int64, error)
+	// GetMulti returns values of keys, all of them must exist
+	GetMulti(ctx context.Context, keys []string) (map[string][]byte, error)
+	// GetExisting returns values of keys in one read like GetMulti, keys which don't exist are left out
+	GetExisting(ctx context.Context, keys []string) (map[string][]byte, error)
+	Put(ctx context.Context, key string, value []byte) error
+	Delete(ctx context.Context, key string) error
+	// CompareAndSwap puts value only if the revision of key is still rev, false means key was changed by others
+	CompareAndSwap(ctx context.Context, key string, value []byte, rev int64) (bool, error)
+	Close() error
+}
+
+// NewStore creates the storage backend chosen by storeConfig
+func NewStore(config coretypes.Config, storeConfig bdtypes.StoreConfig) (Store, error) {
+	switch storeConfig.Type {
+	case bdtypes.StoreETCD:
+		return NewETCDStore(config)
+	case bdtypes.StoreMemory:
+		return NewMemoryStore(), nil
+	case bdtypes.StoreBolt:
+		return NewBoltStore(storeConfig.Path)
+	default:
+		return nil, errors.Wrapf(bdtypes.ErrInvalidConfig, "unknown store type %q", storeConfig.Type)
+	}
+}
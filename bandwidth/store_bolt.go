@@ -0,0 +1,135 @@
+package bandwidth
+
+import (
+	"context"
+	"encoding/binary"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	coretypes "github.com/projecteru2/core/types"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	boltBucket = "bandwidth"
+	// boltTimeout is how long to wait for the file lock held by another plugin process
+	boltTimeout = 10 * time.Second
+)
+
+// boltStore keeps data in a single bbolt file, for single host deployments.
+// Each value is prefixed by its revision, which comes from the sequence of the bucket.
+type boltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore opens or creates the bolt file at path
+func NewBoltStore(path string) (Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: boltTimeout})
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(boltBucket))
+		return err
+	}); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &boltStore{db: db}, nil
+}
+
+// Get .
+func (s *boltStore) Get(_ context.Context, key string) ([]byte, int64, error) {
+	var value []byte
+	var rev int64
+	err := s.db.View(func(tx *bolt.Tx) error {
+		value, rev = decodeBoltValue(tx.Bucket([]byte(boltBucket)).Get([]byte(key)))
+		return nil
+	})
+	return value, rev, err
+}
+
+// GetMulti .
+func (s *boltStore) GetMulti(_ context.Context, keys []string) (map[string][]byte, error) {
+	return s.getMulti(keys, true)
+}
+
+// GetExisting .
+func (s *boltStore) GetExisting(_ context.Context, keys []string) (map[string][]byte, error) {
+	return s.getMulti(keys, false)
+}
+
+// getMulti returns values of keys in one transaction, missing keys fail if strict
+func (s *boltStore) getMulti(keys []string, strict bool) (map[string][]byte, error) {
+	values := map[string][]byte{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(boltBucket))
+		for _, key := range keys {
+			value, _ := decodeBoltValue(b.Get([]byte(key)))
+			switch {
+			case value == nil && strict:
+				return errors.Wrapf(coretypes.ErrInvaildCount, "key: %s", key)
+			case value != nil:
+				values[key] = value
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// Put .
+func (s *boltStore) Put(_ context.Context, key string, value []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return putBoltValue(tx.Bucket([]byte(boltBucket)), key, value)
+	})
+}
+
+// Delete .
+func (s *boltStore) Delete(_ context.Context, key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(boltBucket)).Delete([]byte(key))
+	})
+}
+
+// CompareAndSwap runs in a write txn, bolt serializes them
+func (s *boltStore) CompareAndSwap(_ context.Context, key string, value []byte, rev int64) (bool, error) {
+	swapped := false
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(boltBucket))
+		// a missing key has revision 0
+		if _, r := decodeBoltValue(b.Get([]byte(key))); r != rev {
+			return nil
+		}
+		swapped = true
+		return putBoltValue(b, key, value)
+	})
+	return swapped, err
+}
+
+// Close .
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
+
+func putBoltValue(b *bolt.Bucket, key string, value []byte) error {
+	rev, err := b.NextSequence()
+	if err != nil {
+		return err
+	}
+	data := make([]byte, 8+len(value))
+	binary.BigEndian.PutUint64(data, rev)
+	copy(data[8:], value)
+	return b.Put([]byte(key), data)
+}
+
+// decodeBoltValue copies the value out, data from bolt is only valid in the txn
+func decodeBoltValue(data []byte) ([]byte, int64) {
+	if len(data) < 8 {
+		return nil, 0
+	}
+	return copyBytes(data[8:]), int64(binary.BigEndian.Uint64(data))
+}
@@ -0,0 +1,136 @@
+package bandwidth
+
+import (
+	"context"
+	"crypto/tls"
+
+	"github.com/cockroachdb/errors"
+	coretypes "github.com/projecteru2/core/types"
+	"go.etcd.io/etcd/client/pkg/v3/transport"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/namespace"
+)
+
+// etcdStore keeps data in etcd, revisions are mod revisions of keys
+type etcdStore struct {
+	cli    clientv3.KV
+	closer func() error
+}
+
+// NewETCDStore connects to the etcd cluster of config, dialing within the connection timeout of core
+func NewETCDStore(coreConfig coretypes.Config) (Store, error) {
+	config := coreConfig.Etcd
+	if len(config.Machines) < 1 {
+		return nil, coretypes.ErrConfigInvaild
+	}
+	var tlsConfig *tls.Config
+	var err error
+	if config.Ca != "" && config.Key != "" && config.Cert != "" {
+		tlsInfo := transport.TLSInfo{
+			TrustedCAFile: config.Ca,
+			KeyFile:       config.Key,
+			CertFile:      config.Cert,
+		}
+		if tlsConfig, err = tlsInfo.ClientConfig(); err != nil {
+			return nil, err
+		}
+	}
+	cli, err := clientv3.New(clientv3.Config{
+		Endpoints:            config.Machines,
+		Username:             config.Auth.Username,
+		Password:             config.Auth.Password,
+		TLS:                  tlsConfig,
+		DialTimeout:          coreConfig.ConnectionTimeout,
+		DialKeepAliveTime:    coreConfig.HAKeepaliveInterval,
+		DialKeepAliveTimeout: coreConfig.ConnectionTimeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &etcdStore{cli: namespace.NewKV(cli.KV, config.Prefix), closer: cli.Close}, nil
+}
+
+// Get .
+func (s *etcdStore) Get(ctx context.Context, key string) ([]byte, int64, error) {
+	resp, err := s.cli.Get(ctx, key)
+	if err != nil {
+		return nil, 0, err
+	}
+	switch resp.Count {
+	case 0:
+		return nil, 0, nil
+	case 1:
+		return resp.Kvs[0].Value, resp.Kvs[0].ModRevision, nil
+	default:
+		return nil, 0, errors.Wrapf(coretypes.ErrInvaildCount, "key: %s", key)
+	}
+}
+
+// GetMulti gets all keys in one txn
+func (s *etcdStore) GetMulti(ctx context.Context, keys []string) (map[string][]byte, error) {
+	return s.getMulti(ctx, keys, true)
+}
+
+// GetExisting gets keys in one txn, keys which don't exist are left out
+func (s *etcdStore) GetExisting(ctx context.Context, keys []string) (map[string][]byte, error) {
+	return s.getMulti(ctx, keys, false)
+}
+
+// getMulti gets all keys in one txn, missing keys fail if strict
+func (s *etcdStore) getMulti(ctx context.Context, keys []string, strict bool) (map[string][]byte, error) {
+	values := map[string][]byte{}
+	if len(keys) == 0 {
+		return values, nil
+	}
+	ops := []clientv3.Op{}
+	for _, key := range keys {
+		ops = append(ops, clientv3.OpGet(key))
+	}
+	resp, err := s.cli.Txn(ctx).Then(ops...).Commit()
+	if err != nil {
+		return nil, err
+	}
+	for idx, op := range resp.Responses {
+		r := op.GetResponseRange()
+		if r.Count == 0 && !strict {
+			continue
+		}
+		if r.Count != 1 {
+			return nil, errors.Wrapf(coretypes.ErrInvaildCount, "key: %s", keys[idx])
+		}
+		values[keys[idx]] = r.Kvs[0].Value
+	}
+	return values, nil
+}
+
+// Put .
+func (s *etcdStore) Put(ctx context.Context, key string, value []byte) error {
+	_, err := s.cli.Put(ctx, key, string(value))
+	return err
+}
+
+// Delete .
+func (s *etcdStore) Delete(ctx context.Context, key string) error {
+	_, err := s.cli.Delete(ctx, key)
+	return err
+}
+
+// CompareAndSwap compares the mod revision of key in a txn
+func (s *etcdStore) CompareAndSwap(ctx context.Context, key string, value []byte, rev int64) (bool, error) {
+	resp, err := s.cli.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(key), "=", rev)).
+		Then(clientv3.OpPut(key, string(value))).
+		Commit()
+	if err != nil {
+		return false, err
+	}
+	return resp.Succeeded, nil
+}
+
+// Close .
+func (s *etcdStore) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+	return s.closer()
+}
@@ -0,0 +1,107 @@
+package bandwidth
+
+import (
+	"context"
+	"sync"
+
+	"github.com/cockroachdb/errors"
+	coretypes "github.com/projecteru2/core/types"
+)
+
+type memoryEntry struct {
+	value []byte
+	rev   int64
+}
+
+// memoryStore keeps data in memory, for tests and dry runs
+type memoryStore struct {
+	sync.Mutex
+	rev  int64
+	data map[string]memoryEntry
+}
+
+// NewMemoryStore .
+func NewMemoryStore() Store {
+	return &memoryStore{data: map[string]memoryEntry{}}
+}
+
+// Get .
+func (s *memoryStore) Get(_ context.Context, key string) ([]byte, int64, error) {
+	s.Lock()
+	defer s.Unlock()
+	entry, ok := s.data[key]
+	if !ok {
+		return nil, 0, nil
+	}
+	return copyBytes(entry.value), entry.rev, nil
+}
+
+// GetMulti .
+func (s *memoryStore) GetMulti(_ context.Context, keys []string) (map[string][]byte, error) {
+	return s.getMulti(keys, true)
+}
+
+// GetExisting .
+func (s *memoryStore) GetExisting(_ context.Context, keys []string) (map[string][]byte, error) {
+	return s.getMulti(keys, false)
+}
+
+// getMulti returns values of keys, missing keys fail if strict
+func (s *memoryStore) getMulti(keys []string, strict bool) (map[string][]byte, error) {
+	s.Lock()
+	defer s.Unlock()
+	values := map[string][]byte{}
+	for _, key := range keys {
+		entry, ok := s.data[key]
+		if !ok {
+			if strict {
+				return nil, errors.Wrapf(coretypes.ErrInvaildCount, "key: %s", key)
+			}
+			continue
+		}
+		values[key] = copyBytes(entry.value)
+	}
+	return values, nil
+}
+
+// Put .
+func (s *memoryStore) Put(_ context.Context, key string, value []byte) error {
+	s.Lock()
+	defer s.Unlock()
+	s.put(key, value)
+	return nil
+}
+
+// Delete .
+func (s *memoryStore) Delete(_ context.Context, key string) error {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.data, key)
+	return nil
+}
+
+// CompareAndSwap .
+func (s *memoryStore) CompareAndSwap(_ context.Context, key string, value []byte, rev int64) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	// a missing key has revision 0
+	if s.data[key].rev != rev {
+		return false, nil
+	}
+	s.put(key, value)
+	return true, nil
+}
+
+// Close .
+func (s *memoryStore) Close() error {
+	return nil
+}
+
+func (s *memoryStore) put(key string, value []byte) {
+	s.rev++
+	s.data[key] = memoryEntry{value: copyBytes(value), rev: s.rev}
+}
+
+func copyBytes(b []byte) []byte {
+	return append([]byte{}, b...)
+}
@@ -0,0 +1,139 @@
+package bandwidth
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	embedded "github.com/projecteru2/core/store/etcdv3/embedded"
+	coretypes "github.com/projecteru2/core/types"
+	"github.com/stretchr/testify/assert"
+	bdtypes "github.com/yuyang0/resource-bandwidth/bandwidth/types"
+)
+
+func TestStores(t *testing.T) {
+	bolt, err := NewBoltStore(filepath.Join(t.TempDir(), "bandwidth.db"))
+	assert.Nil(t, err)
+	defer bolt.Close()
+
+	for name, store := range map[string]Store{
+		"etcd":   &etcdStore{cli: embedded.NewCluster(t, "/store").RandClient()},
+		"memory": NewMemoryStore(),
+		"bolt":   bolt,
+	} {
+		t.Run(name, func(t *testing.T) {
+			testStore(t, store)
+		})
+	}
+}
+
+func testStore(t *testing.T, store Store) {
+	ctx := context.Background()
+
+	value, rev, err := store.Get(ctx, "/a")
+	assert.Nil(t, err)
+	assert.Nil(t, value)
+	assert.Equal(t, int64(0), rev)
+
+	assert.Nil(t, store.Put(ctx, "/a", []byte("1")))
+	value, rev, err = store.Get(ctx, "/a")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("1"), value)
+	assert.Greater(t, rev, int64(0))
+
+	// stale revision
+	ok, err := store.CompareAndSwap(ctx, "/a", []byte("2"), rev-1)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = store.CompareAndSwap(ctx, "/a", []byte("2"), rev)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	value, rev1, err := store.Get(ctx, "/a")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("2"), value)
+	assert.Greater(t, rev1, rev)
+
+	// revision 0 means the key must not exist
+	ok, err = store.CompareAndSwap(ctx, "/a", []byte("3"), 0)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = store.CompareAndSwap(ctx, "/b", []byte("3"), 0)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	values, err := store.GetMulti(ctx, []string{"/a", "/b"})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]byte{"/a": []byte("2"), "/b": []byte("3")}, values)
+	_, err = store.GetMulti(ctx, []string{"/a", "/c"})
+	assert.ErrorIs(t, err, coretypes.ErrInvaildCount)
+	values, err = store.GetExisting(ctx, []string{"/a", "/c"})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]byte{"/a": []byte("2")}, values)
+
+	assert.Nil(t, store.Delete(ctx, "/a"))
+	value, _, err = store.Get(ctx, "/a")
+	assert.Nil(t, err)
+	assert.Nil(t, value)
+
+	// concurrent swaps on the same revision, only one wins
+	_, rev, err = store.Get(ctx, "/b")
+	assert.Nil(t, err)
+	wins := 0
+	mu := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, err := store.CompareAndSwap(ctx, "/b", []byte("4"), rev)
+			assert.Nil(t, err)
+			if ok {
+				mu.Lock()
+				wins++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, wins)
+}
+
+func TestNewStore(t *testing.T) {
+	_, err := NewStore(coretypes.Config{}, bdtypes.StoreConfig{Type: bdtypes.StoreETCD})
+	assert.ErrorIs(t, err, coretypes.ErrConfigInvaild)
+	_, err = NewStore(coretypes.Config{}, bdtypes.StoreConfig{Type: "zk"})
+	assert.ErrorIs(t, err, bdtypes.ErrInvalidConfig)
+
+	store, err := NewStore(coretypes.Config{}, bdtypes.StoreConfig{Type: bdtypes.StoreMemory})
+	assert.Nil(t, err)
+	assert.Nil(t, store.Close())
+
+	// data survives reopening the bolt file
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "bandwidth.db")
+	store, err = NewStore(coretypes.Config{}, bdtypes.StoreConfig{Type: bdtypes.StoreBolt, Path: path})
+	assert.Nil(t, err)
+	assert.Nil(t, store.Put(ctx, "/a", []byte("1")))
+	assert.Nil(t, store.Close())
+	store, err = NewStore(coretypes.Config{}, bdtypes.StoreConfig{Type: bdtypes.StoreBolt, Path: path})
+	assert.Nil(t, err)
+	value, _, err := store.Get(ctx, "/a")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("1"), value)
+	assert.Nil(t, store.Close())
+}
+
+func TestNewPluginWithMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	bdConfig := bdtypes.DefaultConfig()
+	bdConfig.Store.Type = bdtypes.StoreMemory
+	cm, err := NewPlugin(ctx, coretypes.Config{}, bdConfig)
+	assert.Nil(t, err)
+	defer cm.Close()
+
+	nodes := generateNodes(ctx, t, cm, 2, 0)
+	r, err := cm.GetMostIdleNode(ctx, nodes)
+	assert.Nil(t, err)
+	assert.Contains(t, nodes, r.Nodename)
+}
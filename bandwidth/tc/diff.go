@@ -0,0 +1,162 @@
+package tc
+
+import (
+	"fmt"
+)
+
+// tolerance is the relative difference of rates taken as equal, tc rounds what it prints
+const tolerance = 0.01
+
+// Diff returns the commands which change current into desired and the differences they fix,
+// only the chains and the devices of desired are compared, so current should be observed for desired.
+// Chains and devices which are not in place are rebuilt, others are changed class by class and rule by rule.
+func Diff(current, desired *Plan) ([]Command, []string) {
+	cmds := []Command{}
+	diffs := []string{}
+	for _, selector := range desired.Chains {
+		c, d := diffChain(current, desired, selector)
+		cmds = append(cmds, c...)
+		diffs = append(diffs, d...)
+	}
+
+	devices := map[string]*Device{}
+	for _, device := range current.Devices {
+		devices[device.Name] = device
+	}
+	for _, want := range desired.Devices {
+		have := devices[want.Name]
+		switch {
+		case have == nil:
+			diffs = append(diffs, fmt.Sprintf("device %s is not shaped", want.Name))
+			cmds = append(cmds, want.commands()...)
+		case have.Uplink != want.Uplink:
+			diffs = append(diffs, fmt.Sprintf("ingress of %s is not redirected to %s", want.Uplink, want.Name))
+			cmds = append(cmds, want.commands()...)
+		default:
+			c, d := diffDevice(have, want)
+			cmds = append(cmds, c...)
+			diffs = append(diffs, d...)
+		}
+	}
+	return cmds, diffs
+}
+
+func diffChain(current, desired *Plan, selector string) ([]Command, []string) {
+	c := chains[selector]
+	inPlace := false
+	for _, s := range current.Chains {
+		inPlace = inPlace || s == selector
+	}
+	if !inPlace {
+		return desired.markCommands(selector), []string{fmt.Sprintf("chain %s is not in place", c.chain)}
+	}
+
+	type rule struct {
+		match string
+		mark  uint32
+	}
+	want := map[rule]bool{}
+	for _, mark := range desired.Marks {
+		if mark.Selector == selector {
+			want[rule{mark.Match, mark.Mark}] = true
+		}
+	}
+	cmds := []Command{}
+	diffs := []string{}
+	seen := map[rule]bool{}
+	for _, mark := range current.Marks {
+		r := rule{mark.Match, mark.Mark}
+		if mark.Selector != selector {
+			continue
+		}
+		if !want[r] || seen[r] {
+			diffs = append(diffs, fmt.Sprintf("mark 0x%x of %s %s in %s is stale", mark.Mark, selector, mark.Match, c.chain))
+			cmds = append(cmds, Command{Args: markArgs("-D", mark)})
+		}
+		seen[r] = true
+	}
+	for _, mark := range desired.Marks {
+		if mark.Selector == selector && !seen[rule{mark.Match, mark.Mark}] {
+			diffs = append(diffs, fmt.Sprintf("mark 0x%x of %s %s in %s is missing", mark.Mark, selector, mark.Match, c.chain))
+			// in front of saving the mark to conntrack
+			cmds = append(cmds, Command{Args: markArgs("-I", mark)})
+		}
+	}
+	return cmds, diffs
+}
+
+func diffDevice(have, want *Device) ([]Command, []string) {
+	cmds := []Command{}
+	diffs := []string{}
+
+	classes := map[string]*Class{}
+	for _, class := range have.Classes {
+		classes[class.ClassID] = class
+	}
+	for _, class := range want.Classes {
+		current, ok := classes[class.ClassID]
+		switch {
+		case !ok:
+			diffs = append(diffs, fmt.Sprintf("class %s of %s is missing", class.ClassID, want.Name))
+			cmds = append(cmds, Command{Args: classArgs("add", want.Name, class)})
+		case !class.equal(current):
+			diffs = append(diffs, fmt.Sprintf("class %s of %s: %s != %s", class.ClassID, want.Name, current, class))
+			cmds = append(cmds, Command{Args: classArgs("change", want.Name, class)})
+		}
+	}
+
+	filters := map[uint32]*Filter{}
+	for _, filter := range have.Filters {
+		filters[filter.Mark] = filter
+	}
+	wantFilters := map[uint32]bool{}
+	for _, filter := range want.Filters {
+		wantFilters[filter.Mark] = true
+		current, ok := filters[filter.Mark]
+		switch {
+		case !ok:
+			diffs = append(diffs, fmt.Sprintf("filter 0x%x of %s is missing", filter.Mark, want.Name))
+			cmds = append(cmds, Command{Args: filterArgs("add", want.Name, filter)})
+		case current.FlowID != filter.FlowID:
+			diffs = append(diffs, fmt.Sprintf("filter 0x%x of %s: flowid %s != %s", filter.Mark, want.Name, current.FlowID, filter.FlowID))
+			cmds = append(cmds, Command{Args: filterArgs("replace", want.Name, filter)})
+		}
+	}
+
+	// classes are deleted after the filters sending packets to them
+	for _, filter := range have.Filters {
+		if !wantFilters[filter.Mark] {
+			diffs = append(diffs, fmt.Sprintf("filter 0x%x of %s is stale", filter.Mark, want.Name))
+			cmds = append(cmds, Command{Args: filterArgs("del", want.Name, filter)})
+		}
+	}
+	wantClasses := map[string]bool{}
+	for _, class := range want.Classes {
+		wantClasses[class.ClassID] = true
+	}
+	for _, class := range have.Classes {
+		if !wantClasses[class.ClassID] {
+			diffs = append(diffs, fmt.Sprintf("class %s of %s is stale", class.ClassID, want.Name))
+			cmds = append(cmds, Command{Args: []string{"tc", "class", "del", "dev", want.Name, "classid", class.ClassID}})
+		}
+	}
+	return cmds, diffs
+}
+
+// equal compares the class with the one observed, bursts are not compared since the kernel keeps them
+// as transmit times at the rates, which lose precision and wrap for big bursts at low rates
+func (c *Class) equal(observed *Class) bool {
+	return near(c.Rate, observed.Rate) && near(c.Ceil, observed.Ceil)
+}
+
+func (c *Class) String() string {
+	return fmt.Sprintf("rate %dbit ceil %dbit", c.Rate, c.Ceil)
+}
+
+func near(want, have int64) bool {
+	d := want - have
+	if d < 0 {
+		d = -d
+	}
+	return float64(d) <= float64(want)*tolerance
+}
@@ -0,0 +1,109 @@
+package tc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func commandLines(cmds []Command) []string {
+	lines := []string{}
+	for _, cmd := range cmds {
+		lines = append(lines, cmd.String())
+	}
+	return lines
+}
+
+func TestDiff(t *testing.T) {
+	desired, err := NewPlan(newTargets(), Options{Device: "eth0"})
+	assert.Nil(t, err)
+	current, err := Observe(context.Background(), newObservedExecutor(), desired)
+	assert.Nil(t, err)
+
+	cmds, diffs := Diff(current, desired)
+	assert.Equal(t, []string{
+		"chain BANDWIDTH-IFACE is not in place",
+		"class 1:10 of ifb-eth0: rate 10bit ceil 40bit != rate 10bit ceil 20bit",
+		"filter 0x12 of ifb-eth0 is stale",
+		"class 1:12 of ifb-eth0 is stale",
+		"device eth1 is not shaped",
+		"device ifb-eth1 is not shaped",
+	}, diffs)
+
+	expected := commandLines(desired.markCommands(SelectInterface))
+	expected = append(expected,
+		"tc class change dev ifb-eth0 parent 1:1 classid 1:10 htb rate 10bit ceil 20bit burst 3b cburst 3b",
+		"tc filter del dev ifb-eth0 parent 1: protocol all prio 1 handle 0x12 fw",
+		"tc class del dev ifb-eth0 classid 1:12",
+	)
+	expected = append(expected, commandLines(desired.Devices[2].commands())...)
+	expected = append(expected, commandLines(desired.Devices[3].commands())...)
+	assert.Equal(t, expected, commandLines(cmds))
+
+	// nothing to do once in place
+	cmds, diffs = Diff(desired, desired)
+	assert.Empty(t, cmds)
+	assert.Empty(t, diffs)
+}
+
+func TestDiffMarks(t *testing.T) {
+	desired, err := NewPlan(newTargets(), Options{Device: "eth0"})
+	assert.Nil(t, err)
+	current := &Plan{
+		Devices: desired.Devices,
+		Marks: []*Mark{
+			{Selector: SelectCgroup, Match: "/workloads/w1", Mark: 0x10},
+			{Selector: SelectCgroup, Match: "/workloads/w1", Mark: 0x10},
+			{Selector: SelectInterface, Match: "veth9", Mark: 0x11},
+		},
+		Chains: []string{SelectCgroup, SelectInterface},
+	}
+
+	cmds, diffs := Diff(current, desired)
+	assert.Equal(t, []string{
+		"mark 0x10 of cgroup /workloads/w1 in BANDWIDTH-CGROUP is stale",
+		"mark 0x11 of interface veth9 in BANDWIDTH-IFACE is stale",
+		"mark 0x11 of interface veth2 in BANDWIDTH-IFACE is missing",
+	}, diffs)
+	assert.Equal(t, []string{
+		"iptables -t mangle -D BANDWIDTH-CGROUP -m cgroup --path /workloads/w1 -j MARK --set-mark 0x10",
+		"iptables -t mangle -D BANDWIDTH-IFACE -i veth9 -j MARK --set-mark 0x11",
+		"iptables -t mangle -I BANDWIDTH-IFACE -i veth2 -j MARK --set-mark 0x11",
+	}, commandLines(cmds))
+}
+
+func TestDiffRedirect(t *testing.T) {
+	desired, err := NewPlan(newTargets(), Options{Device: "eth0"})
+	assert.Nil(t, err)
+	current := &Plan{Devices: []*Device{}, Marks: desired.Marks, Chains: desired.Chains}
+	for _, device := range desired.Devices {
+		observed := *device
+		observed.Uplink = ""
+		observed.Classes = append([]*Class{}, device.Classes...)
+		current.Devices = append(current.Devices, &observed)
+	}
+
+	// bursts are not compared
+	current.Devices[0].Classes[1] = &Class{ClassID: "1:10", Parent: "1:1", Rate: 30, Ceil: 60, Burst: 1600}
+	current.Devices[2].Classes[1] = &Class{ClassID: "1:11", Parent: "1:1", Rate: 8, Ceil: 60}
+
+	cmds, diffs := Diff(current, desired)
+	assert.Equal(t, []string{
+		"ingress of eth0 is not redirected to ifb-eth0",
+		"class 1:11 of eth1: rate 8bit ceil 60bit != rate 8bit ceil 50bit",
+		"ingress of eth1 is not redirected to ifb-eth1",
+	}, diffs)
+	expected := commandLines(desired.Devices[1].commands())
+	expected = append(expected, "tc class change dev eth1 parent 1:1 classid 1:11 htb rate 8bit ceil 50bit")
+	expected = append(expected, commandLines(desired.Devices[3].commands())...)
+	assert.Equal(t, expected, commandLines(cmds))
+}
+
+func TestNear(t *testing.T) {
+	// tc prints 1234567bit as 1234Kbit
+	assert.True(t, near(1234567, 1234000))
+	assert.True(t, near(50, 50))
+	assert.False(t, near(50, 51))
+	assert.False(t, near(1000, 0))
+}
@@ -0,0 +1,48 @@
+package tc
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+)
+
+// Executor runs the commands which observe and change the node, it's the only way the agent touches the kernel
+type Executor interface {
+	// Run runs args and returns its stdout, exiting non-zero is an error
+	Run(ctx context.Context, args []string) ([]byte, error)
+}
+
+// NewExecutor returns the executor running commands on this host
+func NewExecutor() Executor {
+	return hostExecutor{}
+}
+
+type hostExecutor struct{}
+
+// Run .
+func (hostExecutor) Run(ctx context.Context, args []string) ([]byte, error) {
+	if len(args) == 0 {
+		return nil, errors.New("empty command")
+	}
+	stderr := &bytes.Buffer{}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...) //nolint:gosec
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return out, errors.Wrapf(err, "%s: %s", Command{Args: args}, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// Apply runs cmds in order and stops at the first failure, failures of commands which may fail are ignored
+func Apply(ctx context.Context, e Executor, cmds []Command) error {
+	for _, cmd := range cmds {
+		if _, err := e.Run(ctx, cmd.Args); err != nil && !cmd.MayFail {
+			return err
+		}
+	}
+	return nil
+}
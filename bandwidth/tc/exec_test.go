@@ -0,0 +1,56 @@
+package tc
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeExecutor returns canned outputs by command line and fails other commands, it records all it runs
+type fakeExecutor struct {
+	outputs map[string]string
+	ran     []string
+}
+
+func (e *fakeExecutor) Run(_ context.Context, args []string) ([]byte, error) {
+	line := strings.Join(args, " ")
+	e.ran = append(e.ran, line)
+	out, ok := e.outputs[line]
+	if !ok {
+		return nil, errors.Newf("%s: exit status 1", line)
+	}
+	return []byte(out), nil
+}
+
+func TestApply(t *testing.T) {
+	ctx := context.Background()
+	e := &fakeExecutor{outputs: map[string]string{"tc qdisc add": ""}}
+	cmds := []Command{
+		{Args: []string{"tc", "qdisc", "del"}, MayFail: true},
+		{Args: []string{"tc", "qdisc", "add"}},
+		{Args: []string{"tc", "class", "add"}},
+		{Args: []string{"tc", "filter", "add"}},
+	}
+	assert.Error(t, Apply(ctx, e, cmds))
+	assert.Equal(t, []string{"tc qdisc del", "tc qdisc add", "tc class add"}, e.ran)
+
+	e = &fakeExecutor{outputs: map[string]string{"tc qdisc add": ""}}
+	assert.Nil(t, Apply(ctx, e, cmds[:2]))
+	assert.Len(t, e.ran, 2)
+}
+
+func TestHostExecutor(t *testing.T) {
+	ctx := context.Background()
+	e := NewExecutor()
+	out, err := e.Run(ctx, []string{"sh", "-c", "echo ok"})
+	assert.Nil(t, err)
+	assert.Equal(t, "ok\n", string(out))
+
+	_, err = e.Run(ctx, []string{"sh", "-c", "echo failed >&2; exit 2"})
+	assert.ErrorContains(t, err, "failed")
+	_, err = e.Run(ctx, nil)
+	assert.Error(t, err)
+}
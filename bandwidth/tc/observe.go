@@ -0,0 +1,249 @@
+package tc
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+)
+
+// Observe reads the current state of the devices and the chains of desired from the node,
+// devices without an HTB root and chains which are missing or not jumped to are left out
+func Observe(ctx context.Context, e Executor, desired *Plan) (*Plan, error) {
+	current := &Plan{Devices: []*Device{}, Marks: []*Mark{}, Chains: []string{}}
+	for _, selector := range desired.Chains {
+		marks, ok, err := observeChain(ctx, e, selector)
+		if err != nil {
+			return nil, err
+		}
+		current.Marks = append(current.Marks, marks...)
+		if ok {
+			current.Chains = append(current.Chains, selector)
+		}
+	}
+	for _, want := range desired.Devices {
+		device, err := observeDevice(ctx, e, want)
+		if err != nil {
+			return nil, err
+		}
+		if device != nil {
+			current.Devices = append(current.Devices, device)
+		}
+	}
+	return current, nil
+}
+
+// observeChain returns the marks in the chain of selector, and whether the chain is in place:
+// it exists, its hook jumps to it and it saves the mark to conntrack at last
+func observeChain(ctx context.Context, e Executor, selector string) ([]*Mark, bool, error) {
+	c := chains[selector]
+	out, err := e.Run(ctx, mangle("-S", c.chain))
+	if err != nil {
+		// no such chain
+		return nil, false, nil //nolint:nilerr
+	}
+	marks := []*Mark{}
+	saved := false
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "-A" {
+			continue
+		}
+		saved = strings.Contains(line, "-j CONNMARK --save-mark")
+		mark, err := parseMarkRule(selector, fields[2:])
+		if err != nil {
+			return nil, false, errors.Wrapf(err, "chain %s", c.chain)
+		}
+		if mark != nil {
+			marks = append(marks, mark)
+		}
+	}
+	_, err = e.Run(ctx, mangle("-C", c.hook, "-j", c.chain))
+	return marks, saved && err == nil, nil
+}
+
+// parseMarkRule parses the rule of iptables -S without `-A chain`, rules which don't set a mark return nil
+func parseMarkRule(selector string, fields []string) (*Mark, error) {
+	mark := &Mark{Selector: selector}
+	isMark := false
+	for i := 0; i+1 < len(fields); i++ {
+		value := strings.Trim(fields[i+1], `"`)
+		switch fields[i] {
+		case "--path", "-i":
+			mark.Match = value
+		case "--set-xmark", "--set-mark":
+			isMark = true
+			// like 0x10/0xffffffff
+			n, err := strconv.ParseUint(strings.SplitN(value, "/", 2)[0], 0, 32)
+			if err != nil {
+				return nil, errors.Wrapf(err, "mark %q", value)
+			}
+			mark.Mark = uint32(n)
+		}
+	}
+	if !isMark {
+		return nil, nil
+	}
+	return mark, nil
+}
+
+// observeDevice returns the tree on the device of want, nil if it has no HTB root,
+// for an IFB the uplink is set only if its ingress is redirected to the IFB
+func observeDevice(ctx context.Context, e Executor, want *Device) (*Device, error) {
+	out, err := e.Run(ctx, []string{"tc", "-j", "qdisc", "show", "dev", want.Name})
+	if err != nil {
+		// no such device
+		return nil, nil //nolint:nilerr
+	}
+	qdiscs := []struct {
+		Kind   string `json:"kind"`
+		Handle string `json:"handle"`
+		Root   bool   `json:"root"`
+	}{}
+	if err := json.Unmarshal(out, &qdiscs); err != nil {
+		return nil, errors.Wrapf(err, "qdiscs of %s", want.Name)
+	}
+	shaped := false
+	for _, qdisc := range qdiscs {
+		shaped = shaped || (qdisc.Root && qdisc.Kind == "htb" && qdisc.Handle == rootHandle)
+	}
+	if !shaped {
+		return nil, nil
+	}
+
+	device := &Device{Name: want.Name, Classes: []*Class{}, Filters: []*Filter{}}
+	if out, err = e.Run(ctx, []string{"tc", "class", "show", "dev", want.Name}); err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		class, err := parseClass(line)
+		if err != nil {
+			return nil, errors.Wrapf(err, "classes of %s", want.Name)
+		}
+		if class != nil {
+			device.Classes = append(device.Classes, class)
+		}
+	}
+	if out, err = e.Run(ctx, []string{"tc", "filter", "show", "dev", want.Name, "parent", rootHandle}); err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		filter, err := parseFilter(line)
+		if err != nil {
+			return nil, errors.Wrapf(err, "filters of %s", want.Name)
+		}
+		if filter != nil {
+			device.Filters = append(device.Filters, filter)
+		}
+	}
+
+	if want.Uplink != "" {
+		// the uplink may have no ingress qdisc at all, which fails
+		if out, err := e.Run(ctx, []string{"tc", "filter", "show", "dev", want.Uplink, "parent", "ffff:"}); err == nil {
+			for _, match := range redirectPattern.FindAllStringSubmatch(string(out), -1) {
+				if match[1] == want.Name {
+					device.Uplink = want.Uplink
+				}
+			}
+		}
+	}
+	return device, nil
+}
+
+var redirectPattern = regexp.MustCompile(`Redirect to device (\S+)\)`)
+
+// parseClass parses a line of tc class show like
+// `class htb 1:10 parent 1:1 prio 0 rate 30Kbit ceil 60Kbit burst 3000b cburst 3000b`, other lines return nil
+func parseClass(line string) (*Class, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 3 || fields[0] != "class" || fields[1] != "htb" {
+		return nil, nil
+	}
+	class := &Class{ClassID: fields[2]}
+	for i := 3; i < len(fields); i++ {
+		if fields[i] == "root" {
+			class.Parent = rootHandle
+			continue
+		}
+		if i+1 == len(fields) {
+			break
+		}
+		var err error
+		switch value := fields[i+1]; fields[i] {
+		case "parent":
+			class.Parent = value
+		case "rate":
+			class.Rate, err = parseSize(value, "bit", 1000)
+		case "ceil":
+			class.Ceil, err = parseSize(value, "bit", 1000)
+		case "burst":
+			class.Burst, err = parseSize(value, "b", 1024)
+		case "cburst":
+			class.Cburst, err = parseSize(value, "b", 1024)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		i++
+	}
+	return class, nil
+}
+
+// parseFilter parses a line of tc filter show like
+// `filter protocol all pref 1 fw chain 0 handle 0x10 classid 1:10`, lines without a handle return nil
+func parseFilter(line string) (*Filter, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || fields[0] != "filter" {
+		return nil, nil
+	}
+	filter := &Filter{}
+	hasHandle := false
+	for i := 1; i+1 < len(fields); i++ {
+		switch value := fields[i+1]; fields[i] {
+		case "handle":
+			// like 0x10 or 0x10/0xff
+			n, err := strconv.ParseUint(strings.SplitN(value, "/", 2)[0], 0, 32)
+			if err != nil {
+				return nil, errors.Wrapf(err, "handle %q", value)
+			}
+			filter.Mark, hasHandle = uint32(n), true
+		case "classid", "flowid":
+			filter.FlowID = value
+		}
+	}
+	if !hasHandle {
+		return nil, nil
+	}
+	return filter, nil
+}
+
+var sizePrefixes = map[string]int{"": 0, "K": 1, "M": 2, "G": 3, "T": 4}
+
+// parseSize parses a number printed by tc like 30Kbit or 3Kb, prefixes are powers of base
+func parseSize(s string, unit string, base float64) (int64, error) {
+	number, ok := strings.CutSuffix(s, unit)
+	if !ok {
+		return 0, errors.Errorf("unknown size %q", s)
+	}
+	prefix := ""
+	if n := len(number); n > 0 && (number[n-1] < '0' || number[n-1] > '9') {
+		prefix, number = number[n-1:], number[:n-1]
+	}
+	power, ok := sizePrefixes[prefix]
+	if !ok {
+		return 0, errors.Errorf("unknown size %q", s)
+	}
+	f, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, errors.Errorf("unknown size %q", s)
+	}
+	for ; power > 0; power-- {
+		f *= base
+	}
+	return int64(f + 0.5), nil
+}
@@ -0,0 +1,113 @@
+package tc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newObservedExecutor returns the node of newTargets on eth0: the cgroup chain and eth0 are in place,
+// the interface chain saves no mark, ifb-eth0 has a changed and a stale class, eth1 has no HTB root and ifb-eth1 is missing
+func newObservedExecutor() *fakeExecutor {
+	return &fakeExecutor{outputs: map[string]string{
+		"iptables -t mangle -S BANDWIDTH-CGROUP": `-N BANDWIDTH-CGROUP
+-A BANDWIDTH-CGROUP -m cgroup --path /workloads/w1 -j MARK --set-xmark 0x10/0xffffffff
+-A BANDWIDTH-CGROUP -j CONNMARK --save-mark --nfmask 0xffffffff --ctmask 0xffffffff
+`,
+		"iptables -t mangle -C OUTPUT -j BANDWIDTH-CGROUP": "",
+		"iptables -t mangle -S BANDWIDTH-IFACE": `-N BANDWIDTH-IFACE
+-A BANDWIDTH-IFACE -i veth2 -j MARK --set-xmark 0x11/0xffffffff
+`,
+		"iptables -t mangle -C PREROUTING -j BANDWIDTH-IFACE": "",
+
+		"tc -j qdisc show dev eth0": `[{"kind":"htb","handle":"1:","root":true,"refcnt":2,"options":{"r2q":10,"default":"0"}},{"kind":"ingress","handle":"ffff:","parent":"ffff:fff1","options":{}}]`,
+		"tc class show dev eth0": `class htb 1:10 parent 1:1 prio 0 rate 30bit ceil 60bit burst 8b cburst 8b 
+class htb 1:1 root rate 60bit ceil 60bit burst 1600b cburst 1600b 
+`,
+		"tc filter show dev eth0 parent 1:": `filter protocol all pref 1 fw chain 0 
+filter protocol all pref 1 fw chain 0 handle 0x10 classid 1:10 
+`,
+		"tc filter show dev eth0 parent ffff:": `filter protocol all pref 1 matchall chain 0 
+filter protocol all pref 1 matchall chain 0 handle 0x1 
+  not_in_hw
+	action order 1: connmark zone 0 pipe
+	 index 1 ref 1 bind 1
+
+	action order 2: mirred (Egress Redirect to device ifb-eth0) stolen
+	index 1 ref 1 bind 1
+`,
+
+		"tc -j qdisc show dev ifb-eth0": `[{"kind":"htb","handle":"1:","root":true,"refcnt":2,"options":{"r2q":10,"default":"0"}}]`,
+		"tc class show dev ifb-eth0": `class htb 1:12 parent 1:1 prio 0 rate 8bit ceil 8bit burst 1600b cburst 1600b 
+class htb 1:10 parent 1:1 leaf 10: prio 0 rate 10bit ceil 40bit burst 3b cburst 3b 
+class htb 1:1 root rate 20bit ceil 20bit burst 1600b cburst 1600b 
+`,
+		"tc filter show dev ifb-eth0 parent 1:": `filter protocol all pref 1 fw chain 0 
+filter protocol all pref 1 fw chain 0 handle 0x10 classid 1:10 
+filter protocol all pref 1 fw chain 0 handle 0x12 classid 1:12 
+`,
+
+		"tc -j qdisc show dev eth1": `[{"kind":"mq","handle":"0:","root":true,"options":{}}]`,
+	}}
+}
+
+func TestObserve(t *testing.T) {
+	desired, err := NewPlan(newTargets(), Options{Device: "eth0"})
+	assert.Nil(t, err)
+	current, err := Observe(context.Background(), newObservedExecutor(), desired)
+	assert.Nil(t, err)
+
+	assert.Equal(t, []string{SelectCgroup}, current.Chains)
+	assert.Equal(t, []*Mark{
+		{Selector: SelectCgroup, Match: "/workloads/w1", Mark: 0x10},
+		{Selector: SelectInterface, Match: "veth2", Mark: 0x11},
+	}, current.Marks)
+	assert.Equal(t, []*Device{
+		{
+			Name: "eth0",
+			Classes: []*Class{
+				{ClassID: "1:10", Parent: "1:1", Rate: 30, Ceil: 60, Burst: 8, Cburst: 8},
+				{ClassID: "1:1", Parent: "1:", Rate: 60, Ceil: 60, Burst: 1600, Cburst: 1600},
+			},
+			Filters: []*Filter{{Mark: 0x10, FlowID: "1:10"}},
+		},
+		{
+			Name:   "ifb-eth0",
+			Uplink: "eth0",
+			Classes: []*Class{
+				{ClassID: "1:12", Parent: "1:1", Rate: 8, Ceil: 8, Burst: 1600, Cburst: 1600},
+				{ClassID: "1:10", Parent: "1:1", Rate: 10, Ceil: 40, Burst: 3, Cburst: 3},
+				{ClassID: "1:1", Parent: "1:", Rate: 20, Ceil: 20, Burst: 1600, Cburst: 1600},
+			},
+			Filters: []*Filter{{Mark: 0x10, FlowID: "1:10"}, {Mark: 0x12, FlowID: "1:12"}},
+		},
+	}, current.Devices)
+
+	// a class which can't be parsed fails the observation
+	e := newObservedExecutor()
+	e.outputs["tc class show dev eth0"] = "class htb 1:1 root rate 60XB ceil 60bit\n"
+	_, err = Observe(context.Background(), e, desired)
+	assert.Error(t, err)
+}
+
+func TestParseSize(t *testing.T) {
+	for s, expected := range map[string]int64{
+		"8bit":     8,
+		"1234Kbit": 1234000,
+		"1Gbit":    1000000000,
+		"2.5Mbit":  2500000,
+	} {
+		n, err := parseSize(s, "bit", 1000)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, n, s)
+	}
+	n, err := parseSize("16Kb", "b", 1024)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(16384), n)
+
+	for _, s := range []string{"8", "8Xbit", "Kbit", "1.2.3bit"} {
+		_, err := parseSize(s, "bit", 1000)
+		assert.Error(t, err, s)
+	}
+}
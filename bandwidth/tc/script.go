@@ -0,0 +1,161 @@
+package tc
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// mangle chains of marks by selector, each selector only works in some hooks
+var chains = map[string]struct {
+	chain string
+	hook  string
+	match func(string) []string
+}{
+	SelectCgroup: {
+		chain: "BANDWIDTH-CGROUP",
+		hook:  "OUTPUT",
+		match: func(path string) []string { return []string{"-m", "cgroup", "--path", path} },
+	},
+	SelectInterface: {
+		chain: "BANDWIDTH-IFACE",
+		hook:  "PREROUTING",
+		match: func(name string) []string { return []string{"-i", name} },
+	},
+}
+
+// Command is one command of a plan
+type Command struct {
+	Args []string `json:"args"`
+	// MayFail is true if the command fails when there is nothing to clean or the thing already exists
+	MayFail bool `json:"may_fail,omitempty"`
+}
+
+func (c Command) String() string {
+	args := make([]string, 0, len(c.Args))
+	for _, arg := range c.Args {
+		args = append(args, Quote(arg))
+	}
+	s := strings.Join(args, " ")
+	if c.MayFail {
+		s += " 2>/dev/null || true"
+	}
+	return s
+}
+
+// Commands returns the commands which apply the plan from any state,
+// trees of the devices in the plan are rebuilt from scratch and the mark chains are flushed
+func (p *Plan) Commands() []Command {
+	cmds := []Command{}
+	for _, selector := range []string{SelectCgroup, SelectInterface} {
+		cmds = append(cmds, p.markCommands(selector)...)
+	}
+	for _, device := range p.Devices {
+		cmds = append(cmds, device.commands()...)
+	}
+	return cmds
+}
+
+// Script returns the commands of the plan as a shell script
+func (p *Plan) Script() string {
+	b := &strings.Builder{}
+	b.WriteString("#!/bin/sh\n# traffic control plan of resource-bandwidth\nset -e\n")
+	for _, cmd := range p.Commands() {
+		fmt.Fprintln(b, cmd.String())
+	}
+	return b.String()
+}
+
+func (p *Plan) markCommands(selector string) []Command {
+	c := chains[selector]
+	cmds := []Command{
+		{Args: mangle("-N", c.chain), MayFail: true},
+		{Args: mangle("-F", c.chain)},
+		// delete then append, so the chain is jumped to exactly once
+		{Args: mangle("-D", c.hook, "-j", c.chain), MayFail: true},
+		{Args: mangle("-A", c.hook, "-j", c.chain)},
+	}
+	for _, mark := range p.Marks {
+		if mark.Selector == selector {
+			cmds = append(cmds, Command{Args: markArgs("-A", mark)})
+		}
+	}
+	// replies are marked from conntrack on the ingress of the uplink
+	cmds = append(cmds, Command{Args: mangle("-A", c.chain, "-j", "CONNMARK", "--save-mark")})
+	return cmds
+}
+
+func (d *Device) commands() []Command {
+	cmds := []Command{}
+	if d.Uplink != "" {
+		cmds = append(cmds,
+			Command{Args: []string{"ip", "link", "add", "name", d.Name, "type", "ifb"}, MayFail: true},
+			Command{Args: []string{"ip", "link", "set", "dev", d.Name, "up"}},
+		)
+	}
+	cmds = append(cmds,
+		Command{Args: []string{"tc", "qdisc", "del", "dev", d.Name, "root"}, MayFail: true},
+		// unclassified packets are not shaped
+		Command{Args: []string{"tc", "qdisc", "add", "dev", d.Name, "root", "handle", rootHandle, "htb", "default", "0"}},
+	)
+	for _, class := range d.Classes {
+		cmds = append(cmds, Command{Args: classArgs("add", d.Name, class)})
+	}
+	for _, filter := range d.Filters {
+		cmds = append(cmds, Command{Args: filterArgs("add", d.Name, filter)})
+	}
+	if d.Uplink != "" {
+		cmds = append(cmds,
+			Command{Args: []string{"tc", "qdisc", "del", "dev", d.Uplink, "ingress"}, MayFail: true},
+			Command{Args: []string{"tc", "qdisc", "add", "dev", d.Uplink, "handle", "ffff:", "ingress"}},
+			Command{Args: []string{"tc", "filter", "add", "dev", d.Uplink, "parent", "ffff:", "protocol", "all", "prio", "1",
+				"matchall", "action", "connmark", "action", "mirred", "egress", "redirect", "dev", d.Name}},
+		)
+	}
+	return cmds
+}
+
+func mangle(args ...string) []string {
+	return append([]string{"iptables", "-t", "mangle"}, args...)
+}
+
+// markArgs returns the iptables command of the rule of mark, op is -A, -I or -D
+func markArgs(op string, mark *Mark) []string {
+	c := chains[mark.Selector]
+	args := mangle(op, c.chain)
+	args = append(args, c.match(mark.Match)...)
+	return append(args, "-j", "MARK", "--set-mark", fmt.Sprintf("0x%x", mark.Mark))
+}
+
+// classArgs returns the tc command of class, verb is add or change
+func classArgs(verb string, device string, class *Class) []string {
+	args := []string{"tc", "class", verb, "dev", device, "parent", class.Parent, "classid", class.ClassID,
+		"htb", "rate", fmt.Sprintf("%dbit", class.Rate), "ceil", fmt.Sprintf("%dbit", class.Ceil)}
+	if class.Burst > 0 {
+		args = append(args, "burst", fmt.Sprintf("%db", class.Burst))
+	}
+	if class.Cburst > 0 {
+		args = append(args, "cburst", fmt.Sprintf("%db", class.Cburst))
+	}
+	return args
+}
+
+// filterArgs returns the tc command of filter, verb is add, replace or del
+func filterArgs(verb string, device string, filter *Filter) []string {
+	args := []string{"tc", "filter", verb, "dev", device, "parent", rootHandle,
+		"protocol", "all", "prio", "1", "handle", fmt.Sprintf("0x%x", filter.Mark), "fw"}
+	if verb == "del" {
+		return args
+	}
+	return append(args, "flowid", filter.FlowID)
+}
+
+var safeArg = regexp.MustCompile(`^[A-Za-z0-9_./:%+=@,-]+$`)
+
+// Quote quotes arg for the shell if needed
+func Quote(arg string) string {
+	if safeArg.MatchString(arg) {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
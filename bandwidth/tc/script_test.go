@@ -0,0 +1,71 @@
+package tc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yuyang0/resource-bandwidth/bandwidth/types"
+)
+
+func TestScript(t *testing.T) {
+	targets := []*Target{
+		{
+			ID:           "w1",
+			Slot:         1,
+			EngineParams: &types.EngineParams{Ingress: types.Limit{Average: 10, Peak: 20, Burst: 3}, Egress: types.Limit{Average: 30, Peak: 60}},
+			Selector:     SelectCgroup,
+			Match:        "/workloads/it's w1",
+		},
+		{
+			ID:           "w2",
+			Slot:         2,
+			EngineParams: &types.EngineParams{Ingress: types.Limit{Average: 10, Peak: 10}, Egress: types.Limit{Average: 10, Peak: 10}},
+			Selector:     SelectInterface,
+			Match:        "veth2",
+		},
+	}
+	plan, err := NewPlan(targets, Options{Device: "eth0", Capacity: types.NewNodeResource(100, 100)})
+	assert.Nil(t, err)
+
+	expected := `#!/bin/sh
+# traffic control plan of resource-bandwidth
+set -e
+iptables -t mangle -N BANDWIDTH-CGROUP 2>/dev/null || true
+iptables -t mangle -F BANDWIDTH-CGROUP
+iptables -t mangle -D OUTPUT -j BANDWIDTH-CGROUP 2>/dev/null || true
+iptables -t mangle -A OUTPUT -j BANDWIDTH-CGROUP
+iptables -t mangle -A BANDWIDTH-CGROUP -m cgroup --path '/workloads/it'\''s w1' -j MARK --set-mark 0x10
+iptables -t mangle -A BANDWIDTH-CGROUP -j CONNMARK --save-mark
+iptables -t mangle -N BANDWIDTH-IFACE 2>/dev/null || true
+iptables -t mangle -F BANDWIDTH-IFACE
+iptables -t mangle -D PREROUTING -j BANDWIDTH-IFACE 2>/dev/null || true
+iptables -t mangle -A PREROUTING -j BANDWIDTH-IFACE
+iptables -t mangle -A BANDWIDTH-IFACE -i veth2 -j MARK --set-mark 0x11
+iptables -t mangle -A BANDWIDTH-IFACE -j CONNMARK --save-mark
+tc qdisc del dev eth0 root 2>/dev/null || true
+tc qdisc add dev eth0 root handle 1: htb default 0
+tc class add dev eth0 parent 1: classid 1:1 htb rate 100bit ceil 100bit
+tc class add dev eth0 parent 1:1 classid 1:10 htb rate 30bit ceil 60bit
+tc class add dev eth0 parent 1:1 classid 1:11 htb rate 10bit ceil 10bit
+tc filter add dev eth0 parent 1: protocol all prio 1 handle 0x10 fw flowid 1:10
+tc filter add dev eth0 parent 1: protocol all prio 1 handle 0x11 fw flowid 1:11
+ip link add name ifb-eth0 type ifb 2>/dev/null || true
+ip link set dev ifb-eth0 up
+tc qdisc del dev ifb-eth0 root 2>/dev/null || true
+tc qdisc add dev ifb-eth0 root handle 1: htb default 0
+tc class add dev ifb-eth0 parent 1: classid 1:1 htb rate 100bit ceil 100bit
+tc class add dev ifb-eth0 parent 1:1 classid 1:10 htb rate 10bit ceil 20bit burst 3b cburst 3b
+tc class add dev ifb-eth0 parent 1:1 classid 1:11 htb rate 10bit ceil 10bit
+tc filter add dev ifb-eth0 parent 1: protocol all prio 1 handle 0x10 fw flowid 1:10
+tc filter add dev ifb-eth0 parent 1: protocol all prio 1 handle 0x11 fw flowid 1:11
+tc qdisc del dev eth0 ingress 2>/dev/null || true
+tc qdisc add dev eth0 handle ffff: ingress
+tc filter add dev eth0 parent ffff: protocol all prio 1 matchall action connmark action mirred egress redirect dev ifb-eth0
+`
+	assert.Equal(t, expected, plan.Script())
+
+	// rates are in bits per second whatever the unit is
+	plan, err = NewPlan(targets, Options{Device: "eth0", Unit: types.Unit(1e6)})
+	assert.Nil(t, err)
+	assert.Contains(t, plan.Commands()[15].Args, "30000000bit")
+}
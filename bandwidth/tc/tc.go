@@ -0,0 +1,254 @@
+// Package tc renders engine params of the workloads on a node into a linux traffic control plan.
+// Egress of workloads is shaped by an HTB tree on the uplink, ingress by an HTB tree on an IFB device
+// which the ingress of the uplink is redirected to. Packets are marked by workload in the mangle table,
+// the mark is kept in conntrack for replies, and fw filters send each mark to the class of its workload.
+package tc
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+
+	"github.com/cockroachdb/errors"
+	"github.com/yuyang0/resource-bandwidth/bandwidth/types"
+)
+
+// selectors of packets of a target
+const (
+	// SelectCgroup matches packets sent by processes in the cgroup of the target, Match is the cgroup v2 path
+	SelectCgroup = types.SelectCgroup
+	// SelectInterface matches packets from the host side interface of the target, like a veth or a tap
+	SelectInterface = types.SelectInterface
+)
+
+const (
+	// minRate is the rate in bits per second of classes without guarantee, HTB needs a positive one
+	minRate = 8
+	// firstMinor is the minor of the first workload class and its mark, lower ones are left for the root
+	firstMinor = 0x10
+	maxMinor   = 0xffff
+	// ifNameSize is the max length of an interface name
+	ifNameSize = 15
+)
+
+// Target is a workload to shape
+type Target struct {
+	ID string `json:"id"`
+	// Slot numbers the target on its node from 1, its mark and classes follow it,
+	// so they don't change when other targets come and go
+	Slot         int                 `json:"slot"`
+	EngineParams *types.EngineParams `json:"engine_params"`
+	Selector     string              `json:"selector"`
+	Match        string              `json:"match"`
+}
+
+// Options .
+type Options struct {
+	// Device is the uplink of targets whose engine params have no NIC
+	Device string
+	// Capacity is the rate of root classes, by NIC or the node totals, nil means the sum of ceils
+	Capacity *types.NodeResource
+	// Devices are in the plan even without targets, so their trees are cleared once the last target is gone
+	Devices []string
+	// Unit is the unit of the bandwidths of targets and capacity, 0 means bits per second
+	Unit types.Unit
+}
+
+// Plan is the traffic control state of a node
+type Plan struct {
+	Devices []*Device `json:"devices"`
+	Marks   []*Mark   `json:"marks"`
+	// Chains are the selectors whose mark chains are in place, a plan always has all of them
+	Chains []string `json:"chains"`
+}
+
+// Device is the HTB tree of one device
+type Device struct {
+	Name string `json:"name"`
+	// Uplink is the device whose ingress is redirected to this IFB, empty when the tree shapes egress of itself
+	Uplink  string    `json:"uplink,omitempty"`
+	Classes []*Class  `json:"classes"`
+	Filters []*Filter `json:"filters"`
+}
+
+// Class is an HTB class, Rate and Ceil are in bits per second, Burst and Cburst in bytes where 0 leaves them to tc
+type Class struct {
+	ClassID string `json:"classid"`
+	Parent  string `json:"parent"`
+	// Target is empty for the root class
+	Target string `json:"target,omitempty"`
+	Rate   int64  `json:"rate"`
+	Ceil   int64  `json:"ceil"`
+	Burst  int64  `json:"burst,omitempty"`
+	Cburst int64  `json:"cburst,omitempty"`
+}
+
+// Filter sends packets with Mark to the class FlowID
+type Filter struct {
+	Mark   uint32 `json:"mark"`
+	FlowID string `json:"flowid"`
+}
+
+// Mark is the mangle rule marking packets of a target
+type Mark struct {
+	Target   string `json:"target"`
+	Selector string `json:"selector"`
+	Match    string `json:"match"`
+	Mark     uint32 `json:"mark"`
+}
+
+// NewPlan returns the plan of targets, it only depends on its input so the same targets always give the same plan.
+// Marks and minors of classes are by slot, a target keeps them whatever the other targets are.
+func NewPlan(targets []*Target, opts Options) (*Plan, error) {
+	targets = append([]*Target{}, targets...)
+	sort.Slice(targets, func(i, j int) bool { return targets[i].ID < targets[j].ID })
+
+	plan := &Plan{Devices: []*Device{}, Marks: []*Mark{}, Chains: []string{SelectCgroup, SelectInterface}}
+	byDevice := map[string][]*Target{}
+	for _, device := range opts.Devices {
+		byDevice[device] = nil
+	}
+	marks := map[string]uint32{}
+	slots := map[int]string{}
+	for _, target := range targets {
+		if err := target.validate(); err != nil {
+			return nil, err
+		}
+		device := target.EngineParams.NIC
+		if device == "" {
+			device = opts.Device
+		}
+		if device == "" {
+			return nil, errors.Wrapf(types.ErrInvalidTarget, "no device for target %s", target.ID)
+		}
+		if _, ok := marks[target.ID]; ok {
+			return nil, errors.Wrapf(types.ErrInvalidTarget, "duplicated target %s", target.ID)
+		}
+		if id, ok := slots[target.Slot]; ok {
+			return nil, errors.Wrapf(types.ErrInvalidTarget, "slot %d of target %s is taken by %s", target.Slot, target.ID, id)
+		}
+		slots[target.Slot] = target.ID
+		mark := uint32(firstMinor + target.Slot - 1)
+		marks[target.ID] = mark
+		byDevice[device] = append(byDevice[device], target)
+		plan.Marks = append(plan.Marks, &Mark{Target: target.ID, Selector: target.Selector, Match: target.Match, Mark: mark})
+	}
+
+	devices := make([]string, 0, len(byDevice))
+	for device := range byDevice {
+		devices = append(devices, device)
+	}
+	sort.Strings(devices)
+	ifbs := map[string]string{}
+	for _, device := range devices {
+		if other, ok := ifbs[IFBName(device)]; ok {
+			return nil, errors.Wrapf(types.ErrInvalidTarget, "device %s and %s share the ifb device %s", other, device, IFBName(device))
+		}
+		ifbs[IFBName(device)] = device
+	}
+	unit := opts.Unit
+	if unit == 0 {
+		unit = types.BitUnit
+	}
+	for _, device := range devices {
+		capacity := deviceCapacity(opts.Capacity, device)
+		egress := &Device{Name: device}
+		ingress := &Device{Name: IFBName(device), Uplink: device}
+		for _, target := range byDevice[device] {
+			egress.add(target.ID, marks[target.ID], target.EngineParams.Egress, unit)
+			ingress.add(target.ID, marks[target.ID], target.EngineParams.Ingress, unit)
+		}
+		egress.addRoot(capacity.Egress, unit)
+		ingress.addRoot(capacity.Ingress, unit)
+		plan.Devices = append(plan.Devices, egress, ingress)
+	}
+	return plan, nil
+}
+
+// IFBName returns the IFB device which the ingress of device is redirected to,
+// a name too long for an interface keeps the head of device with a hash of it, so devices sharing a head don't share the IFB
+func IFBName(device string) string {
+	name := "ifb-" + device
+	if len(name) <= ifNameSize {
+		return name
+	}
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(device))
+	sum := fmt.Sprintf("%08x", hash.Sum32())
+	return name[:ifNameSize-len(sum)] + sum
+}
+
+func (t *Target) validate() error {
+	switch {
+	case t.ID == "":
+		return errors.Wrap(types.ErrInvalidTarget, "empty id")
+	case t.EngineParams == nil:
+		return errors.Wrapf(types.ErrInvalidTarget, "no engine params for target %s", t.ID)
+	case t.Slot < 1 || t.Slot > maxMinor-firstMinor+1:
+		return errors.Wrapf(types.ErrInvalidTarget, "slot %d of target %s is out of range", t.Slot, t.ID)
+	case t.Selector != SelectCgroup && t.Selector != SelectInterface:
+		return errors.Wrapf(types.ErrInvalidTarget, "unknown selector %q of target %s", t.Selector, t.ID)
+	case t.Match == "":
+		return errors.Wrapf(types.ErrInvalidTarget, "empty match of target %s", t.ID)
+	}
+	return nil
+}
+
+// add adds the class and the filter of a target
+func (d *Device) add(id string, mark uint32, limit types.Limit, unit types.Unit) {
+	rate := unit.BitsPerSecond(limit.Average)
+	if rate < minRate {
+		rate = minRate
+	}
+	ceil := unit.BitsPerSecond(limit.Peak)
+	if ceil < rate {
+		ceil = rate
+	}
+	classID := classID(mark)
+	d.Classes = append(d.Classes, &Class{
+		ClassID: classID,
+		Parent:  rootClassID,
+		Target:  id,
+		Rate:    rate,
+		Ceil:    ceil,
+		Burst:   limit.Burst,
+		Cburst:  limit.Burst,
+	})
+	d.Filters = append(d.Filters, &Filter{Mark: mark, FlowID: classID})
+}
+
+// addRoot puts the root class which the classes of targets borrow from in front,
+// zero capacity means the sum of ceils
+func (d *Device) addRoot(capacity int64, unit types.Unit) {
+	rate := unit.BitsPerSecond(capacity)
+	if rate <= 0 {
+		for _, class := range d.Classes {
+			rate += class.Ceil
+		}
+	}
+	if rate < minRate {
+		rate = minRate
+	}
+	root := &Class{ClassID: rootClassID, Parent: rootHandle, Rate: rate, Ceil: rate}
+	d.Classes = append([]*Class{root}, d.Classes...)
+}
+
+const (
+	rootHandle  = "1:"
+	rootClassID = "1:1"
+)
+
+func classID(minor uint32) string {
+	return fmt.Sprintf("1:%x", minor)
+}
+
+// deviceCapacity returns the capacity of the NIC named device, or the node totals if there is no such NIC
+func deviceCapacity(capacity *types.NodeResource, device string) *types.NodeResource {
+	if capacity == nil {
+		return types.NewNodeResource(0, 0)
+	}
+	if _, ok := capacity.NICs[device]; ok {
+		return capacity.NIC(device)
+	}
+	return capacity.NIC("")
+}
@@ -0,0 +1,154 @@
+package tc
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yuyang0/resource-bandwidth/bandwidth/types"
+)
+
+func newTargets() []*Target {
+	return []*Target{
+		{
+			ID:   "w2",
+			Slot: 2,
+			EngineParams: &types.EngineParams{
+				Ingress: types.Limit{Average: 100, Peak: 200, Burst: 25},
+				Egress:  types.Limit{Average: 0, Peak: 50},
+				NIC:     "eth1",
+			},
+			Selector: SelectInterface,
+			Match:    "veth2",
+		},
+		{
+			ID:   "w1",
+			Slot: 1,
+			EngineParams: &types.EngineParams{
+				Ingress: types.Limit{Average: 10, Peak: 20, Burst: 3},
+				Egress:  types.Limit{Average: 30, Peak: 60, Burst: 8},
+			},
+			Selector: SelectCgroup,
+			Match:    "/workloads/w1",
+		},
+	}
+}
+
+func TestNewPlan(t *testing.T) {
+	capacity := &types.NodeResource{Ingress: 1000, Egress: 1000, NICs: types.NICs{"eth1": {Ingress: 500, Egress: 400}}}
+	plan, err := NewPlan(newTargets(), Options{Device: "eth0", Capacity: capacity})
+	assert.Nil(t, err)
+
+	assert.Equal(t, []*Mark{
+		{Target: "w1", Selector: SelectCgroup, Match: "/workloads/w1", Mark: 0x10},
+		{Target: "w2", Selector: SelectInterface, Match: "veth2", Mark: 0x11},
+	}, plan.Marks)
+	assert.Len(t, plan.Devices, 4)
+
+	eth0 := plan.Devices[0]
+	assert.Equal(t, "eth0", eth0.Name)
+	assert.Equal(t, "", eth0.Uplink)
+	assert.Equal(t, []*Class{
+		{ClassID: "1:1", Parent: "1:", Rate: 1000, Ceil: 1000},
+		{ClassID: "1:10", Parent: "1:1", Target: "w1", Rate: 30, Ceil: 60, Burst: 8, Cburst: 8},
+	}, eth0.Classes)
+	assert.Equal(t, []*Filter{{Mark: 0x10, FlowID: "1:10"}}, eth0.Filters)
+
+	ifb0 := plan.Devices[1]
+	assert.Equal(t, "ifb-eth0", ifb0.Name)
+	assert.Equal(t, "eth0", ifb0.Uplink)
+	assert.Equal(t, int64(10), ifb0.Classes[1].Rate)
+
+	// the NIC capacity bounds its tree, classes without guarantee get the minimal rate
+	eth1 := plan.Devices[2]
+	assert.Equal(t, "eth1", eth1.Name)
+	assert.Equal(t, []*Class{
+		{ClassID: "1:1", Parent: "1:", Rate: 400, Ceil: 400},
+		{ClassID: "1:11", Parent: "1:1", Target: "w2", Rate: minRate, Ceil: 50},
+	}, eth1.Classes)
+	assert.Equal(t, int64(500), plan.Devices[3].Classes[0].Rate)
+
+	// the same targets in any order give the same plan
+	targets := newTargets()
+	targets[0], targets[1] = targets[1], targets[0]
+	plan1, err := NewPlan(targets, Options{Device: "eth0", Capacity: capacity})
+	assert.Nil(t, err)
+	assert.Equal(t, plan, plan1)
+
+	// adding a target doesn't renumber others, even one sorted in front of them
+	targets = append(newTargets(), &Target{
+		ID:           "w0",
+		Slot:         3,
+		EngineParams: &types.EngineParams{Ingress: types.Limit{Average: 1, Peak: 2}, Egress: types.Limit{Average: 1, Peak: 2}},
+		Selector:     SelectCgroup,
+		Match:        "/workloads/w0",
+	})
+	plan1, err = NewPlan(targets, Options{Device: "eth0", Capacity: capacity})
+	assert.Nil(t, err)
+	assert.Equal(t, plan.Marks, plan1.Marks[1:])
+	assert.Equal(t, uint32(0x12), plan1.Marks[0].Mark)
+	assert.Subset(t, plan1.Devices[0].Classes, eth0.Classes)
+	assert.Contains(t, plan1.Devices[0].Filters, &Filter{Mark: 0x12, FlowID: "1:12"})
+	assert.Equal(t, eth1.Classes, plan1.Devices[2].Classes)
+
+	// the sum of ceils without capacity
+	plan, err = NewPlan(newTargets(), Options{Device: "eth0"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(60), plan.Devices[0].Classes[0].Rate)
+
+	// managed devices are in the plan without targets
+	plan, err = NewPlan(nil, Options{Device: "eth0", Devices: []string{"eth0"}})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{SelectCgroup, SelectInterface}, plan.Chains)
+	assert.Len(t, plan.Devices, 2)
+	assert.Equal(t, []*Class{{ClassID: "1:1", Parent: "1:", Rate: minRate, Ceil: minRate}}, plan.Devices[1].Classes)
+	assert.Empty(t, plan.Devices[1].Filters)
+
+	plan, err = NewPlan(newTargets(), Options{Device: "eth0"})
+	assert.Nil(t, err)
+	data, err := json.Marshal(plan)
+	assert.Nil(t, err)
+	plan1 = &Plan{}
+	assert.Nil(t, json.Unmarshal(data, plan1))
+	assert.Equal(t, plan, plan1)
+}
+
+func TestNewPlanInvalid(t *testing.T) {
+	_, err := NewPlan(newTargets(), Options{})
+	assert.ErrorIs(t, err, types.ErrInvalidTarget)
+
+	for _, target := range []*Target{
+		{Slot: 1, EngineParams: &types.EngineParams{}, Selector: SelectCgroup, Match: "/a"},
+		{ID: "w1", Slot: 1, Selector: SelectCgroup, Match: "/a"},
+		{ID: "w1", Slot: 1, EngineParams: &types.EngineParams{}, Selector: "ip", Match: "/a"},
+		{ID: "w1", Slot: 1, EngineParams: &types.EngineParams{}, Selector: SelectCgroup},
+		{ID: "w1", EngineParams: &types.EngineParams{}, Selector: SelectCgroup, Match: "/a"},
+		{ID: "w1", Slot: maxMinor, EngineParams: &types.EngineParams{}, Selector: SelectCgroup, Match: "/a"},
+	} {
+		_, err := NewPlan([]*Target{target}, Options{Device: "eth0"})
+		assert.ErrorIs(t, err, types.ErrInvalidTarget)
+	}
+
+	targets := newTargets()
+	targets[1].ID = "w2"
+	_, err = NewPlan(targets, Options{Device: "eth0"})
+	assert.ErrorIs(t, err, types.ErrInvalidTarget)
+	targets = newTargets()
+	targets[1].Slot = 2
+	_, err = NewPlan(targets, Options{Device: "eth0"})
+	assert.ErrorIs(t, err, types.ErrInvalidTarget)
+
+	// long names are cut with a hash, so devices with the same head get different IFB devices
+	long0, long1 := IFBName("enp59s0f1np0"), IFBName("enp59s0f1np1")
+	assert.Len(t, long1, 15)
+	assert.True(t, strings.HasPrefix(long1, "ifb-enp"))
+	assert.NotEqual(t, long0, long1)
+	assert.Equal(t, "ifb-eth0", IFBName("eth0"))
+	// a device which happens to be named like the cut name of another is refused
+	targets = newTargets()
+	targets[0].EngineParams.NIC = "enp59s0f1np1"
+	targets[1].EngineParams.NIC = strings.TrimPrefix(long1, "ifb-")
+	_, err = NewPlan(targets, Options{Device: "eth0"})
+	assert.ErrorIs(t, err, types.ErrInvalidTarget)
+}
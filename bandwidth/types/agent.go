@@ -0,0 +1,55 @@
+package types
+
+import (
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+// selectors of packets of a workload, they are the selectors of the tc package
+const (
+	// SelectCgroup matches packets sent by processes in the cgroup v2 of the workload
+	SelectCgroup = "cgroup"
+	// SelectInterface matches packets from the host side interface of the workload
+	SelectInterface = "interface"
+)
+
+// AgentIDPlaceholder is replaced by the allocation ID of a workload in AgentConfig.Match
+const AgentIDPlaceholder = "{id}"
+
+// AgentConfig is the config of `agent`, which enforces the allocations of a node with tc,
+// it only applies what differs from the kernel and reports what still differs as diffs of the node
+type AgentConfig struct {
+	// Device is the uplink of workloads whose engine params have no NIC
+	Device string `yaml:"device" json:"device" default:"eth0"`
+	// Devices are shaped like Device even without workloads, so the tree of the last workload released is cleared
+	Devices []string `yaml:"devices" json:"devices"`
+	// Selector is how packets of a workload are recognized, cgroup or interface
+	Selector string `yaml:"selector" json:"selector" default:"cgroup"`
+	// Match is the cgroup path or the interface of a workload, {id} is its allocation ID,
+	// which engines get as the id of engine params
+	Match string `yaml:"match" json:"match" default:"/resource-bandwidth/{id}"`
+	// Interval is the time between reconciliations
+	Interval time.Duration `yaml:"interval" json:"interval" default:"30s"`
+}
+
+// Validate .
+func (c *AgentConfig) Validate() error {
+	switch {
+	case c.Device == "":
+		return errors.Wrap(ErrInvalidConfig, "agent device is empty")
+	case c.Selector != SelectCgroup && c.Selector != SelectInterface:
+		return errors.Wrapf(ErrInvalidConfig, "unknown agent selector %q", c.Selector)
+	case !strings.Contains(c.Match, AgentIDPlaceholder):
+		return errors.Wrapf(ErrInvalidConfig, "agent match %q has no %s", c.Match, AgentIDPlaceholder)
+	case c.Interval <= 0:
+		return errors.Wrapf(ErrInvalidConfig, "agent interval must be positive, got %s", c.Interval)
+	}
+	return nil
+}
+
+// MatchOf returns the match of the workload with allocation ID id
+func (c *AgentConfig) MatchOf(id string) string {
+	return strings.ReplaceAll(c.Match, AgentIDPlaceholder, id)
+}
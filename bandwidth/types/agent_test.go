@@ -0,0 +1,26 @@
+package types
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAgentConfig(t *testing.T) {
+	c := &AgentConfig{Device: "eth0", Selector: SelectInterface, Match: "veth-{id}", Interval: time.Second}
+	assert.Nil(t, c.Validate())
+	assert.Equal(t, "veth-abc", c.MatchOf("abc"))
+
+	c.Interval = 0
+	assert.ErrorIs(t, c.Validate(), ErrInvalidConfig)
+	c.Interval = time.Second
+	c.Match = "veth0"
+	assert.ErrorIs(t, c.Validate(), ErrInvalidConfig)
+	c.Match = "/sys/fs/cgroup/{id}"
+	c.Selector = "ebpf"
+	assert.ErrorIs(t, c.Validate(), ErrInvalidConfig)
+	c.Selector = SelectCgroup
+	c.Device = ""
+	assert.ErrorIs(t, c.Validate(), ErrInvalidConfig)
+}
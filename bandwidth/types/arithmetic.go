@@ -0,0 +1,52 @@
+package types
+
+import (
+	"fmt"
+	"math"
+)
+
+// RangeError is a bandwidth which went out of [0, MaxInt64] in checked arithmetic
+type RangeError struct {
+	// Node is filled by the plugin, empty when the node is unknown
+	Node string
+	// Field is the path of the bandwidth, like "ingress" or "nics.eth0.egress"
+	Field string
+	Value int64
+	Delta int64
+	// Err is ErrBandwidthUnderflow or ErrBandwidthOverflow
+	Err error
+}
+
+func (e *RangeError) Error() string {
+	return fmt.Sprintf("node %q %s %d%+d: %s", e.Node, e.Field, e.Value, e.Delta, e.Err)
+}
+
+func (e *RangeError) Unwrap() error {
+	return e.Err
+}
+
+// checkedAdd adds delta to value, the result is clamped to [0, MaxInt64] and the clamping is returned
+func checkedAdd(field string, value *int64, delta int64) *RangeError {
+	origin := *value
+	switch {
+	case delta > 0 && origin > math.MaxInt64-delta:
+		*value = math.MaxInt64
+		return &RangeError{Field: field, Value: origin, Delta: delta, Err: ErrBandwidthOverflow}
+	case delta < 0 && origin < math.MinInt64-delta, origin+delta < 0:
+		*value = 0
+		return &RangeError{Field: field, Value: origin, Delta: delta, Err: ErrBandwidthUnderflow}
+	}
+	*value = origin + delta
+	return nil
+}
+
+// rangeErrors collects the non nil results of checkedAdd
+func rangeErrors(results ...*RangeError) []*RangeError {
+	var res []*RangeError
+	for _, result := range results {
+		if result != nil {
+			res = append(res, result)
+		}
+	}
+	return res
+}
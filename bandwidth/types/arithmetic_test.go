@@ -0,0 +1,48 @@
+package types
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckedArithmetic(t *testing.T) {
+	r := NewNodeResource(10, 10)
+	assert.Empty(t, r.SubChecked(NewNodeResource(5, 10)))
+	assert.Equal(t, NewNodeResource(5, 0), r)
+
+	rangeErrs := r.SubChecked(NewNodeResource(10, 1))
+	assert.Len(t, rangeErrs, 2)
+	assert.ErrorIs(t, rangeErrs[0], ErrBandwidthUnderflow)
+	assert.Equal(t, "ingress", rangeErrs[0].Field)
+	assert.Equal(t, int64(5), rangeErrs[0].Value)
+	assert.Equal(t, int64(-10), rangeErrs[0].Delta)
+	assert.Equal(t, NewNodeResource(0, 0), r)
+
+	r = NewNodeResource(math.MaxInt64-1, 1)
+	rangeErrs = r.AddChecked(NewNodeResource(2, 2))
+	assert.Len(t, rangeErrs, 1)
+	assert.ErrorIs(t, rangeErrs[0], ErrBandwidthOverflow)
+	assert.Equal(t, NewNodeResource(math.MaxInt64, 3), r)
+
+	r = &NodeResource{NICs: NICs{"eth0": {Ingress: 10, Egress: 10}}}
+	rangeErrs = r.SubChecked(&NodeResource{NICs: NICs{"eth0": {Ingress: 20}}})
+	assert.Len(t, rangeErrs, 1)
+	assert.Equal(t, "nics.eth0.ingress", rangeErrs[0].Field)
+	assert.Equal(t, &NIC{Ingress: 0, Egress: 10}, r.NICs["eth0"])
+
+	w := &WorkloadResource{Ingress: 10, Egress: 10, IngressPeak: 20}
+	rangeErrs = w.SubChecked(&WorkloadResource{Ingress: 10, Egress: 10, IngressPeak: 30})
+	assert.Len(t, rangeErrs, 1)
+	assert.Equal(t, "ingress_peak", rangeErrs[0].Field)
+	assert.True(t, w.IsZero())
+
+	ep := &EngineParams{Ingress: Limit{Average: 10, Peak: 20, Burst: 30}}
+	rangeErrs = ep.SubChecked(&EngineParams{Ingress: Limit{Average: 10, Peak: 30}, Egress: Limit{Burst: 1}})
+	assert.Len(t, rangeErrs, 2)
+	assert.Equal(t, &EngineParams{Ingress: Limit{Burst: 30}}, ep)
+
+	rangeErrs[0].Node = "node1"
+	assert.EqualError(t, rangeErrs[0], `node "node1" ingress.peak 20-30: bandwidth underflow`)
+}
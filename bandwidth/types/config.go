@@ -0,0 +1,208 @@
+package types
+
+import (
+	"github.com/cockroachdb/errors"
+	"github.com/jinzhu/configor"
+)
+
+// Config is the bandwidth plugin specific config,
+// it's read from the `bandwidth` section of the config file, `check-config` prints it with defaults filled
+type Config struct {
+	// CapacityCheck rejects allocations which push usage beyond capacity * OvercommitRatio,
+	// deploy capacity reported to core is from capacity * OvercommitRatio either way
+	CapacityCheck   bool    `yaml:"capacity_check" json:"capacity_check"`
+	OvercommitRatio float64 `yaml:"overcommit_ratio" json:"overcommit_ratio" default:"1"`
+	// Unit is the canonical unit of all bandwidth values, strings like "100Mbps" are normalized to it,
+	// nodes kept in another unit are converted on read and rewritten in this one by their next update
+	Unit string `yaml:"unit" json:"unit" default:"bps"`
+	// Reserved is the default bandwidth kept for the system on nodes added without one, like "100Mbps" or "5%",
+	// workloads can't be scheduled on it
+	Reserved string `yaml:"reserved" json:"reserved"`
+	// Peak is the policy of peak and burst in engine params
+	Peak PeakConfig `yaml:"peak" json:"peak"`
+	// OperationWindow is how many applied operation IDs are remembered per node to turn replays into no-ops
+	OperationWindow int `yaml:"operation_window" json:"operation_window" default:"128"`
+	// Arithmetic is what happens when an update takes a bandwidth below 0 or beyond int64, clamp or error
+	Arithmetic string `yaml:"arithmetic" json:"arithmetic" default:"clamp"`
+	// Placement is spread or binpack, it drives capacity reports and the most idle node
+	Placement string `yaml:"placement" json:"placement" default:"spread"`
+	// Idle decides the most idle node
+	Idle IdleConfig `yaml:"idle" json:"idle"`
+	// Render is the form of engine params by engine
+	Render RenderConfig `yaml:"render" json:"render"`
+	// Weight is the weight of nodes in capacity reports
+	Weight WeightConfig `yaml:"weight" json:"weight"`
+	// Domains are bandwidth domains shared by nodes, like rack and row uplinks,
+	// deploys are checked against every domain enclosing the node, usage of a domain is the sum of its nodes
+	Domains []DomainConfig `yaml:"domains" json:"domains"`
+	// Store is the storage backend of node resource info
+	Store StoreConfig `yaml:"store" json:"store"`
+	// Agent is the node agent which enforces allocations
+	Agent AgentConfig `yaml:"agent" json:"agent"`
+	// Daemon is the address of the daemon, like unix:///run/resource-bandwidth.sock or 127.0.0.1:7890,
+	// `serve` listens on it and other commands forward to it when it's running
+	Daemon string `yaml:"daemon" json:"daemon"`
+}
+
+// storage backends
+const (
+	// StoreETCD keeps data in the etcd cluster of the core config
+	StoreETCD = "etcd"
+	// StoreMemory keeps data in memory, it's gone when the process exits, for dry runs
+	StoreMemory = "memory"
+	// StoreBolt keeps data in a single bbolt file, for single host deployments, processes wait for its file lock
+	StoreBolt = "bolt"
+)
+
+// StoreConfig .
+type StoreConfig struct {
+	Type string `yaml:"type" json:"type" default:"etcd"`
+	// Path is the file of the bolt store
+	Path string `yaml:"path" json:"path"`
+}
+
+// Validate .
+func (c *StoreConfig) Validate() error {
+	switch c.Type {
+	case StoreETCD, StoreMemory:
+	case StoreBolt:
+		if c.Path == "" {
+			return errors.Wrap(ErrInvalidConfig, "bolt store needs a path")
+		}
+	default:
+		return errors.Wrapf(ErrInvalidConfig, "unknown store type %q", c.Type)
+	}
+	return nil
+}
+
+// arithmetic policies
+const (
+	// ArithmeticClamp clamps the bandwidth to the range and logs a warning
+	ArithmeticClamp = "clamp"
+	// ArithmeticError fails the update with a RangeError
+	ArithmeticError = "error"
+)
+
+// placement policies
+const (
+	// PlacementSpread prefers the least used nodes
+	PlacementSpread = "spread"
+	// PlacementBinpack prefers the most used nodes which still fit, to keep others empty
+	PlacementBinpack = "binpack"
+)
+
+// strategies of the most idle node
+const (
+	// IdleRatio picks the lowest usage to capacity ratio
+	IdleRatio = "ratio"
+	// IdleFree picks the most free bandwidth in the busier direction
+	IdleFree = "free"
+	// IdleAfterRequest picks the lowest ratio after adding the incoming request, it's IdleRatio without one
+	IdleAfterRequest = "after-request"
+	// IdlePeakHeadroom picks the lowest ratio of the peaks workloads may burst to
+	IdlePeakHeadroom = "peak-headroom"
+)
+
+// IdleConfig .
+type IdleConfig struct {
+	// Strategy scores nodes, lower is more idle and ties go to the first node name
+	Strategy string `yaml:"strategy" json:"strategy" default:"ratio"`
+	// Priority is returned with the most idle node, core compares it with other resource plugins
+	Priority int `yaml:"priority" json:"priority" default:"100"`
+}
+
+// Validate .
+func (c *IdleConfig) Validate() error {
+	switch c.Strategy {
+	case IdleRatio, IdleFree, IdleAfterRequest, IdlePeakHeadroom:
+		return nil
+	default:
+		return errors.Wrapf(ErrInvalidConfig, "unknown idle strategy %q", c.Strategy)
+	}
+}
+
+// WeightConfig decides the weight core uses to average the usage of bandwidth with other resources of a node
+type WeightConfig struct {
+	// Base is the bandwidth of a node weighing 1, bigger nodes weigh proportionally more
+	Base string `yaml:"base" json:"base" default:"1Gbps"`
+	// Nodes are fixed weights of nodes, they take precedence over the weight from capacity
+	Nodes map[string]float64 `yaml:"nodes" json:"nodes"`
+}
+
+// Validate .
+func (c *WeightConfig) Validate(unit Unit) error {
+	if base, err := unit.ParseBandwidth(c.Base); err != nil || base <= 0 {
+		return errors.Wrapf(ErrInvalidConfig, "weight base must be a positive bandwidth, got %q", c.Base)
+	}
+	for node, weight := range c.Nodes {
+		if weight <= 0 {
+			return errors.Wrapf(ErrInvalidConfig, "weight of node %s must be positive, got %v", node, weight)
+		}
+	}
+	return nil
+}
+
+type configFile struct {
+	Bandwidth Config `yaml:"bandwidth"`
+}
+
+// DefaultConfig returns config with all default values, it panics if the defaults can't be loaded
+func DefaultConfig() *Config {
+	cfg, err := LoadConfig()
+	if err != nil {
+		panic(err)
+	}
+	return cfg
+}
+
+// LoadConfig loads plugin config from files, missing fields are set to default values
+func LoadConfig(paths ...string) (*Config, error) {
+	f := &configFile{}
+	if err := configor.New(&configor.Config{Silent: true}).Load(f, paths...); err != nil {
+		return nil, err
+	}
+	return &f.Bandwidth, nil
+}
+
+// Validate returns the first problem of the config
+func (c *Config) Validate() error {
+	if errs := c.Check(); len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// Check returns all problems of the config
+func (c *Config) Check() []error {
+	var errs []error
+	if c.OvercommitRatio <= 0 {
+		errs = append(errs, errors.Wrapf(ErrInvalidConfig, "overcommit_ratio must be positive, got %v", c.OvercommitRatio))
+	}
+	unit, err := ParseUnit(c.Unit)
+	if err != nil {
+		errs = append(errs, errors.Wrapf(ErrInvalidConfig, "invalid unit %q", c.Unit))
+		unit = BitUnit
+	}
+	if _, err := NewReserved(c.Reserved, unit); c.Reserved != "" && err != nil {
+		errs = append(errs, errors.Wrapf(ErrInvalidConfig, "invalid reserved %q", c.Reserved))
+	}
+	if c.OperationWindow <= 0 {
+		errs = append(errs, errors.Wrapf(ErrInvalidConfig, "operation_window must be positive, got %d", c.OperationWindow))
+	}
+	if c.Arithmetic != ArithmeticClamp && c.Arithmetic != ArithmeticError {
+		errs = append(errs, errors.Wrapf(ErrInvalidConfig, "unknown arithmetic %q", c.Arithmetic))
+	}
+	if c.Placement != PlacementSpread && c.Placement != PlacementBinpack {
+		errs = append(errs, errors.Wrapf(ErrInvalidConfig, "unknown placement %q", c.Placement))
+	}
+	validateWeight := func() error { return c.Weight.Validate(unit) }
+	for _, validate := range []func() error{c.Peak.Validate, c.Idle.Validate, c.Render.Validate, validateWeight, c.Store.Validate, c.Agent.Validate} {
+		if err := validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if _, err := NewDomains(c.Domains, unit); err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
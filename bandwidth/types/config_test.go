@@ -0,0 +1,101 @@
+package types
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadConfig(t *testing.T) {
+	cfg := DefaultConfig()
+	assert.False(t, cfg.CapacityCheck)
+	assert.Equal(t, float64(1), cfg.OvercommitRatio)
+	assert.Equal(t, DefaultUnit, cfg.Unit)
+	assert.Equal(t, PeakConfig{Mode: PeakRatio, Ratio: 2, BurstSeconds: 0.1}, cfg.Peak)
+	assert.Equal(t, StoreConfig{Type: StoreETCD}, cfg.Store)
+	assert.Equal(t, 128, cfg.OperationWindow)
+	assert.Equal(t, WeightConfig{Base: "1Gbps"}, cfg.Weight)
+	assert.Equal(t, IdleConfig{Strategy: IdleRatio, Priority: 100}, cfg.Idle)
+	assert.Equal(t, PlacementSpread, cfg.Placement)
+	assert.Equal(t, AgentConfig{Device: "eth0", Selector: SelectCgroup, Match: "/resource-bandwidth/{id}", Interval: 30 * time.Second}, cfg.Agent)
+	assert.Nil(t, cfg.Validate())
+
+	content := `
+etcd:
+    machines:
+        - http://127.0.0.1:2379
+bandwidth:
+    capacity_check: true
+    overcommit_ratio: 1.5
+    unit: Mbps
+    peak:
+        mode: free
+        ratio: 3
+    store:
+        type: bolt
+        path: /var/lib/eru-bandwidth/bandwidth.db
+`
+	path := filepath.Join(t.TempDir(), "bandwidth.yaml")
+	assert.Nil(t, os.WriteFile(path, []byte(content), 0600))
+	cfg, err := LoadConfig(path)
+	assert.Nil(t, err)
+	assert.True(t, cfg.CapacityCheck)
+	assert.Equal(t, 1.5, cfg.OvercommitRatio)
+	assert.Equal(t, "Mbps", cfg.Unit)
+	assert.Equal(t, PeakConfig{Mode: PeakFree, Ratio: 3, BurstSeconds: 0.1}, cfg.Peak)
+	assert.Equal(t, StoreConfig{Type: StoreBolt, Path: "/var/lib/eru-bandwidth/bandwidth.db"}, cfg.Store)
+	assert.Nil(t, cfg.Validate())
+
+	cfg.Store.Path = ""
+	assert.ErrorIs(t, cfg.Validate(), ErrInvalidConfig)
+	cfg.Store = StoreConfig{Type: "zk"}
+	assert.ErrorIs(t, cfg.Validate(), ErrInvalidConfig)
+	cfg.Store = StoreConfig{Type: StoreMemory}
+	assert.Nil(t, cfg.Validate())
+	cfg.OperationWindow = -1
+	assert.ErrorIs(t, cfg.Validate(), ErrInvalidConfig)
+	cfg.OperationWindow = 1
+	cfg.Weight = WeightConfig{Base: "0"}
+	assert.ErrorIs(t, cfg.Validate(), ErrInvalidConfig)
+	cfg.Weight = WeightConfig{Base: "10Gbps", Nodes: map[string]float64{"node1": 0}}
+	assert.ErrorIs(t, cfg.Validate(), ErrInvalidConfig)
+	cfg.Weight.Nodes["node1"] = 2
+	assert.Nil(t, cfg.Validate())
+	cfg.Idle.Strategy = "random"
+	assert.ErrorIs(t, cfg.Validate(), ErrInvalidConfig)
+	cfg.Idle.Strategy = IdlePeakHeadroom
+	assert.Nil(t, cfg.Validate())
+	cfg.Placement = "random"
+	assert.ErrorIs(t, cfg.Validate(), ErrInvalidConfig)
+	cfg.Placement = PlacementBinpack
+	assert.Nil(t, cfg.Validate())
+
+	cfg.Unit = "Mxx"
+	assert.ErrorIs(t, cfg.Validate(), ErrInvalidConfig)
+
+	cfg.Unit = ""
+	cfg.OvercommitRatio = 0
+	assert.ErrorIs(t, cfg.Validate(), ErrInvalidConfig)
+}
+
+func TestConfigCheck(t *testing.T) {
+	cfg := DefaultConfig()
+	assert.Empty(t, cfg.Check())
+
+	cfg.Unit = "Mxx"
+	cfg.Placement = "random"
+	cfg.Store = StoreConfig{Type: StoreBolt}
+	cfg.Domains = []DomainConfig{{Name: "rack1"}}
+	cfg.Reserved = "10x"
+	cfg.Arithmetic = "wrap"
+	cfg.Agent.Selector = "ebpf"
+	errs := cfg.Check()
+	assert.Len(t, errs, 7)
+	for _, err := range errs {
+		assert.ErrorIs(t, err, ErrInvalidConfig)
+	}
+	assert.EqualError(t, cfg.Validate(), errs[0].Error())
+}
@@ -0,0 +1,98 @@
+package types
+
+import (
+	"encoding/json"
+	"math"
+)
+
+// Convert converts the bandwidths of the resource info kept in unit from to unit to,
+// bursts are in bytes and percentages of the reservation are relative, so they are kept
+func (n *NodeResourceInfo) Convert(from, to Unit) {
+	scale := scaler(from, to)
+	n.Capacity.convert(scale)
+	n.Usage.convert(scale)
+	for _, allocation := range n.Workloads {
+		allocation.Resource.convert(scale)
+		allocation.EngineParams.convert(scale)
+	}
+	for _, hold := range n.Holds {
+		hold.Resource.convert(scale)
+	}
+	for _, issue := range n.Issues {
+		issue.EngineParams.convert(scale)
+	}
+	for _, op := range n.Operations {
+		op.Before.convert(scale)
+		op.After.convert(scale)
+	}
+	if len(n.Reserved) > 0 {
+		n.Reserved = walkBandwidth(n.Reserved, nil, func(value any, _ func() int64) any {
+			return convertNumber(value, scale)
+		})
+	}
+}
+
+// Convert converts the pending bandwidths of the domain record kept in unit from to unit to
+func (d *DomainRecord) Convert(from, to Unit) {
+	scale := scaler(from, to)
+	for _, pending := range d.Pending {
+		pending.Resource.convert(scale)
+	}
+}
+
+// scaler returns the conversion of bandwidths in unit from to unit to, values beyond int64 are clamped
+func scaler(from, to Unit) func(int64) int64 {
+	return func(v int64) int64 {
+		f := math.Round(float64(v) * float64(from) / float64(to))
+		switch {
+		case f >= math.MaxInt64:
+			return math.MaxInt64
+		case f <= math.MinInt64:
+			return math.MinInt64
+		}
+		return int64(f)
+	}
+}
+
+func (r *NodeResource) convert(scale func(int64) int64) {
+	if r == nil {
+		return
+	}
+	r.Ingress, r.Egress = scale(r.Ingress), scale(r.Egress)
+	for _, nic := range r.NICs {
+		nic.Ingress, nic.Egress = scale(nic.Ingress), scale(nic.Egress)
+	}
+}
+
+func (w *WorkloadResource) convert(scale func(int64) int64) {
+	w.Ingress, w.Egress = scale(w.Ingress), scale(w.Egress)
+	w.IngressPeak, w.EgressPeak = scale(w.IngressPeak), scale(w.EgressPeak)
+}
+
+func (ep *EngineParams) convert(scale func(int64) int64) {
+	if ep == nil {
+		return
+	}
+	ep.Ingress.Average, ep.Ingress.Peak = scale(ep.Ingress.Average), scale(ep.Ingress.Peak)
+	ep.Egress.Average, ep.Egress.Peak = scale(ep.Egress.Average), scale(ep.Egress.Peak)
+}
+
+// convertNumber scales a number of raw params, anything else like a percentage is kept
+func convertNumber(value any, scale func(int64) int64) any {
+	switch v := value.(type) {
+	case float64:
+		return scale(int64(math.Round(v)))
+	case int64:
+		return scale(v)
+	case int:
+		return scale(int64(v))
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return scale(n)
+		}
+		if f, err := v.Float64(); err == nil {
+			return scale(int64(math.Round(f)))
+		}
+	}
+	return value
+}
@@ -0,0 +1,44 @@
+package types
+
+import (
+	"testing"
+
+	resourcetypes "github.com/projecteru2/core/resource/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNodeResourceInfoConvert(t *testing.T) {
+	mbps, err := ParseUnit("Mbps")
+	assert.Nil(t, err)
+	kbps, err := ParseUnit("Kbps")
+	assert.Nil(t, err)
+
+	info := &NodeResourceInfo{
+		Capacity: &NodeResource{Ingress: 100, Egress: 50, NICs: NICs{"eth0": {Ingress: 100, Egress: 50}}},
+		Usage:    NewNodeResource(10, 5),
+		Workloads: Ledger{"a": {
+			ID:           "a",
+			Resource:     &WorkloadResource{ID: "a", Ingress: 10, Egress: 5, IngressPeak: 20},
+			EngineParams: &EngineParams{Ingress: Limit{Average: 10, Peak: 20, Burst: 30}},
+		}},
+		Holds:      Holds{"b": {ID: "b", Resource: &WorkloadResource{ID: "b", Ingress: 1}}},
+		Issues:     Issues{"b": {EngineParams: &EngineParams{Egress: Limit{Peak: 2}}}},
+		Operations: Operations{{ID: "op", Before: NewNodeResource(0, 0), After: NewNodeResource(10, 5)}},
+		Reserved:   resourcetypes.RawParams{"ingress": float64(1), "egress": "10%"},
+	}
+	info.Convert(mbps, kbps)
+	assert.Equal(t, &NodeResource{Ingress: 100000, Egress: 50000, NICs: NICs{"eth0": {Ingress: 100000, Egress: 50000}}}, info.Capacity)
+	assert.Equal(t, NewNodeResource(10000, 5000), info.Usage)
+	assert.Equal(t, &WorkloadResource{ID: "a", Ingress: 10000, Egress: 5000, IngressPeak: 20000}, info.Workloads["a"].Resource)
+	// bursts are in bytes
+	assert.Equal(t, Limit{Average: 10000, Peak: 20000, Burst: 30}, info.Workloads["a"].EngineParams.Ingress)
+	assert.Equal(t, int64(1000), info.Holds["b"].Resource.Ingress)
+	assert.Equal(t, int64(2000), info.Issues["b"].EngineParams.Egress.Peak)
+	assert.Equal(t, NewNodeResource(10000, 5000), info.Operations[0].After)
+	assert.Equal(t, resourcetypes.RawParams{"ingress": int64(1000), "egress": "10%"}, info.Reserved)
+
+	// back to the unit it was in
+	info.Convert(kbps, mbps)
+	assert.Equal(t, NewNodeResource(10, 5), info.Usage)
+	assert.Equal(t, NewNodeResource(1, 5), info.ReservedResource())
+}
@@ -0,0 +1,132 @@
+package types
+
+import (
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/mitchellh/mapstructure"
+	resourcetypes "github.com/projecteru2/core/resource/types"
+)
+
+// symmetricKeys are the keys which set the same value for both directions,
+// records written before ingress and egress were split only have these keys
+var symmetricKeys = map[string][]string{
+	"bandwidth": {"ingress", "egress"},
+	"peak":      {"ingress_peak", "egress_peak"},
+}
+
+// decode decodes rawParams into out, symmetric keys are expanded unless the direction is set explicitly,
+// bandwidth strings are normalized to unit
+func decode(rawParams resourcetypes.RawParams, out any, unit Unit) error {
+	params := walkBandwidth(rawParams, nil, func(value any, _ func() int64) any { return value })
+	var hookErr error
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: func(from reflect.Type, to reflect.Type, data any) (res any, err error) {
+			if to == durationType {
+				res, err = durationHook(from, data)
+			} else {
+				res, err = unit.bandwidthHook(from, to, data)
+			}
+			if hookErr == nil {
+				hookErr = err
+			}
+			return res, err
+		},
+		Result: out,
+	})
+	if err != nil {
+		return err
+	}
+	if err = decoder.Decode(params); err != nil && hookErr != nil {
+		// mapstructure flattens errors into strings
+		return errors.Wrapf(hookErr, "%v", err)
+	}
+	return err
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// durationHook decodes durations like "30s", plain numbers are seconds
+func durationHook(from reflect.Type, data any) (any, error) {
+	value := reflect.ValueOf(data)
+	switch from.Kind() {
+	case reflect.String:
+		s := value.String()
+		if n, err := strconv.ParseFloat(s, 64); err == nil {
+			return time.Duration(n * float64(time.Second)), nil
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return nil, errors.Wrapf(ErrInvalidDuration, "%q", s)
+		}
+		return d, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return time.Duration(value.Int()) * time.Second, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return time.Duration(value.Uint()) * time.Second, nil
+	case reflect.Float32, reflect.Float64:
+		return time.Duration(value.Float() * float64(time.Second)), nil
+	default:
+		return data, nil
+	}
+}
+
+// decodeRelative is decode which accepts percentages, they are decoded as 0 and returned for resolving later,
+// the returned params hold numbers in unit besides the percentages
+func decodeRelative(rawParams resourcetypes.RawParams, out any, unit Unit) (resourcetypes.RawParams, error) {
+	if !hasPercents(rawParams) {
+		return nil, decode(rawParams, out, unit)
+	}
+	relative, err := unit.normalize(rawParams)
+	if err != nil {
+		return nil, err
+	}
+	params, err := resolvePercents(relative, nil)
+	if err != nil {
+		return nil, err
+	}
+	return relative, decode(params, out, unit)
+}
+
+func expandSymmetricKeys(rawParams resourcetypes.RawParams) resourcetypes.RawParams {
+	params := resourcetypes.RawParams{}
+	for key, value := range rawParams {
+		params[key] = value
+	}
+	for key, directions := range symmetricKeys {
+		if !params.IsSet(key) {
+			continue
+		}
+		for _, direction := range directions {
+			if !params.IsSet(direction) {
+				params[direction] = params[key]
+			}
+		}
+		delete(params, key)
+	}
+	return params
+}
+
+// isSet returns true if key or a symmetric key covering it is set
+func isSet(rawParams resourcetypes.RawParams, key string) bool {
+	if rawParams.IsSet(key) {
+		return true
+	}
+	for symmetricKey, directions := range symmetricKeys {
+		for _, direction := range directions {
+			if direction == key && rawParams.IsSet(symmetricKey) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func ratio(a, b int64) float64 {
+	if b == 0 {
+		return 0
+	}
+	return float64(a) / float64(b)
+}
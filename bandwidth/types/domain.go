@@ -0,0 +1,164 @@
+package types
+
+import (
+	"sort"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	resourcetypes "github.com/projecteru2/core/resource/types"
+)
+
+// DomainConfig describes a bandwidth domain shared by nodes, like the uplink of a rack or a row
+type DomainConfig struct {
+	Name string `yaml:"name" json:"name"`
+	// Level is a free form label of the domain, like rack or row
+	Level string `yaml:"level" json:"level"`
+	// Parent is the name of the enclosing domain, empty for the top level
+	Parent string `yaml:"parent" json:"parent"`
+	// Capacity is the bandwidth of the domain, like {bandwidth: 10Gbps} or {ingress: 10Gbps, egress: 40Gbps}
+	Capacity resourcetypes.RawParams `yaml:"capacity" json:"capacity"`
+	// Nodes are the nodes directly in the domain
+	Nodes []string `yaml:"nodes" json:"nodes"`
+}
+
+// Domain is a parsed DomainConfig
+type Domain struct {
+	Name     string
+	Level    string
+	Parent   string
+	Capacity *NodeResource
+	Nodes    []string
+}
+
+// Allocatable returns the resource still allocatable in the domain when capacity is overcommitted by ratio
+func (d *Domain) Allocatable(usage *NodeResource, overcommitRatio float64) *NodeResource {
+	return allocatable(d.Capacity, usage.NIC(""), overcommitRatio)
+}
+
+// Domains are bandwidth domains by name
+type Domains map[string]*Domain
+
+// NewDomains parses and checks configs into unit, every node is in at most one domain and parents form a tree
+func NewDomains(configs []DomainConfig, unit Unit) (Domains, error) {
+	domains := Domains{}
+	nodes := map[string]string{}
+	for _, config := range configs {
+		if config.Name == "" {
+			return nil, errors.Wrap(ErrInvalidConfig, "domain needs a name")
+		}
+		if _, ok := domains[config.Name]; ok {
+			return nil, errors.Wrapf(ErrInvalidConfig, "duplicated domain %s", config.Name)
+		}
+		capacity := &NodeResource{}
+		if err := capacity.Parse(config.Capacity, unit); err != nil {
+			return nil, errors.Wrapf(ErrInvalidConfig, "capacity of domain %s: %v", config.Name, err)
+		}
+		if capacity.IsRelative() || capacity.IsZero() || capacity.Validate() != nil || len(capacity.NICs) > 0 {
+			return nil, errors.Wrapf(ErrInvalidConfig, "capacity of domain %s must be ingress and egress bandwidth", config.Name)
+		}
+		for _, node := range config.Nodes {
+			if domain, ok := nodes[node]; ok {
+				return nil, errors.Wrapf(ErrInvalidConfig, "node %s is in both domain %s and %s", node, domain, config.Name)
+			}
+			nodes[node] = config.Name
+		}
+		domains[config.Name] = &Domain{
+			Name:     config.Name,
+			Level:    config.Level,
+			Parent:   config.Parent,
+			Capacity: capacity,
+			Nodes:    append([]string{}, config.Nodes...),
+		}
+	}
+	for _, domain := range domains {
+		seen := map[string]bool{}
+		for d := domain; d.Parent != ""; d = domains[d.Parent] {
+			if seen[d.Name] {
+				return nil, errors.Wrapf(ErrInvalidConfig, "domain %s is in a loop", domain.Name)
+			}
+			seen[d.Name] = true
+			if _, ok := domains[d.Parent]; !ok {
+				return nil, errors.Wrapf(ErrInvalidConfig, "parent %s of domain %s doesn't exist", d.Parent, d.Name)
+			}
+		}
+	}
+	return domains, nil
+}
+
+// Names returns sorted names
+func (d Domains) Names() []string {
+	names := make([]string, 0, len(d))
+	for name := range d {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Path returns the domains enclosing the node, innermost first
+func (d Domains) Path(nodename string) []*Domain {
+	var path []*Domain
+	for _, domain := range d {
+		for _, node := range domain.Nodes {
+			if node != nodename {
+				continue
+			}
+			for ; domain != nil; domain = d[domain.Parent] {
+				path = append(path, domain)
+			}
+			return path
+		}
+	}
+	return path
+}
+
+// Nodes returns sorted nodes in the domain and all domains under it
+func (d Domains) Nodes(name string) []string {
+	var nodes []string
+	for _, domain := range d {
+		for p := domain; p != nil; p = d[p.Parent] {
+			if p.Name == name {
+				nodes = append(nodes, domain.Nodes...)
+				break
+			}
+		}
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// DomainPending is the bandwidth calculated on a node of a domain for a workload which is not committed yet
+type DomainPending struct {
+	Node      string        `json:"node"`
+	Resource  *NodeResource `json:"resource"`
+	ExpiresAt time.Time     `json:"expires_at"`
+}
+
+// DomainRecord is kept per domain and updated by compare-and-swap, so calculations on different nodes
+// of the domain see the bandwidth pending on each other
+type DomainRecord struct {
+	// Pending is by the operation ID of the workload resource
+	Pending map[string]*DomainPending `json:"pending,omitempty"`
+	// Unit is the unit the bandwidths are kept in
+	Unit string `json:"unit,omitempty"`
+}
+
+// PendingResource sums up the pending bandwidth which is not expired at now, except the operations in skip
+func (d *DomainRecord) PendingResource(now time.Time, skip map[string]bool) *NodeResource {
+	res := NewNodeResource(0, 0)
+	for id, pending := range d.Pending {
+		if !skip[id] && now.Before(pending.ExpiresAt) {
+			res.Add(pending.Resource)
+		}
+	}
+	return res
+}
+
+// Prune removes expired pending bandwidth
+func (d *DomainRecord) Prune(now time.Time) {
+	for id, pending := range d.Pending {
+		if !now.Before(pending.ExpiresAt) {
+			delete(d.Pending, id)
+		}
+	}
+}
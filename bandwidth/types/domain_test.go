@@ -0,0 +1,72 @@
+package types
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewDomains(t *testing.T) {
+	content := `
+bandwidth:
+    unit: Mbps
+    domains:
+        - name: rack1
+          level: rack
+          parent: row1
+          capacity:
+              bandwidth: 10Gbps
+          nodes: [node1, node2]
+        - name: rack2
+          level: rack
+          parent: row1
+          capacity:
+              ingress: 10000
+              egress: 20Gbps
+          nodes: [node3]
+        - name: row1
+          level: row
+          capacity:
+              bandwidth: 40Gbps
+`
+	path := filepath.Join(t.TempDir(), "bandwidth.yaml")
+	assert.Nil(t, os.WriteFile(path, []byte(content), 0600))
+	cfg, err := LoadConfig(path)
+	assert.Nil(t, err)
+	assert.Nil(t, cfg.Validate())
+	unit, err := ParseUnit(cfg.Unit)
+	assert.Nil(t, err)
+
+	domains, err := NewDomains(cfg.Domains, unit)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"rack1", "rack2", "row1"}, domains.Names())
+	assert.Equal(t, NewNodeResource(10000, 10000), domains["rack1"].Capacity)
+	assert.Equal(t, NewNodeResource(10000, 20000), domains["rack2"].Capacity)
+
+	path1 := domains.Path("node3")
+	assert.Len(t, path1, 2)
+	assert.Equal(t, "rack2", path1[0].Name)
+	assert.Equal(t, "row1", path1[1].Name)
+	assert.Empty(t, domains.Path("node4"))
+	assert.Equal(t, []string{"node1", "node2", "node3"}, domains.Nodes("row1"))
+	assert.Equal(t, []string{"node3"}, domains.Nodes("rack2"))
+
+	assert.Equal(t, NewNodeResource(2000, 0), domains["rack1"].Allocatable(NewNodeResource(8000, 12000), 1))
+
+	for _, configs := range [][]DomainConfig{
+		{{Capacity: map[string]any{"bandwidth": 1}}},
+		{{Name: "a", Capacity: map[string]any{"bandwidth": 1}}, {Name: "a", Capacity: map[string]any{"bandwidth": 1}}},
+		{{Name: "a"}},
+		{{Name: "a", Capacity: map[string]any{"bandwidth": "10%"}}},
+		{{Name: "a", Capacity: map[string]any{"bandwidth": "10Mxx"}}},
+		{{Name: "a", Capacity: map[string]any{"bandwidth": 1}, Parent: "b"}},
+		{{Name: "a", Capacity: map[string]any{"bandwidth": 1}, Parent: "b"}, {Name: "b", Capacity: map[string]any{"bandwidth": 1}, Parent: "a"}},
+		{{Name: "a", Capacity: map[string]any{"bandwidth": 1}, Nodes: []string{"n"}}, {Name: "b", Capacity: map[string]any{"bandwidth": 1}, Nodes: []string{"n"}}},
+	} {
+		_, err := NewDomains(configs, BitUnit)
+		assert.ErrorIs(t, err, ErrInvalidConfig)
+		assert.ErrorIs(t, (&Config{OvercommitRatio: 1, Unit: DefaultUnit, Peak: DefaultConfig().Peak, Store: DefaultConfig().Store, OperationWindow: 1, Domains: configs}).Validate(), ErrInvalidConfig)
+	}
+}
@@ -5,40 +5,95 @@ import (
 	resourcetypes "github.com/projecteru2/core/resource/types"
 )
 
-// EngineParams .
-type EngineParams struct {
+// Limit is the shaping parameters of one direction
+type Limit struct {
 	Average int64 `json:"average" mapstructure:"average"`
 	Peak    int64 `json:"peak" mapstructure:"peak"`
+	// Burst is the token bucket size in bytes
+	Burst int64 `json:"burst" mapstructure:"burst"`
+}
+
+func (l Limit) asMap() map[string]any {
+	return map[string]any{
+		"average": l.Average,
+		"peak":    l.Peak,
+		"burst":   l.Burst,
+	}
+}
+
+// EngineParams .
+type EngineParams struct {
+	Ingress Limit `json:"ingress" mapstructure:"ingress"`
+	Egress  Limit `json:"egress" mapstructure:"egress"`
+	// NIC is the interface to shape traffic on, empty means the engine's default one
+	NIC string `json:"nic,omitempty" mapstructure:"nic"`
+	// ID is the allocation ID of the workload, the engine names the cgroup or the interface of the workload
+	// after it so that the agent finds its packets
+	ID string `json:"id,omitempty" mapstructure:"id"`
 }
 
 func (ep *EngineParams) AsRawParams() resourcetypes.RawParams {
-	return resourcetypes.RawParams{
-		"average": ep.Average,
-		"peak":    ep.Peak,
+	rawParams := resourcetypes.RawParams{
+		"ingress": ep.Ingress.asMap(),
+		"egress":  ep.Egress.asMap(),
+	}
+	if ep.NIC != "" {
+		rawParams["nic"] = ep.NIC
 	}
+	if ep.ID != "" {
+		rawParams["id"] = ep.ID
+	}
+	return rawParams
 }
 
 func (ep *EngineParams) Parse(rawParams resourcetypes.RawParams) error {
 	return mapstructure.Decode(rawParams, ep)
 }
 
-func (ep *EngineParams) Bandwidth() int64 {
-	return ep.Average
-}
-
 func (ep *EngineParams) DeepCopy() *EngineParams {
 	return &EngineParams{
-		Average: ep.Average,
-		Peak:    ep.Peak,
+		Ingress: ep.Ingress,
+		Egress:  ep.Egress,
+		NIC:     ep.NIC,
+		ID:      ep.ID,
 	}
 }
 
 func (ep *EngineParams) Sub(ep1 *EngineParams) {
-	ep.Average -= ep1.Average
-	ep.Peak -= ep1.Peak
+	ep.Ingress.Average -= ep1.Ingress.Average
+	ep.Ingress.Peak -= ep1.Ingress.Peak
+	ep.Ingress.Burst -= ep1.Ingress.Burst
+	ep.Egress.Average -= ep1.Egress.Average
+	ep.Egress.Peak -= ep1.Egress.Peak
+	ep.Egress.Burst -= ep1.Egress.Burst
+}
+
+// AddChecked adds ep1 like Add, limits out of range are clamped and returned
+func (ep *EngineParams) AddChecked(ep1 *EngineParams) []*RangeError {
+	return ep.addChecked(ep1, 1)
+}
+
+// SubChecked subtracts ep1 like Sub, limits out of range are clamped and returned
+func (ep *EngineParams) SubChecked(ep1 *EngineParams) []*RangeError {
+	return ep.addChecked(ep1, -1)
+}
+
+func (ep *EngineParams) addChecked(ep1 *EngineParams, sign int64) []*RangeError {
+	return rangeErrors(
+		checkedAdd("ingress.average", &ep.Ingress.Average, sign*ep1.Ingress.Average),
+		checkedAdd("ingress.peak", &ep.Ingress.Peak, sign*ep1.Ingress.Peak),
+		checkedAdd("ingress.burst", &ep.Ingress.Burst, sign*ep1.Ingress.Burst),
+		checkedAdd("egress.average", &ep.Egress.Average, sign*ep1.Egress.Average),
+		checkedAdd("egress.peak", &ep.Egress.Peak, sign*ep1.Egress.Peak),
+		checkedAdd("egress.burst", &ep.Egress.Burst, sign*ep1.Egress.Burst),
+	)
 }
 
 func (ep *EngineParams) Add(ep1 *EngineParams) {
-	ep.Average += ep1.Average
-	ep.Peak += ep1.Peak
+	ep.Ingress.Average += ep1.Ingress.Average
+	ep.Ingress.Peak += ep1.Ingress.Peak
+	ep.Ingress.Burst += ep1.Ingress.Burst
+	ep.Egress.Average += ep1.Egress.Average
+	ep.Egress.Peak += ep1.Egress.Peak
+	ep.Egress.Burst += ep1.Egress.Burst
 }
@@ -3,6 +3,17 @@ package types
 import "github.com/cockroachdb/errors"
 
 var (
-	ErrInvalidCapacity  = errors.New("invalid resource capacity")
-	ErrInvalidBandwidth = errors.New("invalid bandwidth")
+	ErrInvalidCapacity    = errors.New("invalid resource capacity")
+	ErrInvalidBandwidth   = errors.New("invalid bandwidth")
+	ErrInvalidConfig      = errors.New("invalid bandwidth plugin config")
+	ErrNotEnoughBandwidth = errors.New("not enough bandwidth")
+	ErrNoMatchedNIC       = errors.New("no matched nic")
+	ErrInvalidQoS         = errors.New("invalid qos class")
+	ErrConcurrentUpdate   = errors.New("node resource info was updated concurrently")
+	ErrInvalidDuration    = errors.New("invalid duration")
+	ErrHoldNotFound       = errors.New("hold not found or expired")
+	ErrOperationIDReused  = errors.New("operation id was used by another kind of update")
+	ErrBandwidthUnderflow = errors.New("bandwidth underflow")
+	ErrBandwidthOverflow  = errors.New("bandwidth overflow")
+	ErrInvalidTarget      = errors.New("invalid shaping target")
 )
@@ -0,0 +1,64 @@
+package types
+
+import (
+	"sort"
+	"time"
+)
+
+// Hold is a reservation of a workload which is calculated but not deployed yet,
+// it counts as usage until it's committed, released or expired
+type Hold struct {
+	ID        string            `json:"id"`
+	Resource  *WorkloadResource `json:"resource"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+// Expired .
+func (h *Hold) Expired(now time.Time) bool {
+	return !now.Before(h.ExpiresAt)
+}
+
+// Holds are the holds on a node by the allocation ID of the workload
+type Holds map[string]*Hold
+
+// IDs returns sorted IDs
+func (h Holds) IDs() []string {
+	ids := make([]string, 0, len(h))
+	for id := range h {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// NodeResource sums up the node resource reserved by holds which are not expired
+func (h Holds) NodeResource(now time.Time) *NodeResource {
+	res := NewNodeResource(0, 0)
+	for _, hold := range h {
+		if !hold.Expired(now) {
+			res.Add(hold.Resource.NodeResource())
+		}
+	}
+	return res
+}
+
+// Prune removes expired holds
+func (h Holds) Prune(now time.Time) {
+	for id, hold := range h {
+		if hold.Expired(now) {
+			delete(h, id)
+		}
+	}
+}
+
+// DeepCopy .
+func (h Holds) DeepCopy() Holds {
+	if h == nil {
+		return nil
+	}
+	res := Holds{}
+	for id, hold := range h {
+		res[id] = &Hold{ID: hold.ID, Resource: hold.Resource.DeepCopy(), ExpiresAt: hold.ExpiresAt}
+	}
+	return res
+}
@@ -0,0 +1,60 @@
+package types
+
+import (
+	"testing"
+	"time"
+
+	resourcetypes "github.com/projecteru2/core/resource/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHolds(t *testing.T) {
+	now := time.Now()
+	holds := Holds{
+		"a": {ID: "a", Resource: &WorkloadResource{ID: "a", Ingress: 10, Egress: 10}, ExpiresAt: now.Add(time.Minute)},
+		"b": {ID: "b", Resource: &WorkloadResource{ID: "b", Ingress: 5, Egress: 1, NIC: "eth0"}, ExpiresAt: now.Add(time.Second)},
+		"c": {ID: "c", Resource: &WorkloadResource{ID: "c", Ingress: 7, Egress: 7}, ExpiresAt: now},
+	}
+	assert.Equal(t, []string{"a", "b", "c"}, holds.IDs())
+	assert.True(t, holds["c"].Expired(now))
+	assert.False(t, holds["b"].Expired(now))
+
+	res := holds.NodeResource(now)
+	assert.Equal(t, NewNodeResource(15, 11), res.NIC(""))
+	assert.Equal(t, NewNodeResource(5, 1), res.NIC("eth0"))
+	assert.Equal(t, NewNodeResource(10, 10), holds.NodeResource(now.Add(time.Second)))
+
+	info := &NodeResourceInfo{Capacity: NewNodeResource(100, 100), Usage: NewNodeResource(1, 1), Holds: holds}
+	assert.Equal(t, NewNodeResource(16, 12), info.WithHolds(now).Usage.NIC(""))
+	assert.Equal(t, NewNodeResource(1, 1), info.Usage)
+
+	holds1 := holds.DeepCopy()
+	holds1.Prune(now.Add(time.Second))
+	assert.Equal(t, []string{"a"}, holds1.IDs())
+	assert.Len(t, holds, 3)
+	assert.Nil(t, Holds(nil).DeepCopy())
+	Holds(nil).Prune(now)
+}
+
+func TestWorkloadResourceRequestHold(t *testing.T) {
+	for raw, hold := range map[any]time.Duration{
+		"30s":  30 * time.Second,
+		30:     30 * time.Second,
+		"1.5":  1500 * time.Millisecond,
+		0.25:   250 * time.Millisecond,
+		"1m5s": 65 * time.Second,
+	} {
+		req := &WorkloadResourceRequest{}
+		assert.Nil(t, req.Parse(resourcetypes.RawParams{"bandwidth": 10, "hold": raw}, BitUnit))
+		assert.Equal(t, hold, req.Hold)
+		assert.Equal(t, hold, req.DeepCopy().Hold)
+		assert.Nil(t, req.Validate())
+	}
+
+	req := &WorkloadResourceRequest{}
+	assert.ErrorIs(t, req.Parse(resourcetypes.RawParams{"hold": "soon"}, BitUnit), ErrInvalidDuration)
+	assert.Nil(t, req.Parse(resourcetypes.RawParams{"hold": "-1s"}, BitUnit))
+	assert.ErrorIs(t, req.Validate(), ErrInvalidDuration)
+	// bandwidth errors keep their kind
+	assert.ErrorIs(t, req.Parse(resourcetypes.RawParams{"bandwidth": "10Mxx"}, BitUnit), ErrInvalidBandwidth)
+}
@@ -0,0 +1,132 @@
+package types
+
+import (
+	"sort"
+	"time"
+)
+
+// Allocation is the record of one workload on a node
+type Allocation struct {
+	ID       string            `json:"id"`
+	Resource *WorkloadResource `json:"resource"`
+	// EngineParams is what the workload got for its current resource
+	EngineParams *EngineParams `json:"engine_params"`
+	// Slot numbers the allocation on its node from 1, the agent marks and classifies its packets by it
+	Slot      int       `json:"slot,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// DeepCopy .
+func (a *Allocation) DeepCopy() *Allocation {
+	res := *a
+	res.Resource = a.Resource.DeepCopy()
+	if a.EngineParams != nil {
+		engineParams := *a.EngineParams
+		res.EngineParams = &engineParams
+	}
+	return &res
+}
+
+// Ledger is the allocations of workloads on a node by ID
+type Ledger map[string]*Allocation
+
+// IDs returns sorted IDs
+func (l Ledger) IDs() []string {
+	ids := make([]string, 0, len(l))
+	for id := range l {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// AssignSlots gives allocations without a slot the lowest free ones in the order of IDs, taken slots are kept
+func (l Ledger) AssignSlots() {
+	taken := map[int]bool{}
+	for _, allocation := range l {
+		if allocation.Slot > 0 {
+			taken[allocation.Slot] = true
+		}
+	}
+	slot := 1
+	for _, id := range l.IDs() {
+		if l[id].Slot > 0 {
+			continue
+		}
+		for taken[slot] {
+			slot++
+		}
+		l[id].Slot = slot
+		taken[slot] = true
+	}
+}
+
+// NodeResource sums up the node resource reserved by all allocations
+func (l Ledger) NodeResource() *NodeResource {
+	res := NewNodeResource(0, 0)
+	for _, allocation := range l {
+		res.Add(allocation.Resource.NodeResource())
+	}
+	return res
+}
+
+// PeakResource sums up the peaks the allocations may burst to, reservations are used if engine params are unknown
+func (l Ledger) PeakResource() *NodeResource {
+	res := NewNodeResource(0, 0)
+	for _, allocation := range l {
+		if allocation.EngineParams == nil {
+			res.Add(allocation.Resource.NodeResource().NIC(""))
+			continue
+		}
+		res.Add(NewNodeResource(allocation.EngineParams.Ingress.Peak, allocation.EngineParams.Egress.Peak))
+	}
+	return res
+}
+
+// DeepCopy .
+func (l Ledger) DeepCopy() Ledger {
+	if l == nil {
+		return nil
+	}
+	res := Ledger{}
+	for id, allocation := range l {
+		res[id] = allocation.DeepCopy()
+	}
+	return res
+}
+
+// Issue is the engine params calculate-deploy or calculate-realloc issued for a workload which is not committed yet
+type Issue struct {
+	EngineParams *EngineParams `json:"engine_params"`
+	ExpiresAt    time.Time     `json:"expires_at"`
+}
+
+// Expired .
+func (i *Issue) Expired(now time.Time) bool {
+	return !now.Before(i.ExpiresAt)
+}
+
+// Issues are the issues on a node by the allocation ID of the workload
+type Issues map[string]*Issue
+
+// Prune removes expired issues
+func (i Issues) Prune(now time.Time) {
+	for id, issue := range i {
+		if issue.Expired(now) {
+			delete(i, id)
+		}
+	}
+}
+
+// DeepCopy .
+func (i Issues) DeepCopy() Issues {
+	if i == nil {
+		return nil
+	}
+	res := Issues{}
+	for id, issue := range i {
+		res[id] = &Issue{EngineParams: issue.EngineParams.DeepCopy(), ExpiresAt: issue.ExpiresAt}
+	}
+	return res
+}
@@ -0,0 +1,81 @@
+package types
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	resourcetypes "github.com/projecteru2/core/resource/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLedger(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	ledger := Ledger{
+		"b": {ID: "b", Resource: &WorkloadResource{ID: "b", Ingress: 10, Egress: 20, NIC: "eth0"}, CreatedAt: now, UpdatedAt: now},
+		"a": {ID: "a", Resource: &WorkloadResource{ID: "a", Ingress: 5, Egress: 5, NIC: "eth1"}, EngineParams: &EngineParams{NIC: "eth1"}},
+		"c": {ID: "c", Resource: &WorkloadResource{ID: "c", Ingress: 5, Egress: 5, QoS: QoSBestEffort}},
+	}
+	assert.Equal(t, []string{"a", "b", "c"}, ledger.IDs())
+
+	res := ledger.NodeResource()
+	assert.Equal(t, NewNodeResource(15, 25), res.NIC(""))
+	assert.Equal(t, NewNodeResource(10, 20), res.NIC("eth0"))
+	assert.Equal(t, NewNodeResource(5, 5), res.NIC("eth1"))
+
+	ledger1 := ledger.DeepCopy()
+	assert.Equal(t, ledger, ledger1)
+	ledger1["a"].Resource.Ingress = 100
+	ledger1["a"].EngineParams.NIC = "eth2"
+	assert.Equal(t, int64(5), ledger["a"].Resource.Ingress)
+	assert.Equal(t, "eth1", ledger["a"].EngineParams.NIC)
+	assert.Nil(t, Ledger(nil).DeepCopy())
+
+	info := &NodeResourceInfo{Capacity: NewNodeResource(100, 100), Usage: res, Workloads: ledger}
+	data, err := json.Marshal(info)
+	assert.Nil(t, err)
+	info1 := &NodeResourceInfo{}
+	assert.Nil(t, json.Unmarshal(data, info1))
+	assert.Equal(t, info, info1)
+
+	// records without ledger are still read
+	info2 := &NodeResourceInfo{}
+	assert.Nil(t, json.Unmarshal([]byte(`{"capacity":{"ingress":1,"egress":1},"usage":{"ingress":0,"egress":0}}`), info2))
+	assert.Nil(t, info2.Workloads)
+}
+
+func TestWorkloadResourceID(t *testing.T) {
+	w := &WorkloadResource{}
+	assert.Nil(t, w.Parse(resourcetypes.RawParams{"id": "abc", "bandwidth": 10}, BitUnit))
+	assert.Equal(t, "abc", w.ID)
+	assert.Equal(t, "abc", w.AsRawParams()["id"])
+	assert.Equal(t, "abc", w.DeepCopy().ID)
+	assert.NotContains(t, (&WorkloadResource{}).AsRawParams(), "id")
+	assert.False(t, w.IsZero())
+	w.Sub(w.DeepCopy())
+	assert.True(t, w.IsZero())
+}
+
+func TestLedgerAssignSlots(t *testing.T) {
+	ledger := Ledger{
+		"b": {ID: "b", Slot: 1},
+		"d": {ID: "d", Slot: 3},
+		"a": {ID: "a"},
+		"c": {ID: "c"},
+		"e": {ID: "e"},
+	}
+	ledger.AssignSlots()
+	slots := map[string]int{}
+	for id, allocation := range ledger {
+		slots[id] = allocation.Slot
+	}
+	assert.Equal(t, map[string]int{"a": 2, "b": 1, "c": 4, "d": 3, "e": 5}, slots)
+
+	// taken slots are kept when others come and go
+	delete(ledger, "b")
+	ledger["0"] = &Allocation{ID: "0"}
+	ledger.AssignSlots()
+	assert.Equal(t, 1, ledger["0"].Slot)
+	assert.Equal(t, 2, ledger["a"].Slot)
+	assert.Equal(t, 5, ledger["e"].Slot)
+}
@@ -0,0 +1,145 @@
+package types
+
+import "sort"
+
+// NIC indicate the bandwidth of a network interface
+type NIC struct {
+	Ingress int64    `json:"ingress" mapstructure:"ingress"`
+	Egress  int64    `json:"egress" mapstructure:"egress"`
+	Labels  []string `json:"labels,omitempty" mapstructure:"labels"`
+}
+
+func (n *NIC) asMap() map[string]any {
+	m := map[string]any{
+		"ingress": n.Ingress,
+		"egress":  n.Egress,
+	}
+	if len(n.Labels) > 0 {
+		m["labels"] = n.Labels
+	}
+	return m
+}
+
+// DeepCopy .
+func (n *NIC) DeepCopy() *NIC {
+	res := &NIC{
+		Ingress: n.Ingress,
+		Egress:  n.Egress,
+	}
+	if n.Labels != nil {
+		res.Labels = append([]string{}, n.Labels...)
+	}
+	return res
+}
+
+// HasLabel .
+func (n *NIC) HasLabel(label string) bool {
+	for _, l := range n.Labels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
+// NICs indicate named network interfaces
+type NICs map[string]*NIC
+
+func (ns NICs) asMap() map[string]any {
+	m := map[string]any{}
+	for name, nic := range ns {
+		m[name] = nic.asMap()
+	}
+	return m
+}
+
+// DeepCopy .
+func (ns NICs) DeepCopy() NICs {
+	if ns == nil {
+		return nil
+	}
+	res := NICs{}
+	for name, nic := range ns {
+		res[name] = nic.DeepCopy()
+	}
+	return res
+}
+
+// Names returns sorted NIC names
+func (ns NICs) Names() []string {
+	return NICNames(ns)
+}
+
+// NICNames returns the sorted union of NIC names
+func NICNames(nicsList ...NICs) []string {
+	seen := map[string]struct{}{}
+	names := []string{}
+	for _, ns := range nicsList {
+		for name := range ns {
+			if _, ok := seen[name]; !ok {
+				seen[name] = struct{}{}
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Sum returns total ingress and egress of all NICs
+func (ns NICs) Sum() (ingress int64, egress int64) {
+	for _, nic := range ns {
+		ingress += nic.Ingress
+		egress += nic.Egress
+	}
+	return ingress, egress
+}
+
+func (ns NICs) validate() error {
+	for name, nic := range ns {
+		if name == "" || nic == nil || nic.Ingress < 0 || nic.Egress < 0 {
+			return ErrInvalidBandwidth
+		}
+	}
+	return nil
+}
+
+// add adds ns1 to ns, NICs not in ns are created with the labels of ns1
+func (ns NICs) add(ns1 NICs, sign int64) NICs {
+	if len(ns1) == 0 {
+		return ns
+	}
+	if ns == nil {
+		ns = NICs{}
+	}
+	for name, nic := range ns1 {
+		if _, ok := ns[name]; !ok {
+			ns[name] = &NIC{Labels: nic.DeepCopy().Labels}
+		}
+		ns[name].Ingress += sign * nic.Ingress
+		ns[name].Egress += sign * nic.Egress
+	}
+	return ns
+}
+
+// addChecked is add with bandwidths clamped to [0, MaxInt64], the clamped ones are returned
+func (ns NICs) addChecked(ns1 NICs, sign int64) (NICs, []*RangeError) {
+	if len(ns1) == 0 {
+		return ns, nil
+	}
+	if ns == nil {
+		ns = NICs{}
+	}
+	var res []*RangeError
+	for _, name := range ns1.Names() {
+		nic := ns1[name]
+		if _, ok := ns[name]; !ok {
+			ns[name] = &NIC{Labels: nic.DeepCopy().Labels}
+		}
+		res = append(res, rangeErrors(
+			checkedAdd("nics."+name+".ingress", &ns[name].Ingress, sign*nic.Ingress),
+			checkedAdd("nics."+name+".egress", &ns[name].Egress, sign*nic.Egress),
+		)...)
+	}
+	return ns, res
+}
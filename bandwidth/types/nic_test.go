@@ -0,0 +1,83 @@
+package types
+
+import (
+	"encoding/json"
+	"testing"
+
+	resourcetypes "github.com/projecteru2/core/resource/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNodeResourceNICs(t *testing.T) {
+	n := &NodeResource{}
+	err := n.Parse(resourcetypes.RawParams{
+		"nics": map[string]any{
+			"eth0": map[string]any{"bandwidth": 100, "labels": []string{"storage-net"}},
+			"eth1": map[string]any{"ingress": 50, "egress": 20},
+		},
+	}, BitUnit)
+	assert.Nil(t, err)
+	assert.Nil(t, n.Validate())
+	// totals follow the NICs
+	assert.Equal(t, int64(150), n.Ingress)
+	assert.Equal(t, int64(120), n.Egress)
+	assert.Equal(t, []string{"eth0", "eth1"}, n.NICs.Names())
+	assert.True(t, n.NICs["eth0"].HasLabel("storage-net"))
+	assert.False(t, n.NICs["eth1"].HasLabel("storage-net"))
+	assert.Equal(t, NewNodeResource(50, 20), n.NIC("eth1"))
+	assert.Equal(t, NewNodeResource(0, 0), n.NIC("eth2"))
+
+	// explicit totals win
+	n1 := &NodeResource{}
+	err = n1.Parse(resourcetypes.RawParams{
+		"bandwidth": 100,
+		"nics":      n.NICs.asMap(),
+	}, BitUnit)
+	assert.Nil(t, err)
+	assert.Equal(t, NewNodeResource(100, 100), n1.NIC(""))
+
+	data, err := json.Marshal(n)
+	assert.Nil(t, err)
+	n2 := &NodeResource{}
+	assert.Nil(t, json.Unmarshal(data, n2))
+	assert.Equal(t, n, n2)
+
+	n3 := &NodeResource{}
+	assert.Nil(t, n3.Parse(n.AsRawParams(), BitUnit))
+	assert.Equal(t, n, n3)
+
+	n2.Sub(&NodeResource{Ingress: 10, Egress: 10, NICs: NICs{"eth0": {Ingress: 10, Egress: 10}}})
+	assert.Equal(t, NewNodeResource(90, 90), n2.NIC("eth0"))
+	assert.Equal(t, NewNodeResource(100, 100), n.NIC("eth0"))
+
+	n.NICs["eth2"] = &NIC{Ingress: -1}
+	assert.ErrorIs(t, n.Validate(), ErrInvalidBandwidth)
+}
+
+func TestCandidateNICs(t *testing.T) {
+	info := &NodeResourceInfo{
+		Capacity: NewNodeResource(100, 100),
+		Usage:    NewNodeResource(0, 0),
+	}
+	assert.Equal(t, []string{""}, info.CandidateNICs("", ""))
+	assert.Nil(t, info.CandidateNICs("eth0", ""))
+	assert.Nil(t, info.CandidateNICs("", "storage-net"))
+
+	info.Capacity.NICs = NICs{
+		"eth0": {Ingress: 100, Egress: 100, Labels: []string{"storage-net"}},
+		"eth1": {Ingress: 100, Egress: 100},
+	}
+	info.Usage.NICs = NICs{
+		"eth0": {Ingress: 80, Egress: 10},
+	}
+	info.Usage.Ingress, info.Usage.Egress = 80, 10
+	assert.Equal(t, []string{"eth0", "eth1"}, info.CandidateNICs("", ""))
+	assert.Equal(t, []string{"eth1"}, info.CandidateNICs("eth1", ""))
+	assert.Equal(t, []string{"eth0"}, info.CandidateNICs("", "storage-net"))
+	assert.Empty(t, info.CandidateNICs("eth1", "storage-net"))
+
+	assert.Equal(t, NewNodeResource(20, 90), info.GetNICAllocatableResource("eth0", 1))
+	// bounded by node totals
+	assert.Equal(t, NewNodeResource(20, 90), info.GetNICAllocatableResource("eth1", 1))
+	assert.Equal(t, NewNodeResource(20, 90), info.GetNICAllocatableResource("", 1))
+}
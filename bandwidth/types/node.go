@@ -1,86 +1,307 @@
 package types
 
 import (
-	"github.com/mitchellh/mapstructure"
+	"bytes"
+	"encoding/json"
+	"math"
+	"time"
+
+	"github.com/cockroachdb/errors"
 	resourcetypes "github.com/projecteru2/core/resource/types"
 )
 
-// NodeResource indicate node cpumem resource
+// NodeResource indicate node bandwidth resource,
+// Ingress and Egress are the totals of the node, NICs are the per interface pools
 type NodeResource struct {
-	Bandwidth int64 `json:"bandwidth" mapstructure:"bandwidth"`
+	Ingress int64 `json:"ingress" mapstructure:"ingress"`
+	Egress  int64 `json:"egress" mapstructure:"egress"`
+	NICs    NICs  `json:"nics,omitempty" mapstructure:"nics"`
+	// relative keeps the raw params with percentages until they are resolved
+	relative resourcetypes.RawParams
 }
 
-func NewNodeResource(bd int64) *NodeResource {
+func NewNodeResource(ingress, egress int64) *NodeResource {
 	return &NodeResource{
-		Bandwidth: bd,
+		Ingress: ingress,
+		Egress:  egress,
 	}
 }
 
 func (r *NodeResource) AsRawParams() resourcetypes.RawParams {
-	return resourcetypes.RawParams{
-		"bandwidth": r.Bandwidth,
+	rawParams := resourcetypes.RawParams{
+		"ingress": r.Ingress,
+		"egress":  r.Egress,
+	}
+	if len(r.NICs) > 0 {
+		rawParams["nics"] = r.NICs.asMap()
+	}
+	return rawParams
+}
+
+// Parse parses rawParams, bandwidth strings are normalized to unit
+func (r *NodeResource) Parse(rawParams resourcetypes.RawParams, unit Unit) (err error) {
+	if r.relative, err = decodeRelative(rawParams, r, unit); err != nil {
+		return err
+	}
+	r.Ingress, r.Egress = fillTotals(rawParams, r.NICs, r.Ingress, r.Egress)
+	return nil
+}
+
+// IsRelative returns true if some bandwidth is a percentage which is not resolved yet
+func (r *NodeResource) IsRelative() bool {
+	return r.relative != nil
+}
+
+// Resolve resolves percentages against base
+func (r *NodeResource) Resolve(base *NodeResource) error {
+	if r.relative == nil {
+		return nil
+	}
+	rawParams, err := resolvePercents(r.relative, base)
+	if err != nil {
+		return err
 	}
+	*r = NodeResource{}
+	// the relative params only hold numbers once resolved, they parse the same in any unit
+	return r.Parse(rawParams, BitUnit)
 }
 
-// Parse .
-func (r *NodeResource) Parse(rawParams resourcetypes.RawParams) error {
-	return mapstructure.Decode(rawParams, r)
+// UnmarshalJSON also accepts the single `bandwidth` value of old records as symmetric
+func (r *NodeResource) UnmarshalJSON(data []byte) error {
+	rawParams := resourcetypes.RawParams{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&rawParams); err != nil {
+		return err
+	}
+	// records hold numbers in the unit of the node resource info
+	return r.Parse(rawParams, BitUnit)
 }
 
 func (r *NodeResource) Validate() error {
-	if r.Bandwidth < 0 {
+	if r.Ingress < 0 || r.Egress < 0 {
 		return ErrInvalidBandwidth
 	}
-	return nil
+	return r.NICs.validate()
 }
 
 // DeepCopy .
 func (r *NodeResource) DeepCopy() *NodeResource {
 	res := &NodeResource{
-		Bandwidth: r.Bandwidth,
+		Ingress:  r.Ingress,
+		Egress:   r.Egress,
+		NICs:     r.NICs.DeepCopy(),
+		relative: r.relative,
 	}
 	return res
 }
 
 // Add .
 func (r *NodeResource) Add(r1 *NodeResource) {
-	r.Bandwidth += r1.Bandwidth
+	r.Ingress += r1.Ingress
+	r.Egress += r1.Egress
+	r.NICs = r.NICs.add(r1.NICs, 1)
 }
 
 // Sub .
 func (r *NodeResource) Sub(r1 *NodeResource) {
-	r.Bandwidth -= r1.Bandwidth
+	r.Ingress -= r1.Ingress
+	r.Egress -= r1.Egress
+	r.NICs = r.NICs.add(r1.NICs, -1)
 }
 
-// NodeResourceInfo indicate cpumem capacity and usage
-type NodeResourceInfo struct {
-	Capacity *NodeResource `json:"capacity"`
-	Usage    *NodeResource `json:"usage"`
+// AddChecked adds r1 like Add, bandwidths out of range are clamped and returned
+func (r *NodeResource) AddChecked(r1 *NodeResource) []*RangeError {
+	return r.addChecked(r1, 1)
 }
 
-func (n *NodeResourceInfo) CapBandwidth() int64 {
-	return n.Capacity.Bandwidth
+// SubChecked subtracts r1 like Sub, bandwidths out of range are clamped and returned
+func (r *NodeResource) SubChecked(r1 *NodeResource) []*RangeError {
+	return r.addChecked(r1, -1)
+}
+
+func (r *NodeResource) addChecked(r1 *NodeResource, sign int64) []*RangeError {
+	res := rangeErrors(
+		checkedAdd("ingress", &r.Ingress, sign*r1.Ingress),
+		checkedAdd("egress", &r.Egress, sign*r1.Egress),
+	)
+	var nicsErrs []*RangeError
+	r.NICs, nicsErrs = r.NICs.addChecked(r1.NICs, sign)
+	return append(res, nicsErrs...)
+}
+
+// NIC returns the bandwidth of the named NIC, empty name means the whole node
+func (r *NodeResource) NIC(name string) *NodeResource {
+	if name == "" {
+		return NewNodeResource(r.Ingress, r.Egress)
+	}
+	if nic, ok := r.NICs[name]; ok {
+		return NewNodeResource(nic.Ingress, nic.Egress)
+	}
+	return NewNodeResource(0, 0)
 }
 
-func (n *NodeResourceInfo) UsageBandwidth() int64 {
-	return n.Usage.Bandwidth
+// IsZero .
+func (r *NodeResource) IsZero() bool {
+	return r.Ingress == 0 && r.Egress == 0
+}
+
+// Fit returns how many res can be held by r, math.MaxInt if res is empty
+func (r *NodeResource) Fit(res *NodeResource) int {
+	fit := int64(math.MaxInt)
+	if res.Ingress > 0 && r.Ingress/res.Ingress < fit {
+		fit = r.Ingress / res.Ingress
+	}
+	if res.Egress > 0 && r.Egress/res.Egress < fit {
+		fit = r.Egress / res.Egress
+	}
+	if fit < 0 {
+		return 0
+	}
+	return int(fit)
+}
+
+// Ratio returns the ratio of r to total in the busier direction
+func (r *NodeResource) Ratio(total *NodeResource) float64 {
+	return math.Max(ratio(r.Ingress, total.Ingress), ratio(r.Egress, total.Egress))
+}
+
+// NodeResourceInfo indicate bandwidth capacity and usage
+type NodeResourceInfo struct {
+	Capacity *NodeResource `json:"capacity"`
+	Usage    *NodeResource `json:"usage"`
+	// Workloads is the ledger of workloads with an allocation ID
+	Workloads Ledger `json:"workloads,omitempty"`
+	// Holds are reservations of workloads not deployed yet
+	Holds Holds `json:"holds,omitempty"`
+	// Issues are the engine params issued for workloads not committed yet, the ledger takes them on commit
+	Issues Issues `json:"issues,omitempty"`
+	// Operations are the recently applied updates with an operation ID
+	Operations Operations `json:"operations,omitempty"`
+	// Reserved is the bandwidth kept for the system, percentages are of the capacity
+	Reserved resourcetypes.RawParams `json:"reserved,omitempty"`
+	// Drift is the differences between the allocations and the shaping of the node last reported by its agent
+	Drift []string `json:"drift,omitempty"`
+	// Warnings are the problems found by updates since the last FixNodeResource, like bandwidths clamped by the clamp arithmetic
+	Warnings []string `json:"warnings,omitempty"`
+	// Unit is the unit the bandwidths are kept in, empty for records written before it was stored
+	Unit string `json:"unit,omitempty"`
 }
 
 // DeepCopy .
 func (n *NodeResourceInfo) DeepCopy() *NodeResourceInfo {
 	return &NodeResourceInfo{
-		Capacity: n.Capacity.DeepCopy(),
-		Usage:    n.Usage.DeepCopy(),
+		Capacity:   n.Capacity.DeepCopy(),
+		Usage:      n.Usage.DeepCopy(),
+		Workloads:  n.Workloads.DeepCopy(),
+		Holds:      n.Holds.DeepCopy(),
+		Issues:     n.Issues.DeepCopy(),
+		Operations: n.Operations.DeepCopy(),
+		Reserved:   copyRawParams(n.Reserved),
+		Drift:      append([]string(nil), n.Drift...),
+		Warnings:   append([]string(nil), n.Warnings...),
+		Unit:       n.Unit,
 	}
 }
 
+// WithHolds returns a copy whose usage includes the holds which are not expired at now
+func (n *NodeResourceInfo) WithHolds(now time.Time) *NodeResourceInfo {
+	res := n.DeepCopy()
+	res.Usage.Add(n.Holds.NodeResource(now))
+	return res
+}
+
+// Schedulable returns a copy to schedule workloads on, its usage includes the holds which are not expired at now
+// and its capacity excludes the reservation
+func (n *NodeResourceInfo) Schedulable(now time.Time) *NodeResourceInfo {
+	res := n.WithHolds(now)
+	reserved := n.ReservedResource()
+	res.Capacity.Ingress -= reserved.Ingress
+	res.Capacity.Egress -= reserved.Egress
+	// capacity may have been reduced below an absolute reservation
+	if res.Capacity.Ingress < 0 {
+		res.Capacity.Ingress = 0
+	}
+	if res.Capacity.Egress < 0 {
+		res.Capacity.Egress = 0
+	}
+	return res
+}
+
+// ReservedResource returns the reservation resolved against the capacity
+func (n *NodeResourceInfo) ReservedResource() *NodeResource {
+	reserved, err := ParseReserved(n.Reserved, n.Capacity)
+	if err != nil {
+		// it's validated before written
+		return NewNodeResource(0, 0)
+	}
+	return reserved
+}
+
 func (n *NodeResourceInfo) Validate() error {
 	if err := n.Capacity.Validate(); err != nil {
 		return err
 	}
+	if _, err := ParseReserved(n.Reserved, n.Capacity); err != nil {
+		return err
+	}
 	return n.Usage.Validate()
 }
 
+// NewReserved returns the raw params of a reservation in unit, value is a bandwidth or a percentage for both directions,
+// or a map of ingress and egress
+func NewReserved(value any, unit Unit) (resourcetypes.RawParams, error) {
+	var reserved resourcetypes.RawParams
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case resourcetypes.RawParams:
+		reserved = copyRawParams(v)
+	case map[string]any:
+		reserved = copyRawParams(v)
+	default:
+		reserved = resourcetypes.RawParams{"bandwidth": v}
+	}
+	reserved, err := unit.normalize(reserved)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := ParseReserved(reserved, NewNodeResource(0, 0)); err != nil {
+		return nil, err
+	}
+	return reserved, nil
+}
+
+// ParseReserved resolves the raw params of a reservation from NewReserved against capacity, only totals are reserved
+func ParseReserved(reserved resourcetypes.RawParams, capacity *NodeResource) (*NodeResource, error) {
+	res := NewNodeResource(0, 0)
+	if len(reserved) == 0 {
+		return res, nil
+	}
+	// the reservation holds numbers and percentages only
+	if err := res.Parse(reserved, BitUnit); err != nil {
+		return nil, err
+	}
+	if err := res.Resolve(capacity.NIC("")); err != nil {
+		return nil, err
+	}
+	if err := res.Validate(); err != nil || len(res.NICs) > 0 {
+		return nil, errors.Wrapf(ErrInvalidBandwidth, "invalid reserved %v", reserved)
+	}
+	return res, nil
+}
+
+func copyRawParams(rawParams map[string]any) resourcetypes.RawParams {
+	if rawParams == nil {
+		return nil
+	}
+	res := resourcetypes.RawParams{}
+	for key, value := range rawParams {
+		res[key] = value
+	}
+	return res
+}
+
 func (n *NodeResourceInfo) GetAvailableResource() *NodeResource {
 	availableResource := n.Capacity.DeepCopy()
 	availableResource.Sub(n.Usage)
@@ -88,20 +309,113 @@ func (n *NodeResourceInfo) GetAvailableResource() *NodeResource {
 	return availableResource
 }
 
+// GetAllocatableResource returns the resource still allocatable when capacity is overcommitted by ratio
+func (n *NodeResourceInfo) GetAllocatableResource(overcommitRatio float64) *NodeResource {
+	return allocatable(n.Capacity.NIC(""), n.Usage.NIC(""), overcommitRatio)
+}
+
+// GetNICAllocatableResource returns the resource still allocatable on a NIC, bounded by the node totals
+func (n *NodeResourceInfo) GetNICAllocatableResource(name string, overcommitRatio float64) *NodeResource {
+	allocatableResource := n.GetAllocatableResource(overcommitRatio)
+	if name == "" {
+		return allocatableResource
+	}
+	nicAllocatableResource := allocatable(n.Capacity.NIC(name), n.Usage.NIC(name), overcommitRatio)
+	if nicAllocatableResource.Ingress < allocatableResource.Ingress {
+		allocatableResource.Ingress = nicAllocatableResource.Ingress
+	}
+	if nicAllocatableResource.Egress < allocatableResource.Egress {
+		allocatableResource.Egress = nicAllocatableResource.Egress
+	}
+	return allocatableResource
+}
+
+// CandidateNICs returns the NICs a workload can be placed on, filtered by name and label.
+// A node without NICs is a single pool named "", which can't satisfy any filter.
+func (n *NodeResourceInfo) CandidateNICs(name, label string) []string {
+	if len(n.Capacity.NICs) == 0 {
+		if name != "" || label != "" {
+			return nil
+		}
+		return []string{""}
+	}
+	candidates := []string{}
+	for _, nicName := range n.Capacity.NICs.Names() {
+		if name != "" && nicName != name {
+			continue
+		}
+		if label != "" && !n.Capacity.NICs[nicName].HasLabel(label) {
+			continue
+		}
+		candidates = append(candidates, nicName)
+	}
+	return candidates
+}
+
+func allocatable(capacity, usage *NodeResource, overcommitRatio float64) *NodeResource {
+	allocatableResource := NewNodeResource(
+		int64(float64(capacity.Ingress)*overcommitRatio),
+		int64(float64(capacity.Egress)*overcommitRatio),
+	)
+	allocatableResource.Sub(usage)
+	if allocatableResource.Ingress < 0 {
+		allocatableResource.Ingress = 0
+	}
+	if allocatableResource.Egress < 0 {
+		allocatableResource.Egress = 0
+	}
+	return allocatableResource
+}
+
 // NodeResourceRequest includes all possible fields passed by eru-core for editing node, it not parsed!
 type NodeResourceRequest struct {
-	Bandwidth int64 `json:"bandwidth" mapstructure:"bandwidth"`
+	Ingress int64 `json:"ingress" mapstructure:"ingress"`
+	Egress  int64 `json:"egress" mapstructure:"egress"`
+	NICs    NICs  `json:"nics" mapstructure:"nics"`
+	// relative keeps the raw params with percentages until they are resolved
+	relative resourcetypes.RawParams
 }
 
-func (n *NodeResourceRequest) Parse(rawParams resourcetypes.RawParams) error {
-	return mapstructure.Decode(rawParams, n)
+func (n *NodeResourceRequest) Parse(rawParams resourcetypes.RawParams, unit Unit) (err error) {
+	if n.relative, err = decodeRelative(rawParams, n, unit); err != nil {
+		return err
+	}
+	n.Ingress, n.Egress = fillTotals(rawParams, n.NICs, n.Ingress, n.Egress)
+	return nil
+}
+
+// IsRelative returns true if some bandwidth is a percentage which is not resolved yet
+func (n *NodeResourceRequest) IsRelative() bool {
+	return n.relative != nil
+}
+
+// Resolve resolves percentages against base, which is the current capacity of the node
+func (n *NodeResourceRequest) Resolve(base *NodeResource) error {
+	if n.relative == nil {
+		return nil
+	}
+	rawParams, err := resolvePercents(n.relative, base)
+	if err != nil {
+		return err
+	}
+	*n = NodeResourceRequest{}
+	return n.Parse(rawParams, BitUnit)
 }
 
 func (n *NodeResourceRequest) Validate() error {
-	if n.Bandwidth < 0 {
+	if n.Ingress < 0 || n.Egress < 0 {
 		return ErrInvalidBandwidth
 	}
-	return nil
+	return n.NICs.validate()
+}
+
+// NodeResource .
+func (n *NodeResourceRequest) NodeResource() *NodeResource {
+	return &NodeResource{
+		Ingress: n.Ingress,
+		Egress:  n.Egress,
+		NICs:    n.NICs.DeepCopy(),
+	}
 }
 
 // Merge fields to NodeResourceRequest.
@@ -109,7 +423,29 @@ func (n *NodeResourceRequest) LoadFromOrigin(nodeResource *NodeResource, resourc
 	if n == nil {
 		return
 	}
-	if !resourceRequest.IsSet("bandwidth") {
-		n.Bandwidth = nodeResource.Bandwidth
+	// totals follow the NICs if they are set
+	if !resourceRequest.IsSet("nics") {
+		n.NICs = nodeResource.NICs.DeepCopy()
+		if !isSet(resourceRequest, "ingress") {
+			n.Ingress = nodeResource.Ingress
+		}
+		if !isSet(resourceRequest, "egress") {
+			n.Egress = nodeResource.Egress
+		}
+	}
+}
+
+// fillTotals returns the sum of NICs for the totals which are not set explicitly
+func fillTotals(rawParams resourcetypes.RawParams, nics NICs, ingress, egress int64) (int64, int64) {
+	if len(nics) == 0 {
+		return ingress, egress
+	}
+	nicsIngress, nicsEgress := nics.Sum()
+	if !isSet(rawParams, "ingress") {
+		ingress = nicsIngress
+	}
+	if !isSet(rawParams, "egress") {
+		egress = nicsEgress
 	}
+	return ingress, egress
 }
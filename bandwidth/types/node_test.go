@@ -2,7 +2,9 @@ package types
 
 import (
 	"encoding/json"
+	"math"
 	"testing"
+	"time"
 
 	resourcetypes "github.com/projecteru2/core/resource/types"
 	"github.com/stretchr/testify/assert"
@@ -11,33 +13,35 @@ import (
 func TestNodeResource(t *testing.T) {
 	nParams := map[string]any{}
 	n := &NodeResource{}
-	err := n.Parse(nParams)
+	err := n.Parse(nParams, BitUnit)
 	assert.Nil(t, err)
 
 	n = &NodeResource{}
-	err = n.Parse(nil)
+	err = n.Parse(nil, BitUnit)
 	assert.Nil(t, err)
 
 	nParams = map[string]any{
 		"bandwidth1": 100,
 	}
 	n = &NodeResource{}
-	err = n.Parse(nParams)
+	err = n.Parse(nParams, BitUnit)
 	assert.Nil(t, err)
-	assert.Zero(t, n.Bandwidth)
+	assert.Zero(t, n.Ingress)
+	assert.Zero(t, n.Egress)
 
 	nParams = map[string]any{
 		"bandwidth": 100,
 	}
 	n = &NodeResource{}
-	err = n.Parse(nParams)
+	err = n.Parse(nParams, BitUnit)
 	assert.Nil(t, err)
-	assert.Equal(t, n.Bandwidth, int64(100))
+	assert.Equal(t, n.Ingress, int64(100))
+	assert.Equal(t, n.Egress, int64(100))
 }
 
 func TestNodeResourceRequest(t *testing.T) {
 	req := &NodeResourceRequest{}
-	err := req.Parse(nil)
+	err := req.Parse(nil, BitUnit)
 	assert.Nil(t, err)
 }
 
@@ -51,12 +55,99 @@ func TestJsonLoadNodeReqResp(t *testing.T) {
 	err := json.Unmarshal([]byte(j1), &obj)
 	assert.Nil(t, err)
 	req := &NodeResourceRequest{}
-	err = req.Parse(obj)
+	err = req.Parse(obj, BitUnit)
 	assert.Nil(t, err)
-	assert.Equal(t, req.Bandwidth, int64(100))
+	assert.Equal(t, req.Ingress, int64(100))
+	assert.Equal(t, req.Egress, int64(100))
 
 	res := &NodeResource{}
-	err = res.Parse(obj)
+	err = res.Parse(obj, BitUnit)
 	assert.Nil(t, err)
-	assert.Equal(t, res.Bandwidth, int64(100))
+	assert.Equal(t, res.Ingress, int64(100))
+	assert.Equal(t, res.Egress, int64(100))
+}
+
+func TestNodeResourceDirections(t *testing.T) {
+	n := &NodeResource{}
+	err := n.Parse(resourcetypes.RawParams{
+		"ingress": 10,
+		"egress":  20,
+	}, BitUnit)
+	assert.Nil(t, err)
+	assert.Equal(t, NewNodeResource(10, 20), n)
+
+	// explicit direction overrides the symmetric value
+	n = &NodeResource{}
+	err = n.Parse(resourcetypes.RawParams{
+		"bandwidth": 10,
+		"egress":    20,
+	}, BitUnit)
+	assert.Nil(t, err)
+	assert.Equal(t, NewNodeResource(10, 20), n)
+
+	// records written before the split
+	info := &NodeResourceInfo{}
+	err = json.Unmarshal([]byte(`{"capacity": {"bandwidth": 100}, "usage": {"bandwidth": 10}}`), info)
+	assert.Nil(t, err)
+	assert.Equal(t, NewNodeResource(100, 100), info.Capacity)
+	assert.Equal(t, NewNodeResource(10, 10), info.Usage)
+
+	data, err := json.Marshal(info)
+	assert.Nil(t, err)
+	info1 := &NodeResourceInfo{}
+	assert.Nil(t, json.Unmarshal(data, info1))
+	assert.Equal(t, info, info1)
+
+	assert.Equal(t, 0.1, info.Usage.Ratio(info.Capacity))
+	assert.Equal(t, 0.5, NewNodeResource(10, 50).Ratio(info.Capacity))
+	assert.Equal(t, float64(0), info.Usage.Ratio(NewNodeResource(0, 0)))
+
+	assert.Equal(t, 9, info.GetAvailableResource().Fit(NewNodeResource(10, 0)))
+	assert.Equal(t, 3, info.GetAvailableResource().Fit(NewNodeResource(10, 30)))
+	assert.Equal(t, math.MaxInt, info.GetAvailableResource().Fit(NewNodeResource(0, 0)))
+
+	assert.Equal(t, NewNodeResource(140, 140), info.GetAllocatableResource(1.5))
+	info.Usage = NewNodeResource(200, 0)
+	assert.Equal(t, NewNodeResource(0, 100), info.GetAllocatableResource(1))
+
+	req := &NodeResourceRequest{Ingress: 1, Egress: 2}
+	req.LoadFromOrigin(NewNodeResource(10, 20), resourcetypes.RawParams{"ingress": 1})
+	assert.Equal(t, int64(1), req.Ingress)
+	assert.Equal(t, int64(20), req.Egress)
+}
+
+func TestNodeResourceReserved(t *testing.T) {
+	reserved, err := NewReserved("10%", BitUnit)
+	assert.Nil(t, err)
+	assert.Equal(t, resourcetypes.RawParams{"ingress": "10%", "egress": "10%"}, reserved)
+	reserved, err = NewReserved(map[string]any{"ingress": 20, "egress": "50%"}, BitUnit)
+	assert.Nil(t, err)
+	assert.Equal(t, resourcetypes.RawParams{"ingress": 20, "egress": "50%"}, reserved)
+	// bandwidths are kept in the unit
+	reserved, err = NewReserved("20Mbps", Unit(1e6))
+	assert.Nil(t, err)
+	assert.Equal(t, resourcetypes.RawParams{"ingress": int64(20), "egress": int64(20)}, reserved)
+	reserved, err = NewReserved(nil, BitUnit)
+	assert.Nil(t, err)
+	assert.Nil(t, reserved)
+	_, err = NewReserved("abc", BitUnit)
+	assert.ErrorIs(t, err, ErrInvalidBandwidth)
+	_, err = NewReserved(map[string]any{"nics": map[string]any{"eth0": map[string]any{"bandwidth": 10}}}, BitUnit)
+	assert.ErrorIs(t, err, ErrInvalidBandwidth)
+
+	info := &NodeResourceInfo{
+		Capacity: NewNodeResource(100, 200),
+		Usage:    NewNodeResource(10, 10),
+		Reserved: resourcetypes.RawParams{"ingress": 20, "egress": "50%"},
+	}
+	assert.Nil(t, info.Validate())
+	assert.Equal(t, NewNodeResource(20, 100), info.ReservedResource())
+	schedulable := info.Schedulable(time.Now())
+	assert.Equal(t, NewNodeResource(80, 100), schedulable.Capacity)
+	assert.Equal(t, NewNodeResource(10, 10), schedulable.Usage)
+	assert.Equal(t, NewNodeResource(100, 200), info.Capacity)
+
+	// capacity reduced below an absolute reservation
+	info.Capacity = NewNodeResource(10, 10)
+	assert.Equal(t, NewNodeResource(0, 5), info.Schedulable(time.Now()).Capacity)
 }
@@ -0,0 +1,61 @@
+package types
+
+import "time"
+
+// kinds of operations
+const (
+	OperationUsage    = "usage"
+	OperationCapacity = "capacity"
+)
+
+// Operation is the record of an applied update with an operation ID,
+// a replay of the ID returns Before and After instead of applying again
+type Operation struct {
+	ID        string        `json:"id"`
+	Kind      string        `json:"kind"`
+	Before    *NodeResource `json:"before"`
+	After     *NodeResource `json:"after"`
+	AppliedAt time.Time     `json:"applied_at"`
+}
+
+// DeepCopy .
+func (o *Operation) DeepCopy() *Operation {
+	res := *o
+	res.Before = o.Before.DeepCopy()
+	res.After = o.After.DeepCopy()
+	return &res
+}
+
+// Operations are the recently applied operations on a node, oldest first
+type Operations []*Operation
+
+// Find returns the operation with id, nil if it's not recorded
+func (o Operations) Find(id string) *Operation {
+	for _, op := range o {
+		if op.ID == id {
+			return op
+		}
+	}
+	return nil
+}
+
+// Record appends op and drops the oldest ones beyond window
+func (o Operations) Record(op *Operation, window int) Operations {
+	o = append(o, op)
+	if len(o) > window {
+		o = append(Operations{}, o[len(o)-window:]...)
+	}
+	return o
+}
+
+// DeepCopy .
+func (o Operations) DeepCopy() Operations {
+	if o == nil {
+		return nil
+	}
+	res := make(Operations, 0, len(o))
+	for _, op := range o {
+		res = append(res, op.DeepCopy())
+	}
+	return res
+}
@@ -0,0 +1,26 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOperations(t *testing.T) {
+	var ops Operations
+	assert.Nil(t, ops.Find("a"))
+	assert.Nil(t, ops.DeepCopy())
+
+	for _, id := range []string{"a", "b", "c"} {
+		ops = ops.Record(&Operation{ID: id, Kind: OperationUsage, Before: NewNodeResource(0, 0), After: NewNodeResource(1, 1)}, 2)
+	}
+	assert.Len(t, ops, 2)
+	assert.Nil(t, ops.Find("a"))
+	assert.Equal(t, "b", ops.Find("b").ID)
+	assert.Equal(t, "c", ops[1].ID)
+
+	ops1 := ops.DeepCopy()
+	ops1[0].After.Add(NewNodeResource(1, 1))
+	assert.Equal(t, NewNodeResource(1, 1), ops.Find("b").After)
+	assert.Equal(t, NewNodeResource(2, 2), ops1.Find("b").After)
+}
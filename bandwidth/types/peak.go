@@ -0,0 +1,73 @@
+package types
+
+import (
+	"math"
+
+	"github.com/cockroachdb/errors"
+)
+
+// peak modes
+const (
+	// PeakRatio sets peak to average * ratio
+	PeakRatio = "ratio"
+	// PeakExtra sets peak to average + extra
+	PeakExtra = "extra"
+	// PeakCapacity sets peak to the capacity of the NIC or the node
+	PeakCapacity = "capacity"
+	// PeakFree sets peak to min(average * ratio, free capacity)
+	PeakFree = "free"
+)
+
+// PeakConfig is the policy of peak and burst in engine params
+type PeakConfig struct {
+	Mode  string  `yaml:"mode" json:"mode" default:"ratio"`
+	Ratio float64 `yaml:"ratio" json:"ratio" default:"2"`
+	// Extra is in the canonical unit
+	Extra int64 `yaml:"extra" json:"extra"`
+	// BurstSeconds is how long a workload can send at peak, which sizes the token bucket
+	BurstSeconds float64 `yaml:"burst_seconds" json:"burst_seconds" default:"0.1"`
+}
+
+// Validate .
+func (c *PeakConfig) Validate() error {
+	switch c.Mode {
+	case PeakRatio, PeakExtra, PeakCapacity, PeakFree:
+	default:
+		return errors.Wrapf(ErrInvalidConfig, "unknown peak mode %q", c.Mode)
+	}
+	if c.Ratio < 1 {
+		return errors.Wrapf(ErrInvalidConfig, "peak ratio must be at least 1, got %v", c.Ratio)
+	}
+	if c.Extra < 0 || c.BurstSeconds < 0 {
+		return errors.Wrapf(ErrInvalidConfig, "peak extra and burst_seconds can't be negative")
+	}
+	return nil
+}
+
+// Peak returns the peak of average, capacity is of the NIC or the node the workload is on,
+// free is the capacity not reserved by other workloads. Peak is never less than average.
+func (c *PeakConfig) Peak(average, capacity, free int64) int64 {
+	var peak int64
+	switch c.Mode {
+	case PeakExtra:
+		peak = average + c.Extra
+	case PeakCapacity:
+		peak = capacity
+	case PeakFree:
+		peak = int64(float64(average) * c.Ratio)
+		if free < peak {
+			peak = free
+		}
+	default:
+		peak = int64(float64(average) * c.Ratio)
+	}
+	if peak < average {
+		return average
+	}
+	return peak
+}
+
+// Burst returns the token bucket size in bytes for peak in unit
+func (c *PeakConfig) Burst(peak int64, unit Unit) int64 {
+	return int64(math.Ceil(float64(peak) * float64(unit) / 8 * c.BurstSeconds))
+}
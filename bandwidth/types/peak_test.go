@@ -0,0 +1,73 @@
+package types
+
+import (
+	"testing"
+
+	resourcetypes "github.com/projecteru2/core/resource/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPeakConfig(t *testing.T) {
+	c := &DefaultConfig().Peak
+	assert.Nil(t, c.Validate())
+	assert.Equal(t, PeakRatio, c.Mode)
+	assert.Equal(t, int64(20), c.Peak(10, 100, 100))
+
+	c.Mode = PeakExtra
+	c.Extra = 5
+	assert.Equal(t, int64(15), c.Peak(10, 100, 100))
+
+	c.Mode = PeakCapacity
+	assert.Equal(t, int64(100), c.Peak(10, 100, 0))
+	// never less than average
+	assert.Equal(t, int64(10), c.Peak(10, 0, 0))
+
+	c.Mode = PeakFree
+	assert.Equal(t, int64(20), c.Peak(10, 100, 50))
+	assert.Equal(t, int64(15), c.Peak(10, 100, 15))
+	assert.Equal(t, int64(10), c.Peak(10, 100, 0))
+
+	// 8000bps for 0.1s
+	assert.Equal(t, int64(100), c.Burst(8000, BitUnit))
+	assert.Equal(t, int64(1250000), c.Burst(100, Unit(1e6)))
+
+	for _, invalid := range []PeakConfig{
+		{Mode: "xxx", Ratio: 2},
+		{Mode: PeakRatio, Ratio: 0.5},
+		{Mode: PeakExtra, Ratio: 1, Extra: -1},
+		{Mode: PeakRatio, Ratio: 1, BurstSeconds: -1},
+	} {
+		assert.ErrorIs(t, invalid.Validate(), ErrInvalidConfig)
+	}
+}
+
+func TestWorkloadResourceRequestPeak(t *testing.T) {
+	req := &WorkloadResourceRequest{}
+	assert.Nil(t, req.Parse(resourcetypes.RawParams{"bandwidth": 10, "peak": 30, "egress_peak": 20}, BitUnit))
+	assert.Nil(t, req.Validate())
+	assert.Equal(t, int64(30), req.IngressPeak)
+	assert.Equal(t, int64(20), req.EgressPeak)
+
+	wr := req.WorkloadResource("")
+	wr1 := &WorkloadResource{}
+	assert.Nil(t, wr1.Parse(wr.AsRawParams(), BitUnit))
+	assert.Equal(t, wr, wr1)
+
+	req = &WorkloadResourceRequest{}
+	assert.Nil(t, req.Parse(resourcetypes.RawParams{"bandwidth": 10, "peak": 5}, BitUnit))
+	assert.ErrorIs(t, req.Validate(), ErrInvalidBandwidth)
+
+	// percentage of capacity
+	req = &WorkloadResourceRequest{}
+	assert.Nil(t, req.Parse(resourcetypes.RawParams{"bandwidth": 10, "peak": "50%"}, BitUnit))
+	assert.Nil(t, req.Resolve(NewNodeResource(100, 200)))
+	assert.Equal(t, int64(50), req.IngressPeak)
+	assert.Equal(t, int64(100), req.EgressPeak)
+
+	// realloc changes peaks by delta
+	req = &WorkloadResourceRequest{Ingress: 10, IngressPeak: 10}
+	req.MergeFromResource(wr)
+	assert.Equal(t, int64(40), req.IngressPeak)
+	assert.Equal(t, int64(20), req.EgressPeak)
+	assert.Nil(t, req.Validate())
+}
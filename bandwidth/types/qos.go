@@ -0,0 +1,28 @@
+package types
+
+// QoS classes of workloads
+const (
+	// QoSGuaranteed workloads reserve their peaks, so they never borrow
+	QoSGuaranteed = "guaranteed"
+	// QoSBurstable workloads reserve their averages and may borrow up to their peaks, it's the default
+	QoSBurstable = "burstable"
+	// QoSBestEffort workloads reserve nothing and only get a share of the leftover
+	QoSBestEffort = "besteffort"
+)
+
+func validateQoS(qos string) error {
+	switch qos {
+	case "", QoSGuaranteed, QoSBurstable, QoSBestEffort:
+		return nil
+	default:
+		return ErrInvalidQoS
+	}
+}
+
+// qosClass returns the class of qos, empty means burstable
+func qosClass(qos string) string {
+	if qos == "" {
+		return QoSBurstable
+	}
+	return qos
+}
@@ -0,0 +1,56 @@
+package types
+
+import "github.com/cockroachdb/errors"
+
+// renderers of engine params
+const (
+	// RenderGeneric is the ingress and egress limits as they are, in the unit of the plugin
+	RenderGeneric = "generic"
+	// RenderLibvirt is the bandwidth element of libvirt interfaces, in KiB/s and KiB
+	RenderLibvirt = "libvirt"
+	// RenderCNI is the generic form with the runtimeConfig of the CNI bandwidth plugin and the pod annotations added
+	RenderCNI = "cni"
+	// RenderOVS is the interface policing and linux-htb QoS records of an OVS port, with ovs-vsctl commands,
+	// the cleanup commands go first when a workload is reallocated
+	RenderOVS = "ovs"
+)
+
+var renderers = map[string]bool{
+	RenderGeneric: true,
+	RenderLibvirt: true,
+	RenderCNI:     true,
+	RenderOVS:     true,
+}
+
+// RenderConfig decides the form of engine params returned to core
+type RenderConfig struct {
+	// Renderer is for engines not in Engines
+	Renderer string `yaml:"renderer" json:"renderer" default:"generic"`
+	// Engines maps the engine type of a request to a renderer, like `virt: libvirt`
+	Engines map[string]string `yaml:"engines" json:"engines"`
+}
+
+// Validate .
+func (c *RenderConfig) Validate() error {
+	if !renderers[c.Renderer] {
+		return errors.Wrapf(ErrInvalidConfig, "unknown renderer %q", c.Renderer)
+	}
+	for engine, renderer := range c.Engines {
+		if !renderers[renderer] {
+			return errors.Wrapf(ErrInvalidConfig, "unknown renderer %q of engine %q", renderer, engine)
+		}
+	}
+	return nil
+}
+
+// RendererOf returns the renderer of engine, a renderer name is taken as is,
+// unknown and empty engines get the default one
+func (c *RenderConfig) RendererOf(engine string) string {
+	if renderer, ok := c.Engines[engine]; ok {
+		return renderer
+	}
+	if renderers[engine] {
+		return engine
+	}
+	return c.Renderer
+}
@@ -0,0 +1,23 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderConfig(t *testing.T) {
+	c := &RenderConfig{Renderer: RenderGeneric, Engines: map[string]string{"virt": RenderLibvirt}}
+	assert.Nil(t, c.Validate())
+	assert.Equal(t, RenderLibvirt, c.RendererOf("virt"))
+	assert.Equal(t, RenderLibvirt, c.RendererOf(RenderLibvirt))
+	assert.Equal(t, RenderCNI, c.RendererOf(RenderCNI))
+	assert.Equal(t, RenderOVS, c.RendererOf(RenderOVS))
+	assert.Equal(t, RenderGeneric, c.RendererOf("docker"))
+	assert.Equal(t, RenderGeneric, c.RendererOf(""))
+
+	c.Engines["systemd"] = "unit"
+	assert.ErrorIs(t, c.Validate(), ErrInvalidConfig)
+	c = &RenderConfig{Renderer: "xml"}
+	assert.ErrorIs(t, c.Validate(), ErrInvalidConfig)
+}
@@ -0,0 +1,248 @@
+package types
+
+import (
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	units "github.com/docker/go-units"
+	resourcetypes "github.com/projecteru2/core/resource/types"
+)
+
+// DefaultUnit is the canonical unit used when none is configured
+const DefaultUnit = "bps"
+
+// Unit is the canonical unit of bandwidths, its value is the number of bits per second of one unit
+type Unit float64
+
+// BitUnit is the unit of DefaultUnit
+const BitUnit Unit = 1
+
+// ParseUnit parses the name of a unit such as "Mbps", empty name means DefaultUnit
+func ParseUnit(name string) (Unit, error) {
+	if name == "" {
+		name = DefaultUnit
+	}
+	bits, ok, err := parseBits("1" + name)
+	if err != nil {
+		return 0, err
+	}
+	if !ok || bits <= 0 {
+		return 0, errors.Wrapf(ErrInvalidBandwidth, "invalid unit %q", name)
+	}
+	return Unit(bits), nil
+}
+
+// BitsPerSecond converts n in unit u to bits per second, values beyond int64 are clamped
+func (u Unit) BitsPerSecond(n int64) int64 {
+	bits := math.Round(float64(n) * float64(u))
+	if bits >= math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return int64(bits)
+}
+
+// ParseBandwidth parses strings such as "100Mbps", "1.5Gbit" or "200MB/s" into unit u,
+// numbers without unit are already in u
+func (u Unit) ParseBandwidth(s string) (int64, error) {
+	bits, ok, err := parseBits(s)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		// integers are exact, floats lose precision beyond 2^53
+		if n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64); err == nil {
+			return n, nil
+		}
+		return roundBandwidth(bits, s)
+	}
+	return roundBandwidth(bits/float64(u), s)
+}
+
+// roundBandwidth rounds f to int64, f out of the range of int64 is an overflow
+func roundBandwidth(f float64, s string) (int64, error) {
+	f = math.Round(f)
+	// float64(math.MaxInt64) is 2^63 which is already out of range
+	if f >= math.MaxInt64 || f < math.MinInt64 {
+		return 0, errors.Wrapf(ErrBandwidthOverflow, "bandwidth %q is out of range", s)
+	}
+	return int64(f), nil
+}
+
+// parseBits returns bits per second of s, or the plain number and false if s has no unit
+func parseBits(s string) (float64, bool, error) {
+	s = strings.TrimSpace(s)
+	sign := 1.0
+	if strings.HasPrefix(s, "-") {
+		sign, s = -1, strings.TrimSpace(s[1:])
+	}
+
+	bytes := false
+	size := strings.TrimSuffix(s, "/s")
+	perSecond := size != s
+	switch {
+	case !perSecond && strings.HasSuffix(size, "bps"):
+		size = strings.TrimSuffix(size, "bps")
+	case !perSecond && strings.HasSuffix(size, "Bps"):
+		size, bytes = strings.TrimSuffix(size, "Bps"), true
+	case strings.HasSuffix(size, "bits"):
+		size = strings.TrimSuffix(size, "bits")
+	case strings.HasSuffix(size, "bit"):
+		size = strings.TrimSuffix(size, "bit")
+	case strings.HasSuffix(size, "B"):
+		size, bytes = strings.TrimSuffix(size, "B"), true
+	case strings.HasSuffix(size, "b"):
+		size = strings.TrimSuffix(size, "b")
+	case !perSecond:
+		n, err := strconv.ParseFloat(size, 64)
+		if err != nil {
+			return 0, false, errors.Wrapf(ErrInvalidBandwidth, "invalid bandwidth %q", s)
+		}
+		return sign * n, false, nil
+	default:
+		return 0, false, errors.Wrapf(ErrInvalidBandwidth, "invalid bandwidth %q", s)
+	}
+
+	var n int64
+	var err error
+	if strings.HasSuffix(size, "i") {
+		// binary prefixes, go-units only accepts them with a trailing B
+		n, err = units.RAMInBytes(size + "B")
+	} else {
+		n, err = units.FromHumanSize(size)
+	}
+	if err != nil {
+		return 0, false, errors.Wrapf(ErrInvalidBandwidth, "invalid bandwidth %q: %v", s, err)
+	}
+	bits := float64(n)
+	if bytes {
+		bits *= 8
+	}
+	return sign * bits, true, nil
+}
+
+// parsePercent returns the percentage of s, false if s is not a percentage at all
+func parsePercent(s string) (float64, bool, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasSuffix(s, "%") {
+		return 0, false, nil
+	}
+	p, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(s, "%")), 64)
+	if err != nil {
+		return 0, true, errors.Wrapf(ErrInvalidBandwidth, "invalid percentage %q", s)
+	}
+	return p, true, nil
+}
+
+// bandwidthHook decodes bandwidth strings into unit u, json.Number is a string as well
+func (u Unit) bandwidthHook(from reflect.Type, to reflect.Type, data any) (any, error) {
+	if from.Kind() != reflect.String || to.Kind() != reflect.Int64 {
+		return data, nil
+	}
+	s := reflect.ValueOf(data).String()
+	if _, ok, _ := parsePercent(s); ok {
+		return nil, errors.Wrapf(ErrInvalidBandwidth, "percentage %q is not allowed here", s)
+	}
+	return u.ParseBandwidth(s)
+}
+
+// normalize returns a copy of rawParams with bandwidth strings converted to numbers in unit u,
+// percentages are kept for resolving later
+func (u Unit) normalize(rawParams resourcetypes.RawParams) (resourcetypes.RawParams, error) {
+	var err error
+	params := walkBandwidth(rawParams, nil, func(value any, _ func() int64) any {
+		s, ok := value.(string)
+		if !ok {
+			return value
+		}
+		if _, ok, _ := parsePercent(s); ok {
+			return value
+		}
+		n, e := u.ParseBandwidth(s)
+		if e != nil {
+			err = e
+			return value
+		}
+		return n
+	})
+	return params, err
+}
+
+// hasPercents returns true if any bandwidth in rawParams is a percentage
+func hasPercents(rawParams resourcetypes.RawParams) bool {
+	found := false
+	walkBandwidth(rawParams, nil, func(value any, _ func() int64) any {
+		if s, ok := value.(string); ok {
+			if _, ok, _ := parsePercent(s); ok {
+				found = true
+			}
+		}
+		return value
+	})
+	return found
+}
+
+// resolvePercents returns a copy of rawParams with percentages replaced by the share of base,
+// bandwidth of NICs is resolved against the same NIC of base
+func resolvePercents(rawParams resourcetypes.RawParams, base *NodeResource) (resourcetypes.RawParams, error) {
+	var err error
+	params := walkBandwidth(rawParams, base, func(value any, baseValue func() int64) any {
+		s, ok := value.(string)
+		if !ok {
+			return value
+		}
+		p, ok, e := parsePercent(s)
+		if !ok {
+			return value
+		}
+		if e != nil {
+			err = e
+			return value
+		}
+		n, e := roundBandwidth(float64(baseValue())*p/100, s)
+		if e != nil {
+			err = e
+			return value
+		}
+		return n
+	})
+	return params, err
+}
+
+// walkBandwidth returns a copy of rawParams with symmetric keys expanded and each bandwidth value replaced by fn,
+// fn gets the matched value of base, nil base means zero
+func walkBandwidth(rawParams resourcetypes.RawParams, base *NodeResource, fn func(value any, baseValue func() int64) any) resourcetypes.RawParams {
+	nodeBase := base
+	if nodeBase == nil {
+		nodeBase = NewNodeResource(0, 0)
+	}
+	walk := func(params resourcetypes.RawParams, base *NodeResource) resourcetypes.RawParams {
+		params = expandSymmetricKeys(params)
+		for key, baseValue := range map[string]func() int64{
+			"ingress":      func() int64 { return base.Ingress },
+			"egress":       func() int64 { return base.Egress },
+			"ingress_peak": func() int64 { return base.Ingress },
+			"egress_peak":  func() int64 { return base.Egress },
+		} {
+			if params.IsSet(key) {
+				params[key] = fn(params[key], baseValue)
+			}
+		}
+		return params
+	}
+
+	params := walk(rawParams, nodeBase)
+	if nics, ok := params["nics"].(map[string]any); ok {
+		walked := map[string]any{}
+		for name, nic := range nics {
+			if m, ok := nic.(map[string]any); ok {
+				nic = map[string]any(walk(m, nodeBase.NIC(name)))
+			}
+			walked[name] = nic
+		}
+		params["nics"] = walked
+	}
+	return params
+}
@@ -0,0 +1,154 @@
+package types
+
+import (
+	"math"
+	"testing"
+
+	resourcetypes "github.com/projecteru2/core/resource/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseBandwidth(t *testing.T) {
+	cases := map[string]int64{
+		"100":       100,
+		"-100":      -100,
+		"100bps":    100,
+		"100Mbps":   100000000,
+		"100 Mbps":  100000000,
+		"10kbps":    10000,
+		"1.5Gbit":   1500000000,
+		"1.5Gbits":  1500000000,
+		"10Mbit/s":  10000000,
+		"10Mb/s":    10000000,
+		"200MB/s":   1600000000,
+		"200MBps":   1600000000,
+		"1MiB/s":    8388608,
+		"1Mibps":    1048576,
+		"-10Mbps":   -10000000,
+		"1B/s":      8,
+		"1Gbit/s":   1000000000,
+		"0.5Kbit/s": 500,
+	}
+	for s, expected := range cases {
+		n, err := BitUnit.ParseBandwidth(s)
+		assert.Nil(t, err, s)
+		assert.Equal(t, expected, n, s)
+	}
+
+	for _, s := range []string{"", "abc", "100M", "100Xbps", "Mbps", "10%", "100/s"} {
+		_, err := BitUnit.ParseBandwidth(s)
+		assert.ErrorIs(t, err, ErrInvalidBandwidth, s)
+	}
+
+	// integers are exact up to the range of int64
+	n, err := BitUnit.ParseBandwidth("9223372036854775807")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(math.MaxInt64), n)
+	for _, s := range []string{"9223372036854775808", "1e19"} {
+		_, err := BitUnit.ParseBandwidth(s)
+		assert.ErrorIs(t, err, ErrBandwidthOverflow, s)
+	}
+
+	// canonical unit
+	unit, err := ParseUnit("Mbps")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3000000), unit.BitsPerSecond(3))
+	assert.Equal(t, int64(math.MaxInt64), unit.BitsPerSecond(math.MaxInt64))
+	n, err = unit.ParseBandwidth("1Gbps")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1000), n)
+	n, err = unit.ParseBandwidth("1000")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1000), n)
+	unit, err = ParseUnit("KB/s")
+	assert.Nil(t, err)
+	n, err = unit.ParseBandwidth("8Mbps")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1000), n)
+
+	for _, name := range []string{"M", "xx", "-1bps", "%"} {
+		_, err := ParseUnit(name)
+		assert.ErrorIs(t, err, ErrInvalidBandwidth, name)
+	}
+	unit, err = ParseUnit("")
+	assert.Nil(t, err)
+	assert.Equal(t, BitUnit, unit)
+}
+
+func TestParseUnits(t *testing.T) {
+	req := &WorkloadResourceRequest{}
+	assert.Nil(t, req.Parse(resourcetypes.RawParams{"bandwidth": "10Mbps", "egress": "1MB/s"}, BitUnit))
+	assert.False(t, req.IsRelative())
+	assert.Equal(t, int64(10000000), req.Ingress)
+	assert.Equal(t, int64(8000000), req.Egress)
+
+	assert.ErrorIs(t, req.Parse(resourcetypes.RawParams{"bandwidth": "10Xbps"}, BitUnit), ErrInvalidBandwidth)
+
+	// bandwidths besides percentages are in the unit before resolving
+	assert.Nil(t, req.Parse(resourcetypes.RawParams{"ingress": "10%", "egress": "2Mbps"}, Unit(1e6)))
+	assert.Nil(t, req.Resolve(NewNodeResource(1000, 1000)))
+	assert.Equal(t, int64(100), req.Ingress)
+	assert.Equal(t, int64(2), req.Egress)
+
+	n := &NodeResource{}
+	assert.Nil(t, n.Parse(resourcetypes.RawParams{
+		"nics": map[string]any{
+			"eth0": map[string]any{"bandwidth": "1Gbps"},
+			"eth1": map[string]any{"ingress": "100Mbps", "egress": 100},
+		},
+	}, BitUnit))
+	assert.Equal(t, int64(1100000000), n.Ingress)
+	assert.Equal(t, int64(1000000100), n.Egress)
+
+	// percentages are not allowed in workload resource
+	wr := &WorkloadResource{}
+	assert.ErrorIs(t, wr.Parse(resourcetypes.RawParams{"bandwidth": "10%"}, BitUnit), ErrInvalidBandwidth)
+}
+
+func TestResolvePercents(t *testing.T) {
+	capacity := &NodeResource{
+		Ingress: 1000,
+		Egress:  500,
+		NICs: NICs{
+			"eth0": {Ingress: 600, Egress: 300},
+			"eth1": {Ingress: 400, Egress: 200},
+		},
+	}
+
+	req := &WorkloadResourceRequest{}
+	assert.Nil(t, req.Parse(resourcetypes.RawParams{"bandwidth": "10%", "nic": "eth0"}, BitUnit))
+	assert.True(t, req.IsRelative())
+	assert.Zero(t, req.Ingress)
+	cp := req.DeepCopy()
+	assert.Nil(t, req.Resolve(capacity.NIC(req.NIC)))
+	assert.False(t, req.IsRelative())
+	assert.Equal(t, int64(60), req.Ingress)
+	assert.Equal(t, int64(30), req.Egress)
+	assert.Equal(t, "eth0", req.NIC)
+	assert.Nil(t, cp.Resolve(capacity))
+	assert.Equal(t, int64(100), cp.Ingress)
+	assert.Equal(t, int64(50), cp.Egress)
+
+	assert.ErrorIs(t, req.Parse(resourcetypes.RawParams{"bandwidth": "x%"}, BitUnit), ErrInvalidBandwidth)
+
+	nodeReq := &NodeResourceRequest{}
+	assert.Nil(t, nodeReq.Parse(resourcetypes.RawParams{
+		"nics": map[string]any{
+			"eth0": map[string]any{"bandwidth": "50%"},
+			"eth1": map[string]any{"ingress": "-10%", "egress": 20},
+		},
+	}, BitUnit))
+	assert.True(t, nodeReq.IsRelative())
+	assert.Nil(t, nodeReq.Resolve(capacity))
+	assert.Equal(t, NewNodeResource(300, 150), nodeReq.NodeResource().NIC("eth0"))
+	assert.Equal(t, NewNodeResource(-40, 20), nodeReq.NodeResource().NIC("eth1"))
+	// totals follow the resolved NICs
+	assert.Equal(t, int64(260), nodeReq.Ingress)
+	assert.Equal(t, int64(170), nodeReq.Egress)
+
+	usage := &NodeResource{}
+	assert.Nil(t, usage.Parse(resourcetypes.RawParams{"ingress": "20%", "egress": 10}, BitUnit))
+	assert.True(t, usage.IsRelative())
+	assert.Nil(t, usage.Resolve(capacity))
+	assert.Equal(t, NewNodeResource(200, 10), usage)
+}
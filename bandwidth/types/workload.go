@@ -1,78 +1,296 @@
 package types
 
 import (
-	"github.com/mitchellh/mapstructure"
+	"time"
+
+	"github.com/cockroachdb/errors"
 	resourcetypes "github.com/projecteru2/core/resource/types"
 )
 
 // WorkloadResource indicate Bandwidth workload resource
 type WorkloadResource struct {
-	Bandwidth int64 `json:"bandwidth" mapstructure:"bandwidth"`
+	// ID is the allocation ID which keys the workload in the ledger of the node, empty means untracked
+	ID      string `json:"id,omitempty" mapstructure:"id"`
+	Ingress int64  `json:"ingress" mapstructure:"ingress"`
+	Egress  int64  `json:"egress" mapstructure:"egress"`
+	NIC     string `json:"nic,omitempty" mapstructure:"nic"`
+	// Weight is the share of spare bandwidth when remapping, 0 means in proportion to the guaranteed bandwidth
+	Weight float64 `json:"weight,omitempty" mapstructure:"weight"`
+	// IngressPeak and EgressPeak are explicit peaks, 0 means peaks follow the policy
+	IngressPeak int64 `json:"ingress_peak,omitempty" mapstructure:"ingress_peak"`
+	EgressPeak  int64 `json:"egress_peak,omitempty" mapstructure:"egress_peak"`
+	// QoS is the class of the workload, empty means burstable
+	QoS string `json:"qos,omitempty" mapstructure:"qos"`
+	// Engine is the engine type of the workload, engine params are rendered in its form
+	Engine string `json:"engine,omitempty" mapstructure:"engine"`
+	// OperationID names the calculation which issued the resource, core's retries of setting usage with it are applied once
+	OperationID string `json:"operation_id,omitempty" mapstructure:"operation_id"`
 }
 
 func (w *WorkloadResource) AsRawParams() resourcetypes.RawParams {
-	return resourcetypes.RawParams{
-		"bandwidth": w.Bandwidth,
+	rawParams := resourcetypes.RawParams{
+		"ingress": w.Ingress,
+		"egress":  w.Egress,
+	}
+	if w.ID != "" {
+		rawParams["id"] = w.ID
+	}
+	if w.NIC != "" {
+		rawParams["nic"] = w.NIC
+	}
+	if w.Weight > 0 {
+		rawParams["weight"] = w.Weight
+	}
+	if w.IngressPeak != 0 {
+		rawParams["ingress_peak"] = w.IngressPeak
+	}
+	if w.EgressPeak != 0 {
+		rawParams["egress_peak"] = w.EgressPeak
+	}
+	if w.QoS != "" {
+		rawParams["qos"] = w.QoS
 	}
+	if w.Engine != "" {
+		rawParams["engine"] = w.Engine
+	}
+	if w.OperationID != "" {
+		rawParams["operation_id"] = w.OperationID
+	}
+	return rawParams
 }
 func (w *WorkloadResource) Validate() error {
-	if w.Bandwidth < 0 {
+	if w.Ingress < 0 || w.Egress < 0 || w.Weight < 0 {
 		return ErrInvalidBandwidth
 	}
-	return nil
+	return validateQoS(w.QoS)
 }
 
 // ParseFromRawParams .
-func (w *WorkloadResource) Parse(rawParams resourcetypes.RawParams) error {
-	return mapstructure.Decode(rawParams, w)
+func (w *WorkloadResource) Parse(rawParams resourcetypes.RawParams, unit Unit) error {
+	return decode(rawParams, w, unit)
 }
 
 // DeepCopy .
 func (w *WorkloadResource) DeepCopy() *WorkloadResource {
 	res := &WorkloadResource{
-		Bandwidth: w.Bandwidth,
+		ID:      w.ID,
+		Ingress: w.Ingress,
+		Egress:  w.Egress,
+		NIC:     w.NIC,
+		Weight:  w.Weight,
+
+		IngressPeak: w.IngressPeak,
+		EgressPeak:  w.EgressPeak,
+		QoS:         w.QoS,
+		Engine:      w.Engine,
+		OperationID: w.OperationID,
 	}
 	return res
 }
 
 // Add .
 func (w *WorkloadResource) Add(w1 *WorkloadResource) {
-	w.Bandwidth += w1.Bandwidth
+	w.Ingress += w1.Ingress
+	w.Egress += w1.Egress
+	w.IngressPeak += w1.IngressPeak
+	w.EgressPeak += w1.EgressPeak
 }
 
 // Sub .
 func (w *WorkloadResource) Sub(w1 *WorkloadResource) {
-	w.Bandwidth -= w1.Bandwidth
+	w.Ingress -= w1.Ingress
+	w.Egress -= w1.Egress
+	w.IngressPeak -= w1.IngressPeak
+	w.EgressPeak -= w1.EgressPeak
+}
+
+// AddChecked adds w1 like Add, bandwidths out of range are clamped and returned
+func (w *WorkloadResource) AddChecked(w1 *WorkloadResource) []*RangeError {
+	return w.addChecked(w1, 1)
+}
+
+// SubChecked subtracts w1 like Sub, bandwidths out of range are clamped and returned
+func (w *WorkloadResource) SubChecked(w1 *WorkloadResource) []*RangeError {
+	return w.addChecked(w1, -1)
+}
+
+func (w *WorkloadResource) addChecked(w1 *WorkloadResource, sign int64) []*RangeError {
+	return rangeErrors(
+		checkedAdd("ingress", &w.Ingress, sign*w1.Ingress),
+		checkedAdd("egress", &w.Egress, sign*w1.Egress),
+		checkedAdd("ingress_peak", &w.IngressPeak, sign*w1.IngressPeak),
+		checkedAdd("egress_peak", &w.EgressPeak, sign*w1.EgressPeak),
+	)
+}
+
+// IsZero returns true if the workload has no bandwidth at all
+func (w *WorkloadResource) IsZero() bool {
+	return w.Ingress == 0 && w.Egress == 0 && w.IngressPeak == 0 && w.EgressPeak == 0
+}
+
+// Weights returns the share weights of spare ingress and egress bandwidth
+func (w *WorkloadResource) Weights() (float64, float64) {
+	if w.Weight > 0 {
+		return w.Weight, w.Weight
+	}
+	return float64(w.Ingress), float64(w.Egress)
+}
+
+// QoSClass returns the QoS class, empty means burstable
+func (w *WorkloadResource) QoSClass() string {
+	return qosClass(w.QoS)
+}
+
+// NodeResource returns the node resource reserved by the workload:
+// peaks for guaranteed, averages for burstable and nothing for best effort
+func (w *WorkloadResource) NodeResource() *NodeResource {
+	var res *NodeResource
+	switch w.QoSClass() {
+	case QoSGuaranteed:
+		res = NewNodeResource(w.IngressPeak, w.EgressPeak)
+	case QoSBestEffort:
+		return NewNodeResource(0, 0)
+	default:
+		res = NewNodeResource(w.Ingress, w.Egress)
+	}
+	if w.NIC != "" {
+		res.NICs = NICs{w.NIC: {Ingress: res.Ingress, Egress: res.Egress}}
+	}
+	return res
 }
 
 // WorkloadResourceRaw includes all possible fields passed by eru-core for editing workload
 // for request calculation
 type WorkloadResourceRequest struct {
-	Bandwidth int64 `json:"bandwidth" mapstructure:"bandwidth"`
+	Ingress int64 `json:"ingress" mapstructure:"ingress"`
+	Egress  int64 `json:"egress" mapstructure:"egress"`
+	// NIC and NICLabel pin the workload to NICs by name or by label
+	NIC      string `json:"nic" mapstructure:"nic"`
+	NICLabel string `json:"nic_label" mapstructure:"nic_label"`
+	// Weight is the share of spare bandwidth when remapping
+	Weight float64 `json:"weight" mapstructure:"weight"`
+	// IngressPeak and EgressPeak are explicit peaks, they can't be less than the averages
+	IngressPeak int64 `json:"ingress_peak" mapstructure:"ingress_peak"`
+	EgressPeak  int64 `json:"egress_peak" mapstructure:"egress_peak"`
+	// QoS is the class of the workload, empty means burstable
+	QoS string `json:"qos" mapstructure:"qos"`
+	// Hold places a hold of the calculated workloads on the node for this long, like "30s" or 30
+	Hold time.Duration `json:"hold" mapstructure:"hold"`
+	// Engine is the engine type of the workloads, like "virt", render.engines maps it to the form of engine params
+	Engine string `json:"engine" mapstructure:"engine"`
+	// relative keeps the raw params with percentages until they are resolved
+	relative resourcetypes.RawParams
 }
 
 // Validate .
 func (w *WorkloadResourceRequest) Validate() error {
-	if w.Bandwidth < 0 {
+	if w.Ingress < 0 || w.Egress < 0 || w.Weight < 0 || w.IngressPeak < 0 || w.EgressPeak < 0 {
 		return ErrInvalidBandwidth
 	}
-	return nil
+	if w.Hold < 0 {
+		return errors.Wrapf(ErrInvalidDuration, "hold can't be negative, got %v", w.Hold)
+	}
+	if (w.IngressPeak > 0 && w.IngressPeak < w.Ingress) || (w.EgressPeak > 0 && w.EgressPeak < w.Egress) {
+		return errors.Wrapf(ErrInvalidBandwidth, "peak %d/%d is less than average %d/%d", w.IngressPeak, w.EgressPeak, w.Ingress, w.Egress)
+	}
+	return validateQoS(w.QoS)
 }
 
 // Parse .
-func (w *WorkloadResourceRequest) Parse(rawParams resourcetypes.RawParams) (err error) {
-	return mapstructure.Decode(rawParams, w)
+func (w *WorkloadResourceRequest) Parse(rawParams resourcetypes.RawParams, unit Unit) (err error) {
+	w.relative, err = decodeRelative(rawParams, w, unit)
+	return err
+}
+
+// QoSClass returns the QoS class, empty means burstable
+func (w *WorkloadResourceRequest) QoSClass() string {
+	return qosClass(w.QoS)
+}
+
+// IsRelative returns true if some bandwidth is a percentage which is not resolved yet
+func (w *WorkloadResourceRequest) IsRelative() bool {
+	return w.relative != nil
+}
+
+// Resolve resolves percentages against base, which is the capacity of the pinned NIC or the whole node
+func (w *WorkloadResourceRequest) Resolve(base *NodeResource) error {
+	if w.relative == nil {
+		return nil
+	}
+	rawParams, err := resolvePercents(w.relative, base)
+	if err != nil {
+		return err
+	}
+	*w = WorkloadResourceRequest{}
+	return w.Parse(rawParams, BitUnit)
 }
 
 func (w *WorkloadResourceRequest) MergeFromResource(r *WorkloadResource) {
-	w.Bandwidth += r.Bandwidth
-	if w.Bandwidth < 0 {
-		w.Bandwidth = 0
+	if r.NIC != "" {
+		w.NIC = r.NIC
+		w.NICLabel = ""
+	}
+	if w.Weight == 0 {
+		w.Weight = r.Weight
+	}
+	if w.QoS == "" {
+		w.QoS = r.QoS
+	}
+	if w.Engine == "" {
+		w.Engine = r.Engine
+	}
+	w.IngressPeak += r.IngressPeak
+	if w.IngressPeak < 0 {
+		w.IngressPeak = 0
+	}
+	w.EgressPeak += r.EgressPeak
+	if w.EgressPeak < 0 {
+		w.EgressPeak = 0
+	}
+	w.Ingress += r.Ingress
+	if w.Ingress < 0 {
+		w.Ingress = 0
+	}
+	w.Egress += r.Egress
+	if w.Egress < 0 {
+		w.Egress = 0
 	}
 }
 
 func (w *WorkloadResourceRequest) DeepCopy() *WorkloadResourceRequest {
 	return &WorkloadResourceRequest{
-		Bandwidth: w.Bandwidth,
+		Ingress:  w.Ingress,
+		Egress:   w.Egress,
+		NIC:      w.NIC,
+		NICLabel: w.NICLabel,
+		Weight:   w.Weight,
+		relative: w.relative,
+
+		IngressPeak: w.IngressPeak,
+		EgressPeak:  w.EgressPeak,
+		QoS:         w.QoS,
+		Hold:        w.Hold,
+		Engine:      w.Engine,
+	}
+}
+
+// NodeResource returns the node resource reserved by one workload, peaks of guaranteed workloads
+// are only known here if they are explicit
+func (w *WorkloadResourceRequest) NodeResource() *NodeResource {
+	return w.WorkloadResource("").NodeResource()
+}
+
+// WorkloadResource returns the resource of a workload placed on nic
+func (w *WorkloadResourceRequest) WorkloadResource(nic string) *WorkloadResource {
+	return &WorkloadResource{
+		Ingress: w.Ingress,
+		Egress:  w.Egress,
+		NIC:     nic,
+		Weight:  w.Weight,
+
+		IngressPeak: w.IngressPeak,
+		EgressPeak:  w.EgressPeak,
+		QoS:         w.QoS,
+		Engine:      w.Engine,
 	}
 }
@@ -11,14 +11,14 @@ import (
 
 func TestWorkloadResource(t *testing.T) {
 	wr := &WorkloadResource{}
-	err := wr.Parse(nil)
+	err := wr.Parse(nil, BitUnit)
 	assert.Nil(t, err)
 }
 
 func TestWorkloadResourceRequest(t *testing.T) {
 	// empty request
 	req := &WorkloadResourceRequest{}
-	err := req.Parse(nil)
+	err := req.Parse(nil, BitUnit)
 	assert.Nil(t, err)
 	assert.Nil(t, req.Validate())
 
@@ -26,9 +26,10 @@ func TestWorkloadResourceRequest(t *testing.T) {
 		"bandwidth": 100,
 	}
 	req = &WorkloadResourceRequest{}
-	err = req.Parse(params)
+	err = req.Parse(params, BitUnit)
 	assert.Nil(t, err)
-	assert.Equal(t, req.Bandwidth, int64(100))
+	assert.Equal(t, req.Ingress, int64(100))
+	assert.Equal(t, req.Egress, int64(100))
 
 	// invalid request
 	params = resourcetypes.RawParams{
@@ -36,11 +37,27 @@ func TestWorkloadResourceRequest(t *testing.T) {
 	}
 
 	req = &WorkloadResourceRequest{}
-	err = req.Parse(params)
+	err = req.Parse(params, BitUnit)
 	assert.Nil(t, err)
 	assert.Error(t, req.Validate())
 }
 
+func TestWorkloadResourceRequestDirections(t *testing.T) {
+	req := &WorkloadResourceRequest{}
+	err := req.Parse(resourcetypes.RawParams{
+		"ingress": 100,
+		"egress":  10,
+	}, BitUnit)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(100), req.Ingress)
+	assert.Equal(t, int64(10), req.Egress)
+
+	req.MergeFromResource(&WorkloadResource{Ingress: -200, Egress: 10})
+	assert.Equal(t, int64(0), req.Ingress)
+	assert.Equal(t, int64(20), req.Egress)
+	assert.Equal(t, NewNodeResource(0, 20), req.NodeResource())
+}
+
 func TestJsonLoad(t *testing.T) {
 	j1 := `
 {
@@ -51,12 +68,77 @@ func TestJsonLoad(t *testing.T) {
 	err := json.Unmarshal([]byte(j1), &obj)
 	assert.Nil(t, err)
 	req := &WorkloadResourceRequest{}
-	err = req.Parse(obj)
+	err = req.Parse(obj, BitUnit)
 	assert.Nil(t, err)
-	assert.Equal(t, req.Bandwidth, int64(100))
+	assert.Equal(t, req.Ingress, int64(100))
+	assert.Equal(t, req.Egress, int64(100))
 
 	res := &WorkloadResource{}
-	err = res.Parse(obj)
+	err = res.Parse(obj, BitUnit)
 	assert.Nil(t, err)
-	assert.Equal(t, res.Bandwidth, int64(100))
+	assert.Equal(t, res.Ingress, int64(100))
+	assert.Equal(t, res.Egress, int64(100))
+}
+
+func TestWorkloadResourceWeight(t *testing.T) {
+	wr := &WorkloadResource{}
+	assert.Nil(t, wr.Parse(resourcetypes.RawParams{"ingress": 10, "egress": 20}, BitUnit))
+	ingressWeight, egressWeight := wr.Weights()
+	assert.Equal(t, float64(10), ingressWeight)
+	assert.Equal(t, float64(20), egressWeight)
+	assert.NotContains(t, wr.AsRawParams(), "weight")
+
+	req := &WorkloadResourceRequest{}
+	assert.Nil(t, req.Parse(resourcetypes.RawParams{"bandwidth": 10, "weight": 2.5}, BitUnit))
+	wr = req.WorkloadResource("")
+	ingressWeight, egressWeight = wr.Weights()
+	assert.Equal(t, 2.5, ingressWeight)
+	assert.Equal(t, 2.5, egressWeight)
+
+	wr1 := &WorkloadResource{}
+	assert.Nil(t, wr1.Parse(wr.AsRawParams(), BitUnit))
+	assert.Equal(t, wr, wr1)
+
+	// realloc keeps the weight unless a new one is given
+	req = &WorkloadResourceRequest{}
+	req.MergeFromResource(wr)
+	assert.Equal(t, 2.5, req.Weight)
+	req = &WorkloadResourceRequest{Weight: 1}
+	req.MergeFromResource(wr)
+	assert.Equal(t, float64(1), req.Weight)
+
+	req.Weight = -1
+	assert.ErrorIs(t, req.Validate(), ErrInvalidBandwidth)
+}
+
+func TestWorkloadResourceQoS(t *testing.T) {
+	wr := &WorkloadResource{Ingress: 10, Egress: 20, IngressPeak: 30, EgressPeak: 40, NIC: "eth0"}
+	assert.Equal(t, QoSBurstable, wr.QoSClass())
+	assert.Equal(t, &NodeResource{Ingress: 10, Egress: 20, NICs: NICs{"eth0": {Ingress: 10, Egress: 20}}}, wr.NodeResource())
+
+	wr.QoS = QoSGuaranteed
+	assert.Equal(t, &NodeResource{Ingress: 30, Egress: 40, NICs: NICs{"eth0": {Ingress: 30, Egress: 40}}}, wr.NodeResource())
+
+	wr.QoS = QoSBestEffort
+	assert.Equal(t, NewNodeResource(0, 0), wr.NodeResource())
+	assert.Nil(t, wr.Validate())
+
+	wr1 := &WorkloadResource{}
+	assert.Nil(t, wr1.Parse(wr.AsRawParams(), BitUnit))
+	assert.Equal(t, wr, wr1)
+
+	wr.QoS = "xxx"
+	assert.ErrorIs(t, wr.Validate(), ErrInvalidQoS)
+
+	req := &WorkloadResourceRequest{}
+	assert.Nil(t, req.Parse(resourcetypes.RawParams{"bandwidth": 10, "qos": "guaranteed"}, BitUnit))
+	assert.Nil(t, req.Validate())
+	assert.Equal(t, QoSGuaranteed, req.WorkloadResource("").QoS)
+	req.QoS = "best-effort"
+	assert.ErrorIs(t, req.Validate(), ErrInvalidQoS)
+
+	// realloc keeps the class
+	req = &WorkloadResourceRequest{}
+	req.MergeFromResource(&WorkloadResource{QoS: QoSBestEffort})
+	assert.Equal(t, QoSBestEffort, req.QoS)
 }
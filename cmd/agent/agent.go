@@ -0,0 +1,76 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/projecteru2/core/log"
+	"github.com/urfave/cli/v2"
+	"github.com/yuyang0/resource-bandwidth/bandwidth"
+	"github.com/yuyang0/resource-bandwidth/bandwidth/agent"
+	"github.com/yuyang0/resource-bandwidth/bandwidth/tc"
+	"github.com/yuyang0/resource-bandwidth/cmd"
+)
+
+func Agent() *cli.Command {
+	return &cli.Command{
+		Name:   "agent",
+		Usage:  "run on a node, enforce its allocations with tc and report drift back",
+		Action: run,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "node",
+				Usage: "name of this node, the hostname by default",
+			},
+			&cli.BoolFlag{
+				Name:  "once",
+				Usage: "reconcile once and print the result",
+			},
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "only report the drift and print the commands fixing it, implies --once",
+			},
+		},
+	}
+}
+
+func run(c *cli.Context) error {
+	config, bdConfig, err := cmd.LoadConfig()
+	if err != nil {
+		return cli.Exit(err, 128)
+	}
+	nodename := c.String("node")
+	if nodename == "" {
+		if nodename, err = os.Hostname(); err != nil {
+			return cli.Exit(err, 128)
+		}
+	}
+
+	s, err := bandwidth.NewPlugin(c.Context, config, bdConfig)
+	if err != nil {
+		return cli.Exit(err, 128)
+	}
+	defer func() { _ = s.Close() }()
+	a := agent.New(s, nodename, bdConfig.Agent, tc.NewExecutor())
+
+	if dryRun := c.Bool("dry-run"); dryRun || c.Bool("once") {
+		result, err := a.Reconcile(c.Context, dryRun)
+		if result != nil {
+			o, _ := json.Marshal(result)
+			fmt.Println(string(o))
+		}
+		if err != nil {
+			return cli.Exit(err, 128)
+		}
+		return nil
+	}
+
+	ctx, stop := signal.NotifyContext(c.Context, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	log.WithFunc("resource.bandwidth.agent").Infof(ctx, "enforcing node %s every %s", nodename, bdConfig.Agent.Interval)
+	a.Run(ctx)
+	return nil
+}
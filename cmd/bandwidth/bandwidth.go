@@ -1,6 +1,8 @@
 package bandwidth
 
 import (
+	"context"
+
 	"github.com/yuyang0/resource-bandwidth/bandwidth"
 	"github.com/yuyang0/resource-bandwidth/cmd"
 
@@ -12,12 +14,10 @@ func Name() *cli.Command {
 	return &cli.Command{
 		Name:   "name",
 		Usage:  "show name",
-		Action: name,
+		Action: cmd.Action("name", name),
 	}
 }
 
-func name(c *cli.Context) error {
-	return cmd.Serve(c, func(s *bandwidth.Plugin, _ resourcetypes.RawParams) (interface{}, error) {
-		return s.Name(), nil
-	})
+func name(_ context.Context, s *bandwidth.Plugin, _ resourcetypes.RawParams) (interface{}, error) {
+	return s.Name(), nil
 }
@@ -1,6 +1,8 @@
 package calculate
 
 import (
+	"context"
+
 	"github.com/projecteru2/core/resource/plugins/binary"
 	resourcetypes "github.com/projecteru2/core/resource/types"
 	"github.com/projecteru2/core/types"
@@ -13,19 +15,21 @@ func CalculateDeploy() *cli.Command { //nolint
 	return &cli.Command{
 		Name:   binary.CalculateDeployCommand,
 		Usage:  "calculate deploy plan",
-		Action: calculateDeploy,
+		Action: cmd.Action(binary.CalculateDeployCommand, calculateDeploy),
 	}
 }
 
-func calculateDeploy(c *cli.Context) error {
-	return cmd.Serve(c, func(s *bandwidth.Plugin, in resourcetypes.RawParams) (interface{}, error) {
-		nodename := in.String("nodename")
-		if nodename == "" {
-			return nil, types.ErrEmptyNodeName
-		}
-		deployCount := in.Int("deploy_count")
+func calculateDeploy(ctx context.Context, s *bandwidth.Plugin, in resourcetypes.RawParams) (interface{}, error) {
+	nodename := in.String("nodename")
+	if nodename == "" {
+		return nil, types.ErrEmptyNodeName
+	}
+	deployCount := in.Int("deploy_count")
 
-		workloadResourceRequest := in.RawParams("workload_resource_request")
-		return s.CalculateDeploy(c.Context, nodename, deployCount, workloadResourceRequest)
-	})
+	// engine params are rendered in the form of the engine, unless the request names one
+	if engine := in.String("engine"); engine != "" {
+		ctx = bandwidth.WithEngine(ctx, engine)
+	}
+	workloadResourceRequest := in.RawParams("workload_resource_request")
+	return s.CalculateDeploy(ctx, nodename, deployCount, workloadResourceRequest)
 }
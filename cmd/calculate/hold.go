@@ -0,0 +1,48 @@
+package calculate
+
+import (
+	"context"
+
+	resourcetypes "github.com/projecteru2/core/resource/types"
+	"github.com/projecteru2/core/types"
+	"github.com/urfave/cli/v2"
+	"github.com/yuyang0/resource-bandwidth/bandwidth"
+	"github.com/yuyang0/resource-bandwidth/cmd"
+)
+
+const (
+	commitHoldsCommand  = "commit-holds"
+	releaseHoldsCommand = "release-holds"
+)
+
+func CommitHolds() *cli.Command {
+	return &cli.Command{
+		Name:   commitHoldsCommand,
+		Usage:  "turn holds placed by calculate-deploy into usage",
+		Action: cmd.Action(commitHoldsCommand, commitHolds),
+	}
+}
+
+func ReleaseHolds() *cli.Command {
+	return &cli.Command{
+		Name:   releaseHoldsCommand,
+		Usage:  "release holds placed by calculate-deploy",
+		Action: cmd.Action(releaseHoldsCommand, releaseHolds),
+	}
+}
+
+func commitHolds(ctx context.Context, s *bandwidth.Plugin, in resourcetypes.RawParams) (interface{}, error) {
+	nodename := in.String("nodename")
+	if nodename == "" {
+		return nil, types.ErrEmptyNodeName
+	}
+	return s.CommitHolds(ctx, nodename, in.StringSlice("ids"))
+}
+
+func releaseHolds(ctx context.Context, s *bandwidth.Plugin, in resourcetypes.RawParams) (interface{}, error) {
+	nodename := in.String("nodename")
+	if nodename == "" {
+		return nil, types.ErrEmptyNodeName
+	}
+	return nil, s.ReleaseHolds(ctx, nodename, in.StringSlice("ids"))
+}
@@ -1,6 +1,8 @@
 package calculate
 
 import (
+	"context"
+
 	"github.com/projecteru2/core/resource/plugins/binary"
 	resourcetypes "github.com/projecteru2/core/resource/types"
 	"github.com/projecteru2/core/types"
@@ -13,20 +15,22 @@ func CalculateRealloc() *cli.Command { //nolint
 	return &cli.Command{
 		Name:   binary.CalculateReallocCommand,
 		Usage:  "calculate realloc plan",
-		Action: calculateRealloc,
+		Action: cmd.Action(binary.CalculateReallocCommand, calculateRealloc),
 	}
 }
 
-func calculateRealloc(c *cli.Context) error {
-	return cmd.Serve(c, func(s *bandwidth.Plugin, in resourcetypes.RawParams) (interface{}, error) {
-		nodename := in.String("nodename")
-		if nodename == "" {
-			return nil, types.ErrEmptyNodeName
-		}
+func calculateRealloc(ctx context.Context, s *bandwidth.Plugin, in resourcetypes.RawParams) (interface{}, error) {
+	nodename := in.String("nodename")
+	if nodename == "" {
+		return nil, types.ErrEmptyNodeName
+	}
 
-		workloadResource := in.RawParams("workload_resource")
-		workloadResourceRequest := in.RawParams("workload_resource_request")
+	workloadResource := in.RawParams("workload_resource")
+	workloadResourceRequest := in.RawParams("workload_resource_request")
 
-		return s.CalculateRealloc(c.Context, nodename, workloadResource, workloadResourceRequest)
-	})
+	// engine params are rendered in the form of the engine, unless the request names one
+	if engine := in.String("engine"); engine != "" {
+		ctx = bandwidth.WithEngine(ctx, engine)
+	}
+	return s.CalculateRealloc(ctx, nodename, workloadResource, workloadResourceRequest)
 }
@@ -1,6 +1,8 @@
 package calculate
 
 import (
+	"context"
+
 	"github.com/mitchellh/mapstructure"
 	"github.com/projecteru2/core/resource/plugins/binary"
 	resourcetypes "github.com/projecteru2/core/resource/types"
@@ -14,23 +16,25 @@ func CalculateRemap() *cli.Command { //nolint
 	return &cli.Command{
 		Name:   binary.CalculateRemapCommand,
 		Usage:  "remap resource",
-		Action: calculateRemap,
+		Action: cmd.Action(binary.CalculateRemapCommand, calculateRemap),
 	}
 }
 
-func calculateRemap(c *cli.Context) error {
-	return cmd.Serve(c, func(s *bandwidth.Plugin, in resourcetypes.RawParams) (interface{}, error) {
-		nodename := in.String("nodename")
-		if nodename == "" {
-			return nil, types.ErrEmptyNodeName
-		}
+func calculateRemap(ctx context.Context, s *bandwidth.Plugin, in resourcetypes.RawParams) (interface{}, error) {
+	nodename := in.String("nodename")
+	if nodename == "" {
+		return nil, types.ErrEmptyNodeName
+	}
 
-		workloadsResource := map[string]resourcetypes.RawParams{}
-		for ID, data := range in.RawParams("workloads_resource") {
-			workloadsResource[ID] = resourcetypes.RawParams{}
-			_ = mapstructure.Decode(data, workloadsResource[ID])
-		}
-		// NO NEED REMAP Bandwidth
-		return s.CalculateRemap(c.Context, nodename, workloadsResource)
-	})
+	workloadsResource := map[string]resourcetypes.RawParams{}
+	for ID, data := range in.RawParams("workloads_resource") {
+		workloadsResource[ID] = resourcetypes.RawParams{}
+		_ = mapstructure.Decode(data, workloadsResource[ID])
+	}
+	// engine params are rendered in the form of the engine, unless the request names one
+	if engine := in.String("engine"); engine != "" {
+		ctx = bandwidth.WithEngine(ctx, engine)
+	}
+	// NO NEED REMAP Bandwidth
+	return s.CalculateRemap(ctx, nodename, workloadsResource)
 }
@@ -1,46 +1,105 @@
 package cmd
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
-	"testing"
 
 	resourcetypes "github.com/projecteru2/core/resource/types"
+	coretypes "github.com/projecteru2/core/types"
 	"github.com/projecteru2/core/utils"
 	"github.com/urfave/cli/v2"
 	"github.com/yuyang0/resource-bandwidth/bandwidth"
+	bdtypes "github.com/yuyang0/resource-bandwidth/bandwidth/types"
 )
 
 var (
 	ConfigPath      string
 	EmbeddedStorage bool
+	// DaemonAddress overrides the daemon address in config
+	DaemonAddress string
 )
 
-func Serve(c *cli.Context, f func(s *bandwidth.Plugin, in resourcetypes.RawParams) (interface{}, error)) error {
+// Handler handles one command of the binary plugin protocol
+type Handler func(ctx context.Context, s *bandwidth.Plugin, in resourcetypes.RawParams) (interface{}, error)
+
+// handlers are all registered commands, the daemon serves them by name
+var handlers = map[string]Handler{}
+
+// Action registers f as the handler of command, and returns the cli action which runs it once,
+// in the daemon if it's running, otherwise in this process
+func Action(command string, f Handler) cli.ActionFunc {
+	handlers[command] = f
+	return func(c *cli.Context) error {
+		config, bdConfig, err := LoadConfig()
+		if err != nil {
+			return cli.Exit(err, 128)
+		}
+
+		in := resourcetypes.RawParams{}
+		if err := json.NewDecoder(os.Stdin).Decode(&in); err != nil {
+			fmt.Fprintf(os.Stderr, "Bandwidth: failed decode input json: %s\n", err)
+			fmt.Fprintf(os.Stderr, "Bandwidth: input: %v\n", in)
+			return cli.Exit(err, 128)
+		}
+
+		// a dry run with embedded storage never touches the daemon
+		if address := GetDaemonAddress(bdConfig); address != "" && !EmbeddedStorage {
+			if o, ok, err := forward(c.Context, address, command, in); ok {
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Bandwidth: failed call daemon: %s\n", err)
+					fmt.Fprintf(os.Stderr, "Bandwidth: input: %v\n", in)
+					return cli.Exit(err, 128)
+				}
+				fmt.Print(string(o))
+				return nil
+			}
+		}
+		return Serve(c, config, bdConfig, in, f)
+	}
+}
+
+// LoadConfig loads the core config and the plugin config
+func LoadConfig() (coretypes.Config, *bdtypes.Config, error) {
 	config, err := utils.LoadConfig(ConfigPath)
 	if err != nil {
-		return cli.Exit(err, 128)
+		return config, nil, err
 	}
 
-	var t *testing.T
+	bdConfig, err := LoadPluginConfig()
+	return config, bdConfig, err
+}
+
+// LoadPluginConfig loads the plugin config only, flags override it
+func LoadPluginConfig() (*bdtypes.Config, error) {
+	bdConfig, err := bdtypes.LoadConfig(ConfigPath)
+	if err != nil {
+		return nil, err
+	}
 	if EmbeddedStorage {
-		t = &testing.T{}
+		bdConfig.Store.Type = bdtypes.StoreMemory
 	}
+	return bdConfig, nil
+}
 
-	s, err := bandwidth.NewPlugin(c.Context, config, t)
-	if err != nil {
-		return cli.Exit(err, 128)
+// GetDaemonAddress returns the daemon address of the flag or config, empty means no daemon
+func GetDaemonAddress(bdConfig *bdtypes.Config) string {
+	if DaemonAddress != "" {
+		return DaemonAddress
 	}
+	return bdConfig.Daemon
+}
 
-	in := resourcetypes.RawParams{}
-	if err := json.NewDecoder(os.Stdin).Decode(&in); err != nil {
-		fmt.Fprintf(os.Stderr, "Bandwidth: failed decode input json: %s\n", err)
-		fmt.Fprintf(os.Stderr, "Bandwidth: input: %v\n", in)
+// Serve runs f once in this process
+func Serve(c *cli.Context, config coretypes.Config, bdConfig *bdtypes.Config, in resourcetypes.RawParams, f Handler) error {
+	s, err := bandwidth.NewPlugin(c.Context, config, bdConfig)
+	if err != nil {
 		return cli.Exit(err, 128)
 	}
+	defer func() { _ = s.Close() }()
 
-	if r, err := f(s, in); err != nil {
+	if r, err := f(c.Context, s, in); err != nil {
 		fmt.Fprintf(os.Stderr, "Bandwidth: failed call function: %s\n", err)
 		fmt.Fprintf(os.Stderr, "Bandwidth: input: %v\n", in)
 		return cli.Exit(err, 128)
@@ -0,0 +1,40 @@
+package config
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/urfave/cli/v2"
+	"github.com/yuyang0/resource-bandwidth/cmd"
+	"gopkg.in/yaml.v2"
+)
+
+func CheckConfig() *cli.Command {
+	return &cli.Command{
+		Name:   "check-config",
+		Usage:  "print the effective plugin config with defaults filled, and all errors in it",
+		Action: checkConfig,
+	}
+}
+
+func checkConfig(*cli.Context) error {
+	// the core config isn't needed to check the plugin section
+	bdConfig, err := cmd.LoadPluginConfig()
+	if err != nil {
+		return cli.Exit(err, 128)
+	}
+	o, err := yaml.Marshal(map[string]any{"bandwidth": bdConfig})
+	if err != nil {
+		return cli.Exit(err, 128)
+	}
+	fmt.Print(string(o))
+
+	errs := bdConfig.Check()
+	for _, err := range errs {
+		fmt.Fprintf(os.Stderr, "Bandwidth: %s\n", err)
+	}
+	if len(errs) > 0 {
+		return cli.Exit(fmt.Sprintf("Bandwidth: %d errors in config", len(errs)), 128)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	resourcetypes "github.com/projecteru2/core/resource/types"
+	"github.com/yuyang0/resource-bandwidth/bandwidth"
+)
+
+// ParseAddress returns network and address of a daemon address,
+// unix:///path or an absolute path is a unix socket, tcp://host:port or host:port is tcp
+func ParseAddress(address string) (string, string) {
+	switch {
+	case strings.HasPrefix(address, "unix://"):
+		return "unix", strings.TrimPrefix(address, "unix://")
+	case strings.HasPrefix(address, "/"):
+		return "unix", address
+	default:
+		return "tcp", strings.TrimPrefix(address, "tcp://")
+	}
+}
+
+// Listen listens on a daemon address, a stale unix socket is removed first
+func Listen(address string) (net.Listener, error) {
+	network, addr := ParseAddress(address)
+	if network == "unix" {
+		if err := os.Remove(addr); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return net.Listen(network, addr)
+}
+
+// NewDaemon returns the http handler of the daemon, each command is POST /<command>
+// with the same json input and output as the binary commands
+func NewDaemon(s *bandwidth.Plugin) http.Handler {
+	mux := http.NewServeMux()
+	for command, f := range handlers {
+		f := f
+		mux.HandleFunc("/"+command, func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			in := resourcetypes.RawParams{}
+			if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+				http.Error(w, fmt.Sprintf("failed decode input json: %s", err), http.StatusBadRequest)
+				return
+			}
+			res, err := f(r.Context(), s, in)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("failed call function: %s", err), http.StatusInternalServerError)
+				return
+			}
+			o, err := json.Marshal(res)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("failed encode return object: %s", err), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write(o)
+		})
+	}
+	return mux
+}
+
+// forward calls command on the daemon, false means the daemon isn't running
+func forward(ctx context.Context, address string, command string, in resourcetypes.RawParams) ([]byte, bool, error) {
+	network, addr := ParseAddress(address)
+	dialer := &net.Dialer{}
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, addr)
+			},
+		},
+	}
+
+	body, err := json.Marshal(in)
+	if err != nil {
+		return nil, true, err
+	}
+	// host is ignored by the dialer
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://bandwidth/"+command, bytes.NewReader(body))
+	if err != nil {
+		return nil, true, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		var opErr *net.OpError
+		if errors.As(err, &opErr) && opErr.Op == "dial" {
+			return nil, false, nil
+		}
+		return nil, true, err
+	}
+	defer resp.Body.Close()
+
+	o, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, true, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, true, errors.New(strings.TrimSpace(string(o)))
+	}
+	return o, true, nil
+}
@@ -1,6 +1,8 @@
 package metrics
 
 import (
+	"context"
+
 	"github.com/yuyang0/resource-bandwidth/bandwidth"
 	"github.com/yuyang0/resource-bandwidth/cmd"
 
@@ -13,12 +15,10 @@ func Description() *cli.Command {
 	return &cli.Command{
 		Name:   binary.GetMetricsDescriptionCommand,
 		Usage:  "show metrics descriptions",
-		Action: description,
+		Action: cmd.Action(binary.GetMetricsDescriptionCommand, description),
 	}
 }
 
-func description(c *cli.Context) error {
-	return cmd.Serve(c, func(s *bandwidth.Plugin, _ resourcetypes.RawParams) (interface{}, error) {
-		return s.GetMetricsDescription(c.Context)
-	})
+func description(ctx context.Context, s *bandwidth.Plugin, _ resourcetypes.RawParams) (interface{}, error) {
+	return s.GetMetricsDescription(ctx)
 }
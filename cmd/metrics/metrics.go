@@ -1,6 +1,8 @@
 package metrics
 
 import (
+	"context"
+
 	"github.com/yuyang0/resource-bandwidth/bandwidth"
 	"github.com/yuyang0/resource-bandwidth/cmd"
 
@@ -13,14 +15,12 @@ func GetMetrics() *cli.Command {
 	return &cli.Command{
 		Name:   binary.GetMetricsCommand,
 		Usage:  "show metrics",
-		Action: metric,
+		Action: cmd.Action(binary.GetMetricsCommand, metric),
 	}
 }
 
-func metric(c *cli.Context) error {
-	return cmd.Serve(c, func(s *bandwidth.Plugin, in resourcetypes.RawParams) (interface{}, error) {
-		podname := in.String("podname")
-		nodename := in.String("nodename")
-		return s.GetMetrics(c.Context, podname, nodename)
-	})
+func metric(ctx context.Context, s *bandwidth.Plugin, in resourcetypes.RawParams) (interface{}, error) {
+	podname := in.String("podname")
+	nodename := in.String("nodename")
+	return s.GetMetrics(ctx, podname, nodename)
 }
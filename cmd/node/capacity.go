@@ -1,6 +1,8 @@
 package node
 
 import (
+	"context"
+
 	"github.com/projecteru2/core/resource/plugins/binary"
 	resourcetypes "github.com/projecteru2/core/resource/types"
 	"github.com/projecteru2/core/types"
@@ -13,41 +15,41 @@ func GetNodesDeployCapacity() *cli.Command {
 	return &cli.Command{
 		Name:   binary.GetNodesDeployCapacityCommand,
 		Usage:  "get deploy capacity",
-		Action: getNodesDeployCapacity,
+		Action: cmd.Action(binary.GetNodesDeployCapacityCommand, getNodesDeployCapacity),
 	}
 }
 
-func getNodesDeployCapacity(c *cli.Context) error {
-	return cmd.Serve(c, func(s *bandwidth.Plugin, in resourcetypes.RawParams) (interface{}, error) {
-		nodenames := in.StringSlice("nodenames")
-		if len(nodenames) == 0 {
-			return nil, types.ErrEmptyNodeName
-		}
+func getNodesDeployCapacity(ctx context.Context, s *bandwidth.Plugin, in resourcetypes.RawParams) (interface{}, error) {
+	nodenames := in.StringSlice("nodenames")
+	if len(nodenames) == 0 {
+		return nil, types.ErrEmptyNodeName
+	}
 
-		workloadResource := in.RawParams("workload_resource")
-		return s.GetNodesDeployCapacity(c.Context, nodenames, workloadResource)
-	})
+	workloadResource := in.RawParams("workload_resource")
+	return s.GetNodesDeployCapacity(ctx, nodenames, workloadResource)
 }
 
 func SetNodeResourceCapacity() *cli.Command {
 	return &cli.Command{
 		Name:   binary.SetNodeResourceCapacityCommand,
 		Usage:  "set node capacity",
-		Action: setNodeResourceCapacity,
+		Action: cmd.Action(binary.SetNodeResourceCapacityCommand, setNodeResourceCapacity),
 	}
 }
 
-func setNodeResourceCapacity(c *cli.Context) error {
-	return cmd.Serve(c, func(s *bandwidth.Plugin, in resourcetypes.RawParams) (interface{}, error) {
-		nodename := in.String("nodename")
-		if nodename == "" {
-			return nil, types.ErrEmptyNodeName
-		}
-
-		incr := in.Bool("incr")
-		delta := in.Bool("delta")
-		resourceRequest := in.RawParams("resource_request")
-		resource := in.RawParams("resource")
-		return s.SetNodeResourceCapacity(c.Context, nodename, resourceRequest, resource, delta, incr)
-	})
+func setNodeResourceCapacity(ctx context.Context, s *bandwidth.Plugin, in resourcetypes.RawParams) (interface{}, error) {
+	nodename := in.String("nodename")
+	if nodename == "" {
+		return nil, types.ErrEmptyNodeName
+	}
+
+	incr := in.Bool("incr")
+	delta := in.Bool("delta")
+	resourceRequest := in.RawParams("resource_request")
+	resource := in.RawParams("resource")
+	// replays of the same operation are no-ops
+	if operationID := in.String("operation_id"); operationID != "" {
+		ctx = bandwidth.WithOperationID(ctx, operationID)
+	}
+	return s.SetNodeResourceCapacity(ctx, nodename, resourceRequest, resource, delta, incr)
 }
@@ -1,6 +1,8 @@
 package node
 
 import (
+	"context"
+
 	"github.com/projecteru2/core/resource/plugins/binary"
 	resourcetypes "github.com/projecteru2/core/resource/types"
 	"github.com/projecteru2/core/types"
@@ -13,17 +15,19 @@ func GetMostIdleNode() *cli.Command {
 	return &cli.Command{
 		Name:   binary.GetMostIdleNodeCommand,
 		Usage:  "get most idle node",
-		Action: getMostIdleNode,
+		Action: cmd.Action(binary.GetMostIdleNodeCommand, getMostIdleNode),
 	}
 }
 
-func getMostIdleNode(c *cli.Context) error {
-	return cmd.Serve(c, func(s *bandwidth.Plugin, in resourcetypes.RawParams) (interface{}, error) {
-		nodenames := in.StringSlice("nodenames")
-		if len(nodenames) == 0 {
-			return nil, types.ErrEmptyNodeName
-		}
+func getMostIdleNode(ctx context.Context, s *bandwidth.Plugin, in resourcetypes.RawParams) (interface{}, error) {
+	nodenames := in.StringSlice("nodenames")
+	if len(nodenames) == 0 {
+		return nil, types.ErrEmptyNodeName
+	}
 
-		return s.GetMostIdleNode(c.Context, nodenames)
-	})
+	// the after-request strategy needs the request, core doesn't send it
+	if resourceRequest := in.RawParams("workload_resource_request"); len(resourceRequest) > 0 {
+		ctx = bandwidth.WithIncomingRequest(ctx, resourceRequest)
+	}
+	return s.GetMostIdleNode(ctx, nodenames)
 }
@@ -1,6 +1,8 @@
 package node
 
 import (
+	"context"
+
 	"github.com/cockroachdb/errors"
 	"github.com/projecteru2/core/resource/plugins/binary"
 	resourcetypes "github.com/projecteru2/core/resource/types"
@@ -15,65 +17,59 @@ func GetNodeResourceInfo() *cli.Command {
 	return &cli.Command{
 		Name:   binary.GetNodeResourceInfoCommand,
 		Usage:  "get node resource info",
-		Action: getNodeResourceInfo,
+		Action: cmd.Action(binary.GetNodeResourceInfoCommand, getNodeResourceInfo),
 	}
 }
 
-func getNodeResourceInfo(c *cli.Context) error {
-	return cmd.Serve(c, func(s *bandwidth.Plugin, in resourcetypes.RawParams) (interface{}, error) {
-		nodename := in.String("nodename")
-		if nodename == "" {
-			return nil, types.ErrEmptyNodeName
-		}
+func getNodeResourceInfo(ctx context.Context, s *bandwidth.Plugin, in resourcetypes.RawParams) (interface{}, error) {
+	nodename := in.String("nodename")
+	if nodename == "" {
+		return nil, types.ErrEmptyNodeName
+	}
 
-		workloadsResource := in.SliceRawParams("workloads_resource")
-		r, err := s.GetNodeResourceInfo(c.Context, nodename, workloadsResource)
-		// when ETCD key doesn't exist, then return an empty NodeResourceInfo value
-		if err == nil || errors.Is(err, coretypes.ErrNodeNotExists) {
-			return r, nil
-		}
-		return r, err
-	})
+	workloadsResource := in.SliceRawParams("workloads_resource")
+	r, err := s.GetNodeResourceInfo(ctx, nodename, workloadsResource)
+	// when ETCD key doesn't exist, then return an empty NodeResourceInfo value
+	if err == nil || errors.Is(err, coretypes.ErrNodeNotExists) {
+		return r, nil
+	}
+	return r, err
 }
 
 func SetNodeResourceInfo() *cli.Command {
 	return &cli.Command{
 		Name:   binary.SetNodeResourceInfoCommand,
 		Usage:  "set node resource info",
-		Action: setNodeResourceInfo,
+		Action: cmd.Action(binary.SetNodeResourceInfoCommand, setNodeResourceInfo),
 	}
 }
 
-func setNodeResourceInfo(c *cli.Context) error {
-	return cmd.Serve(c, func(s *bandwidth.Plugin, in resourcetypes.RawParams) (interface{}, error) {
-		nodename := in.String("nodename")
-		if nodename == "" {
-			return nil, types.ErrEmptyNodeName
-		}
+func setNodeResourceInfo(ctx context.Context, s *bandwidth.Plugin, in resourcetypes.RawParams) (interface{}, error) {
+	nodename := in.String("nodename")
+	if nodename == "" {
+		return nil, types.ErrEmptyNodeName
+	}
 
-		capacity := in.RawParams("capacity")
-		usage := in.RawParams("usage")
+	capacity := in.RawParams("capacity")
+	usage := in.RawParams("usage")
 
-		return s.SetNodeResourceInfo(c.Context, nodename, capacity, usage)
-	})
+	return s.SetNodeResourceInfo(ctx, nodename, capacity, usage)
 }
 
 func FixNodeResource() *cli.Command {
 	return &cli.Command{
 		Name:   binary.FixNodeResourceCommand,
 		Usage:  "fix node resource",
-		Action: fixNodeResource,
+		Action: cmd.Action(binary.FixNodeResourceCommand, fixNodeResource),
 	}
 }
 
-func fixNodeResource(c *cli.Context) error {
-	return cmd.Serve(c, func(s *bandwidth.Plugin, in resourcetypes.RawParams) (interface{}, error) {
-		nodename := in.String("nodename")
-		if nodename == "" {
-			return nil, types.ErrEmptyNodeName
-		}
+func fixNodeResource(ctx context.Context, s *bandwidth.Plugin, in resourcetypes.RawParams) (interface{}, error) {
+	nodename := in.String("nodename")
+	if nodename == "" {
+		return nil, types.ErrEmptyNodeName
+	}
 
-		workloadsResource := in.SliceRawParams("workloads_resource")
-		return s.FixNodeResource(c.Context, nodename, workloadsResource)
-	})
+	workloadsResource := in.SliceRawParams("workloads_resource")
+	return s.FixNodeResource(ctx, nodename, workloadsResource)
 }
@@ -1,6 +1,7 @@
 package node
 
 import (
+	"context"
 	"encoding/json"
 
 	"github.com/yuyang0/resource-bandwidth/bandwidth"
@@ -17,7 +18,7 @@ func AddNode() *cli.Command {
 	return &cli.Command{
 		Name:   binary.AddNodeCommand,
 		Usage:  "add node",
-		Action: addNode,
+		Action: cmd.Action(binary.AddNodeCommand, addNode),
 	}
 }
 
@@ -25,36 +26,32 @@ func RemoveNode() *cli.Command {
 	return &cli.Command{
 		Name:   binary.RemoveNodeCommand,
 		Usage:  "remove node",
-		Action: removeNode,
+		Action: cmd.Action(binary.RemoveNodeCommand, removeNode),
 	}
 }
 
-func addNode(c *cli.Context) error {
-	return cmd.Serve(c, func(s *bandwidth.Plugin, in resourcetypes.RawParams) (interface{}, error) {
-		nodename := in.String("nodename")
-		if nodename == "" {
-			return nil, types.ErrEmptyNodeName
-		}
-		engineInfo := in.RawParams("info")
-		eInfoBytes, err := json.Marshal(engineInfo)
-		if err != nil {
-			return nil, err
-		}
-		resource := in.RawParams("resource")
-		info := &enginetypes.Info{}
-		if err := json.Unmarshal(eInfoBytes, info); err != nil {
-			return nil, err
-		}
-		return s.AddNode(c.Context, nodename, resource, info)
-	})
+func addNode(ctx context.Context, s *bandwidth.Plugin, in resourcetypes.RawParams) (interface{}, error) {
+	nodename := in.String("nodename")
+	if nodename == "" {
+		return nil, types.ErrEmptyNodeName
+	}
+	engineInfo := in.RawParams("info")
+	eInfoBytes, err := json.Marshal(engineInfo)
+	if err != nil {
+		return nil, err
+	}
+	resource := in.RawParams("resource")
+	info := &enginetypes.Info{}
+	if err := json.Unmarshal(eInfoBytes, info); err != nil {
+		return nil, err
+	}
+	return s.AddNode(ctx, nodename, resource, info)
 }
 
-func removeNode(c *cli.Context) error {
-	return cmd.Serve(c, func(s *bandwidth.Plugin, in resourcetypes.RawParams) (interface{}, error) {
-		nodename := in.String("nodename")
-		if nodename == "" {
-			return nil, types.ErrEmptyNodeName
-		}
-		return s.RemoveNode(c.Context, nodename)
-	})
+func removeNode(ctx context.Context, s *bandwidth.Plugin, in resourcetypes.RawParams) (interface{}, error) {
+	nodename := in.String("nodename")
+	if nodename == "" {
+		return nil, types.ErrEmptyNodeName
+	}
+	return s.RemoveNode(ctx, nodename)
 }
@@ -1,6 +1,8 @@
 package node
 
 import (
+	"context"
+
 	"github.com/projecteru2/core/resource/plugins/binary"
 	resourcetypes "github.com/projecteru2/core/resource/types"
 	"github.com/projecteru2/core/types"
@@ -13,22 +15,24 @@ func SetNodeResourceUsage() *cli.Command {
 	return &cli.Command{
 		Name:   binary.SetNodeResourceUsageCommand,
 		Usage:  "set node usage",
-		Action: setNodeResourceUsage,
+		Action: cmd.Action(binary.SetNodeResourceUsageCommand, setNodeResourceUsage),
 	}
 }
 
-func setNodeResourceUsage(c *cli.Context) error {
-	return cmd.Serve(c, func(s *bandwidth.Plugin, in resourcetypes.RawParams) (interface{}, error) {
-		nodename := in.String("nodename")
-		if nodename == "" {
-			return nil, types.ErrEmptyNodeName
-		}
+func setNodeResourceUsage(ctx context.Context, s *bandwidth.Plugin, in resourcetypes.RawParams) (interface{}, error) {
+	nodename := in.String("nodename")
+	if nodename == "" {
+		return nil, types.ErrEmptyNodeName
+	}
 
-		incr := in.Bool("incr")
-		delta := in.Bool("delta")
-		resource := in.RawParams("resource")
-		resourceRequest := in.RawParams("resource_request")
-		workloadsResource := in.SliceRawParams("workloads_resource")
-		return s.SetNodeResourceUsage(c.Context, nodename, resourceRequest, resource, workloadsResource, delta, incr)
-	})
+	incr := in.Bool("incr")
+	delta := in.Bool("delta")
+	resource := in.RawParams("resource")
+	resourceRequest := in.RawParams("resource_request")
+	workloadsResource := in.SliceRawParams("workloads_resource")
+	// replays of the same operation are no-ops
+	if operationID := in.String("operation_id"); operationID != "" {
+		ctx = bandwidth.WithOperationID(ctx, operationID)
+	}
+	return s.SetNodeResourceUsage(ctx, nodename, resourceRequest, resource, workloadsResource, delta, incr)
 }
@@ -0,0 +1,70 @@
+package serve
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/projecteru2/core/log"
+	"github.com/urfave/cli/v2"
+	"github.com/yuyang0/resource-bandwidth/bandwidth"
+	"github.com/yuyang0/resource-bandwidth/cmd"
+)
+
+const (
+	defaultAddress    = "unix:///var/run/resource-bandwidth.sock"
+	readHeaderTimeout = 5 * time.Second
+	shutdownTimeout   = 10 * time.Second
+)
+
+func Serve() *cli.Command {
+	return &cli.Command{
+		Name:   "serve",
+		Usage:  "run as a daemon which keeps the plugin alive and serves all commands",
+		Action: serve,
+	}
+}
+
+func serve(c *cli.Context) error {
+	config, bdConfig, err := cmd.LoadConfig()
+	if err != nil {
+		return cli.Exit(err, 128)
+	}
+	address := cmd.GetDaemonAddress(bdConfig)
+	if address == "" {
+		address = defaultAddress
+	}
+
+	s, err := bandwidth.NewPlugin(c.Context, config, bdConfig)
+	if err != nil {
+		return cli.Exit(err, 128)
+	}
+	defer func() { _ = s.Close() }()
+
+	l, err := cmd.Listen(address)
+	if err != nil {
+		return cli.Exit(err, 128)
+	}
+
+	ctx, stop := signal.NotifyContext(c.Context, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	server := &http.Server{
+		Handler:           cmd.NewDaemon(s),
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	log.WithFunc("resource.bandwidth.serve").Infof(ctx, "serving on %s", address)
+	if err := server.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return cli.Exit(err, 128)
+	}
+	return nil
+}